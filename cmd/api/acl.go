@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+	"net/http"
+)
+
+// movieViewer returns who the request reads movies for. With movie ACLs disabled, and for
+// holders of admin:movies, every movie is visible.
+func (app *application) movieViewer(r *http.Request) (data.Viewer, error) {
+	if !app.config.movies.acl {
+		return data.Viewer{All: true}, nil
+	}
+
+	user := app.contextGetUser(r)
+	if user.IsAnonymous() {
+		return data.Viewer{}, nil
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return data.Viewer{}, err
+	}
+
+	if permissions.Include("admin:movies") {
+		return data.Viewer{All: true}, nil
+	}
+
+	return data.Viewer{UserID: user.ID, Permissions: permissions}, nil
+}
+
+// visibleMovie reads the movie in the request path, responding with 404 and returning nil
+// when it does not exist or the user may not see it.
+func (app *application) visibleMovie(w http.ResponseWriter, r *http.Request) *data.Movie {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+
+	viewer, err := app.movieViewer(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil
+	}
+
+	movie, err := app.models.Movies.WithContext(r.Context()).Get(id, viewer)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+
+	return movie
+}
+
+// canManageACL reports whether the user may change who can see the movie, which only its
+// owner and holders of admin:movies can.
+func (app *application) canManageACL(r *http.Request, movie *data.Movie) (bool, error) {
+	user := app.contextGetUser(r)
+
+	if movie.OwnerID != 0 && movie.OwnerID == user.ID {
+		return true, nil
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return false, err
+	}
+
+	return permissions.Include("admin:movies"), nil
+}
+
+func (app *application) showMovieACLHandler(w http.ResponseWriter, r *http.Request) {
+	movie := app.visibleMovie(w, r)
+	if movie == nil {
+		return
+	}
+
+	allowed, err := app.canManageACL(r, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !allowed {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	acl, err := app.models.Movies.WithContext(r.Context()).GetACL(movie.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"acl": acl}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateMovieACLHandler(w http.ResponseWriter, r *http.Request) {
+	movie := app.visibleMovie(w, r)
+	if movie == nil {
+		return
+	}
+
+	allowed, err := app.canManageACL(r, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !allowed {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	acl := &data.MovieACL{UserIDs: []int64{}, Permissions: []string{}}
+
+	err = app.readJSON(w, r, acl)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateMovieACL(v, acl); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Movies.WithContext(r.Context()).SetACL(movie.ID, acl)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.invalidateMovie(movie.ID)
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"acl": acl}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
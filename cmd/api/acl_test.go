@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"greenlight/internal/data"
+	"greenlight/internal/sqlfake"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// aclCatalog answers the movie and permission queries from memory, applying the visibility
+// rules of the viewer condition to the movies it returns.
+type aclCatalog struct {
+	mu          sync.Mutex
+	movies      []*data.Movie
+	aclUsers    map[int64][]int64
+	aclPerms    map[int64][]string
+	permissions map[int64][]string
+	setACL      []any
+}
+
+func newACLCatalog() *aclCatalog {
+	return &aclCatalog{
+		movies: []*data.Movie{
+			{ID: 1, Title: "Public", Slug: "public", Year: 2000, Runtime: 90, Genres: []string{"Drama"}, Version: 1, Visibility: data.VisibilityPublic},
+			{ID: 2, Title: "Members", Slug: "members", Year: 2000, Runtime: 90, Genres: []string{"Drama"}, Version: 1, Visibility: data.VisibilityAuthenticated},
+			{ID: 3, Title: "Restricted", Slug: "restricted", Year: 2000, Runtime: 90, Genres: []string{"Drama"}, Version: 1, Visibility: data.VisibilityRestricted, OwnerID: 5},
+		},
+		aclUsers: map[int64][]int64{3: {6}},
+		aclPerms: map[int64][]string{3: {"movies:staff"}},
+		permissions: map[int64][]string{
+			5: {"movies:write"},
+			6: {"movies:write"},
+			7: {"movies:write", "movies:staff"},
+			8: {"movies:write"},
+			9: {"movies:write", "admin:movies"},
+		},
+	}
+}
+
+func (c *aclCatalog) visible(query string, args []any, movie *data.Movie) bool {
+	if !strings.Contains(query, "movies.visibility = 'public'") {
+		return true
+	}
+
+	userID, permissions := args[len(args)-2].(int64), args[len(args)-1].([]string)
+
+	switch movie.Visibility {
+	case data.VisibilityPublic:
+		return true
+	case data.VisibilityAuthenticated:
+		return userID > 0
+	case data.VisibilityRestricted:
+		if userID <= 0 {
+			return false
+		}
+		if movie.OwnerID == userID || slices.Contains(c.aclUsers[movie.ID], userID) {
+			return true
+		}
+		for _, p := range c.aclPerms[movie.ID] {
+			if slices.Contains(permissions, p) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (c *aclCatalog) handle(query string, args []any) (*sqlfake.Result, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case len(args) == 0:
+		return nil, nil
+
+	case strings.Contains(query, "FROM permissions"):
+		res := &sqlfake.Result{}
+		for _, code := range c.permissions[args[0].(int64)] {
+			res.Rows = append(res.Rows, []any{code})
+		}
+		return res, nil
+
+	case strings.Contains(query, "INSERT INTO movie_acl"):
+		c.setACL = args
+		return &sqlfake.Result{RowsAffected: 1}, nil
+
+	case strings.Contains(query, "count(id) OVER()"):
+		var visible []*data.Movie
+		for _, m := range c.movies {
+			if c.visible(query, args, m) {
+				visible = append(visible, m)
+			}
+		}
+		res := listRows(len(visible), visible...)
+		for i, m := range visible {
+			res.Rows[i][10], res.Rows[i][11] = m.Visibility, m.OwnerID
+		}
+		return res, nil
+
+	case strings.Contains(query, "FROM movies") && strings.Contains(query, "id = $1"):
+		for _, m := range c.movies {
+			if m.ID == args[0].(int64) && c.visible(query, args, m) {
+				return &sqlfake.Result{Rows: [][]any{{
+					m.ID, testEpoch, testEpoch, m.Title, m.Slug, int64(m.Year), int64(m.Runtime), "{Drama}", int64(m.Version), m.Visibility, m.OwnerID, int64(0),
+				}}}, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+func TestRestrictedMoviesAreInvisible(t *testing.T) {
+	tests := []struct {
+		name        string
+		user        *data.User
+		wantVisible []int64
+	}{
+		{"anonymous", data.AnonymousUser, []int64{1}},
+		{"stranger", &data.User{ID: 8, Activated: true}, []int64{1, 2}},
+		{"owner", &data.User{ID: 5, Activated: true}, []int64{1, 2, 3}},
+		{"listed user", &data.User{ID: 6, Activated: true}, []int64{1, 2, 3}},
+		{"listed permission", &data.User{ID: 7, Activated: true}, []int64{1, 2, 3}},
+		{"admin", &data.User{ID: 9, Activated: true}, []int64{1, 2, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, clk := newConfiguredTestApplication(t, map[string]string{"MOVIE_ACL_ENABLED": "true"})
+			useTestDB(t, app, clk, newACLCatalog().handle)
+
+			rr := serve(t, http.HandlerFunc(app.listMoviesHandler), asUser(app, httptest.NewRequest(http.MethodGet, "/v1/movies", nil), tt.user))
+
+			var body struct {
+				Movies []data.Movie `json:"movies"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+
+			var listed []int64
+			for _, m := range body.Movies {
+				listed = append(listed, m.ID)
+			}
+
+			if !slices.Equal(listed, tt.wantVisible) {
+				t.Errorf("list: got movies %v; want %v", listed, tt.wantVisible)
+			}
+
+			for _, id := range []string{"1", "2", "3"} {
+				r := withParams(asUser(app, httptest.NewRequest(http.MethodGet, "/v1/movies/"+id, nil), tt.user), "id", id)
+				rr := serve(t, http.HandlerFunc(app.showMovieHandler), r)
+
+				want := http.StatusNotFound
+				if slices.Contains(tt.wantVisible, int64(id[0]-'0')) {
+					want = http.StatusOK
+				}
+
+				if rr.Code != want {
+					t.Errorf("show movie %s: got status %d; want %d", id, rr.Code, want)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateMovieACL(t *testing.T) {
+	tests := []struct {
+		name       string
+		user       int64
+		movie      string
+		body       string
+		wantStatus int
+	}{
+		{"owner", 5, "3", `{"visibility": "restricted", "user_ids": [6, 8], "permissions": ["movies:staff"]}`, http.StatusOK},
+		{"admin", 9, "1", `{"visibility": "authenticated"}`, http.StatusOK},
+		{"listed user", 6, "3", `{"visibility": "public"}`, http.StatusForbidden},
+		{"stranger on a visible movie", 8, "1", `{"visibility": "restricted"}`, http.StatusForbidden},
+		{"stranger on a restricted movie", 8, "3", `{"visibility": "public"}`, http.StatusNotFound},
+		{"invalid visibility", 5, "3", `{"visibility": "secret"}`, http.StatusUnprocessableEntity},
+		{"duplicate users", 5, "3", `{"visibility": "restricted", "user_ids": [6, 6]}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, clk := newConfiguredTestApplication(t, map[string]string{"MOVIE_ACL_ENABLED": "true"})
+
+			catalog := newACLCatalog()
+			useTestDB(t, app, clk, catalog.handle)
+
+			user := &data.User{ID: tt.user, Activated: true}
+			r := withParams(asUser(app, httptest.NewRequest(http.MethodPut, "/v1/movies/"+tt.movie+"/acl", strings.NewReader(tt.body)), user), "id", tt.movie)
+
+			rr := serve(t, http.HandlerFunc(app.updateMovieACLHandler), r)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d; want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				if catalog.setACL != nil {
+					t.Errorf("got the ACL set to %v; want it unchanged", catalog.setACL)
+				}
+				return
+			}
+
+			var acl data.MovieACL
+			if err := json.Unmarshal([]byte(tt.body), &acl); err != nil {
+				t.Fatal(err)
+			}
+			if acl.UserIDs == nil {
+				acl.UserIDs = []int64{}
+			}
+			if acl.Permissions == nil {
+				acl.Permissions = []string{}
+			}
+
+			args := catalog.setACL
+			if len(args) != 4 || args[1] != acl.Visibility || !slices.Equal(args[2].([]int64), acl.UserIDs) || !slices.Equal(args[3].([]string), acl.Permissions) {
+				t.Errorf("got ACL args %v; want %+v", args, acl)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"golang.org/x/time/rate"
+)
+
+// denialAuditor rate limits the recording of access denials. Denials over the limit are
+// only counted, and the count is reported with the next denial which is recorded.
+type denialAuditor struct {
+	limiter    *rate.Limiter
+	suppressed atomic.Int64
+}
+
+// recordDenial records that the request was refused with a 403, to the log and/or the
+// access_denials table as configured. permission is the permission the route requires, if
+// the denial was for lacking it.
+func (app *application) recordDenial(r *http.Request, permission, reason string) {
+	mode := app.config.audit.denials
+	if mode == "off" {
+		return
+	}
+
+	if !app.denials.limiter.Allow() {
+		app.denials.suppressed.Add(1)
+		return
+	}
+
+	denial := &data.Denial{
+		UserID:     app.contextGetUser(r).ID,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Permission: permission,
+		Reason:     reason,
+	}
+
+	suppressed := app.denials.suppressed.Swap(0)
+
+	if mode == "log" || mode == "both" {
+		properties := app.requestProperties(r)
+		properties["user_id"] = strconv.FormatInt(denial.UserID, 10)
+		properties["path"] = denial.Path
+		properties["reason"] = denial.Reason
+		if permission != "" {
+			properties["permission"] = permission
+		}
+		if suppressed > 0 {
+			properties["suppressed"] = strconv.FormatInt(suppressed, 10)
+		}
+
+		app.logger.PrintInfo("access denied", properties)
+	}
+
+	if mode == "table" || mode == "both" {
+		app.background(func() {
+			err := app.models.Denials.Insert(denial)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+}
+
+func (app *application) listDenialsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		UserID int
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.UserID = app.readInt(qs, "user_id", 0, v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.SortSafeList = []string{"id", "-id"}
+
+	v.Check(input.UserID >= 0, "user_id", "must not be negative")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	denials, metadata, err := app.models.Denials.GetAll(int64(input.UserID), input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"denials": denials, "metadata": app.paginationMetadata(r, metadata)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"greenlight/internal/data"
+	"greenlight/internal/jsonlog"
+	"greenlight/internal/sqlfake"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// denialStore answers the permission and access_denials queries from memory.
+type denialStore struct {
+	mu      sync.Mutex
+	denials []data.Denial
+}
+
+func (s *denialStore) handle(query string, args []any) (*sqlfake.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if strings.Contains(query, "INSERT INTO access_denials") {
+		s.denials = append(s.denials, data.Denial{
+			UserID:     args[0].(int64),
+			Method:     args[1].(string),
+			Path:       args[2].(string),
+			Permission: args[3].(string),
+			Reason:     args[4].(string),
+		})
+		return &sqlfake.Result{Rows: [][]any{{int64(len(s.denials)), testEpoch}}}, nil
+	}
+
+	// The user has no permissions.
+	return nil, nil
+}
+
+// auditEntries returns the properties of the "access denied" entries in the log.
+func auditEntries(t *testing.T, log *bytes.Buffer) []map[string]string {
+	t.Helper()
+
+	var entries []map[string]string
+
+	for _, line := range strings.Split(strings.TrimSpace(log.String()), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var entry struct {
+			Message    string            `json:"message"`
+			Properties map[string]string `json:"properties"`
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+
+		if entry.Message == "access denied" {
+			entries = append(entries, entry.Properties)
+		}
+	}
+
+	return entries
+}
+
+func TestDeniedRequestsAreAudited(t *testing.T) {
+	inactive := &data.User{ID: 2, Name: "Bob", Email: "bob@example.com"}
+
+	tests := []struct {
+		name           string
+		mode           string
+		user           *data.User
+		wantReason     string
+		wantPermission string
+		wantLog        bool
+		wantTable      bool
+	}{
+		{"missing permission to the log", "log", testUser, data.DenialMissingPermission, "movies:write", true, false},
+		{"missing permission to the table", "table", testUser, data.DenialMissingPermission, "movies:write", false, true},
+		{"missing permission to both", "both", testUser, data.DenialMissingPermission, "movies:write", true, true},
+		{"inactive account", "both", inactive, data.DenialInactiveAccount, "", true, true},
+		{"off", "off", testUser, data.DenialMissingPermission, "movies:write", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, clk := newConfiguredTestApplication(t, map[string]string{"AUDIT_DENIALS": tt.mode})
+			app.denials.limiter = rate.NewLimiter(rate.Limit(app.config.audit.rps), app.config.audit.burst)
+
+			var out bytes.Buffer
+			app.logger = jsonlog.New(&out, jsonlog.LevelInfo)
+
+			store := &denialStore{}
+			useTestDB(t, app, clk, store.handle)
+
+			h := app.requirePermission("movies:write", func(w http.ResponseWriter, r *http.Request) {
+				t.Error("the handler was reached")
+			})
+
+			r := asUser(app, httptest.NewRequest(http.MethodDelete, "/v1/movies/1", nil), tt.user)
+			if rr := serve(t, h, r); rr.Code != http.StatusForbidden {
+				t.Fatalf("got status %d; want %d", rr.Code, http.StatusForbidden)
+			}
+
+			app.wg.Wait()
+
+			entries := auditEntries(t, &out)
+			if got := len(entries) == 1; got != tt.wantLog {
+				t.Fatalf("got %d log entries; want logged %t", len(entries), tt.wantLog)
+			}
+			if tt.wantLog {
+				want := map[string]string{
+					"request_method": http.MethodDelete,
+					"request_url":    "/v1/movies/1",
+					"user_id":        strconv.FormatInt(tt.user.ID, 10),
+					"path":           "/v1/movies/1",
+					"reason":         tt.wantReason,
+				}
+				if tt.wantPermission != "" {
+					want["permission"] = tt.wantPermission
+				}
+				if !maps.Equal(entries[0], want) {
+					t.Errorf("got log properties %v; want %v", entries[0], want)
+				}
+			}
+
+			if got := len(store.denials) == 1; got != tt.wantTable {
+				t.Fatalf("got %d recorded denials; want recorded %t", len(store.denials), tt.wantTable)
+			}
+			if tt.wantTable {
+				want := data.Denial{UserID: tt.user.ID, Method: http.MethodDelete, Path: "/v1/movies/1", Permission: tt.wantPermission, Reason: tt.wantReason}
+				if store.denials[0] != want {
+					t.Errorf("got denial %+v; want %+v", store.denials[0], want)
+				}
+			}
+		})
+	}
+}
+
+func TestDenialAuditIsRateLimited(t *testing.T) {
+	app, clk := newConfiguredTestApplication(t, map[string]string{"AUDIT_DENIALS": "log"})
+	app.denials.limiter = rate.NewLimiter(rate.Every(time.Hour), 2)
+
+	var out bytes.Buffer
+	app.logger = jsonlog.New(&out, jsonlog.LevelInfo)
+
+	useTestDB(t, app, clk, (&denialStore{}).handle)
+
+	h := app.requirePermission("movies:write", func(w http.ResponseWriter, r *http.Request) {})
+
+	deny := func() {
+		serve(t, h, asUser(app, httptest.NewRequest(http.MethodDelete, "/v1/movies/1", nil), testUser))
+	}
+
+	for i := 0; i < 5; i++ {
+		deny()
+	}
+
+	if entries := auditEntries(t, &out); len(entries) != 2 {
+		t.Fatalf("got %d log entries; want the burst of 2", len(entries))
+	}
+
+	// Once the limit allows it again, the next entry reports the denials it suppressed.
+	app.denials.limiter = rate.NewLimiter(rate.Inf, 1)
+	deny()
+
+	entries := auditEntries(t, &out)
+	if len(entries) != 3 || entries[2]["suppressed"] != "3" {
+		t.Errorf("got log entries %v; want a third reporting 3 suppressed", entries)
+	}
+}
+
+func TestListDenials(t *testing.T) {
+	app, clk := newConfiguredTestApplication(t, nil)
+
+	var args []any
+
+	useTestDB(t, app, clk, func(query string, a []any) (*sqlfake.Result, error) {
+		args = a
+		return &sqlfake.Result{Rows: [][]any{
+			{int64(1), int64(7), testEpoch, int64(2), "DELETE", "/v1/movies/1", "movies:write", data.DenialMissingPermission},
+		}}, nil
+	})
+
+	rr := serve(t, http.HandlerFunc(app.listDenialsHandler), httptest.NewRequest(http.MethodGet, "/v1/audit/denials?user_id=2&page=2&page_size=10", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	if len(args) != 3 || args[0] != int64(2) || args[1] != 10 || args[2] != 10 {
+		t.Errorf("got query args %v; want user 2, limit 10 and offset 10", args)
+	}
+
+	var body struct {
+		Denials []data.Denial `json:"denials"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(body.Denials) != 1 || body.Denials[0].ID != 7 || body.Denials[0].Permission != "movies:write" {
+		t.Errorf("got denials %+v", body.Denials)
+	}
+
+	rr = serve(t, http.HandlerFunc(app.listDenialsHandler), httptest.NewRequest(http.MethodGet, "/v1/audit/denials?user_id=-1", nil))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("negative user_id: got status %d; want %d", rr.Code, http.StatusUnprocessableEntity)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"greenlight/internal/clock"
+	"greenlight/internal/data"
+	"greenlight/internal/jwt"
+	"greenlight/internal/validator"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Authentication schemes which can be enabled, in any combination, with AUTH_SCHEMES.
+const (
+	authSchemeToken  = "token"
+	authSchemeJWT    = "jwt"
+	authSchemeAPIKey = "apikey"
+)
+
+// defaultAPIKeyTTL is how long API keys are valid for unless API_KEY_TTL says otherwise.
+const defaultAPIKeyTTL = 365 * 24 * time.Hour
+
+// errInvalidCredentials is returned by authUser when the credentials are not valid.
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// checkClock compares the local clock with the configured NTP server and logs a warning when
+// they are further apart than the tolerated clock skew, as tokens will then be rejected, or
+// accepted past their expiry, on this server.
+func (app *application) checkClock() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	properties := map[string]string{"ntp_server": app.config.auth.ntpServer}
+
+	offset, err := clock.NTPOffset(ctx, app.config.auth.ntpServer)
+	if err != nil {
+		app.logger.PrintError(err, properties)
+		return
+	}
+
+	properties["offset"] = offset.String()
+
+	if offset.Abs() > app.config.auth.clockSkew {
+		properties["clock_skew"] = app.config.auth.clockSkew.String()
+		app.logger.PrintError(errors.New("the clock is further from the NTP server than the tolerated clock skew"), properties)
+		return
+	}
+
+	app.logger.PrintInfo("clock checked", properties)
+}
+
+// bearerToken returns the token from an "Authorization: Bearer <token>" header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || scheme != "Bearer" || token == "" || strings.Contains(token, " ") {
+		return "", false
+	}
+
+	return token, true
+}
+
+// authCredential returns the credential for the scheme sent with the request, if any.
+// Database tokens and JWTs are both sent as bearer tokens, and are told apart by shape.
+func (app *application) authCredential(scheme string, r *http.Request) (string, bool) {
+	switch scheme {
+	case authSchemeToken:
+		token, ok := bearerToken(r)
+		return token, ok && !jwt.LooksLikeJWT(token)
+	case authSchemeJWT:
+		token, ok := bearerToken(r)
+		return token, ok && jwt.LooksLikeJWT(token)
+	case authSchemeAPIKey:
+		key := r.Header.Get("X-API-Key")
+		return key, key != ""
+	default:
+		return "", false
+	}
+}
+
+// authUser resolves the user the credential belongs to.
+func (app *application) authUser(scheme, credential string) (*data.User, error) {
+	var user *data.User
+	var err error
+
+	switch scheme {
+	case authSchemeToken, authSchemeAPIKey:
+		tokenScope := data.ScopeAuthentication
+		if scheme == authSchemeAPIKey {
+			tokenScope = data.ScopeAPIKey
+		}
+
+		v := validator.New()
+
+		if data.ValidateTokenPlaintext(v, credential); !v.Valid() {
+			return nil, errInvalidCredentials
+		}
+
+		user, err = app.models.Users.GetForToken(tokenScope, credential)
+
+	case authSchemeJWT:
+		claims, verifyErr := jwt.Verify(credential, []byte(app.config.auth.jwtSecret), app.config.auth.jwtIssuer, app.config.auth.jwtAudience, app.clock.Now(), app.config.auth.clockSkew)
+		if verifyErr != nil {
+			return nil, errInvalidCredentials
+		}
+
+		// Only tokens issued for authentication authenticate, and not ones without a scope
+		// or meant for something else, such as password resets.
+		if claims.Scope != data.ScopeAuthentication {
+			return nil, errInvalidCredentials
+		}
+
+		id, parseErr := strconv.ParseInt(claims.Subject, 10, 64)
+		if parseErr != nil {
+			return nil, errInvalidCredentials
+		}
+
+		user, err = app.models.Users.Get(id)
+
+	default:
+		return nil, errInvalidCredentials
+	}
+
+	if errors.Is(err, data.ErrRecordNotFound) {
+		return nil, errInvalidCredentials
+	}
+
+	return user, err
+}
+
+// authChallenges returns the WWW-Authenticate challenges for the enabled schemes.
+func (app *application) authChallenges() string {
+	var challenges []string
+
+	bearer := false
+	for _, scheme := range app.config.auth.schemes {
+		switch scheme {
+		case authSchemeToken, authSchemeJWT:
+			if !bearer {
+				challenges = append(challenges, "Bearer")
+				bearer = true
+			}
+		case authSchemeAPIKey:
+			challenges = append(challenges, `ApiKey header="X-API-Key"`)
+		}
+	}
+
+	return strings.Join(challenges, ", ")
+}
+
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	token, err := app.models.Tokens.New(user.ID, app.config.auth.apiKeyTTL, data.ScopeAPIKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"api_key": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// parseAuthSchemes parses a space separated list of authentication schemes, in order of
+// precedence.
+func parseAuthSchemes(s string) ([]string, error) {
+	schemes := strings.Fields(s)
+	if len(schemes) == 0 {
+		return nil, errors.New("at least one scheme must be enabled")
+	}
+
+	for _, scheme := range schemes {
+		if !validator.PermittedValue(scheme, authSchemeToken, authSchemeJWT, authSchemeAPIKey) {
+			return nil, errors.New("unknown scheme " + scheme)
+		}
+	}
+
+	if !validator.Unique(schemes) {
+		return nil, errors.New("schemes must not be repeated")
+	}
+
+	return schemes, nil
+}
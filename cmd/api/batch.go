@@ -0,0 +1,315 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+	"net/http"
+)
+
+// batchItem is the outcome of one item of a batch request. Failed items carry the same
+// machine readable code and error detail as the equivalent single-item request would.
+type batchItem struct {
+	Index   int    `json:"index"`
+	Status  int    `json:"status"`
+	ID      int64  `json:"id,omitempty"`
+	Outcome string `json:"outcome,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Error   any    `json:"error,omitempty"`
+}
+
+// batchResult collects the outcome of each item. When outcomes is not nil, successful items
+// are also counted by what was done with them, such as created or updated.
+type batchResult struct {
+	items    []batchItem
+	outcomes map[string]int
+}
+
+func (b *batchResult) succeed(index, status int, id int64) {
+	b.items = append(b.items, batchItem{Index: index, Status: status, ID: id})
+}
+
+// upsert records a successful item with what was done with it.
+func (b *batchResult) upsert(index, status int, id int64, outcome string) {
+	if b.outcomes == nil {
+		b.succeed(index, status, id)
+		return
+	}
+
+	b.items = append(b.items, batchItem{Index: index, Status: status, ID: id, Outcome: outcome})
+	b.outcomes[outcome]++
+}
+
+func (b *batchResult) fail(index, status int, code string, err any) {
+	b.items = append(b.items, batchItem{Index: index, Status: status, Code: code, Error: err})
+}
+
+// batchResponse writes the outcome of a batch request: overall counts plus the outcome of
+// each item, in request order. The response status is 200 when every item succeeded and
+// 207 Multi-Status otherwise, so clients must always check the per-item statuses.
+func (app *application) batchResponse(w http.ResponseWriter, r *http.Request, b *batchResult) {
+	succeeded := 0
+	for _, item := range b.items {
+		if item.Status < http.StatusBadRequest {
+			succeeded++
+		}
+	}
+
+	status := http.StatusOK
+	if succeeded < len(b.items) {
+		status = http.StatusMultiStatus
+	}
+
+	env := envelope{
+		"succeeded": succeeded,
+		"failed":    len(b.items) - succeeded,
+		"items":     b.items,
+	}
+
+	for outcome, n := range b.outcomes {
+		env[outcome] = n
+	}
+
+	err := app.writeResponse(w, r, status, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// validateBatchSize checks the number of items in a batch request.
+func (app *application) validateBatchSize(v *validator.Validator, key string, n int) {
+	v.Check(n > 0, key, "must contain at least 1 item")
+	v.Check(n <= app.config.batch.maxItems, key, fmt.Sprintf("must not contain more than %d items", app.config.batch.maxItems))
+}
+
+func (app *application) batchCreateMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Movies []struct {
+			Title   string       `json:"title"`
+			Year    int32        `json:"year"`
+			Runtime data.Runtime `json:"runtime"`
+			Genres  []string     `json:"genres"`
+		} `json:"movies"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if app.validateBatchSize(v, "movies", len(input.Movies)); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	minYear, maxYear := app.movieYearBounds()
+
+	taxonomy, err := app.genreTaxonomy()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var result batchResult
+
+	for i, item := range input.Movies {
+		v := validator.New()
+
+		movie := &data.Movie{
+			Title:   item.Title,
+			Year:    item.Year,
+			Runtime: item.Runtime,
+			Genres:  app.normalizeGenres(v, taxonomy, item.Genres),
+			OwnerID: app.contextGetUser(r).ID,
+		}
+
+		if data.ValidateMovie(v, movie, minYear, maxYear); !v.Valid() {
+			result.fail(i, http.StatusUnprocessableEntity, errCodeValidationFailed, v.Errors)
+			continue
+		}
+
+		err := app.models.Movies.WithContext(r.Context()).Insert(movie)
+		if err != nil {
+			app.logError(r, err)
+			result.fail(i, http.StatusInternalServerError, errCodeServerError, "the server encountered a problem and could not create this movie")
+			continue
+		}
+
+		totalMoviesCreated.Add(1)
+		app.invalidateMovie(movie.ID)
+
+		app.movieChanged(data.EventMovieCreated, movie)
+
+		result.succeed(i, http.StatusCreated, movie.ID)
+	}
+
+	app.batchResponse(w, r, &result)
+}
+
+// batchUpsertMoviesHandler creates or updates movies by their external id, so that an
+// ingestion pipeline can sync its catalog by sending every movie in it. With
+// app.config.batch.upsertOutcomes set, the response counts the movies created, updated and
+// left unchanged, and each item says which happened to it.
+func (app *application) batchUpsertMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Movies []struct {
+			ExternalID string       `json:"external_id"`
+			Title      string       `json:"title"`
+			Year       int32        `json:"year"`
+			Runtime    data.Runtime `json:"runtime"`
+			Genres     []string     `json:"genres"`
+		} `json:"movies"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if app.validateBatchSize(v, "movies", len(input.Movies)); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	minYear, maxYear := app.movieYearBounds()
+
+	taxonomy, err := app.genreTaxonomy()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	viewer, err := app.movieViewer(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var result batchResult
+	if app.config.batch.upsertOutcomes {
+		result.outcomes = map[string]int{data.UpsertCreated: 0, data.UpsertUpdated: 0, data.UpsertUnchanged: 0}
+	}
+
+	for i, item := range input.Movies {
+		v := validator.New()
+
+		movie := &data.Movie{
+			ExternalID: item.ExternalID,
+			Title:      item.Title,
+			Year:       item.Year,
+			Runtime:    item.Runtime,
+			Genres:     app.normalizeGenres(v, taxonomy, item.Genres),
+			OwnerID:    app.contextGetUser(r).ID,
+		}
+
+		data.ValidateExternalID(v, movie.ExternalID)
+
+		if data.ValidateMovie(v, movie, minYear, maxYear); !v.Valid() {
+			result.fail(i, http.StatusUnprocessableEntity, errCodeValidationFailed, v.Errors)
+			continue
+		}
+
+		outcome, err := app.models.Movies.WithContext(r.Context()).Upsert(movie, viewer)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				result.fail(i, http.StatusNotFound, errCodeNotFound, "the requested resource could not be found")
+			case errors.Is(err, data.ErrMovieDeleted):
+				result.fail(i, http.StatusConflict, errCodeEditConflict, "the movie with this external id has been deleted, and must be restored before it can be updated")
+			case errors.Is(err, data.ErrEditConflict):
+				result.fail(i, http.StatusConflict, errCodeEditConflict, "unable to update the record due to an edit conflict, please try again")
+			default:
+				app.logError(r, err)
+				result.fail(i, http.StatusInternalServerError, errCodeServerError, "the server encountered a problem and could not save this movie")
+			}
+			continue
+		}
+
+		switch outcome {
+		case data.UpsertCreated:
+			totalMoviesCreated.Add(1)
+			app.invalidateMovie(movie.ID)
+			app.movieChanged(data.EventMovieCreated, movie)
+			result.upsert(i, http.StatusCreated, movie.ID, outcome)
+		case data.UpsertUpdated:
+			totalMoviesUpdated.Add(1)
+			app.invalidateMovie(movie.ID)
+			app.movieChanged(data.EventMovieUpdated, movie)
+			result.upsert(i, http.StatusOK, movie.ID, outcome)
+		default:
+			result.upsert(i, http.StatusOK, movie.ID, outcome)
+		}
+	}
+
+	app.batchResponse(w, r, &result)
+}
+
+func (app *application) batchDeleteMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		IDs []int64 `json:"ids"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if app.validateBatchSize(v, "ids", len(input.IDs)); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	viewer, err := app.movieViewer(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var result batchResult
+
+	for i, id := range input.IDs {
+		// The movie is read for admins too, so that the deletion event reaches whoever could
+		// see it.
+		deleted := &data.Movie{ID: id}
+
+		movie, err := app.models.Movies.WithContext(r.Context()).Get(id, viewer)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			result.fail(i, http.StatusNotFound, errCodeNotFound, "the requested resource could not be found")
+			continue
+		case err == nil:
+			deleted = movie
+		}
+
+		err = app.models.Movies.WithContext(r.Context()).Delete(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				result.fail(i, http.StatusNotFound, errCodeNotFound, "the requested resource could not be found")
+			default:
+				app.logError(r, err)
+				result.fail(i, http.StatusInternalServerError, errCodeServerError, "the server encountered a problem and could not delete this movie")
+			}
+			continue
+		}
+
+		totalMoviesDeleted.Add(1)
+		app.invalidateMovie(id)
+
+		app.movieChanged(data.EventMovieDeleted, deleted)
+
+		result.succeed(i, http.StatusOK, id)
+	}
+
+	app.batchResponse(w, r, &result)
+}
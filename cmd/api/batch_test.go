@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"greenlight/internal/data"
+	"greenlight/internal/sqlfake"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type batchBody struct {
+	Succeeded int         `json:"succeeded"`
+	Failed    int         `json:"failed"`
+	Items     []batchItem `json:"items"`
+}
+
+func TestBatchCreateMovies(t *testing.T) {
+	const (
+		valid   = `{"title": "Alien", "year": 1979, "runtime": "117 mins", "genres": ["Horror"]}`
+		invalid = `{"title": "", "year": 1979, "runtime": "117 mins", "genres": ["Horror"]}`
+	)
+
+	tests := []struct {
+		name       string
+		movies     []string
+		wantStatus int
+		wantItems  []int
+	}{
+		{"all succeed", []string{valid, valid}, http.StatusOK, []int{http.StatusCreated, http.StatusCreated}},
+		{"all fail", []string{invalid, invalid}, http.StatusMultiStatus, []int{http.StatusUnprocessableEntity, http.StatusUnprocessableEntity}},
+		{"mixed", []string{valid, invalid, valid}, http.StatusMultiStatus, []int{http.StatusCreated, http.StatusUnprocessableEntity, http.StatusCreated}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, clk := newConfiguredTestApplication(t, nil)
+
+			nextID := int64(0)
+			useTestDB(t, app, clk, func(query string, args []any) (*sqlfake.Result, error) {
+				if strings.Contains(query, "INSERT INTO movies") {
+					nextID++
+					return &sqlfake.Result{Rows: [][]any{{nextID, testEpoch, testEpoch, int64(1), "public"}}}, nil
+				}
+				return nil, nil
+			})
+
+			body := `{"movies": [` + strings.Join(tt.movies, ",") + `]}`
+			r := asUser(app, httptest.NewRequest(http.MethodPost, "/v1/batch/movies", strings.NewReader(body)), testUser)
+
+			rr := serve(t, http.HandlerFunc(app.batchCreateMoviesHandler), r)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d; want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+
+			var got batchBody
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+
+			succeeded := 0
+			for _, status := range tt.wantItems {
+				if status < http.StatusBadRequest {
+					succeeded++
+				}
+			}
+			if got.Succeeded != succeeded || got.Failed != len(tt.wantItems)-succeeded {
+				t.Errorf("got %d succeeded and %d failed; want %d and %d", got.Succeeded, got.Failed, succeeded, len(tt.wantItems)-succeeded)
+			}
+
+			if len(got.Items) != len(tt.wantItems) {
+				t.Fatalf("got %d items; want %d", len(got.Items), len(tt.wantItems))
+			}
+
+			var id int64
+			for i, item := range got.Items {
+				if item.Index != i || item.Status != tt.wantItems[i] {
+					t.Errorf("item %d: got index %d and status %d; want status %d", i, item.Index, item.Status, tt.wantItems[i])
+				}
+
+				switch item.Status {
+				case http.StatusCreated:
+					id++
+					if item.ID != id || item.Code != "" || item.Error != nil {
+						t.Errorf("item %d: got %+v; want id %d", i, item, id)
+					}
+				default:
+					if item.Code != errCodeValidationFailed || !strings.Contains(string(mustMarshal(t, item.Error)), `"title"`) {
+						t.Errorf("item %d: got code %q and error %v", i, item.Code, item.Error)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBatchDeleteMoviesReportsEachItem(t *testing.T) {
+	app, clk := newConfiguredTestApplication(t, nil)
+
+	useTestDB(t, app, clk, func(query string, args []any) (*sqlfake.Result, error) {
+		if len(args) == 0 || args[0] != int64(1) {
+			return nil, nil
+		}
+		switch {
+		case strings.HasPrefix(strings.TrimSpace(query), "DELETE FROM movies"):
+			return &sqlfake.Result{Rows: [][]any{{false}}}, nil
+		case strings.Contains(query, "SET deleted_at"):
+			return &sqlfake.Result{RowsAffected: 1}, nil
+		case strings.Contains(query, "FROM movies"):
+			return &sqlfake.Result{Rows: [][]any{{
+				int64(1), testEpoch, testEpoch, "Alien", "alien", int64(1979), int64(117), "{Horror}", int64(1), "public", int64(0), float64(0),
+			}}}, nil
+		}
+		return nil, nil
+	})
+
+	r := asUser(app, httptest.NewRequest(http.MethodDelete, "/v1/batch/movies", strings.NewReader(`{"ids": [1, 2]}`)), testUser)
+
+	rr := serve(t, http.HandlerFunc(app.batchDeleteMoviesHandler), r)
+	if rr.Code != http.StatusMultiStatus {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusMultiStatus, rr.Body)
+	}
+
+	var got batchBody
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Succeeded != 1 || got.Failed != 1 || len(got.Items) != 2 {
+		t.Fatalf("got %+v", got)
+	}
+	if item := got.Items[0]; item.Status != http.StatusOK || item.ID != 1 {
+		t.Errorf("deleted item: got %+v", item)
+	}
+	if item := got.Items[1]; item.Status != http.StatusNotFound || item.Code != errCodeNotFound {
+		t.Errorf("missing item: got %+v", item)
+	}
+}
+
+func mustMarshal(t *testing.T, v any) []byte {
+	t.Helper()
+
+	js, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return js
+}
+
+func TestBatchUpsertMoviesCountsOutcomes(t *testing.T) {
+	tests := []struct {
+		outcomes string
+		want     map[string]int
+	}{
+		{"true", map[string]int{data.UpsertCreated: 1, data.UpsertUpdated: 1, data.UpsertUnchanged: 1}},
+		{"false", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.outcomes, func(t *testing.T) {
+			app, clk := newConfiguredTestApplication(t, map[string]string{"BATCH_UPSERT_OUTCOMES": tt.outcomes})
+
+			stored := map[string][]any{
+				"tt0078748": {int64(1), testEpoch, testEpoch, "Alien", "alien", int64(1979), int64(117), "{Horror}", int64(1), "public", int64(1), int64(0), "tt0078748", false, true},
+				"tt0113277": {int64(2), testEpoch, testEpoch, "Heat", "heat", int64(1995), int64(170), "{Crime}", int64(1), "public", int64(1), int64(0), "tt0113277", false, true},
+			}
+
+			var inserted, updated []any
+
+			useTestDB(t, app, clk, func(query string, args []any) (*sqlfake.Result, error) {
+				switch {
+				case strings.Contains(query, "WHERE external_id = $1"):
+					if row, ok := stored[args[0].(string)]; ok {
+						return &sqlfake.Result{Rows: [][]any{row}}, nil
+					}
+				case strings.Contains(query, "INSERT INTO movies"):
+					inserted = append(inserted, args[6])
+					return &sqlfake.Result{Rows: [][]any{{int64(3), testEpoch, testEpoch, int64(1), "public"}}}, nil
+				case strings.Contains(query, "WITH updated AS"):
+					updated = append(updated, args[4])
+					return &sqlfake.Result{Rows: [][]any{{int64(2), testEpoch}}}, nil
+				}
+				return nil, nil
+			})
+
+			body := `{"movies": [
+				{"external_id": "tt0078748", "title": "Alien", "year": 1979, "runtime": "117 mins", "genres": ["Horror"]},
+				{"external_id": "tt0113277", "title": "Heat", "year": 1995, "runtime": "171 mins", "genres": ["Crime"]},
+				{"external_id": "tt0089881", "title": "Ran", "year": 1985, "runtime": "162 mins", "genres": ["Drama"]}
+			]}`
+			r := asUser(app, httptest.NewRequest(http.MethodPut, "/v1/batch/movies", strings.NewReader(body)), testUser)
+
+			rr := serve(t, http.HandlerFunc(app.batchUpsertMoviesHandler), r)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+			}
+
+			var got struct {
+				batchBody
+				Created   *int `json:"created"`
+				Updated   *int `json:"updated"`
+				Unchanged *int `json:"unchanged"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(inserted) != 1 || inserted[0] != "tt0089881" || len(updated) != 1 || updated[0] != int64(2) {
+				t.Errorf("got inserted %v and updated %v; want only the new movie inserted and the changed one updated", inserted, updated)
+			}
+
+			wantItems := []struct {
+				status  int
+				id      int64
+				outcome string
+			}{
+				{http.StatusOK, 1, data.UpsertUnchanged},
+				{http.StatusOK, 2, data.UpsertUpdated},
+				{http.StatusCreated, 3, data.UpsertCreated},
+			}
+
+			if len(got.Items) != len(wantItems) {
+				t.Fatalf("got %d items; want %d", len(got.Items), len(wantItems))
+			}
+
+			for i, want := range wantItems {
+				if tt.want == nil {
+					want.outcome = ""
+				}
+				if item := got.Items[i]; item.Status != want.status || item.ID != want.id || item.Outcome != want.outcome {
+					t.Errorf("item %d: got %+v; want status %d, id %d and outcome %q", i, item, want.status, want.id, want.outcome)
+				}
+			}
+
+			counts := map[string]*int{data.UpsertCreated: got.Created, data.UpsertUpdated: got.Updated, data.UpsertUnchanged: got.Unchanged}
+			for outcome, n := range counts {
+				want, ok := tt.want[outcome]
+				switch {
+				case ok && (n == nil || *n != want):
+					t.Errorf("got %s count %v; want %d", outcome, n, want)
+				case !ok && n != nil:
+					t.Errorf("got %s count %d; want none", outcome, *n)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// compressResponseWriter holds back the response until it is known to be at least minBytes
+// long, and then compresses it with the chosen encoding. Shorter responses, and those which
+// are compressed already or have no body, are passed through as they were written.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding   string
+	minBytes   int
+	statusCode int
+	buf        []byte
+	started    bool
+	encoder    io.WriteCloser
+}
+
+func (cw *compressResponseWriter) WriteHeader(statusCode int) {
+	if cw.statusCode == 0 {
+		cw.statusCode = statusCode
+	}
+}
+
+func (cw *compressResponseWriter) Write(b []byte) (int, error) {
+	if cw.statusCode == 0 {
+		cw.statusCode = http.StatusOK
+	}
+
+	if cw.started {
+		if cw.encoder != nil {
+			return cw.encoder.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+
+	cw.buf = append(cw.buf, b...)
+
+	if len(cw.buf) >= cw.minBytes {
+		err := cw.start(true)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+// FlushError sends what has been written so far. A response flushed before reaching
+// minBytes is streamed uncompressed, as event streams are.
+func (cw *compressResponseWriter) FlushError() error {
+	if !cw.started {
+		if cw.statusCode == 0 {
+			cw.statusCode = http.StatusOK
+		}
+
+		err := cw.start(false)
+		if err != nil {
+			return err
+		}
+	}
+
+	if flusher, ok := cw.encoder.(interface{ Flush() error }); ok {
+		err := flusher.Flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	return http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// start writes the header and the buffered part of the body, compressing from then on when
+// compress is set and the response can be compressed.
+func (cw *compressResponseWriter) start(compress bool) error {
+	cw.started = true
+
+	if compress && compressible(cw.Header(), cw.statusCode) {
+		cw.Header().Set("Content-Encoding", cw.encoding)
+		cw.Header().Del("Content-Length")
+
+		if cw.encoding == "gzip" {
+			cw.encoder = gzip.NewWriter(cw.ResponseWriter)
+		} else {
+			cw.encoder = zlib.NewWriter(cw.ResponseWriter)
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.statusCode)
+
+	if len(cw.buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if cw.encoder != nil {
+		_, err = cw.encoder.Write(cw.buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf)
+	}
+	cw.buf = nil
+
+	return err
+}
+
+// close sends a response which never reached minBytes uncompressed, or finishes the
+// compressed stream.
+func (cw *compressResponseWriter) close() error {
+	if !cw.started {
+		if cw.statusCode == 0 {
+			return nil
+		}
+		return cw.start(false)
+	}
+
+	if cw.encoder != nil {
+		return cw.encoder.Close()
+	}
+
+	return nil
+}
+
+// compressible reports whether a response with the header and status can be compressed.
+// Responses without a body, with a content encoding of their own and with media types which
+// are compressed formats already, such as poster images, are sent as they are.
+func compressible(header http.Header, statusCode int) bool {
+	if statusCode < http.StatusOK || statusCode == http.StatusNoContent || statusCode == http.StatusNotModified {
+		return false
+	}
+
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	contentType := header.Get("Content-Type")
+
+	for _, prefix := range []string{"image/", "video/", "audio/", "application/zip", "application/gzip"} {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// acceptedEncoding returns the compression the Accept-Encoding header of r prefers, gzip or
+// deflate, or an empty string when it accepts neither. Ties go to gzip.
+func acceptedEncoding(r *http.Request) string {
+	var gzipQ, deflateQ float64
+
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			q, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		switch strings.ToLower(strings.TrimSpace(coding)) {
+		case "gzip", "x-gzip":
+			gzipQ = max(gzipQ, q)
+		case "deflate":
+			deflateQ = max(deflateQ, q)
+		}
+	}
+
+	switch {
+	case gzipQ > 0 && gzipQ >= deflateQ:
+		return "gzip"
+	case deflateQ > 0:
+		return "deflate"
+	default:
+		return ""
+	}
+}
+
+// compress compresses response bodies of at least app.config.compression.minBytes for
+// clients which accept gzip or deflate. It must run outside recoverPanic, so that the error
+// response sent after a panic is written through it like any other.
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.compression.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := acceptedEncoding(r)
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressResponseWriter{ResponseWriter: w, encoding: encoding, minBytes: app.config.compression.minBytes}
+
+		next.ServeHTTP(cw, r)
+
+		err := cw.close()
+		if err != nil {
+			app.logError(r, err)
+		}
+	})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptedEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"br", ""},
+		{"gzip", "gzip"},
+		{"x-gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"GZIP;q=0.8, br", "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+			r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+
+			if got := acceptedEncoding(r); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// decodeBody returns the body of rr, decompressed according to its Content-Encoding.
+func decodeBody(t *testing.T, rr *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	var r io.Reader = rr.Body
+	var err error
+
+	switch rr.Header().Get("Content-Encoding") {
+	case "gzip":
+		r, err = gzip.NewReader(rr.Body)
+	case "deflate":
+		r, err = zlib.NewReader(rr.Body)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(body)
+}
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat(`{"title": "Heat"}`, 100)
+
+	tests := []struct {
+		name           string
+		method         string
+		acceptEncoding string
+		status         int
+		header         map[string]string
+		body           string
+		wantEncoding   string
+	}{
+		{"gzip", http.MethodGet, "gzip", http.StatusOK, nil, large, "gzip"},
+		{"deflate", http.MethodGet, "deflate", http.StatusOK, nil, large, "deflate"},
+		{"not accepted", http.MethodGet, "", http.StatusOK, nil, large, ""},
+		{"small", http.MethodGet, "gzip", http.StatusOK, nil, `{"title": "Heat"}`, ""},
+		{"not modified", http.MethodGet, "gzip", http.StatusNotModified, nil, "", ""},
+		{"head", http.MethodHead, "gzip", http.StatusOK, nil, "", ""},
+		{"image", http.MethodGet, "gzip", http.StatusOK, map[string]string{"Content-Type": "image/png"}, large, ""},
+		{"encoded already", http.MethodGet, "gzip", http.StatusOK, map[string]string{"Content-Encoding": "br"}, large, "br"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := newTestApplication(t)
+			app.config.compression.enabled = true
+			app.config.compression.minBytes = 1024
+
+			h := app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for key, value := range tt.header {
+					w.Header().Set(key, value)
+				}
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}))
+
+			r := httptest.NewRequest(tt.method, "/v1/movies", nil)
+			r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+
+			rr := serve(t, h, r)
+
+			if rr.Code != tt.status {
+				t.Errorf("got status %d; want %d", rr.Code, tt.status)
+			}
+			if got := rr.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("got Content-Encoding %q; want %q", got, tt.wantEncoding)
+			}
+			if got := rr.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("got Vary %q; want Accept-Encoding", got)
+			}
+			if tt.wantEncoding != "br" {
+				if got := decodeBody(t, rr); got != tt.body {
+					t.Errorf("got body of %d bytes; want the %d written", len(got), len(tt.body))
+				}
+			}
+		})
+	}
+}
+
+func TestCompressDisabled(t *testing.T) {
+	app, _ := newTestApplication(t)
+	app.config.compression.minBytes = 1
+
+	h := app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"title": "Heat"}`)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	rr := serve(t, h, r)
+	if rr.Header().Get("Content-Encoding") != "" || rr.Header().Get("Vary") != "" {
+		t.Errorf("got header %v; want the response left alone", rr.Header())
+	}
+}
+
+func TestCompressWrapsPanicResponses(t *testing.T) {
+	app, _ := newTestApplication(t)
+	app.config.compression.enabled = true
+	app.config.compression.minBytes = 10
+
+	h := app.compress(app.recoverPanic(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})))
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	rr := serve(t, h, r)
+
+	if rr.Code != http.StatusInternalServerError || rr.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("got status %d with Content-Encoding %q; want a compressed 500", rr.Code, rr.Header().Get("Content-Encoding"))
+	}
+	if got := decodeBody(t, rr); !strings.Contains(got, `"error"`) {
+		t.Errorf("got body %q; want the error response", got)
+	}
+}
+
+func TestCompressStreamsFlushedResponses(t *testing.T) {
+	app, _ := newTestApplication(t)
+	app.config.compression.enabled = true
+	app.config.compression.minBytes = 1024
+
+	h := app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "data: 1\n\n")
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("flush: %v", err)
+		}
+		io.WriteString(w, strings.Repeat("data: 2\n\n", 200))
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/events", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	rr := serve(t, h, r)
+
+	if rr.Header().Get("Content-Encoding") != "" || !strings.HasPrefix(rr.Body.String(), "data: 1\n\n") {
+		t.Errorf("got Content-Encoding %q; want the flushed stream sent uncompressed", rr.Header().Get("Content-Encoding"))
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/tomasen/realip"
+)
+
+// inFlight counts the requests in flight per key, allowing no more than max at once. Keys
+// are dropped as soon as their last request completes, so idle clients take up no memory.
+type inFlight struct {
+	max int
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newInFlight(max int) *inFlight {
+	return &inFlight{max: max, counts: make(map[string]int)}
+}
+
+func (f *inFlight) acquire(key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.counts[key] >= f.max {
+		return false
+	}
+
+	f.counts[key]++
+	return true
+}
+
+func (f *inFlight) release(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.counts[key] <= 1 {
+		delete(f.counts, key)
+		return
+	}
+
+	f.counts[key]--
+}
+
+// limitConcurrency rejects requests from a client IP which already has the configured
+// number of requests in flight. Unlike rateLimit, this catches clients which stay within
+// the rate but hold many slow requests open at once.
+func (app *application) limitConcurrency(next http.Handler) http.Handler {
+	if app.config.concurrency.perIP <= 0 {
+		return next
+	}
+
+	limiter := newInFlight(app.config.concurrency.perIP)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := realip.FromRequest(r)
+
+		if !limiter.acquire(ip) {
+			app.concurrencyLimitExceededResponse(w, r)
+			return
+		}
+		defer limiter.release(ip)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitUserConcurrency is limitConcurrency for authenticated users, so that a user cannot
+// get around the limit by sending requests from several IPs. It must run after authenticate.
+func (app *application) limitUserConcurrency(next http.Handler) http.Handler {
+	if app.config.concurrency.perUser <= 0 {
+		return next
+	}
+
+	limiter := newInFlight(app.config.concurrency.perUser)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+		if user.IsAnonymous() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := strconv.FormatInt(user.ID, 10)
+
+		if !limiter.acquire(key) {
+			app.concurrencyLimitExceededResponse(w, r)
+			return
+		}
+		defer limiter.release(key)
+
+		next.ServeHTTP(w, r)
+	})
+}
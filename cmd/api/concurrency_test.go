@@ -0,0 +1,125 @@
+package main
+
+import (
+	"greenlight/internal/data"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// holdingHandler keeps the requests of blocked clients, named by the X-Client header, in
+// flight until release is closed. Every other request completes straight away.
+func holdingHandler(blocked string, entered chan<- struct{}, release <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Client") == blocked {
+			entered <- struct{}{}
+			<-release
+		}
+	})
+}
+
+// saturate sends n requests made by build, which hold until release is closed, and waits
+// for all of them to be in flight.
+func saturate(t *testing.T, h http.Handler, n int, entered <-chan struct{}, build func() *http.Request) *sync.WaitGroup {
+	t.Helper()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rr := serve(t, h, build()); rr.Code != http.StatusOK {
+				t.Errorf("held request: got status %d; want %d", rr.Code, http.StatusOK)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		<-entered
+	}
+
+	return &wg
+}
+
+func TestConcurrencyLimitPerIP(t *testing.T) {
+	app, _ := newTestApplication(t)
+	app.config.concurrency.perIP = 2
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	h := app.limitConcurrency(holdingHandler("a", entered, release))
+
+	request := func(ip, client string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+		r.RemoteAddr = ip + ":1234"
+		r.Header.Set("X-Client", client)
+		return r
+	}
+
+	wg := saturate(t, h, 2, entered, func() *http.Request { return request("192.0.2.1", "a") })
+
+	if rr := serve(t, h, request("192.0.2.1", "other")); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("overflow: got status %d; want %d", rr.Code, http.StatusTooManyRequests)
+	}
+	if rr := serve(t, h, request("192.0.2.2", "other")); rr.Code != http.StatusOK {
+		t.Errorf("another IP: got status %d; want %d", rr.Code, http.StatusOK)
+	}
+
+	close(release)
+	wg.Wait()
+
+	// Once the held requests complete, the client may send more.
+	if rr := serve(t, h, request("192.0.2.1", "other")); rr.Code != http.StatusOK {
+		t.Errorf("after release: got status %d; want %d", rr.Code, http.StatusOK)
+	}
+}
+
+func TestConcurrencyLimitPerUser(t *testing.T) {
+	app, _ := newTestApplication(t)
+	app.config.concurrency.perUser = 1
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	h := app.limitUserConcurrency(holdingHandler("alice", entered, release))
+
+	alice := &data.User{ID: 1, Activated: true}
+	bob := &data.User{ID: 2, Activated: true}
+
+	request := func(user *data.User, client, ip string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+		r.RemoteAddr = ip + ":1234"
+		r.Header.Set("X-Client", client)
+		return asUser(app, r, user)
+	}
+
+	wg := saturate(t, h, 1, entered, func() *http.Request { return request(alice, "alice", "192.0.2.1") })
+
+	// The limit follows the user from one IP to another.
+	if rr := serve(t, h, request(alice, "other", "192.0.2.9")); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("overflow: got status %d; want %d", rr.Code, http.StatusTooManyRequests)
+	}
+	if rr := serve(t, h, request(bob, "other", "192.0.2.1")); rr.Code != http.StatusOK {
+		t.Errorf("another user: got status %d; want %d", rr.Code, http.StatusOK)
+	}
+	if rr := serve(t, h, request(data.AnonymousUser, "other", "192.0.2.1")); rr.Code != http.StatusOK {
+		t.Errorf("anonymous: got status %d; want %d", rr.Code, http.StatusOK)
+	}
+
+	close(release)
+	wg.Wait()
+}
+
+func TestInFlightDropsIdleKeys(t *testing.T) {
+	f := newInFlight(2)
+
+	if !f.acquire("a") || !f.acquire("a") || f.acquire("a") {
+		t.Fatal("got a limit other than 2 requests in flight")
+	}
+
+	f.release("a")
+	f.release("a")
+
+	if len(f.counts) != 0 {
+		t.Errorf("got counts %v; want idle keys dropped", f.counts)
+	}
+}
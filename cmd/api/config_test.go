@@ -0,0 +1,383 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testEnv returns a lookup function for an environment holding every required setting,
+// with overrides applied on top. An override of "" unsets the variable.
+func testEnv(overrides map[string]string) func(string) (string, bool) {
+	env := map[string]string{
+		"PORT":                      "4000",
+		"ENVIRONEMNT":               "development",
+		"POSTGRESQL_URL":            "postgres://greenlight@localhost/greenlight",
+		"POSTGRESQL_MAX_OPEN_CONNS": "25",
+		"POSTGRESQL_MAX_IDLE_CONNS": "25",
+		"POSTGRESQL_MAX_IDLE_TIME":  "15m",
+		"LIMITER_RPS":               "2",
+		"LIMITER_BURST":             "4",
+		"LIMITER_ENABLED":           "true",
+		"SMTP_HOST":                 "smtp.example.com",
+		"SMTP_PORT":                 "25",
+		"SMTP_USERNAME":             "greenlight",
+		"SMTP_PASSWORD":             "secret",
+		"SMTP_SENDER":               "Greenlight <no-reply@example.com>",
+		"IDEMPOTENCY_SECRET":        "secret",
+	}
+
+	for key, value := range overrides {
+		if value == "" {
+			delete(env, key)
+		} else {
+			env[key] = value
+		}
+	}
+
+	return func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := parseConfig(nil, testEnv(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.port != 4000 || cfg.env != "development" || cfg.smtp.host != "smtp.example.com" {
+		t.Errorf("got port %d, env %q, SMTP host %q", cfg.port, cfg.env, cfg.smtp.host)
+	}
+	if cfg.outbox.workers != 4 || cfg.auth.jwtTTL != 15*time.Minute {
+		t.Errorf("got EMAIL_WORKERS %d and JWT_TTL %s; want the defaults", cfg.outbox.workers, cfg.auth.jwtTTL)
+	}
+}
+
+func TestParseConfigFlagsOverrideEnvironment(t *testing.T) {
+	cfg, err := parseConfig([]string{"-PORT=8080", "-EMAIL_WORKERS", "8"}, testEnv(map[string]string{"EMAIL_WORKERS": "2"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.port != 8080 || cfg.outbox.workers != 8 {
+		t.Errorf("got port %d and EMAIL_WORKERS %d; want 8080 and 8", cfg.port, cfg.outbox.workers)
+	}
+}
+
+func TestParseConfigChecksFlags(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-JWT_TTL=0"}, "invalid JWT_TTL"},
+		{[]string{"-CONCURRENCY_LIMIT_PER_IP=-1"}, "invalid CONCURRENCY_LIMIT_PER_IP"},
+		{[]string{"-EMAIL_WORKERS=0"}, "invalid EMAIL_WORKERS"},
+		{[]string{"-TOKEN_AUTH_TTL=-1h"}, "TOKEN_AUTH_TTL must be positive"},
+		{[]string{"-LIMITER_KEY=cookie"}, "invalid LIMITER_KEY"},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			_, err := parseConfig(tt.args, testEnv(nil))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v; want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseConfigIgnoresEnvironmentOverriddenByFlags(t *testing.T) {
+	env := testEnv(map[string]string{
+		"JWT_TTL":                  "soon",
+		"CONCURRENCY_LIMIT_PER_IP": "-1",
+	})
+
+	_, err := parseConfig(nil, env)
+	if err == nil || !strings.Contains(err.Error(), "invalid JWT_TTL") || !strings.Contains(err.Error(), "invalid CONCURRENCY_LIMIT_PER_IP") {
+		t.Fatalf("got error %v; want both invalid settings reported", err)
+	}
+
+	cfg, err := parseConfig([]string{"-JWT_TTL=1h", "-CONCURRENCY_LIMIT_PER_IP=10"}, env)
+	if err != nil {
+		t.Fatalf("got error %v; want the flags to replace the invalid variables", err)
+	}
+
+	if cfg.auth.jwtTTL != time.Hour || cfg.concurrency.perIP != 10 {
+		t.Errorf("got JWT_TTL %s and CONCURRENCY_LIMIT_PER_IP %d", cfg.auth.jwtTTL, cfg.concurrency.perIP)
+	}
+}
+
+func TestParseConfigReportsEveryInvalidSetting(t *testing.T) {
+	_, err := parseConfig(nil, testEnv(map[string]string{
+		"PORT":          "",
+		"SMTP_HOST":     "",
+		"EMAIL_WORKERS": "none",
+	}))
+	if err == nil {
+		t.Fatal("got no error")
+	}
+
+	for _, want := range []string{"invalid port", "SMTP_HOST is not set", "invalid EMAIL_WORKERS none"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+}
+
+func TestParseConfigRequiresIdempotencySecret(t *testing.T) {
+	_, err := parseConfig(nil, testEnv(map[string]string{"IDEMPOTENCY_SECRET": ""}))
+	if err == nil || !strings.Contains(err.Error(), "IDEMPOTENCY_SECRET must be set for the postgres idempotency backend") {
+		t.Errorf("got error %v; want the missing secret reported", err)
+	}
+
+	if _, err := parseConfig(nil, testEnv(map[string]string{"IDEMPOTENCY_SECRET": "", "IDEMPOTENCY_BACKEND": "disabled"})); err != nil {
+		t.Errorf("got error %v; want no secret needed with idempotency disabled", err)
+	}
+}
+
+func TestParseConfigReportsMissingBackendSettings(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"exports bucket", map[string]string{"EXPORTS_S3_ENDPOINT": "https://s3.example.com"}, "EXPORTS_S3_BUCKET is not set"},
+		{"redis URL", map[string]string{"LIMITER_BACKEND": "redis"}, "REDIS_URL is not set"},
+		{"invalid redis URL", map[string]string{"LIMITER_BACKEND": "redis", "REDIS_URL": "http://localhost"}, "invalid REDIS_URL"},
+		{"posters bucket", map[string]string{"POSTERS_BACKEND": "s3"}, "POSTERS_S3_ENDPOINT and POSTERS_S3_BUCKET must be set"},
+		{"deprecated route", map[string]string{"ROUTE_DEPRECATIONS": "GET /v1/nowhere 2025-06-30"}, "invalid ROUTE_DEPRECATIONS no route GET /v1/nowhere"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseConfig(nil, testEnv(tt.env))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v; want it to contain %q", err, tt.want)
+			}
+		})
+	}
+
+	// Every problem is reported at once.
+	_, err := parseConfig(nil, testEnv(map[string]string{
+		"EXPORTS_S3_ENDPOINT": "https://s3.example.com",
+		"LIMITER_BACKEND":     "redis",
+		"POSTERS_BACKEND":     "s3",
+		"ROUTE_DEPRECATIONS":  "GET /v1/nowhere 2025-06-30",
+	}))
+	if err == nil {
+		t.Fatal("got no error")
+	}
+
+	for _, want := range []string{"EXPORTS_S3_BUCKET", "REDIS_URL", "POSTERS_S3_BUCKET", "ROUTE_DEPRECATIONS"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
+func TestParseConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	err := os.WriteFile(path, []byte(`{
+		"SMTP_HOST": "file.example.com",
+		"EMAIL_WORKERS": 6,
+		"LIMITER_ENABLED": false,
+		"CORS_TRUSTED_ORIGINS": ["https://a.example.com", "https://b.example.com"]
+	}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := testEnv(map[string]string{"SMTP_HOST": "", "LIMITER_ENABLED": "", "EMAIL_WORKERS": "3"})
+
+	cfg, err := parseConfig([]string{"-config", path}, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.smtp.host != "file.example.com" {
+		t.Errorf("got SMTP_HOST %q; want it from the file", cfg.smtp.host)
+	}
+	if cfg.outbox.workers != 3 {
+		t.Errorf("got EMAIL_WORKERS %d; want the environment to override the file", cfg.outbox.workers)
+	}
+	if cfg.limiter.enabled {
+		t.Error("got LIMITER_ENABLED true; want it from the file")
+	}
+	if len(cfg.cors.trustedOrigins) != 2 {
+		t.Errorf("got %d trusted origins; want 2", len(cfg.cors.trustedOrigins))
+	}
+
+	if _, ok := os.LookupEnv("SMTP_HOST"); ok {
+		t.Error("the config file changed the process environment")
+	}
+}
+
+func TestParseConfigFilePrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	err := os.WriteFile(path, []byte(`{"EMAIL_WORKERS": 6}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		env   string
+		flags []string
+		want  int
+	}{
+		{"file", "", nil, 6},
+		{"environment over file", "3", nil, 3},
+		{"flag over environment", "3", []string{"-EMAIL_WORKERS=9"}, 9},
+		{"flag over file", "", []string{"-EMAIL_WORKERS=9"}, 9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"-config", path}, tt.flags...)
+
+			cfg, err := parseConfig(args, testEnv(map[string]string{"EMAIL_WORKERS": tt.env}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if cfg.outbox.workers != tt.want {
+				t.Errorf("got EMAIL_WORKERS %d; want %d", cfg.outbox.workers, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseConfigFileUnknownSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	err := os.WriteFile(path, []byte(`{"SMTP_HOTS": "smtp.example.com", "A_TYPO": "1"}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = parseConfig([]string{"-config=" + path}, testEnv(nil))
+	if err == nil || !strings.Contains(err.Error(), "unknown setting A_TYPO in config file\nunknown setting SMTP_HOTS in config file") {
+		t.Errorf("got error %v; want both unknown settings in order", err)
+	}
+}
+
+func TestParseConfigNeverExplainsInProduction(t *testing.T) {
+	tests := []struct {
+		env  string
+		want bool
+	}{
+		{"development", true},
+		{"staging", true},
+		{"production", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			cfg, err := parseConfig(nil, testEnv(map[string]string{"ENVIRONEMNT": tt.env, "POSTGRESQL_EXPLAIN_SLOW_QUERIES": "true"}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if cfg.db.explainSlow != tt.want {
+				t.Errorf("got explainSlow %t; want %t", cfg.db.explainSlow, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseConfigChecksYearBounds(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides map[string]string
+		wantErr   string
+	}{
+		{"relative", map[string]string{"MOVIE_YEAR_MAX": "current+2"}, ""},
+		{"invalid", map[string]string{"MOVIE_YEAR_MAX": "later"}, "invalid MOVIE_YEAR_MAX"},
+		{"inverted", map[string]string{"MOVIE_YEAR_MIN": "current+1", "MOVIE_YEAR_MAX": "current"}, "is after MOVIE_YEAR_MAX"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseConfig(nil, testEnv(tt.overrides))
+
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("got error %q; want none", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("got error %v; want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseConfigTokenTTLs(t *testing.T) {
+	cfg, err := parseConfig(nil, testEnv(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.tokens.activationTTL != 72*time.Hour || cfg.tokens.authenticationTTL != 24*time.Hour || cfg.tokens.passwordResetTTL != 45*time.Minute {
+		t.Errorf("got token lifetimes %+v; want the defaults", cfg.tokens)
+	}
+
+	cfg, err = parseConfig(nil, testEnv(map[string]string{
+		"TOKEN_ACTIVATION_TTL":     "24h",
+		"TOKEN_AUTH_TTL":           "1h",
+		"TOKEN_PASSWORD_RESET_TTL": "10m",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.tokens.activationTTL != 24*time.Hour || cfg.tokens.authenticationTTL != time.Hour || cfg.tokens.passwordResetTTL != 10*time.Minute {
+		t.Errorf("got token lifetimes %+v; want the ones set", cfg.tokens)
+	}
+
+	_, err = parseConfig(nil, testEnv(map[string]string{
+		"TOKEN_ACTIVATION_TTL":     "0s",
+		"TOKEN_AUTH_TTL":           "a day",
+		"TOKEN_PASSWORD_RESET_TTL": "-5m",
+	}))
+
+	for _, want := range []string{"TOKEN_ACTIVATION_TTL must be positive", "invalid TOKEN_AUTH_TTL", "TOKEN_PASSWORD_RESET_TTL must be positive"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("got error %v; want it to contain %q", err, want)
+		}
+	}
+}
+
+func TestParseConfigMaxRequestBodyBytes(t *testing.T) {
+	cfg, err := parseConfig(nil, testEnv(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.requests.maxBodyBytes != 1_048_576 {
+		t.Errorf("got MAX_REQUEST_BODY_BYTES %d; want the 1MB default", cfg.requests.maxBodyBytes)
+	}
+
+	for _, value := range []string{"0", "-1", "1MB"} {
+		_, err := parseConfig(nil, testEnv(map[string]string{"MAX_REQUEST_BODY_BYTES": value}))
+		if err == nil || !strings.Contains(err.Error(), "MAX_REQUEST_BODY_BYTES") {
+			t.Errorf("%s: got error %v; want MAX_REQUEST_BODY_BYTES rejected", value, err)
+		}
+	}
+}
+
+func TestParseConfigCORS(t *testing.T) {
+	for _, config := range []map[string]string{
+		{"CORS_ALLOWED_METHODS": " "},
+		{"CORS_MAX_AGE": "-1m"},
+		{"CORS_ALLOW_CREDENTIALS": "sometimes"},
+	} {
+		if _, err := parseConfig(nil, testEnv(config)); err == nil || !strings.Contains(err.Error(), "CORS_") {
+			t.Errorf("%v: got error %v; want the setting rejected", config, err)
+		}
+	}
+}
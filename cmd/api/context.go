@@ -3,12 +3,18 @@ package main
 import (
 	"context"
 	"greenlight/internal/data"
+	"greenlight/internal/tracing"
 	"net/http"
 )
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey      = contextKey("user")
+	spanContextKey      = contextKey("span")
+	requestIDContextKey = contextKey("request_id")
+	routeContextKey     = contextKey("route")
+)
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -23,3 +29,40 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+func (app *application) contextSetSpan(r *http.Request, span *tracing.Span) *http.Request {
+	ctx := context.WithValue(r.Context(), spanContextKey, span)
+	return r.WithContext(ctx)
+}
+
+// contextGetSpan returns the span for the request, or nil when tracing is disabled.
+func (app *application) contextGetSpan(r *http.Request) *tracing.Span {
+	span, _ := r.Context().Value(spanContextKey).(*tracing.Span)
+	return span
+}
+
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestID returns the id of the request, or an empty string for requests which
+// did not pass through the requestID middleware.
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+// contextSetRouteHolder stores where the pattern of the route the request matches is to be
+// recorded, so that middleware running before the router can read it once it has run.
+func (app *application) contextSetRouteHolder(r *http.Request, holder *string) *http.Request {
+	ctx := context.WithValue(r.Context(), routeContextKey, holder)
+	return r.WithContext(ctx)
+}
+
+// contextSetRoute records the route the request matched, if a holder has been set.
+func (app *application) contextSetRoute(r *http.Request, route string) {
+	if holder, ok := r.Context().Value(routeContextKey).(*string); ok {
+		*holder = route
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// originPattern is a trusted CORS origin. An origin without a wildcard is matched exactly,
+// while a pattern such as https://*.example.com matches origins with the same scheme and
+// port whose host is one more label followed by the rest of the pattern's host. The
+// wildcard never matches a dot, so https://*.example.com matches https://pr-1.example.com
+// but neither https://a.b.example.com nor https://example.com.
+type originPattern struct {
+	exact  string
+	prefix string
+	suffix string
+}
+
+// parseTrustedOrigins parses a space separated list of origins and origin patterns.
+func parseTrustedOrigins(s string) ([]originPattern, error) {
+	var patterns []originPattern
+
+	for _, field := range strings.Fields(s) {
+		pattern, err := parseOriginPattern(field)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", field, err)
+		}
+
+		patterns = append(patterns, pattern)
+	}
+
+	return patterns, nil
+}
+
+// parseOriginPattern parses an origin, or a pattern with "*" as the first label of its
+// host. Patterns must name a scheme and at least two labels after the wildcard, so that
+// neither https://* nor https://*.com is accepted.
+func parseOriginPattern(s string) (originPattern, error) {
+	if !strings.Contains(s, "*") {
+		return originPattern{exact: s}, nil
+	}
+
+	s = strings.ToLower(s)
+
+	scheme, host, ok := strings.Cut(s, "://")
+	if !ok || scheme == "" || strings.ContainsFunc(scheme, func(c rune) bool { return !isASCIIAlnum(c) && c != '+' && c != '-' && c != '.' }) {
+		return originPattern{}, errors.New("must start with a scheme, such as https://")
+	}
+
+	rest, ok := strings.CutPrefix(host, "*.")
+	if !ok {
+		return originPattern{}, errors.New("wildcard must be the whole first label of the host")
+	}
+
+	domain, port, hasPort := strings.Cut(rest, ":")
+	if hasPort && (port == "" || strings.ContainsFunc(port, func(c rune) bool { return c < '0' || c > '9' })) {
+		return originPattern{}, errors.New("port must be a number")
+	}
+
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return originPattern{}, errors.New("wildcard must be followed by at least two labels")
+	}
+
+	for _, label := range labels {
+		if !validHostLabel(label) {
+			return originPattern{}, fmt.Errorf("invalid host label %q", label)
+		}
+	}
+
+	return originPattern{prefix: scheme + "://", suffix: "." + rest}, nil
+}
+
+// match reports whether origin is trusted by the pattern.
+func (p originPattern) match(origin string) bool {
+	if p.exact != "" {
+		return origin == p.exact
+	}
+
+	if len(origin) <= len(p.prefix)+len(p.suffix) || !strings.HasPrefix(origin, p.prefix) || !strings.HasSuffix(origin, p.suffix) {
+		return false
+	}
+
+	return validHostLabel(origin[len(p.prefix) : len(origin)-len(p.suffix)])
+}
+
+// validHostLabel reports whether label is a lowercase DNS label: letters, digits and
+// hyphens, not starting or ending with a hyphen, and at most 63 bytes long.
+func validHostLabel(label string) bool {
+	if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+		return false
+	}
+
+	for _, c := range label {
+		if c != '-' && (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isASCIIAlnum reports whether c is an ASCII letter or digit.
+func isASCIIAlnum(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
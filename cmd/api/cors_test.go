@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOriginPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		origin  string
+		want    bool
+	}{
+		{"https://app.example.com", "https://app.example.com", true},
+		{"https://app.example.com", "https://App.example.com", false},
+		{"https://app.example.com", "http://app.example.com", false},
+		{"https://*.app.example.com", "https://pr-123.app.example.com", true},
+		{"https://*.app.example.com", "https://app.example.com", false},
+		{"https://*.app.example.com", "https://.app.example.com", false},
+		{"https://*.app.example.com", "https://a.b.app.example.com", false},
+		{"https://*.app.example.com", "https://-pr.app.example.com", false},
+		{"https://*.app.example.com", "http://pr-123.app.example.com", false},
+		{"https://*.app.example.com", "https://evil.com?.app.example.com", false},
+		{"https://*.app.example.com", "https://evil.com/.app.example.com", false},
+		{"https://*.app.example.com", "https://evil.com#.app.example.com", false},
+		{"https://*.app.example.com", "https://user@x.app.example.com", false},
+		{"https://*.app.example.com", "https://pr-123.app.example.com.evil.com", false},
+		{"https://*.app.example.com", "https://pr-123.app.example.com:8443", false},
+		{"https://*.example.com:8443", "https://pr-123.example.com:8443", true},
+		{"HTTPS://*.Example.com", "https://pr-123.example.com", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.origin, func(t *testing.T) {
+			p, err := parseOriginPattern(tt.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := p.match(tt.origin); got != tt.want {
+				t.Errorf("got %t; want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOriginPatternRejectsBroadPatterns(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"*.example.com", "must start with a scheme"},
+		{"https://*", "wildcard must be the whole first label"},
+		{"https://*.com", "wildcard must be followed by at least two labels"},
+		{"https://pr-*.example.com", "wildcard must be the whole first label"},
+		{"https://app.*.example.com", "wildcard must be the whole first label"},
+		{"https://*.*.example.com", `invalid host label "*"`},
+		{"https://*.example.com:port", "port must be a number"},
+		{"https://*.exa_mple.com", `invalid host label "exa_mple"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			_, err := parseOriginPattern(tt.pattern)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v; want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseConfigCompilesTrustedOrigins(t *testing.T) {
+	cfg, err := parseConfig(nil, testEnv(map[string]string{"CORS_TRUSTED_ORIGINS": "https://app.example.com https://*.preview.example.com"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cfg.cors.trustedOrigins) != 2 || !cfg.cors.trustedOrigins[1].match("https://pr-1.preview.example.com") {
+		t.Errorf("got trusted origins %+v", cfg.cors.trustedOrigins)
+	}
+
+	_, err = parseConfig(nil, testEnv(map[string]string{"CORS_TRUSTED_ORIGINS": "https://*.com"}))
+	if err == nil || !strings.Contains(err.Error(), "invalid CORS_TRUSTED_ORIGINS") {
+		t.Errorf("got error %v; want the pattern rejected at startup", err)
+	}
+}
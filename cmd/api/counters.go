@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"expvar"
+)
+
+// Business activity counters, published on /debug/metrics alongside the HTTP metrics. They
+// count events since the process started; expvar.Int is updated atomically, so they are
+// safe to increment from any handler or background goroutine.
+var (
+	totalMoviesCreated   = expvar.NewInt("total_movies_created")
+	totalMoviesUpdated   = expvar.NewInt("total_movies_updated")
+	totalMoviesDeleted   = expvar.NewInt("total_movies_deleted")
+	totalUsersRegistered = expvar.NewInt("total_users_registered")
+	totalLogins          = expvar.NewInt("total_logins")
+	totalEmailsSent      = expvar.NewInt("total_emails_sent")
+	totalEmailsFailed    = expvar.NewInt("total_emails_failed")
+)
+
+// movieEventsDropped counts the movie events not delivered to event stream subscribers
+// which had fallen too far behind.
+var movieEventsDropped = expvar.NewInt("movie_events_dropped")
+
+// sendEmail sends an email through the mailer, counting whether it was delivered.
+func (app *application) sendEmail(ctx context.Context, recipient, templateFile string, data any) error {
+	err := app.mailer.Send(ctx, recipient, templateFile, data)
+	if err != nil {
+		totalEmailsFailed.Add(1)
+		return err
+	}
+
+	totalEmailsSent.Add(1)
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"greenlight/internal/mailer"
+	"greenlight/internal/sqlfake"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBusinessCountersCountConcurrentOperations(t *testing.T) {
+	app, clk := newConfiguredTestApplication(t, nil)
+
+	useTestDB(t, app, clk, func(query string, args []any) (*sqlfake.Result, error) {
+		switch {
+		case strings.Contains(query, "INSERT INTO movies"):
+			return movieRow(), nil
+		case strings.Contains(query, "WITH updated AS"):
+			return &sqlfake.Result{Rows: [][]any{{int64(2), testEpoch}}}, nil
+		case strings.HasPrefix(strings.TrimSpace(query), "DELETE FROM movies"):
+			return &sqlfake.Result{Rows: [][]any{{false}}}, nil
+		case strings.Contains(query, "SET deleted_at"):
+			return &sqlfake.Result{RowsAffected: 1}, nil
+		case strings.Contains(query, "FROM movies") && strings.Contains(query, "id = $1"):
+			return &sqlfake.Result{Rows: [][]any{{
+				int64(1), testEpoch, testEpoch, "Alien", "alien", int64(1979), int64(117), "{Horror}", int64(1), "public", int64(0), float64(0),
+			}}}, nil
+		}
+		return nil, nil
+	})
+
+	created, updated, deleted := totalMoviesCreated.Value(), totalMoviesUpdated.Value(), totalMoviesDeleted.Value()
+
+	operations := []struct {
+		count   int
+		handler http.HandlerFunc
+		method  string
+		body    string
+	}{
+		{20, app.createMovieHandler, http.MethodPost, `{"title": "Alien", "year": 1979, "runtime": "117 mins", "genres": ["Horror"]}`},
+		{10, app.updateMovieHandler, http.MethodPatch, `{"year": 1980}`},
+		{5, app.deleteMovieHandler, http.MethodDelete, ``},
+	}
+
+	var wg sync.WaitGroup
+
+	for _, op := range operations {
+		for range op.count {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				r := httptest.NewRequest(op.method, "/v1/movies/1", strings.NewReader(op.body))
+				rr := httptest.NewRecorder()
+				op.handler(rr, withParams(asUser(app, r, testUser), "id", "1"))
+
+				if rr.Code >= 300 {
+					t.Errorf("%s: got status %d: %s", op.method, rr.Code, rr.Body)
+				}
+			}()
+		}
+	}
+
+	wg.Wait()
+	app.wg.Wait()
+
+	counters := []struct {
+		name string
+		got  int64
+		want int64
+	}{
+		{"created", totalMoviesCreated.Value() - created, 20},
+		{"updated", totalMoviesUpdated.Value() - updated, 10},
+		{"deleted", totalMoviesDeleted.Value() - deleted, 5},
+	}
+
+	for _, c := range counters {
+		if c.got != c.want {
+			t.Errorf("got %d movies %s; want %d", c.got, c.name, c.want)
+		}
+	}
+}
+
+func TestSendEmailCountsFailures(t *testing.T) {
+	app, _ := newConfiguredTestApplication(t, nil)
+
+	// Nothing listens on port 1, so the send fails straight away.
+	app.mailer = mailer.New("127.0.0.1", 1, "", "", "Greenlight <no-reply@example.com>")
+	app.mailer.MaxAttempts = 1
+
+	sent, failed := totalEmailsSent.Value(), totalEmailsFailed.Value()
+
+	if err := app.sendEmail(context.Background(), "alice@example.com", "user_welcome.tmpl", map[string]any{"userID": 1}); err == nil {
+		t.Fatal("got nil error; want the send to fail")
+	}
+
+	if got := totalEmailsFailed.Value() - failed; got != 1 {
+		t.Errorf("got %d emails failed; want 1", got)
+	}
+	if got := totalEmailsSent.Value() - sent; got != 0 {
+		t.Errorf("got %d emails sent; want none", got)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+	"greenlight/internal/webhook"
+	"net/http"
+	"strconv"
+)
+
+// deadLetter stores a delivery which failed every attempt, so that it can be replayed. When
+// dead-lettering is disabled the failure has already been logged, and nothing is stored.
+func (app *application) deadLetter(letter *data.DeadLetter) error {
+	if !app.config.deadLetter.enabled {
+		return nil
+	}
+
+	err := app.models.DeadLetters.Insert(letter)
+	if err != nil {
+		return err
+	}
+
+	app.logger.PrintInfo("delivery dead-lettered", map[string]string{
+		"dead_letter_id": strconv.FormatInt(letter.ID, 10),
+		"kind":           letter.Kind,
+		"source_id":      strconv.FormatInt(letter.SourceID, 10),
+	})
+
+	return nil
+}
+
+func (app *application) listDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.DeadLetterFilter
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Kind = app.readString(qs, "kind", "")
+	input.SourceID = int64(app.readInt(qs, "source_id", 0, v))
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.SortSafeList = []string{"id", "-id"}
+
+	data.ValidateDeadLetterFilter(v, input.DeadLetterFilter)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	letters, metadata, err := app.models.DeadLetters.GetAll(input.DeadLetterFilter, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"dead_letters": letters, "metadata": app.paginationMetadata(r, metadata)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// replayDeadLettersHandler replays the dead letter with the given id, or every dead letter
+// matching the kind and source id, up to app.config.deadLetter.replayLimit at a time.
+// Emails are returned to the outbox and webhook deliveries are attempted again in the
+// background, and either is dead-lettered again if it still fails.
+func (app *application) replayDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ID       int64  `json:"id"`
+		Kind     string `json:"kind"`
+		SourceID int64  `json:"source_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	filter := data.DeadLetterFilter{ID: input.ID, Kind: input.Kind, SourceID: input.SourceID}
+
+	v := validator.New()
+
+	if data.ValidateDeadLetterFilter(v, filter); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	letters, err := app.models.DeadLetters.Take(filter, app.config.deadLetter.replayLimit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if filter.ID != 0 && len(letters) == 0 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var replayed []int64
+	var deliveries []*data.DeadLetter
+
+	for i, letter := range letters {
+		switch letter.Kind {
+		case data.DeadLetterEmail:
+			err = app.models.EmailOutbox.Requeue(letter.SourceID)
+			if err != nil {
+				if !errors.Is(err, data.ErrRecordNotFound) {
+					// Nothing has been delivered yet, so the webhook deliveries are put back
+					// along with the rest of the dead letters.
+					app.restoreDeadLetters(append(deliveries, letters[i:]...))
+					app.serverErrorResponse(w, r, err)
+					return
+				}
+
+				// The email has since been removed from the outbox, so there is nothing left
+				// to replay.
+				app.logger.PrintInfo("dead-lettered email no longer in the outbox", map[string]string{
+					"dead_letter_id": strconv.FormatInt(letter.ID, 10),
+				})
+				continue
+			}
+		case data.DeadLetterWebhook:
+			deliveries = append(deliveries, letter)
+		}
+
+		replayed = append(replayed, letter.ID)
+	}
+
+	if len(deliveries) > 0 {
+		app.background(func() {
+			for _, letter := range deliveries {
+				var event webhook.Event
+
+				err := json.Unmarshal(letter.Payload, &event)
+				if err != nil {
+					app.logger.PrintError(err, map[string]string{"dead_letter_id": strconv.FormatInt(letter.ID, 10)})
+					continue
+				}
+
+				app.deliverWebhook(letter.SourceID, letter.Target, event)
+			}
+		})
+	}
+
+	if replayed == nil {
+		replayed = []int64{}
+	}
+
+	err = app.writeResponse(w, r, http.StatusAccepted, envelope{"replayed": replayed}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// restoreDeadLetters puts back dead letters which were taken for a replay that failed.
+func (app *application) restoreDeadLetters(letters []*data.DeadLetter) {
+	for _, letter := range letters {
+		err := app.models.DeadLetters.Insert(letter)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"dead_letter_id": strconv.FormatInt(letter.ID, 10)})
+		}
+	}
+}
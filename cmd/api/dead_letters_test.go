@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"greenlight/internal/data"
+	"greenlight/internal/sqlfake"
+	"greenlight/internal/webhook"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// deadLetterStore answers the dead letter and outbox failure queries from memory.
+type deadLetterStore struct {
+	mu       sync.Mutex
+	letters  [][]any
+	attempts map[int64]int64
+	failed   map[int64]bool
+}
+
+func newDeadLetterStore() *deadLetterStore {
+	return &deadLetterStore{attempts: map[int64]int64{}, failed: map[int64]bool{}}
+}
+
+func (s *deadLetterStore) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.letters)
+}
+
+func (s *deadLetterStore) handle(query string, args []any) (*sqlfake.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case strings.Contains(query, "INSERT INTO dead_letters"):
+		id := int64(len(s.letters) + 1)
+		payload, _ := args[3].([]byte)
+		s.letters = append(s.letters, []any{id, testEpoch, args[0], args[1], args[2], payload, int64(args[4].(int)), args[5]})
+		return &sqlfake.Result{Rows: [][]any{{id, testEpoch}}}, nil
+
+	case strings.Contains(query, "DELETE FROM dead_letters"):
+		res := &sqlfake.Result{}
+		var kept [][]any
+		for _, letter := range s.letters {
+			if args[0] == int64(0) || letter[0] == args[0] {
+				res.Rows = append(res.Rows, letter)
+			} else {
+				kept = append(kept, letter)
+			}
+		}
+		s.letters = kept
+		return res, nil
+
+	case strings.Contains(query, "SET status = CASE"):
+		id := args[0].(int64)
+		s.attempts[id]++
+		s.failed[id] = s.attempts[id] >= int64(args[2].(int))
+		return &sqlfake.Result{Rows: [][]any{{s.failed[id], s.attempts[id]}}}, nil
+
+	case strings.Contains(query, "SET status = 'pending', attempts = 0"):
+		id := args[0].(int64)
+		if !s.failed[id] {
+			return nil, nil
+		}
+		s.failed[id], s.attempts[id] = false, 0
+		return &sqlfake.Result{RowsAffected: 1}, nil
+	}
+
+	return nil, nil
+}
+
+func replay(t *testing.T, app *application, body string) []int64 {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/admin/dead-letters/replay", strings.NewReader(body))
+
+	rr := serve(t, http.HandlerFunc(app.replayDeadLettersHandler), r)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("replay: got status %d; want %d: %s", rr.Code, http.StatusAccepted, rr.Body)
+	}
+
+	var out struct {
+		Replayed []int64 `json:"replayed"`
+	}
+
+	if err := json.NewDecoder(rr.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	return out.Replayed
+}
+
+func TestExhaustedWebhookDeliveriesAreDeadLetteredAndReplayed(t *testing.T) {
+	var up atomic.Bool
+	var received atomic.Int32
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		received.Add(1)
+	}))
+	defer target.Close()
+
+	app, clk := newConfiguredTestApplication(t, map[string]string{
+		"WEBHOOKS_MAX_ATTEMPTS":  "2",
+		"WEBHOOKS_RETRY_BACKOFF": "1ms",
+	})
+	app.webhooks = webhook.New(time.Second)
+
+	store := newDeadLetterStore()
+	useTestDB(t, app, clk, store.handle)
+
+	app.deliverWebhook(3, target.URL, webhook.Event{Type: data.EventMovieCreated, Timestamp: testEpoch, Data: map[string]any{"id": 1}})
+
+	if store.count() != 1 {
+		t.Fatalf("got %d dead letters; want the exhausted delivery", store.count())
+	}
+
+	letter := store.letters[0]
+	if letter[2] != data.DeadLetterWebhook || letter[3] != int64(3) || letter[4] != target.URL || letter[6] != int64(2) {
+		t.Errorf("got dead letter %v; want webhook 3 to the target after 2 attempts", letter)
+	}
+
+	// The target recovers, and the replay delivers the event.
+	up.Store(true)
+
+	if got := replay(t, app, `{"id": 1}`); len(got) != 1 || got[0] != 1 {
+		t.Errorf("got replayed %v; want [1]", got)
+	}
+
+	app.wg.Wait()
+
+	if received.Load() != 1 {
+		t.Errorf("got %d deliveries after the replay; want 1", received.Load())
+	}
+	if store.count() != 0 {
+		t.Errorf("got %d dead letters after a successful replay; want none", store.count())
+	}
+}
+
+func TestExhaustedEmailsAreDeadLetteredAndReplayed(t *testing.T) {
+	app, clk := newConfiguredTestApplication(t, map[string]string{"EMAIL_MAX_ATTEMPTS": "2"})
+
+	store := newDeadLetterStore()
+	useTestDB(t, app, clk, store.handle)
+
+	email := &data.OutboxEmail{ID: 7, Template: "user_welcome.tmpl"}
+	sendErr := errors.New("smtp: connection refused")
+
+	app.outboxEmailSent(email, sendErr)
+	if store.count() != 0 {
+		t.Fatal("got the email dead-lettered with attempts left")
+	}
+
+	app.outboxEmailSent(email, sendErr)
+	if store.count() != 1 {
+		t.Fatalf("got %d dead letters; want the exhausted email", store.count())
+	}
+
+	if letter := store.letters[0]; letter[2] != data.DeadLetterEmail || letter[3] != int64(7) || letter[7] != sendErr.Error() {
+		t.Errorf("got dead letter %v; want email 7 with its last error", letter)
+	}
+
+	if got := replay(t, app, `{"kind": "email"}`); len(got) != 1 {
+		t.Errorf("got replayed %v; want the email", got)
+	}
+
+	if store.failed[7] || store.attempts[7] != 0 || store.count() != 0 {
+		t.Error("the replayed email was not returned to the outbox with its attempts reset")
+	}
+}
+
+func TestDeadLettersCanBeDisabled(t *testing.T) {
+	app, clk := newConfiguredTestApplication(t, map[string]string{"EMAIL_MAX_ATTEMPTS": "1", "DEAD_LETTER_ENABLED": "false"})
+
+	store := newDeadLetterStore()
+	useTestDB(t, app, clk, store.handle)
+
+	app.outboxEmailSent(&data.OutboxEmail{ID: 7}, errors.New("smtp: connection refused"))
+
+	if store.count() != 0 {
+		t.Errorf("got %d dead letters; want none when disabled", store.count())
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// deprecation marks a route as deprecated. The route keeps working, but every response
+// carries headers announcing when it will be removed and what replaces it.
+type deprecation struct {
+	method      string
+	pattern     string
+	sunset      time.Time
+	replacement string
+}
+
+// parseDeprecations parses a list of deprecations separated by semicolons, each of the form
+// "METHOD PATTERN SUNSET [REPLACEMENT]" where SUNSET is a date such as 2025-06-30, e.g.
+//
+//	GET /v1/users/me/searches 2025-06-30 /v2/searches; DELETE /v1/movies/:id 2025-09-01
+func parseDeprecations(s string) ([]deprecation, error) {
+	var deprecations []deprecation
+
+	for _, entry := range strings.Split(s, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if len(fields) < 3 || len(fields) > 4 {
+			return nil, fmt.Errorf("%q must be of the form METHOD PATTERN SUNSET [REPLACEMENT]", strings.TrimSpace(entry))
+		}
+
+		sunset, err := time.Parse(time.DateOnly, fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("%q has an invalid sunset date: %w", strings.TrimSpace(entry), err)
+		}
+
+		d := deprecation{method: strings.ToUpper(fields[0]), pattern: fields[1], sunset: sunset}
+		if len(fields) == 4 {
+			d.replacement = fields[3]
+		}
+
+		deprecations = append(deprecations, d)
+	}
+
+	return deprecations, nil
+}
+
+// deprecationFor returns the configured deprecation of the route, if there is one.
+func (app *application) deprecationFor(rt route) (deprecation, bool) {
+	for _, d := range app.config.deprecations {
+		if d.method == rt.method && d.pattern == rt.pattern {
+			return d, true
+		}
+	}
+
+	return deprecation{}, false
+}
+
+func (app *application) deprecated(d deprecation, next http.HandlerFunc) http.HandlerFunc {
+	sunset := d.sunset.UTC().Format(http.TimeFormat)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Sunset", sunset)
+
+		if d.replacement != "" {
+			w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", d.replacement))
+		}
+
+		next(w, r)
+	}
+}
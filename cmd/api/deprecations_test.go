@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseDeprecations(t *testing.T) {
+	got, err := parseDeprecations("get /v1/users/me/searches 2025-06-30 /v2/searches; ; DELETE /v1/movies/:id 2025-09-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []deprecation{
+		{method: "GET", pattern: "/v1/users/me/searches", sunset: time.Date(2025, time.June, 30, 0, 0, 0, 0, time.UTC), replacement: "/v2/searches"},
+		{method: "DELETE", pattern: "/v1/movies/:id", sunset: time.Date(2025, time.September, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d deprecations; want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].method != want[i].method || got[i].pattern != want[i].pattern || !got[i].sunset.Equal(want[i].sunset) || got[i].replacement != want[i].replacement {
+			t.Errorf("deprecation %d: got %+v; want %+v", i, got[i], want[i])
+		}
+	}
+
+	for _, invalid := range []string{"GET /v1/movies", "GET /v1/movies soon", "GET /v1/movies 2025-06-30 /v2/movies extra"} {
+		if _, err := parseDeprecations(invalid); err == nil {
+			t.Errorf("parseDeprecations(%q): got nil error", invalid)
+		}
+	}
+}
+
+func TestDeprecatedRoutesAnnounceTheirSunset(t *testing.T) {
+	app, _ := newConfiguredTestApplication(t, map[string]string{"ROUTE_DEPRECATIONS": "GET /v1/users/me/searches 2025-06-30 /v2/searches"})
+
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+
+	tests := []struct {
+		route      route
+		deprecated bool
+	}{
+		{route{method: http.MethodGet, pattern: "/v1/users/me/searches"}, true},
+		{route{method: http.MethodPost, pattern: "/v1/users/me/searches"}, false},
+		{route{method: http.MethodGet, pattern: "/v1/movies"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.route.method+" "+tt.route.pattern, func(t *testing.T) {
+			handler := http.HandlerFunc(ok)
+			if d, found := app.deprecationFor(tt.route); found {
+				handler = app.deprecated(d, handler)
+			}
+
+			rr := serve(t, handler, httptest.NewRequest(tt.route.method, tt.route.pattern, nil))
+
+			if rr.Code != http.StatusOK {
+				t.Errorf("got status %d; want the request still served", rr.Code)
+			}
+
+			headers := map[string]string{
+				"Deprecation": "true",
+				"Sunset":      "Mon, 30 Jun 2025 00:00:00 GMT",
+				"Link":        `</v2/searches>; rel="successor-version"`,
+			}
+			for name, want := range headers {
+				if !tt.deprecated {
+					want = ""
+				}
+				if got := rr.Header().Get(name); got != want {
+					t.Errorf("got %s %q; want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// enqueueUserEmail adds an email of the given category for the user to the outbox. Unless
+// the email is essential, it is skipped when the user has opted out of the category, and
+// otherwise gets an "unsubscribeURL" template value for its unsubscribe link.
+func (app *application) enqueueUserEmail(user *data.User, category, templateFile string, templateData map[string]any) error {
+	if category == data.EmailEssential || !app.config.emailPrefs.enabled {
+		return app.enqueueEmail(user.ID, user.Email, templateFile, templateData)
+	}
+
+	prefs, err := app.models.EmailPrefs.Get(user.ID)
+	if err != nil {
+		return err
+	}
+
+	if !prefs.Allows(category) {
+		app.logger.PrintInfo("email skipped by preferences", map[string]string{"template": templateFile, "category": category})
+		return nil
+	}
+
+	token, err := app.models.Tokens.New(user.ID, app.config.emailPrefs.unsubscribeTTL, data.ScopeUnsubscribe)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	query.Set("token", token.Plaintext)
+	query.Set("category", category)
+
+	templateData["unsubscribeURL"] = strings.TrimRight(app.config.proxy.externalBaseURL, "/") + "/v1/email/unsubscribe?" + query.Encode()
+
+	return app.enqueueEmail(user.ID, user.Email, templateFile, templateData)
+}
+
+func (app *application) showEmailPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	prefs, err := app.models.EmailPrefs.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"email_preferences": prefs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateEmailPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Digests       *bool `json:"digests"`
+		Notifications *bool `json:"notifications"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Digests != nil, "digests", "must be provided")
+	v.Check(input.Notifications != nil, "notifications", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	prefs := &data.EmailPreferences{Digests: *input.Digests, Notifications: *input.Notifications}
+
+	err = app.models.EmailPrefs.Upsert(user.ID, prefs)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"email_preferences": prefs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// unsubscribeHandler opts the owner of the unsubscribe token out of a category of emails, or
+// of every non-essential email when no category is given. It is a GET request so that it can
+// be followed straight from the link in an email.
+func (app *application) unsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	tokenPlaintext := qs.Get("token")
+	category := qs.Get("category")
+
+	v := validator.New()
+
+	data.ValidateTokenPlaintext(v, tokenPlaintext)
+	v.Check(category == "" || validator.PermittedValue(category, data.EmailDigests, data.EmailNotifications), "category", "invalid category value")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeUnsubscribe, tokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired unsubscribe token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	prefs, err := app.models.EmailPrefs.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	switch category {
+	case data.EmailDigests:
+		prefs.Digests = false
+	case data.EmailNotifications:
+		prefs.Notifications = false
+	default:
+		prefs.Digests, prefs.Notifications = false, false
+	}
+
+	err = app.models.EmailPrefs.Upsert(user.ID, prefs)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "you have been unsubscribed", "email_preferences": prefs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
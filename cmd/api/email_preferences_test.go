@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"greenlight/internal/data"
+	"greenlight/internal/sqlfake"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// emailPrefsStore answers the email preference, token and outbox queries from memory.
+type emailPrefsStore struct {
+	mu     sync.Mutex
+	prefs  *data.EmailPreferences
+	tokens [][]byte
+	queued []map[string]any
+}
+
+func (s *emailPrefsStore) handle(query string, args []any) (*sqlfake.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case strings.Contains(query, "FROM email_preferences"):
+		if s.prefs == nil {
+			return nil, nil
+		}
+		return &sqlfake.Result{Rows: [][]any{{s.prefs.Digests, s.prefs.Notifications}}}, nil
+
+	case strings.Contains(query, "INSERT INTO email_preferences"):
+		s.prefs = &data.EmailPreferences{Digests: args[1].(bool), Notifications: args[2].(bool)}
+
+	case strings.Contains(query, "INSERT INTO tokens"):
+		s.tokens = append(s.tokens, args[0].([]byte))
+
+	case strings.Contains(query, "INNER JOIN tokens"):
+		for _, hash := range s.tokens {
+			if bytes.Equal(hash, args[0].([]byte)) && args[1] == data.ScopeUnsubscribe {
+				return &sqlfake.Result{Rows: [][]any{{int64(1), testEpoch, "Alice", "alice@example.com", []byte("hash"), true, int64(1)}}}, nil
+			}
+		}
+
+	case strings.Contains(query, "INSERT INTO email_outbox"):
+		var templateData map[string]any
+		if err := json.Unmarshal(args[3].([]byte), &templateData); err != nil {
+			return nil, err
+		}
+		templateData["template"] = args[2]
+		s.queued = append(s.queued, templateData)
+	}
+
+	return nil, nil
+}
+
+func TestNonEssentialEmailsRespectPreferences(t *testing.T) {
+	optedOut := &data.EmailPreferences{Digests: false, Notifications: true}
+
+	tests := []struct {
+		name     string
+		enabled  string
+		prefs    *data.EmailPreferences
+		category string
+		wantSent bool
+		wantLink bool
+	}{
+		{"essential while opted out", "true", &data.EmailPreferences{}, data.EmailEssential, true, false},
+		{"opted out", "true", optedOut, data.EmailDigests, false, false},
+		{"opted in", "true", optedOut, data.EmailNotifications, true, true},
+		{"never set", "true", nil, data.EmailDigests, true, true},
+		{"preferences disabled", "false", optedOut, data.EmailDigests, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, clk := newConfiguredTestApplication(t, map[string]string{
+				"EMAIL_PREFERENCES_ENABLED": tt.enabled,
+				"EXTERNAL_BASE_URL":         "https://api.example.com",
+			})
+
+			store := &emailPrefsStore{prefs: tt.prefs}
+			useTestDB(t, app, clk, store.handle)
+
+			err := app.enqueueUserEmail(testUser, tt.category, "user_welcome.tmpl", map[string]any{"ID": testUser.ID})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if sent := len(store.queued) == 1; sent != tt.wantSent {
+				t.Fatalf("got %d emails queued; want sent %t", len(store.queued), tt.wantSent)
+			}
+			if !tt.wantSent {
+				return
+			}
+
+			link, ok := store.queued[0]["unsubscribeURL"].(string)
+			if ok != tt.wantLink {
+				t.Fatalf("got unsubscribe link %q; want one %t", link, tt.wantLink)
+			}
+			if !tt.wantLink {
+				return
+			}
+
+			u, err := url.Parse(link)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.Host != "api.example.com" || u.Path != "/v1/email/unsubscribe" || u.Query().Get("category") != tt.category {
+				t.Errorf("got unsubscribe link %q", link)
+			}
+
+			hash := sha256.Sum256([]byte(u.Query().Get("token")))
+			if len(store.tokens) != 1 || !bytes.Equal(store.tokens[0], hash[:]) {
+				t.Errorf("the unsubscribe link does not carry the token which was stored")
+			}
+		})
+	}
+}
+
+func TestUnsubscribeFlipsThePreference(t *testing.T) {
+	tests := []struct {
+		name     string
+		category string
+		want     data.EmailPreferences
+	}{
+		{"digests", data.EmailDigests, data.EmailPreferences{Digests: false, Notifications: true}},
+		{"notifications", data.EmailNotifications, data.EmailPreferences{Digests: true, Notifications: false}},
+		{"everything", "", data.EmailPreferences{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, clk := newConfiguredTestApplication(t, nil)
+
+			store := &emailPrefsStore{}
+			useTestDB(t, app, clk, store.handle)
+
+			token, err := app.models.Tokens.New(testUser.ID, time.Hour, data.ScopeUnsubscribe)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			query := url.Values{"token": {token.Plaintext}}
+			if tt.category != "" {
+				query.Set("category", tt.category)
+			}
+
+			rr := serve(t, http.HandlerFunc(app.unsubscribeHandler), httptest.NewRequest(http.MethodGet, "/v1/email/unsubscribe?"+query.Encode(), nil))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+			}
+
+			if store.prefs == nil || *store.prefs != tt.want {
+				t.Errorf("got preferences %+v; want %+v", store.prefs, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnsubscribeRejectsUnknownTokens(t *testing.T) {
+	app, clk := newConfiguredTestApplication(t, nil)
+
+	store := &emailPrefsStore{}
+	useTestDB(t, app, clk, store.handle)
+
+	for _, query := range []string{"token=ABCDEFGHIJKLMNOPQRSTUVWXYZ", "token=short", "token=ABCDEFGHIJKLMNOPQRSTUVWXYZ&category=essential"} {
+		rr := serve(t, http.HandlerFunc(app.unsubscribeHandler), httptest.NewRequest(http.MethodGet, "/v1/email/unsubscribe?"+query, nil))
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: got status %d; want %d", query, rr.Code, http.StatusUnprocessableEntity)
+		}
+	}
+
+	if store.prefs != nil {
+		t.Errorf("got preferences %+v; want them unchanged", store.prefs)
+	}
+}
+
+func TestUpdateEmailPreferences(t *testing.T) {
+	app, clk := newConfiguredTestApplication(t, nil)
+
+	store := &emailPrefsStore{}
+	useTestDB(t, app, clk, store.handle)
+
+	update := func(body string) int {
+		r := asUser(app, httptest.NewRequest(http.MethodPut, "/v1/users/me/email-preferences", strings.NewReader(body)), testUser)
+		return serve(t, http.HandlerFunc(app.updateEmailPreferencesHandler), r).Code
+	}
+
+	if got := update(`{"digests": false}`); got != http.StatusUnprocessableEntity {
+		t.Errorf("partial update: got status %d; want %d", got, http.StatusUnprocessableEntity)
+	}
+
+	if got := update(`{"digests": false, "notifications": true}`); got != http.StatusOK {
+		t.Fatalf("got status %d; want %d", got, http.StatusOK)
+	}
+
+	rr := serve(t, http.HandlerFunc(app.showEmailPreferencesHandler), asUser(app, httptest.NewRequest(http.MethodGet, "/v1/users/me/email-preferences", nil), testUser))
+	if !strings.Contains(rr.Body.String(), `"digests":false`) || !strings.Contains(rr.Body.String(), `"notifications":true`) {
+		t.Errorf("got body %s; want the updated preferences", rr.Body)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"greenlight/internal/data"
+	"greenlight/internal/metadata"
+)
+
+// enrichMovie fills in the runtime and genres of the movie from the metadata provider when
+// they are missing, and returns a record of the fields it filled. A provider which is not
+// configured, unavailable or does not know the movie is not an error: the movie is left as
+// it was, and validation reports whatever is still missing. Genres from the provider are
+// mapped onto the taxonomy, and dropped when unknown in reject mode.
+func (app *application) enrichMovie(ctx context.Context, movie *data.Movie, taxonomy data.Taxonomy) *data.MetadataSource {
+	if app.metadata == nil || movie.Title == "" || (movie.Runtime != 0 && len(movie.Genres) > 0) {
+		return nil
+	}
+
+	result, err := app.metadata.Lookup(ctx, movie.Title, movie.Year)
+	if err != nil {
+		if !errors.Is(err, metadata.ErrNotFound) {
+			app.logger.PrintError(err, map[string]string{"provider": app.metadata.Name()})
+		}
+		return nil
+	}
+
+	var fields []string
+
+	if movie.Runtime == 0 && result.Runtime > 0 {
+		movie.Runtime = data.Runtime(result.Runtime)
+		fields = append(fields, "runtime")
+	}
+
+	if len(movie.Genres) == 0 && len(result.Genres) > 0 {
+		genres := app.normalizeGenres(nil, taxonomy, result.Genres)
+		movie.Genres = genres[:min(len(genres), data.MaxGenres)]
+		fields = append(fields, "genres")
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	return &data.MetadataSource{Provider: app.metadata.Name(), Fields: fields}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"greenlight/internal/data"
+	"greenlight/internal/metadata"
+	"greenlight/internal/sqlfake"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// stubProvider answers every lookup with result, or fails with err.
+type stubProvider struct {
+	result  *metadata.Result
+	err     error
+	lookups int
+}
+
+func (p *stubProvider) Name() string { return "stub" }
+
+func (p *stubProvider) Lookup(ctx context.Context, title string, year int32) (*metadata.Result, error) {
+	p.lookups++
+	return p.result, p.err
+}
+
+func TestCreateMovieEnrichment(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		body        string
+		provider    *stubProvider
+		wantStatus  int
+		wantRuntime int32
+		wantGenres  []string
+		wantSource  []string
+	}{
+		{
+			name:        "fills blanks",
+			url:         "/v1/movies?enrich=true",
+			body:        `{"title": "Arrival", "year": 2016}`,
+			provider:    &stubProvider{result: &metadata.Result{Runtime: 116, Genres: []string{"drama", "science fiction"}}},
+			wantStatus:  http.StatusCreated,
+			wantRuntime: 116,
+			wantGenres:  []string{"Drama", "Science Fiction"},
+			wantSource:  []string{"runtime", "genres"},
+		},
+		{
+			name:        "keeps given fields",
+			url:         "/v1/movies?enrich=true",
+			body:        `{"title": "Arrival", "year": 2016, "genres": ["Mystery"]}`,
+			provider:    &stubProvider{result: &metadata.Result{Runtime: 116, Genres: []string{"Drama"}}},
+			wantStatus:  http.StatusCreated,
+			wantRuntime: 116,
+			wantGenres:  []string{"Mystery"},
+			wantSource:  []string{"runtime"},
+		},
+		{
+			name:       "not asked for",
+			url:        "/v1/movies",
+			body:       `{"title": "Arrival", "year": 2016}`,
+			provider:   &stubProvider{result: &metadata.Result{Runtime: 116, Genres: []string{"Drama"}}},
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "provider down with blanks",
+			url:        "/v1/movies?enrich=true",
+			body:       `{"title": "Arrival", "year": 2016}`,
+			provider:   &stubProvider{err: errors.New("connection refused")},
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:        "provider down",
+			url:         "/v1/movies?enrich=true",
+			body:        `{"title": "Arrival", "year": 2016, "genres": ["Drama"], "runtime": "116 mins"}`,
+			provider:    &stubProvider{err: errors.New("connection refused")},
+			wantStatus:  http.StatusCreated,
+			wantRuntime: 116,
+			wantGenres:  []string{"Drama"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, clk := newConfiguredTestApplication(t, nil)
+			app.metadata = tt.provider
+
+			var inserted []any
+			var source []string
+
+			useTestDB(t, app, clk, func(query string, args []any) (*sqlfake.Result, error) {
+				switch {
+				case strings.Contains(query, "INSERT INTO movies"):
+					inserted = args
+					return movieRow(), nil
+				case strings.Contains(query, "INSERT INTO movie_metadata_sources"):
+					source = args[2].([]string)
+					return &sqlfake.Result{Rows: [][]any{{int64(1), testEpoch}}}, nil
+				}
+				return nil, nil
+			})
+
+			r := asUser(app, httptest.NewRequest(http.MethodPost, tt.url, strings.NewReader(tt.body)), testUser)
+
+			rr := serve(t, http.HandlerFunc(app.createMovieHandler), r)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d; want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+
+			if !strings.Contains(tt.url, "enrich=true") && tt.provider.lookups != 0 {
+				t.Errorf("got %d lookups; want none without enrich", tt.provider.lookups)
+			}
+
+			if tt.wantStatus != http.StatusCreated {
+				return
+			}
+
+			if runtime := inserted[2].(data.Runtime); int32(runtime) != tt.wantRuntime {
+				t.Errorf("stored runtime %d; want %d", runtime, tt.wantRuntime)
+			}
+			if genres := inserted[3].([]string); !slices.Equal(genres, tt.wantGenres) {
+				t.Errorf("stored genres %q; want %q", genres, tt.wantGenres)
+			}
+			if !slices.Equal(source, tt.wantSource) {
+				t.Errorf("recorded fields %q; want %q", source, tt.wantSource)
+			}
+		})
+	}
+}
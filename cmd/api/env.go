@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// settings looks up configuration values by their environment variable names, in the flags
+// given first, then in the environment and last in the settings of the config file.
+type settings struct {
+	flags     map[string]string
+	lookupEnv func(string) (string, bool)
+	file      map[string]string
+}
+
+// Get returns the value of the setting key, or "" if it is not set.
+func (s settings) Get(key string) string {
+	if value, ok := s.flags[key]; ok {
+		return value
+	}
+
+	if value, ok := s.lookupEnv(key); ok {
+		return value
+	}
+
+	return s.file[key]
+}
+
+// String returns the value of the setting key, or fallback if it is not set.
+func (s settings) String(key, fallback string) string {
+	value := s.Get(key)
+	if value == "" {
+		return fallback
+	}
+
+	return value
+}
+
+// Int returns the value of the setting key parsed as an integer, or fallback if it is not
+// set.
+func (s settings) Int(key string, fallback int) (int, error) {
+	value := s.Get(key)
+	if value == "" {
+		return fallback, nil
+	}
+
+	return strconv.Atoi(value)
+}
+
+// Bool returns the value of the setting key parsed as a boolean, or fallback if it is not
+// set.
+func (s settings) Bool(key string, fallback bool) (bool, error) {
+	value := s.Get(key)
+	if value == "" {
+		return fallback, nil
+	}
+
+	return strconv.ParseBool(value)
+}
+
+// Float returns the value of the setting key parsed as a float, or fallback if it is not
+// set.
+func (s settings) Float(key string, fallback float64) (float64, error) {
+	value := s.Get(key)
+	if value == "" {
+		return fallback, nil
+	}
+
+	return strconv.ParseFloat(value, 64)
+}
+
+// Duration returns the value of the setting key parsed as a duration, or fallback if it is
+// not set.
+func (s settings) Duration(key string, fallback time.Duration) (time.Duration, error) {
+	value := s.Get(key)
+	if value == "" {
+		return fallback, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// configFileArg returns the value of the -config flag in args, which has to be known before
+// the other flags are defined, as the config file provides their defaults.
+func configFileArg(args []string) string {
+	for i, arg := range args {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "config" {
+			continue
+		}
+
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+
+	return ""
+}
+
+// loadConfigFile reads a JSON object of settings keyed by their environment variable names
+// from path. Values may be strings, numbers, booleans or arrays of strings, which are joined
+// with spaces like the space separated variables expect. It returns nothing when path is
+// empty.
+func loadConfigFile(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	js, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file map[string]any
+
+	err = json.Unmarshal(js, &file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	settings := make(map[string]string, len(file))
+
+	for name, value := range file {
+		switch value := value.(type) {
+		case string:
+			settings[name] = value
+		case float64:
+			settings[name] = strconv.FormatFloat(value, 'f', -1, 64)
+		case bool:
+			settings[name] = strconv.FormatBool(value)
+		case []any:
+			items := make([]string, len(value))
+			for i, item := range value {
+				str, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("%s: %s must be an array of strings", path, name)
+				}
+				items[i] = str
+			}
+			settings[name] = strings.Join(items, " ")
+		default:
+			return nil, fmt.Errorf("%s: %s must be a string, number, boolean or array of strings", path, name)
+		}
+	}
+
+	return settings, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import "net/http"
+
+// errorCode describes one of the machine readable codes in error responses. Retriable
+// codes are for failures which may go away if the same request is sent again later.
+type errorCode struct {
+	Code        string `json:"code"`
+	Status      int    `json:"status"`
+	Description string `json:"description"`
+	Retriable   bool   `json:"retriable"`
+}
+
+// errorCatalog lists every error code the API can respond with. A code must be added here
+// when its constant is added to errors.go, so that the catalog served to clients stays
+// complete.
+func (app *application) errorCatalog() []errorCode {
+	return []errorCode{
+		{errCodeServerError, http.StatusInternalServerError, "The server encountered an unexpected problem.", false},
+		{errCodeDependencyUnavailable, app.config.dependencyErrorStatus, "A service the API depends on, such as the database, is unavailable.", true},
+		{errCodeWriteUnavailable, http.StatusServiceUnavailable, "The primary database cannot accept changes at the moment. Reads still work.", true},
+		{errCodeClientCancelled, statusClientClosedRequest, "The client disconnected before the response was written. Only ever logged, as no body is sent.", true},
+		{errCodeRequestTimeout, http.StatusServiceUnavailable, "The server took too long to process the request.", true},
+		{errCodeNotFound, http.StatusNotFound, "The requested resource does not exist, or is not visible to the user.", false},
+		{errCodeMethodNotAllowed, http.StatusMethodNotAllowed, "The resource does not support the request method.", false},
+		{errCodeBadRequest, http.StatusBadRequest, "The request could not be parsed, for example because of badly-formed JSON.", false},
+		{errCodeRequestTooLarge, http.StatusRequestEntityTooLarge, "The request body exceeds the maximum size, MAX_REQUEST_BODY_BYTES for JSON bodies.", false},
+		{errCodeValidationFailed, http.StatusUnprocessableEntity, "The request was understood but some of its values are invalid. The error holds a message per field.", false},
+		{errCodeDeepOffset, http.StatusBadRequest, "The requested page is too deep for offset pagination. Use the cursor parameter instead.", false},
+		{errCodeResponseTooLarge, http.StatusRequestEntityTooLarge, "The response would exceed the maximum size. Request a smaller page_size.", false},
+		{errCodeNotAcceptable, http.StatusNotAcceptable, "The response could not be written as the XML the client asked for. Always sent as JSON.", false},
+		{errCodeEditConflict, http.StatusConflict, "The record was changed by another request. Fetch it again and reapply the change.", true},
+		{errCodePreconditionFailed, http.StatusPreconditionFailed, "The record no longer has the version named by the If-Match header. Fetch it again and reapply the change.", false},
+		{errCodeReindexInProgress, http.StatusConflict, "A search reindex is already running.", true},
+		{errCodeIdempotencyInProgress, http.StatusConflict, "A request with the same Idempotency-Key is still being processed.", true},
+		{errCodeIdempotencyKeyReused, http.StatusUnprocessableEntity, "The Idempotency-Key was already used for a different request. Use a new key for each request.", false},
+		{errCodeRateLimitExceeded, http.StatusTooManyRequests, "The client has sent too many requests, and should retry after the Retry-After header.", true},
+		{errCodeConcurrencyExceeded, http.StatusTooManyRequests, "The client or user already has too many requests in flight.", true},
+		{errCodeInvalidCredentials, http.StatusUnauthorized, "The email address or password is wrong.", false},
+		{errCodeInvalidToken, http.StatusForbidden, "The authentication token is invalid, expired or missing.", false},
+		{errCodeAuthenticationNeeded, http.StatusUnauthorized, "The resource requires an authenticated user.", false},
+		{errCodeInactiveAccount, http.StatusForbidden, "The user account must be activated first.", false},
+		{errCodeAlreadyActivated, http.StatusConflict, "The activation token was already used to activate the account.", false},
+		{errCodeNotPermitted, http.StatusForbidden, "The user does not hold the permission the resource requires.", false},
+	}
+}
+
+func (app *application) listErrorCodesHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeResponse(w, r, http.StatusOK, envelope{"errors": app.errorCatalog()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// errorSchema is the OpenAPI schema of error response bodies, with the codes of the catalog.
+func (app *application) errorSchema() map[string]any {
+	catalog := app.errorCatalog()
+
+	codes := make([]string, len(catalog))
+	for i, code := range catalog {
+		codes[i] = code.Code
+	}
+
+	return map[string]any{
+		"type":     "object",
+		"required": []string{"error", "code"},
+		"properties": map[string]any{
+			"error":       map[string]any{"description": "A message, or for validation.failed a message per field."},
+			"code":        map[string]any{"type": "string", "enum": codes},
+			"docs_url":    map[string]string{"type": "string"},
+			"incident_id": map[string]string{"type": "string"},
+			"trace_id":    map[string]string{"type": "string"},
+		},
+		"x-error-catalog": catalog,
+	}
+}
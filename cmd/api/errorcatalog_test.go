@@ -0,0 +1,84 @@
+package main
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestErrorCatalogCoversEveryCode(t *testing.T) {
+	app, _ := newTestApplication(t)
+
+	catalog := make(map[string]bool)
+	for _, code := range app.errorCatalog() {
+		if catalog[code.Code] {
+			t.Errorf("%s is listed more than once", code.Code)
+		}
+		catalog[code.Code] = true
+	}
+
+	fset := token.NewFileSet()
+
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	constants := 0
+
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+
+		file, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ast.Inspect(file, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.ValueSpec:
+				for i, ident := range n.Names {
+					if !strings.HasPrefix(ident.Name, "errCode") || i >= len(n.Values) {
+						continue
+					}
+
+					lit, ok := n.Values[i].(*ast.BasicLit)
+					if !ok || lit.Kind != token.STRING {
+						t.Errorf("%s: %s is not a string literal", fset.Position(ident.Pos()), ident.Name)
+						continue
+					}
+
+					constants++
+
+					if code := constant.StringVal(constant.MakeFromLiteral(lit.Value, lit.Kind, 0)); !catalog[code] {
+						t.Errorf("%s (%q) is missing from errorCatalog", ident.Name, code)
+					}
+				}
+
+			case *ast.CallExpr:
+				// Every error response must use one of the code constants, rather than a
+				// literal the catalog cannot be checked against.
+				sel, ok := n.Fun.(*ast.SelectorExpr)
+				if !ok || sel.Sel.Name != "errorResponse" || len(n.Args) != 5 {
+					return true
+				}
+
+				if ident, ok := n.Args[3].(*ast.Ident); !ok || !strings.HasPrefix(ident.Name, "errCode") {
+					t.Errorf("%s: errorResponse is not given an errCode constant", fset.Position(n.Pos()))
+				}
+			}
+
+			return true
+		})
+	}
+
+	if constants != len(catalog) {
+		t.Errorf("found %d code constants; the catalog has %d entries", constants, len(catalog))
+	}
+}
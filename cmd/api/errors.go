@@ -1,85 +1,294 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"greenlight/internal/data"
+	"greenlight/internal/outbound"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statusClientClosedRequest is recorded, following nginx, for requests whose client went
+// away before the response was written.
+const statusClientClosedRequest = 499
+
+// Machine readable error codes, included in every error response alongside the human
+// readable message. Every code must also be described in errorCatalog.
+const (
+	errCodeServerError           = "server.error"
+	errCodeDependencyUnavailable = "dependency.unavailable"
+	errCodeWriteUnavailable      = "database.write_unavailable"
+	errCodeClientCancelled       = "request.cancelled"
+	errCodeRequestTimeout        = "request.timeout"
+	errCodeNotFound              = "resource.not_found"
+	errCodeMethodNotAllowed      = "method.not_allowed"
+	errCodeBadRequest            = "request.invalid"
+	errCodeRequestTooLarge       = "request.too_large"
+	errCodeValidationFailed      = "validation.failed"
+	errCodeDeepOffset            = "pagination.deep_offset"
+	errCodeResponseTooLarge      = "response.too_large"
+	errCodeNotAcceptable         = "response.not_acceptable"
+	errCodeEditConflict          = "edit.conflict"
+	errCodePreconditionFailed    = "precondition.failed"
+	errCodeReindexInProgress     = "reindex.in_progress"
+	errCodeIdempotencyInProgress = "idempotency.in_progress"
+	errCodeIdempotencyKeyReused  = "idempotency.key_reused"
+	errCodeRateLimitExceeded     = "rate_limit.exceeded"
+	errCodeConcurrencyExceeded   = "concurrency_limit.exceeded"
+	errCodeInvalidCredentials    = "authentication.invalid_credentials"
+	errCodeInvalidToken          = "authentication.invalid_token"
+	errCodeAuthenticationNeeded  = "authentication.required"
+	errCodeInactiveAccount       = "account.inactive"
+	errCodeAlreadyActivated      = "account.already_activated"
+	errCodeNotPermitted          = "permission.denied"
 )
 
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.PrintError(err, map[string]string{
+	app.logger.PrintError(err, app.requestProperties(r))
+}
+
+// requestProperties returns the log properties identifying the request.
+func (app *application) requestProperties(r *http.Request) map[string]string {
+	properties := map[string]string{
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
-	})
-}
+	}
 
-func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
-	env := envelope{"error": message}
+	if id := app.contextGetRequestID(r); id != "" {
+		properties["request_id"] = id
+	}
 
-	err := app.writeJSON(w, status, env, nil)
+	return properties
+}
+
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, code string, message any) {
+	err := app.writeResponse(w, r, status, app.errorEnvelope(r, status, code, message), nil)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
 
+// errorEnvelope returns the body of an error response.
+func (app *application) errorEnvelope(r *http.Request, status int, code string, message any) envelope {
+	env := envelope{"error": message, "code": code}
+
+	if app.config.errors.docsBaseURL != "" {
+		env["docs_url"] = strings.TrimRight(app.config.errors.docsBaseURL, "/") + "/errors/" + code
+	}
+
+	// Server errors carry the ids the failure was logged under, and nothing more, so that
+	// clients can quote them when reporting the problem.
+	if status >= http.StatusInternalServerError && app.config.errors.incidentIDs {
+		if id := app.contextGetRequestID(r); id != "" {
+			env["incident_id"] = id
+		}
+		if span := app.contextGetSpan(r); span != nil {
+			env["trace_id"] = span.Context.TraceID.String()
+		}
+	}
+
+	return env
+}
+
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errResponseTooLarge) {
+		app.responseTooLargeResponse(w, r)
+		return
+	}
+
+	// Whether the client went away or the request ran out of time is decided by the request's
+	// own context, as dependencies can also fail with context errors of their own.
+	switch ctxErr := r.Context().Err(); {
+	case errors.Is(ctxErr, context.Canceled) && errors.Is(err, context.Canceled) && app.config.errors.quietCancel:
+		app.clientCancelledResponse(w, r, err)
+		return
+	case errors.Is(ctxErr, context.DeadlineExceeded) && errors.Is(err, context.DeadlineExceeded):
+		app.requestTimeoutResponse(w, r, err)
+		return
+	}
+
+	if errors.Is(err, data.ErrWriteUnavailable) {
+		app.writeUnavailableResponse(w, r, err)
+		return
+	}
+
+	var dependencyError *outbound.DependencyError
+	if errors.As(err, &dependencyError) {
+		app.dependencyErrorResponse(w, r, dependencyError.Dependency, err)
+		return
+	}
+
 	app.logError(r, err)
 
 	message := "the server encountered a problem and could not process your request"
-	app.errorResponse(w, r, http.StatusInternalServerError, message)
+	app.errorResponse(w, r, http.StatusInternalServerError, errCodeServerError, message)
+}
+
+// dependencyErrorResponse is used when a request fails because a downstream dependency (the
+// database, the SMTP server...) is unavailable. The full error is logged, but the client only
+// sees which dependency failed so that outages can be told apart from bugs in the API itself.
+func (app *application) dependencyErrorResponse(w http.ResponseWriter, r *http.Request, dependency string, err error) {
+	properties := app.requestProperties(r)
+	properties["dependency"] = dependency
+
+	app.logger.PrintError(err, properties)
+
+	message := map[string]string{
+		"dependency": dependency,
+		"message":    fmt.Sprintf("the %s service is currently unavailable, please try again later", dependency),
+	}
+	app.errorResponse(w, r, app.config.dependencyErrorStatus, errCodeDependencyUnavailable, message)
+}
+
+// clientCancelledResponse is used when a request failed because its client disconnected. No
+// body is written, as there is nobody left to read it.
+func (app *application) clientCancelledResponse(w http.ResponseWriter, r *http.Request, err error) {
+	properties := app.requestProperties(r)
+	properties["code"] = errCodeClientCancelled
+	properties["error"] = err.Error()
+
+	app.logger.PrintInfo("request cancelled by client", properties)
+
+	w.WriteHeader(statusClientClosedRequest)
+}
+
+// requestTimeoutResponse is used when the request ran out of time on the server side.
+func (app *application) requestTimeoutResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logError(r, err)
+
+	message := "the server took too long to process your request, please try again later"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, errCodeRequestTimeout, message)
+}
+
+// writeUnavailableResponse is used when a write fails because the primary database is down.
+// Reads are still served from the replicas, so only the write needs retrying.
+func (app *application) writeUnavailableResponse(w http.ResponseWriter, r *http.Request, err error) {
+	properties := app.requestProperties(r)
+	properties["dependency"] = "database"
+
+	app.logger.PrintError(err, properties)
+
+	w.Header().Set("Retry-After", strconv.Itoa(max(1, int(app.config.db.healthInterval.Seconds()))))
+
+	message := "the database is currently unable to accept changes, please try again later"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, errCodeWriteUnavailable, message)
 }
 
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
 	message := "the requested resource could not be found"
-	app.errorResponse(w, r, http.StatusNotFound, message)
+	app.errorResponse(w, r, http.StatusNotFound, errCodeNotFound, message)
 }
 
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
 	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
-	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
+	app.errorResponse(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, message)
 }
 
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+	if errors.As(err, new(requestTooLargeError)) {
+		app.requestEntityTooLargeResponse(w, r, err)
+		return
+	}
+
+	app.errorResponse(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error())
+}
+
+func (app *application) requestEntityTooLargeResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, r, http.StatusRequestEntityTooLarge, errCodeRequestTooLarge, err.Error())
 }
 
 func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, errCodeValidationFailed, errors)
+}
+
+func (app *application) deepOffsetResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the requested page is too deep for offset pagination, use the cursor parameter instead"
+	app.errorResponse(w, r, http.StatusBadRequest, errCodeDeepOffset, message)
+}
+
+func (app *application) responseTooLargeResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the response would be too large, request a smaller page_size or use the export endpoint instead"
+	app.errorResponse(w, r, http.StatusRequestEntityTooLarge, errCodeResponseTooLarge, message)
 }
 
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
 	message := "unable to update the record due to an edit conflict, please try again"
-	app.errorResponse(w, r, http.StatusConflict, message)
+	app.errorResponse(w, r, http.StatusConflict, errCodeEditConflict, message)
 }
 
-func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the record has changed since the version in the If-Match header, fetch it again and reapply the change"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, errCodePreconditionFailed, message)
+}
+
+func (app *application) reindexInProgressResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a search reindex is already running, poll its status until it completes"
+	app.errorResponse(w, r, http.StatusConflict, errCodeReindexInProgress, message)
+}
+
+func (app *application) idempotencyInProgressResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "1")
+
+	message := "a request with this Idempotency-Key is still being processed, retry once it has completed"
+	app.errorResponse(w, r, http.StatusConflict, errCodeIdempotencyInProgress, message)
+}
+
+func (app *application) idempotencyKeyReusedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this Idempotency-Key was already used for a request with a different body"
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, errCodeIdempotencyKeyReused, message)
+}
+
+// rateLimitExceededResponse tells the client to retry once its bucket holds a token again,
+// in no less than a second.
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(max(1, ceilSeconds(retryAfter))))
+
 	message := "rate limit exceeded"
-	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+	app.errorResponse(w, r, http.StatusTooManyRequests, errCodeRateLimitExceeded, message)
+}
+
+func (app *application) concurrencyLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "1")
+
+	message := "too many concurrent requests, wait for some of them to complete"
+	app.errorResponse(w, r, http.StatusTooManyRequests, errCodeConcurrencyExceeded, message)
 }
 
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, errCodeInvalidCredentials, message)
 }
 
 func (app *application) invalidAuthenticationTokenRespose(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("WWW-Authenticate", "Bearer")
+	w.Header().Set("WWW-Authenticate", app.authChallenges())
 
 	message := "invalid or missing authentication token"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.errorResponse(w, r, http.StatusForbidden, errCodeInvalidToken, message)
 }
 
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", app.authChallenges())
+
 	message := "you must be authenticated to access this resource"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, errCodeAuthenticationNeeded, message)
 }
 
 func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account must be activated to access this resource"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.errorResponse(w, r, http.StatusForbidden, errCodeInactiveAccount, message)
+}
+
+func (app *application) alreadyActivatedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your account has already been activated, there is no need to use the activation token again"
+	app.errorResponse(w, r, http.StatusConflict, errCodeAlreadyActivated, message)
 }
 
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your account does not have the necessary permissions to access this resource"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.errorResponse(w, r, http.StatusForbidden, errCodeNotPermitted, message)
 }
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight/internal/jsonlog"
+	"greenlight/internal/outbound"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerErrorResponseNamesFailedDependency(t *testing.T) {
+	app, _ := newTestApplication(t)
+	app.config.dependencyErrorStatus = http.StatusServiceUnavailable
+
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		dependency string
+	}{
+		{"database", fmt.Errorf("get movie: %w", &outbound.DependencyError{Dependency: "database", Err: context.DeadlineExceeded}), http.StatusServiceUnavailable, "database"},
+		{"smtp", &outbound.DependencyError{Dependency: "smtp", Err: errors.New("connection refused")}, http.StatusServiceUnavailable, "smtp"},
+		{"webhook", &outbound.DependencyError{Dependency: "webhook", Err: errors.New("unexpected status 502")}, http.StatusServiceUnavailable, "webhook"},
+		{"unmarked timeout", context.DeadlineExceeded, http.StatusInternalServerError, ""},
+		{"bug", errors.New("nil map"), http.StatusInternalServerError, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			app.serverErrorResponse(rr, httptest.NewRequest(http.MethodGet, "/v1/movies/1", nil), tt.err)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d; want %d", rr.Code, tt.wantStatus)
+			}
+
+			var body struct {
+				Error struct {
+					Dependency string `json:"dependency"`
+				} `json:"error"`
+			}
+			_ = json.Unmarshal(rr.Body.Bytes(), &body)
+
+			if body.Error.Dependency != tt.dependency {
+				t.Errorf("got dependency %q; want %q", body.Error.Dependency, tt.dependency)
+			}
+		})
+	}
+}
+
+func TestErrorResponseLinksToDocs(t *testing.T) {
+	app, _ := newTestApplication(t)
+
+	tests := []struct {
+		name    string
+		baseURL string
+		want    string
+	}{
+		{"unset", "", ""},
+		{"base URL", "https://docs.example.com", "https://docs.example.com/errors/validation.failed"},
+		{"trailing slash", "https://docs.example.com/api/", "https://docs.example.com/api/errors/validation.failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app.config.errors.docsBaseURL = tt.baseURL
+
+			rr := httptest.NewRecorder()
+			app.failedValidationResponse(rr, httptest.NewRequest(http.MethodPost, "/v1/movies", nil), map[string]string{"title": "must be provided"})
+
+			var body map[string]any
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+
+			if body["code"] != errCodeValidationFailed {
+				t.Errorf("got code %v; want %q", body["code"], errCodeValidationFailed)
+			}
+
+			docsURL, ok := body["docs_url"]
+			if tt.want == "" {
+				if ok {
+					t.Errorf("got docs_url %v; want none", docsURL)
+				}
+			} else if docsURL != tt.want {
+				t.Errorf("got docs_url %v; want %q", docsURL, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerErrorsCarryTheIncidentID(t *testing.T) {
+	const traceID = "0af7651916cd43dd8448eb211c80319c"
+
+	tests := []struct {
+		name        string
+		enabled     string
+		tracing     bool
+		status      int
+		wantIDs     bool
+		wantTraceID bool
+	}{
+		{"server error", "true", false, http.StatusInternalServerError, true, false},
+		{"server error traced", "true", true, http.StatusInternalServerError, true, true},
+		{"client error", "true", true, http.StatusNotFound, false, false},
+		{"disabled", "false", true, http.StatusInternalServerError, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := newConfiguredTestApplication(t, map[string]string{"ERRORS_INCLUDE_INCIDENT_ID": tt.enabled})
+
+			var out bytes.Buffer
+			app.logger = jsonlog.New(&out, jsonlog.LevelError)
+			if tt.tracing {
+				app.tracer = newTracer(app.config, jsonlog.New(io.Discard, jsonlog.LevelOff))
+			}
+
+			h := app.requestID(app.trace(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.status == http.StatusNotFound {
+					app.notFoundResponse(w, r)
+					return
+				}
+				app.serverErrorResponse(w, r, errors.New("pq: password authentication failed for user \"greenlight\""))
+			})))
+
+			r := httptest.NewRequest(http.MethodGet, "/v1/movies/1", nil)
+			r.Header.Set("traceparent", "00-"+traceID+"-b7ad6b7169203331-01")
+
+			rr := serve(t, h, r)
+
+			if rr.Code != tt.status {
+				t.Fatalf("got status %d; want %d", rr.Code, tt.status)
+			}
+			if strings.Contains(rr.Body.String(), "password") {
+				t.Errorf("got body %s; want no error details", rr.Body.String())
+			}
+
+			var body struct {
+				IncidentID *string `json:"incident_id"`
+				TraceID    *string `json:"trace_id"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+
+			if !tt.wantIDs {
+				if body.IncidentID != nil || body.TraceID != nil {
+					t.Errorf("got body %s; want no incident or trace id", rr.Body.String())
+				}
+				return
+			}
+
+			requestID := rr.Header().Get("X-Request-ID")
+			if requestID == "" {
+				t.Fatal("got no X-Request-ID header")
+			}
+			if body.IncidentID == nil || *body.IncidentID != requestID {
+				t.Errorf("got body %s; want incident_id %q", rr.Body.String(), requestID)
+			}
+
+			var entry struct {
+				Properties map[string]string `json:"properties"`
+			}
+			if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+				t.Fatal(err)
+			}
+			if got := entry.Properties["request_id"]; got != requestID {
+				t.Errorf("got logged request_id %q; want %q", got, requestID)
+			}
+
+			switch {
+			case tt.wantTraceID && (body.TraceID == nil || *body.TraceID != traceID):
+				t.Errorf("got body %s; want trace_id %q", rr.Body.String(), traceID)
+			case !tt.wantTraceID && body.TraceID != nil:
+				t.Errorf("got trace_id %q; want none", *body.TraceID)
+			}
+		})
+	}
+}
+
+func TestServerErrorResponseTellsCancellationsFromTimeouts(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	timedOut, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+
+	tests := []struct {
+		name        string
+		quiet       string
+		ctx         context.Context
+		err         error
+		wantStatus  int
+		wantCode    string
+		wantLevel   string
+		wantMessage string
+	}{
+		{"client cancelled", "true", cancelled, fmt.Errorf("get movie: %w", context.Canceled), statusClientClosedRequest, "", "INFO", "request cancelled by client"},
+		{"client cancelled, not quiet", "false", cancelled, context.Canceled, http.StatusInternalServerError, errCodeServerError, "ERROR", "context canceled"},
+		{"server timeout", "true", timedOut, fmt.Errorf("get movie: %w", context.DeadlineExceeded), http.StatusServiceUnavailable, errCodeRequestTimeout, "ERROR", "get movie: context deadline exceeded"},
+		{"cancelled by a dependency", "true", context.Background(), context.Canceled, http.StatusInternalServerError, errCodeServerError, "ERROR", "context canceled"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := newConfiguredTestApplication(t, map[string]string{"ERRORS_QUIET_CLIENT_CANCEL": tt.quiet})
+
+			var out bytes.Buffer
+			app.logger = jsonlog.New(&out, jsonlog.LevelInfo)
+
+			rr := httptest.NewRecorder()
+			app.serverErrorResponse(rr, httptest.NewRequest(http.MethodGet, "/v1/movies/1", nil).WithContext(tt.ctx), tt.err)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d; want %d", rr.Code, tt.wantStatus)
+			}
+
+			if tt.wantCode == "" {
+				if rr.Body.Len() != 0 {
+					t.Errorf("got body %s; want none", rr.Body)
+				}
+			} else if !strings.Contains(rr.Body.String(), `"code":"`+tt.wantCode+`"`) {
+				t.Errorf("got body %s; want code %s", rr.Body, tt.wantCode)
+			}
+
+			var entry struct {
+				Level      string            `json:"level"`
+				Message    string            `json:"message"`
+				Properties map[string]string `json:"properties"`
+			}
+			if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+				t.Fatalf("got log %q: %s", out.String(), err)
+			}
+
+			if entry.Level != tt.wantLevel || entry.Message != tt.wantMessage {
+				t.Errorf("got %s %q logged; want %s %q", entry.Level, entry.Message, tt.wantLevel, tt.wantMessage)
+			}
+			if tt.wantLevel == "INFO" && entry.Properties["code"] != errCodeClientCancelled {
+				t.Errorf("got properties %v; want code %s", entry.Properties, errCodeClientCancelled)
+			}
+		})
+	}
+}
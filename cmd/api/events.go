@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"greenlight/internal/data"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// movieEvent is a change to a movie, as published to the subscribers of the movie event
+// stream.
+type movieEvent struct {
+	Type      string
+	Timestamp time.Time
+	Movie     *data.Movie
+}
+
+// movieChanged notifies the webhooks and the event stream subscribers of a change to the
+// movie. For deletions, the movie is only used to decide who may see the event, and webhooks
+// and subscribers are sent its id alone; it may have only its id set, in which case only
+// viewers who see every movie are told of the deletion.
+func (app *application) movieChanged(event string, movie *data.Movie) {
+	if event == data.EventMovieDeleted {
+		app.dispatchWebhooks(event, &data.Movie{ID: movie.ID})
+	} else {
+		app.dispatchWebhooks(event, movie)
+	}
+
+	dropped := app.movieEvents.Publish(movieEvent{Type: event, Timestamp: app.clock.Now().UTC(), Movie: movie})
+	if dropped > 0 {
+		movieEventsDropped.Add(int64(dropped))
+	}
+}
+
+// movieEventsHandler streams the changes to the movies the user may see as server-sent
+// events, until the client disconnects. A comment is sent every heartbeat interval, so that
+// proxies do not close the connection while no movies change. Subscribers which fall too
+// far behind miss events rather than holding up the others.
+func (app *application) movieEventsHandler(w http.ResponseWriter, r *http.Request) {
+	viewer, err := app.movieViewer(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+
+	// The stream stays open for longer than the server's write timeout allows.
+	err = rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	sub := app.movieEvents.Subscribe(16)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	err = rc.Flush()
+	if err != nil {
+		app.logError(r, err)
+		return
+	}
+
+	heartbeat := time.NewTicker(app.config.events.heartbeat)
+	defer heartbeat.Stop()
+
+	var id int64
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+
+		case event, ok := <-sub.C:
+			// The hub is closed when the server shuts down.
+			if !ok {
+				return
+			}
+
+			if !viewer.CanSee(event.Movie) {
+				continue
+			}
+
+			var payload any = app.sanitizeMovie(r, event.Movie)
+			if event.Type == data.EventMovieDeleted {
+				payload = envelope{"id": event.Movie.ID}
+			}
+
+			var js []byte
+			js, err = json.Marshal(envelope{"type": event.Type, "timestamp": event.Timestamp, "movie": payload})
+			if err != nil {
+				app.logError(r, err)
+				continue
+			}
+
+			id++
+			_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", strconv.FormatInt(id, 10), event.Type, js)
+		}
+
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
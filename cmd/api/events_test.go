@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"greenlight/internal/data"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseEvent is an event read from a server-sent event stream. Heartbeat comments are read as
+// events with only the comment set.
+type sseEvent struct {
+	id, event, data, comment string
+}
+
+// readEvent reads the next event from the stream.
+func readEvent(t *testing.T, stream *bufio.Reader) sseEvent {
+	t.Helper()
+
+	var e sseEvent
+
+	for {
+		line, err := stream.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading the event stream: %v", err)
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return e
+		}
+
+		field, value, _ := strings.Cut(line, ": ")
+		switch field {
+		case "id":
+			e.id = value
+		case "event":
+			e.event = value
+		case "data":
+			e.data = value
+		case "":
+			e.comment = value
+		}
+	}
+}
+
+// subscribeToMovieEvents opens the movie event stream as an anonymous user, and waits for it
+// to be subscribed to the hub.
+func subscribeToMovieEvents(t *testing.T, app *application) (*http.Response, context.CancelFunc) {
+	t.Helper()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.movieEventsHandler(w, asUser(app, r, data.AnonymousUser))
+	}))
+	t.Cleanup(ts.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("got status %d and content type %q", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+
+	// The headers are flushed once the handler has subscribed.
+	if app.movieEvents.Len() != 1 {
+		t.Fatalf("got %d subscribers; want the stream", app.movieEvents.Len())
+	}
+
+	return res, cancel
+}
+
+func TestMovieEventsStream(t *testing.T) {
+	app, clk := newConfiguredTestApplication(t, map[string]string{"MOVIE_ACL_ENABLED": "true"})
+	useTestDB(t, app, clk, nil)
+
+	res, _ := subscribeToMovieEvents(t, app)
+	stream := bufio.NewReader(res.Body)
+
+	public := &data.Movie{ID: 1, Title: "Heat", Visibility: data.VisibilityPublic, Version: 1}
+	private := &data.Movie{ID: 2, Title: "Draft", Visibility: data.VisibilityRestricted, OwnerID: 7, Version: 1}
+
+	// The anonymous user is not told of the changes to movies they cannot see.
+	app.movieChanged(data.EventMovieCreated, private)
+	app.movieChanged(data.EventMovieCreated, public)
+	app.movieChanged(data.EventMovieDeleted, public)
+
+	tests := []struct {
+		id, event string
+		wantMovie string
+	}{
+		{"1", data.EventMovieCreated, `"title":"Heat"`},
+		{"2", data.EventMovieDeleted, `{"id":1}`},
+	}
+
+	for _, tt := range tests {
+		e := readEvent(t, stream)
+
+		if e.id != tt.id || e.event != tt.event {
+			t.Errorf("got event %q with id %q; want %q with id %q", e.event, e.id, tt.event, tt.id)
+		}
+
+		var body struct {
+			Type      string          `json:"type"`
+			Timestamp time.Time       `json:"timestamp"`
+			Movie     json.RawMessage `json:"movie"`
+		}
+
+		if err := json.Unmarshal([]byte(e.data), &body); err != nil {
+			t.Fatalf("got data %q: %v", e.data, err)
+		}
+
+		if body.Type != tt.event || !body.Timestamp.Equal(clk.Now()) || !strings.Contains(string(body.Movie), tt.wantMovie) {
+			t.Errorf("got data %s; want a %s event for %s", e.data, tt.event, tt.wantMovie)
+		}
+		if tt.event == data.EventMovieDeleted && string(body.Movie) != tt.wantMovie {
+			t.Errorf("got deleted movie %s; want only its id", body.Movie)
+		}
+	}
+
+	app.wg.Wait()
+}
+
+func TestMovieEventsHeartbeat(t *testing.T) {
+	app, _ := newConfiguredTestApplication(t, map[string]string{"EVENTS_HEARTBEAT_INTERVAL": "10ms"})
+
+	res, _ := subscribeToMovieEvents(t, app)
+
+	if e := readEvent(t, bufio.NewReader(res.Body)); e.comment != "heartbeat" {
+		t.Errorf("got %+v; want a heartbeat comment", e)
+	}
+}
+
+func TestMovieEventsUnsubscribeOnDisconnect(t *testing.T) {
+	app, _ := newConfiguredTestApplication(t, nil)
+
+	_, cancel := subscribeToMovieEvents(t, app)
+	cancel()
+
+	for deadline := time.Now().Add(5 * time.Second); app.movieEvents.Len() != 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the subscription outlived the client")
+		}
+	}
+}
+
+func TestMovieEventsEndWithTheHub(t *testing.T) {
+	app, _ := newConfiguredTestApplication(t, nil)
+
+	res, _ := subscribeToMovieEvents(t, app)
+
+	// The hub is closed when the server shuts down.
+	app.movieEvents.Close()
+
+	if _, err := bufio.NewReader(res.Body).ReadString('\n'); err == nil {
+		t.Error("the stream stayed open after the hub was closed")
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+	"io"
+	"net/http"
+)
+
+func (app *application) createExportHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	v := validator.New()
+
+	flatten := app.readBool(r.URL.Query(), "flatten", false, v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	export := &data.Export{
+		UserID:    user.ID,
+		Status:    data.ExportStatusPending,
+		ObjectKey: fmt.Sprintf("exports/movies-%s.ndjson.gz", app.clock.Now().UTC().Format("20060102T150405Z")),
+	}
+
+	err := app.models.Exports.Insert(export)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The job updates its own copy of the export, as this one is still being written out.
+	job := *export
+
+	app.background(func() {
+		app.runExport(&job, flatten)
+	})
+
+	headers := make(http.Header)
+	headers.Set("Location", app.absoluteURL(r, fmt.Sprintf("/v1/admin/exports/%d", export.ID)))
+
+	err = app.writeResponse(w, r, http.StatusAccepted, envelope{"export": export}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showExportHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	export, err := app.models.Exports.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"export": export}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// runExport streams every movie as gzipped ndjson straight into the object store through a
+// pipe, so the catalog is never buffered in full, and records the outcome on the export.
+// With flatten set each movie is written as a flat record.
+func (app *application) runExport(export *data.Export, flatten bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), app.config.exports.timeout)
+	defer cancel()
+
+	export.Status = data.ExportStatusRunning
+
+	err := app.models.Exports.UpdateStatus(export)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		gz := gzip.NewWriter(pw)
+		enc := json.NewEncoder(gz)
+
+		err := app.models.Movies.ForEach(ctx, func(movie *data.Movie) error {
+			export.Records++
+
+			if flatten {
+				record, err := app.flatten(movie)
+				if err != nil {
+					return err
+				}
+				return enc.Encode(record)
+			}
+
+			return enc.Encode(movie)
+		})
+		if err == nil {
+			err = gz.Close()
+		}
+
+		pw.CloseWithError(err)
+	}()
+
+	err = app.objectStore.Upload(ctx, export.ObjectKey, pr)
+	pr.CloseWithError(err)
+	<-done
+
+	export.Status = data.ExportStatusSucceeded
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{
+			"export_id": fmt.Sprint(export.ID),
+		})
+
+		export.Status = data.ExportStatusFailed
+		export.Error = "the export could not be uploaded to the object store"
+	}
+
+	err = app.models.Exports.UpdateStatus(export)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+}
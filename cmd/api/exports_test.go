@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"greenlight/internal/data"
+	"greenlight/internal/objectstore"
+	"greenlight/internal/sqlfake"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeObjectStore is an S3-compatible server which keeps multipart uploads in memory.
+type fakeObjectStore struct {
+	mu      sync.Mutex
+	parts   map[string][]byte
+	objects map[string][]byte
+	fail    bool
+}
+
+func newFakeObjectStore(t *testing.T) (*fakeObjectStore, *objectstore.Client) {
+	store := &fakeObjectStore{parts: map[string][]byte{}, objects: map[string][]byte{}}
+
+	srv := httptest.NewServer(store)
+	t.Cleanup(srv.Close)
+
+	return store, objectstore.New(srv.URL, "us-east-1", "backups", "access", "secret")
+}
+
+func (s *fakeObjectStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/backups/")
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		io.WriteString(w, `<InitiateMultipartUploadResult><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>`)
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		if s.fail {
+			http.Error(w, "<Error><Message>slow down</Message></Error>", http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		s.parts[key] = append(s.parts[key], body...)
+		w.Header().Set("ETag", `"etag"`)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		s.objects[key] = s.parts[key]
+		io.WriteString(w, `<CompleteMultipartUploadResult></CompleteMultipartUploadResult>`)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(s.parts, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+// exportStore answers the export and movie queries from memory.
+type exportStore struct {
+	mu          sync.Mutex
+	export      data.Export
+	completedAt any
+}
+
+func (s *exportStore) handle(query string, args []any) (*sqlfake.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case strings.Contains(query, "INSERT INTO exports"):
+		s.export = data.Export{ID: 1, UserID: args[0].(int64), Status: args[1].(string), ObjectKey: args[2].(string)}
+		return &sqlfake.Result{Rows: [][]any{{int64(1), testEpoch}}}, nil
+
+	case strings.Contains(query, "UPDATE exports"):
+		s.export.Status, s.export.Records, s.export.Error = args[0].(string), args[1].(int64), args[2].(string)
+		s.completedAt = nil
+		if s.export.Status == data.ExportStatusSucceeded || s.export.Status == data.ExportStatusFailed {
+			s.completedAt = testEpoch
+		}
+		return &sqlfake.Result{Rows: [][]any{{s.completedAt}}}, nil
+
+	case strings.Contains(query, "FROM exports"):
+		e := s.export
+		return &sqlfake.Result{Rows: [][]any{{e.ID, testEpoch, e.UserID, e.Status, e.ObjectKey, e.Records, e.Error, s.completedAt}}}, nil
+
+	case strings.Contains(query, "FROM movies"):
+		return &sqlfake.Result{Rows: [][]any{
+			{int64(1), testEpoch, testEpoch, "Alien", "alien", int64(1979), int64(117), "{Horror}", int64(1)},
+			{int64(2), testEpoch, testEpoch, "Arrival", "arrival", int64(2016), int64(116), "{Drama}", int64(1)},
+		}}, nil
+	}
+
+	return nil, nil
+}
+
+func TestExportUploadsTheCatalog(t *testing.T) {
+	tests := []struct {
+		name       string
+		fail       bool
+		wantStatus string
+	}{
+		{"success", false, data.ExportStatusSucceeded},
+		{"object store failure", true, data.ExportStatusFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, clk := newConfiguredTestApplication(t, nil)
+
+			objects, client := newFakeObjectStore(t)
+			objects.fail = tt.fail
+			app.objectStore = client
+
+			useTestDB(t, app, clk, (&exportStore{}).handle)
+
+			rr := serve(t, http.HandlerFunc(app.createExportHandler), asUser(app, httptest.NewRequest(http.MethodPost, "/v1/admin/exports", nil), testUser))
+			if rr.Code != http.StatusAccepted {
+				t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusAccepted, rr.Body)
+			}
+
+			app.wg.Wait()
+
+			rr = serve(t, http.HandlerFunc(app.showExportHandler), withParams(httptest.NewRequest(http.MethodGet, "/v1/admin/exports/1", nil), "id", "1"))
+
+			var body struct {
+				Export data.Export `json:"export"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+
+			export := body.Export
+			if export.Status != tt.wantStatus || export.CompletedAt == nil {
+				t.Fatalf("got status %q, completed at %v; want %q", export.Status, export.CompletedAt, tt.wantStatus)
+			}
+
+			object, uploaded := objects.objects[export.ObjectKey]
+			if tt.fail {
+				if uploaded || export.Error == "" {
+					t.Errorf("got object uploaded %t and error %q; want no object and an error", uploaded, export.Error)
+				}
+				return
+			}
+
+			if !uploaded || export.Records != 2 {
+				t.Fatalf("got object uploaded %t with %d records; want 2 records", uploaded, export.Records)
+			}
+
+			gz, err := gzip.NewReader(strings.NewReader(string(object)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var titles []string
+			for scanner := bufio.NewScanner(gz); scanner.Scan(); {
+				var movie data.Movie
+				if err := json.Unmarshal(scanner.Bytes(), &movie); err != nil {
+					t.Fatal(err)
+				}
+				titles = append(titles, movie.Title)
+			}
+
+			if strings.Join(titles, ",") != "Alien,Arrival" {
+				t.Errorf("got titles %q; want Alien and Arrival", titles)
+			}
+		})
+	}
+}
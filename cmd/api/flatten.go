@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// flatten converts v, which must encode to a JSON object, into a flat record for tools which
+// cannot handle nested JSON: arrays are joined into a single string with the configured
+// delimiter, and the fields of nested objects are promoted to the top level with their
+// keys prefixed by the parent key, e.g. {"rating": {"average": 4}} becomes
+// {"rating_average": 4}. It is applied to each record just before serialization, so every
+// output format shares the same flat shape.
+func (app *application) flatten(v any) (map[string]any, error) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var nested map[string]any
+
+	err = json.Unmarshal(js, &nested)
+	if err != nil {
+		return nil, err
+	}
+
+	flat := make(map[string]any, len(nested))
+	app.flattenInto(flat, "", nested)
+
+	return flat, nil
+}
+
+func (app *application) flattenInto(flat map[string]any, prefix string, nested map[string]any) {
+	for key, value := range nested {
+		if prefix != "" {
+			key = prefix + "_" + key
+		}
+
+		switch value := value.(type) {
+		case map[string]any:
+			app.flattenInto(flat, key, value)
+		case []any:
+			parts := make([]string, len(value))
+			for i, element := range value {
+				if s, ok := element.(string); ok {
+					parts[i] = s
+				} else {
+					js, _ := json.Marshal(element)
+					parts[i] = string(js)
+				}
+			}
+			flat[key] = strings.Join(parts, app.config.flatten.delimiter)
+		default:
+			flat[key] = value
+		}
+	}
+}
+
+// flattenAll flattens each of the records.
+func (app *application) flattenAll(records any) ([]map[string]any, error) {
+	js, err := json.Marshal(records)
+	if err != nil {
+		return nil, err
+	}
+
+	var nested []map[string]any
+
+	err = json.Unmarshal(js, &nested)
+	if err != nil {
+		return nil, fmt.Errorf("flatten: records must encode to an array of objects: %w", err)
+	}
+
+	flat := make([]map[string]any, len(nested))
+	for i, record := range nested {
+		flat[i] = make(map[string]any, len(record))
+		app.flattenInto(flat[i], "", record)
+	}
+
+	return flat, nil
+}
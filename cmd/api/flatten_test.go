@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"greenlight/internal/data"
+	"greenlight/internal/sqlfake"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFlattenPromotesNestedFields(t *testing.T) {
+	app, _ := newConfiguredTestApplication(t, map[string]string{"FLATTEN_DELIMITER": ";"})
+
+	type reviews struct {
+		Count         int     `json:"count"`
+		AverageRating float64 `json:"average_rating"`
+	}
+
+	record := struct {
+		ID      int64    `json:"id"`
+		Title   string   `json:"title"`
+		Genres  []string `json:"genres"`
+		Years   []int    `json:"years"`
+		Reviews reviews  `json:"reviews"`
+	}{
+		ID:      1,
+		Title:   "Alien",
+		Genres:  []string{"Horror", "Science Fiction"},
+		Years:   []int{1979, 2003},
+		Reviews: reviews{Count: 12, AverageRating: 4.5},
+	}
+
+	flat, err := app.flatten(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]any{
+		"id":                     float64(1),
+		"title":                  "Alien",
+		"genres":                 "Horror;Science Fiction",
+		"years":                  "1979;2003",
+		"reviews_count":          float64(12),
+		"reviews_average_rating": 4.5,
+	}
+
+	if !reflect.DeepEqual(flat, want) {
+		t.Errorf("got %v; want %v", flat, want)
+	}
+
+	if _, err := app.flattenAll(record); err == nil {
+		t.Error("flattenAll of a single record: got no error; want one")
+	}
+}
+
+func TestListMoviesFlattened(t *testing.T) {
+	app, clk := newConfiguredTestApplication(t, nil)
+
+	useTestDB(t, app, clk, func(query string, args []any) (*sqlfake.Result, error) {
+		return listRows(1, &data.Movie{ID: 1, Title: "Alien", Slug: "alien", Year: 1979, Runtime: 117, Genres: []string{"Horror", "Science Fiction"}, Version: 1}), nil
+	})
+
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantGenres any
+	}{
+		{"", http.StatusOK, []any{"Horror", "Science Fiction"}},
+		{"?flatten=false", http.StatusOK, []any{"Horror", "Science Fiction"}},
+		{"?flatten=true", http.StatusOK, "Horror|Science Fiction"},
+		{"?flatten=maybe", http.StatusUnprocessableEntity, nil},
+	}
+
+	for _, tt := range tests {
+		r := asUser(app, httptest.NewRequest(http.MethodGet, "/v1/movies"+tt.query, nil), testUser)
+		rr := serve(t, http.HandlerFunc(app.listMoviesHandler), r)
+
+		if rr.Code != tt.wantStatus {
+			t.Fatalf("%q: got status %d; want %d: %s", tt.query, rr.Code, tt.wantStatus, rr.Body)
+		}
+		if tt.wantStatus != http.StatusOK {
+			continue
+		}
+
+		var body struct {
+			Movies   []map[string]any `json:"movies"`
+			Metadata map[string]any   `json:"metadata"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(body.Movies) != 1 {
+			t.Fatalf("%q: got %d movies; want 1", tt.query, len(body.Movies))
+		}
+		if got := body.Movies[0]["genres"]; !reflect.DeepEqual(got, tt.wantGenres) {
+			t.Errorf("%q: got genres %#v; want %#v", tt.query, got, tt.wantGenres)
+		}
+		if body.Metadata["total_records"] != float64(1) {
+			t.Errorf("%q: got metadata %v; want it left nested", tt.query, body.Metadata)
+		}
+	}
+}
+
+func TestExportFlattened(t *testing.T) {
+	app, clk := newConfiguredTestApplication(t, nil)
+
+	objects, client := newFakeObjectStore(t)
+	app.objectStore = client
+
+	store := &exportStore{}
+	useTestDB(t, app, clk, store.handle)
+
+	rr := serve(t, http.HandlerFunc(app.createExportHandler), asUser(app, httptest.NewRequest(http.MethodPost, "/v1/admin/exports?flatten=true", nil), testUser))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusAccepted, rr.Body)
+	}
+
+	app.wg.Wait()
+
+	gz, err := gzip.NewReader(strings.NewReader(string(objects.objects[store.export.ObjectKey])))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var genres []any
+	for scanner := bufio.NewScanner(gz); scanner.Scan(); {
+		var record map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		genres = append(genres, record["genres"])
+	}
+
+	if want := []any{"Horror", "Drama"}; !reflect.DeepEqual(genres, want) {
+		t.Errorf("got genres %#v; want %#v", genres, want)
+	}
+}
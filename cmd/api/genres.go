@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Taxonomy modes. With taxonomyPassthrough genres outside the taxonomy are stored as they
+// are, with taxonomyReject movies holding them fail validation.
+const (
+	taxonomyOff         = "off"
+	taxonomyPassthrough = "passthrough"
+	taxonomyReject      = "reject"
+)
+
+// genreTaxonomy returns the configured genre mappings, or nil when the taxonomy is off.
+func (app *application) genreTaxonomy() (data.Taxonomy, error) {
+	if app.config.genres.taxonomy == taxonomyOff {
+		return nil, nil
+	}
+
+	return app.models.GenreMappings.Taxonomy()
+}
+
+// normalizeGenres normalizes the genres and maps them onto the taxonomy, when there is one.
+// Unknown genres are reported to v in reject mode; when v is nil they are silently dropped.
+func (app *application) normalizeGenres(v *validator.Validator, taxonomy data.Taxonomy, genres []string) []string {
+	genres = data.NormalizeGenres(genres, app.config.genres.casing)
+
+	if taxonomy == nil {
+		return genres
+	}
+
+	mapped, unknown := taxonomy.Map(genres, app.config.genres.taxonomy == taxonomyPassthrough)
+
+	if len(unknown) > 0 && v != nil && app.config.genres.taxonomy == taxonomyReject {
+		v.AddError("genres", "contains unknown genres: "+strings.Join(unknown, ", "))
+	}
+
+	return mapped
+}
+
+func (app *application) listGenreMappingsHandler(w http.ResponseWriter, r *http.Request) {
+	mappings, err := app.models.GenreMappings.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"mappings": mappings}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) putGenreMappingHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Canonical string `json:"canonical"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	mapping := &data.GenreMapping{
+		Alias:     httprouter.ParamsFromContext(r.Context()).ByName("alias"),
+		Canonical: strings.Join(strings.Fields(input.Canonical), " "),
+	}
+
+	v := validator.New()
+
+	if data.ValidateGenreMapping(v, mapping); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.GenreMappings.Upsert(mapping)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"mapping": mapping}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteGenreMappingHandler(w http.ResponseWriter, r *http.Request) {
+	alias := httprouter.ParamsFromContext(r.Context()).ByName("alias")
+
+	err := app.models.GenreMappings.Delete(alias)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "genre mapping successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"greenlight/internal/sqlfake"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// genreMappingRows answers the genre_mappings query with the Sci-Fi variants.
+func genreMappingRows() *sqlfake.Result {
+	return &sqlfake.Result{Rows: [][]any{
+		{"sci-fi", "Science Fiction", testEpoch},
+		{"scifi", "Science Fiction", testEpoch},
+	}}
+}
+
+func TestCreateMovieMapsGenresOntoTheTaxonomy(t *testing.T) {
+	tests := []struct {
+		taxonomy   string
+		wantStatus int
+		wantStored []string
+	}{
+		{taxonomyOff, http.StatusCreated, []string{"Sci-fi", "Scifi", "Science Fiction", "Western"}},
+		{taxonomyPassthrough, http.StatusCreated, []string{"Science Fiction", "Western"}},
+		{taxonomyReject, http.StatusUnprocessableEntity, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.taxonomy, func(t *testing.T) {
+			app, clk := newConfiguredTestApplication(t, map[string]string{"GENRES_TAXONOMY": tt.taxonomy})
+
+			var stored []string
+
+			useTestDB(t, app, clk, func(query string, args []any) (*sqlfake.Result, error) {
+				switch {
+				case strings.Contains(query, "FROM genre_mappings"):
+					return genreMappingRows(), nil
+				case strings.Contains(query, "INSERT INTO movies"):
+					stored = args[3].([]string)
+					return movieRow(), nil
+				}
+				return nil, nil
+			})
+
+			body := `{"title": "Moon", "year": 2009, "runtime": "97 mins", "genres": ["Sci-Fi", "SCIFI", "science fiction", "western"]}`
+			r := asUser(app, httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(body)), testUser)
+
+			rr := serve(t, http.HandlerFunc(app.createMovieHandler), r)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d; want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+
+			if tt.wantStatus != http.StatusCreated {
+				if !strings.Contains(rr.Body.String(), "contains unknown genres: Western") {
+					t.Errorf("got body %s; want the unknown genre named", rr.Body)
+				}
+				return
+			}
+
+			if !slices.Equal(stored, tt.wantStored) {
+				t.Errorf("stored genres %q; want %q", stored, tt.wantStored)
+			}
+		})
+	}
+}
+
+func TestPutGenreMappingStoresTheAliasKey(t *testing.T) {
+	app, clk := newConfiguredTestApplication(t, nil)
+
+	var args []any
+
+	useTestDB(t, app, clk, func(query string, a []any) (*sqlfake.Result, error) {
+		switch {
+		case strings.Contains(query, "INSERT INTO genre_mappings"):
+			args = a
+			return &sqlfake.Result{Rows: [][]any{{testEpoch}}}, nil
+		case strings.Contains(query, "DELETE FROM genre_mappings"):
+			return &sqlfake.Result{RowsAffected: 0}, nil
+		}
+		return nil, nil
+	})
+
+	r := withParams(httptest.NewRequest(http.MethodPut, "/v1/admin/genres/mappings/Sci-Fi", strings.NewReader(`{"canonical": " Science   Fiction "}`)), "alias", "  Sci-Fi ")
+	if rr := serve(t, http.HandlerFunc(app.putGenreMappingHandler), r); rr.Code != http.StatusOK {
+		t.Fatalf("put: got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	if len(args) != 2 || args[0] != "sci-fi" || args[1] != "Science Fiction" {
+		t.Errorf("put: got args %q; want alias sci-fi and canonical Science Fiction", args)
+	}
+
+	r = withParams(httptest.NewRequest(http.MethodPut, "/v1/admin/genres/mappings/Sci-Fi", strings.NewReader(`{"canonical": "  "}`)), "alias", "Sci-Fi")
+	if rr := serve(t, http.HandlerFunc(app.putGenreMappingHandler), r); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("blank canonical: got status %d; want %d", rr.Code, http.StatusUnprocessableEntity)
+	}
+
+	r = withParams(httptest.NewRequest(http.MethodDelete, "/v1/admin/genres/mappings/Western", nil), "alias", "Western")
+	if rr := serve(t, http.HandlerFunc(app.deleteGenreMappingHandler), r); rr.Code != http.StatusNotFound {
+		t.Errorf("delete unknown alias: got status %d; want %d", rr.Code, http.StatusNotFound)
+	}
+}
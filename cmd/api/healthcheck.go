@@ -1,19 +1,166 @@
 package main
 
 import (
+	"context"
+	"greenlight/internal/jsonlog"
+	"greenlight/internal/validator"
+	"greenlight/internal/vcs"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
+// Dependency states reported by the healthcheck.
+const (
+	dependencyAvailable   = "available"
+	dependencyUnavailable = "unavailable"
+)
+
+// healthcheckHandler pings the primary database and dials the SMTP server, and responds 503
+// when a critical dependency is unavailable, so that load balancers can route around the
+// instance. The database is always critical. The SMTP server only is when configured so, as
+// emails wait in the outbox until it is back, and otherwise an outage of it reports the
+// status as degraded.
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), app.config.healthcheck.timeout)
+	defer cancel()
+
+	database := dependencyAvailable
+	if err := app.db.PingContext(ctx); err != nil {
+		database = dependencyUnavailable
+	}
+
+	smtp := dependencyAvailable
+	if err := app.dialSMTP(ctx); err != nil {
+		smtp = dependencyUnavailable
+	}
+
+	status, code := "available", http.StatusOK
+
+	switch {
+	case database == dependencyUnavailable, smtp == dependencyUnavailable && app.config.healthcheck.smtpCritical:
+		status, code = "unavailable", http.StatusServiceUnavailable
+	case smtp == dependencyUnavailable:
+		status = "degraded"
+	}
+
+	health := app.db.Health()
+	stats := app.db.Stats()
+
 	env := envelope{
-		"status": "available",
+		"status":   status,
+		"database": database,
+		"smtp":     smtp,
+		"database_pool": map[string]int{
+			"open":   stats.OpenConnections,
+			"in_use": stats.InUse,
+			"idle":   stats.Idle,
+		},
+		"database_replicas": map[string]int{
+			"up":    health.ReplicasUp,
+			"total": health.Replicas,
+		},
 		"system_info": map[string]string{
 			"environment": app.config.env,
 			"version":     version,
 		},
 	}
 
-	err := app.writeJSON(w, http.StatusOK, env, nil)
+	err := app.writeResponse(w, r, code, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// livenessHandler reports that the process is up. It keeps succeeding while the server
+// drains, so that orchestrators do not kill it before in-flight requests complete.
+func (app *application) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeResponse(w, r, http.StatusOK, envelope{"status": "alive"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readinessHandler reports whether the server wants new traffic. Until the server listens,
+// and once a shutdown has begun, it responds 503 with the current state, telling load
+// balancers not to route to it.
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	state := app.lifecycle.get()
+
+	status := http.StatusOK
+	if state != stateServing {
+		status = http.StatusServiceUnavailable
+	}
+
+	err := app.writeResponse(w, r, status, envelope{"status": state}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// dialSMTP checks that the SMTP server accepts connections, without speaking SMTP to it.
+func (app *application) dialSMTP(ctx context.Context) error {
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(app.config.smtp.host, strconv.Itoa(app.config.smtp.port)))
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// buildInfoHandler returns the version, revision and commit time of the running binary, and
+// whether it was built from a modified working tree, as separate fields.
+func (app *application) buildInfoHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeResponse(w, r, http.StatusOK, envelope{"build_info": vcs.Info()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateLogLevelHandler changes the minimum level of the entries logged from then on, so
+// that debug logging can be turned on in production without a restart. The change only
+// applies to this instance, and is lost when it restarts.
+func (app *application) updateLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Level string `json:"level"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	level, err := jsonlog.ParseLevel(input.Level)
+	if err != nil {
+		v := validator.New()
+		v.AddError("level", "must be debug, info or error")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	previous := app.logger.Level()
+
+	properties := map[string]string{
+		"from":    previous.String(),
+		"to":      level.String(),
+		"user_id": strconv.FormatInt(app.contextGetUser(r).ID, 10),
+	}
+
+	// The change is logged under whichever of the two levels is lower, so that raising the
+	// level to error is still recorded, and so is lowering it from error.
+	if level > previous {
+		app.logger.PrintInfo("log level changed", properties)
+		app.logger.SetLevel(level)
+	} else {
+		app.logger.SetLevel(level)
+		app.logger.PrintInfo("log level changed", properties)
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"level": strings.ToLower(level.String())}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
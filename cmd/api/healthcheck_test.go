@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"greenlight/internal/data"
+	"greenlight/internal/jsonlog"
+	"greenlight/internal/sqlfake"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrimaryDownServesReadsAndRejectsWrites(t *testing.T) {
+	app, clk := newConfiguredTestApplication(t, map[string]string{
+		"POSTGRESQL_HEALTH_INTERVAL": "5s",
+		"SMTP_HOST":                  "127.0.0.1",
+		"SMTP_PORT":                  "1",
+	})
+
+	useTestDB(t, app, clk, func(query string, args []any) (*sqlfake.Result, error) {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	})
+
+	replica := sqlfake.Open(func(query string, args []any) (*sqlfake.Result, error) {
+		if query == "" {
+			return nil, nil
+		}
+		return listRows(1, &data.Movie{ID: 1, Title: "Alien", Slug: "alien", Year: 1979, Runtime: 117, Genres: []string{"Horror"}, Version: 1}), nil
+	})
+	t.Cleanup(func() { replica.Close() })
+
+	app.db.Replicas = []*sql.DB{replica}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	app.db.Monitor(ctx, time.Hour, time.Second)
+
+	rr := serve(t, http.HandlerFunc(app.listMoviesHandler), asUser(app, httptest.NewRequest(http.MethodGet, "/v1/movies", nil), testUser))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"Alien"`) {
+		t.Errorf("list: got status %d: %s; want the movies from the replica", rr.Code, rr.Body)
+	}
+
+	body := `{"title": "Arrival", "year": 2016, "runtime": "116 mins", "genres": ["Drama"]}`
+	rr = serve(t, http.HandlerFunc(app.createMovieHandler), asUser(app, httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(body)), testUser))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("create: got status %d; want %d: %s", rr.Code, http.StatusServiceUnavailable, rr.Body)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "5" {
+		t.Errorf("create: got Retry-After %q; want 5", got)
+	}
+	if !strings.Contains(rr.Body.String(), errCodeWriteUnavailable) {
+		t.Errorf("create: got body %s; want code %s", rr.Body, errCodeWriteUnavailable)
+	}
+
+	// The primary is critical to the instance, and its replicas are reported alongside it.
+	rr = serve(t, http.HandlerFunc(app.healthcheckHandler), httptest.NewRequest(http.MethodGet, "/v1/healthcheck", nil))
+
+	var health struct {
+		Status           string         `json:"status"`
+		Database         string         `json:"database"`
+		DatabaseReplicas map[string]int `json:"database_replicas"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &health); err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusServiceUnavailable || health.Database != dependencyUnavailable {
+		t.Errorf("healthcheck: got status %d and database %q; want %d and %q", rr.Code, health.Database, http.StatusServiceUnavailable, dependencyUnavailable)
+	}
+	if health.DatabaseReplicas["up"] != 1 || health.DatabaseReplicas["total"] != 1 {
+		t.Errorf("healthcheck: got replicas %v; want 1 of 1 up", health.DatabaseReplicas)
+	}
+}
+
+func TestHealthcheckReportsDependencies(t *testing.T) {
+	smtp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { smtp.Close() })
+
+	go func() {
+		for {
+			conn, err := smtp.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	smtpUp := strconv.Itoa(smtp.Addr().(*net.TCPAddr).Port)
+
+	tests := []struct {
+		name         string
+		databaseUp   bool
+		smtpPort     string
+		smtpCritical string
+		wantStatus   int
+		wantBody     map[string]string
+	}{
+		{"healthy", true, smtpUp, "false", http.StatusOK, map[string]string{"status": "available", "database": "available", "smtp": "available"}},
+		{"database down", false, smtpUp, "false", http.StatusServiceUnavailable, map[string]string{"status": "unavailable", "database": "unavailable", "smtp": "available"}},
+		{"smtp down", true, "1", "false", http.StatusOK, map[string]string{"status": "degraded", "database": "available", "smtp": "unavailable"}},
+		{"critical smtp down", true, "1", "true", http.StatusServiceUnavailable, map[string]string{"status": "unavailable", "database": "available", "smtp": "unavailable"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, clk := newConfiguredTestApplication(t, map[string]string{
+				"SMTP_HOST":                 "127.0.0.1",
+				"SMTP_PORT":                 tt.smtpPort,
+				"HEALTHCHECK_SMTP_CRITICAL": tt.smtpCritical,
+			})
+
+			useTestDB(t, app, clk, func(query string, args []any) (*sqlfake.Result, error) {
+				if !tt.databaseUp {
+					return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+				}
+				return nil, nil
+			})
+
+			rr := serve(t, http.HandlerFunc(app.healthcheckHandler), httptest.NewRequest(http.MethodGet, "/v1/healthcheck", nil))
+			if rr.Code != tt.wantStatus {
+				t.Errorf("got status %d; want %d", rr.Code, tt.wantStatus)
+			}
+
+			var body struct {
+				Status       string         `json:"status"`
+				Database     string         `json:"database"`
+				SMTP         string         `json:"smtp"`
+				DatabasePool map[string]int `json:"database_pool"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+
+			got := map[string]string{"status": body.Status, "database": body.Database, "smtp": body.SMTP}
+			for key, want := range tt.wantBody {
+				if got[key] != want {
+					t.Errorf("got %s %q; want %q", key, got[key], want)
+				}
+			}
+
+			for _, key := range []string{"open", "in_use", "idle"} {
+				if _, ok := body.DatabasePool[key]; !ok {
+					t.Errorf("got pool stats %v; want %s", body.DatabasePool, key)
+				}
+			}
+			if !strings.Contains(rr.Body.String(), `"version"`) || !strings.Contains(rr.Body.String(), `"environment"`) {
+				t.Errorf("got body %s; want the environment and version kept", rr.Body)
+			}
+		})
+	}
+}
+
+func TestBuildInfo(t *testing.T) {
+	app, _ := newTestApplication(t)
+
+	rr := serve(t, http.HandlerFunc(app.buildInfoHandler), httptest.NewRequest(http.MethodGet, "/v1/debug/buildinfo", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d", rr.Code, http.StatusOK)
+	}
+
+	var body struct {
+		BuildInfo map[string]any `json:"build_info"`
+	}
+
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, field := range []string{"version", "revision", "time"} {
+		if _, ok := body.BuildInfo[field].(string); !ok {
+			t.Errorf("got %s %#v; want a string", field, body.BuildInfo[field])
+		}
+	}
+	if _, ok := body.BuildInfo["dirty"].(bool); !ok {
+		t.Errorf("got dirty %#v; want a boolean", body.BuildInfo["dirty"])
+	}
+
+	for _, rt := range app.routeTable() {
+		if rt.pattern == "/v1/debug/buildinfo" && rt.policy != "metrics:read" {
+			t.Errorf("got the build information behind %q; want metrics:read", rt.policy)
+		}
+	}
+}
+
+func TestUpdateLogLevel(t *testing.T) {
+	tests := []struct {
+		name       string
+		from       jsonlog.Level
+		body       string
+		wantStatus int
+		wantLevel  jsonlog.Level
+		wantLogged bool
+	}{
+		{"lowered to debug", jsonlog.LevelInfo, `{"level": "debug"}`, http.StatusOK, jsonlog.LevelDebug, true},
+		{"raised to error", jsonlog.LevelInfo, `{"level": "error"}`, http.StatusOK, jsonlog.LevelError, true},
+		{"lowered from error", jsonlog.LevelError, `{"level": "info"}`, http.StatusOK, jsonlog.LevelInfo, true},
+		{"unknown", jsonlog.LevelInfo, `{"level": "fatal"}`, http.StatusUnprocessableEntity, jsonlog.LevelInfo, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := newConfiguredTestApplication(t, nil)
+
+			var out bytes.Buffer
+			app.logger = jsonlog.New(&out, tt.from)
+
+			r := asUser(app, httptest.NewRequest(http.MethodPut, "/v1/debug/loglevel", strings.NewReader(tt.body)), testUser)
+
+			rr := serve(t, http.HandlerFunc(app.updateLogLevelHandler), r)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d; want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+
+			if got := app.logger.Level(); got != tt.wantLevel {
+				t.Errorf("got level %s; want %s", got, tt.wantLevel)
+			}
+			if logged := strings.Contains(out.String(), "log level changed"); logged != tt.wantLogged {
+				t.Errorf("got log %q; want the change logged %t", out.String(), tt.wantLogged)
+			}
+		})
+	}
+
+	app, _ := newTestApplication(t)
+
+	for _, rt := range app.routeTable() {
+		if rt.pattern == "/v1/debug/loglevel" && rt.policy != "admin:logs" {
+			t.Errorf("got the log level behind %q; want admin:logs", rt.policy)
+		}
+	}
+}
@@ -4,12 +4,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"greenlight/internal/data"
 	"greenlight/internal/validator"
 	"io"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -27,14 +29,37 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 
 type envelope map[string]any
 
+// errResponseTooLarge is returned by writeJSON, before anything has been written, when the
+// encoded response is larger than the configured limit.
+var errResponseTooLarge = errors.New("response exceeds the maximum size")
+
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
-	js, err := json.Marshal(data)
+	js, err := app.encodeJSON(data)
 	if err != nil {
 		return err
 	}
 
-	js = append(js, '\n')
+	app.writeJSONBytes(w, status, js, headers)
+
+	return nil
+}
+
+// encodeJSON encodes a response body the way writeJSON sends it.
+func (app *application) encodeJSON(data envelope) ([]byte, error) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit := app.config.responses.maxBytes; limit > 0 && len(js) > limit {
+		return nil, errResponseTooLarge
+	}
+
+	return append(js, '\n'), nil
+}
 
+// writeJSONBytes sends a response body which has already been encoded by encodeJSON as JSON.
+func (app *application) writeJSONBytes(w http.ResponseWriter, status int, js []byte, headers http.Header) {
 	for key, value := range headers {
 		w.Header()[key] = value
 	}
@@ -42,18 +67,50 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	w.Write(js)
+}
 
-	return nil
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.Reader.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// requestTooLargeError is returned by readJSON for a body over the size limit, which
+// badRequestResponse sends as a 413 rather than a 400.
+type requestTooLargeError struct {
+	error
 }
 
+// readJSON decodes a single JSON value from the request body into dst. With strict content
+// length checks enabled, a body which does not match its Content-Length header is rejected
+// as such, rather than with a confusing decode error. Chunked requests, which have no
+// Content-Length, are not checked.
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
-	maxBytes := 1_048_576
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
-	dec := json.NewDecoder(r.Body)
+	r.Body = http.MaxBytesReader(w, r.Body, int64(app.config.requests.maxBodyBytes))
+
+	var counter *countingReader
+	var body io.Reader = r.Body
+
+	if app.config.requests.strictContentLength && r.ContentLength >= 0 {
+		counter = &countingReader{Reader: r.Body}
+		body = counter
+	}
+
+	dec := json.NewDecoder(body)
 	dec.DisallowUnknownFields()
 
 	err := dec.Decode(dst)
 	if err != nil {
+		if counter != nil && errors.Is(err, io.ErrUnexpectedEOF) && counter.n < r.ContentLength {
+			return fmt.Errorf("body is shorter than its Content-Length (%d of %d bytes)", counter.n, r.ContentLength)
+		}
+
 		var syntaxError *json.SyntaxError
 		var unmarshalTypeError *json.UnmarshalTypeError
 		var invalidUnmarshalError *json.InvalidUnmarshalError
@@ -80,7 +137,7 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 			return fmt.Errorf("body contains unknown key %s", fieldName)
 
 		case errors.As(err, &maxBytesError):
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+			return requestTooLargeError{fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)}
 
 		case errors.As(err, &invalidUnmarshalError):
 			panic(err)
@@ -92,12 +149,51 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 
 	err = dec.Decode(&struct{}{})
 	if err != io.EOF {
+		if counter != nil && errors.Is(err, io.ErrUnexpectedEOF) && counter.n < r.ContentLength {
+			return fmt.Errorf("body is shorter than its Content-Length (%d of %d bytes)", counter.n, r.ContentLength)
+		}
 		return errors.New("body must only contain a single JSON value")
 	}
 
+	if counter != nil && counter.n != r.ContentLength {
+		return fmt.Errorf("body length of %d bytes does not match its Content-Length of %d bytes", counter.n, r.ContentLength)
+	}
+
 	return nil
 }
 
+// paginationMetadata drops the page flags from the metadata unless they are enabled, and
+// adds links to the pages around the one requested by r when they are.
+func (app *application) paginationMetadata(r *http.Request, metadata data.Metadata) data.Metadata {
+	if !app.config.pagination.flags {
+		metadata.PageFlags = nil
+	}
+
+	if app.config.pagination.links {
+		metadata = metadata.WithLinks(app.absoluteURL(r, r.URL.Path), r.URL.Query())
+	}
+
+	return metadata
+}
+
+// notModified reports whether the If-None-Match header of the request matches etag, using
+// the weak comparison RFC 9110 requires for it. The header may list several tags, or be *.
+func notModified(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (app *application) readString(qs url.Values, key string, defaultValue string) string {
 	s := qs.Get(key)
 
@@ -134,6 +230,57 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+
+	return b
+}
+
+// readOptionalBool is like readBool, but returns nil when the query string has no value for
+// key, so that callers can tell an unset filter from false.
+func (app *application) readOptionalBool(qs url.Values, key string, v *validator.Validator) *bool {
+	if qs.Get(key) == "" {
+		return nil
+	}
+
+	b := app.readBool(qs, key, false, v)
+
+	return &b
+}
+
+// humanDuration formats d for emails, as a whole number of days, hours or minutes when it
+// is one, such as "3 days" or "45 minutes", and as time.Duration does otherwise.
+func humanDuration(d time.Duration) string {
+	for _, unit := range []struct {
+		size time.Duration
+		name string
+	}{
+		{24 * time.Hour, "day"},
+		{time.Hour, "hour"},
+		{time.Minute, "minute"},
+	} {
+		if d >= unit.size && d%unit.size == 0 {
+			n := int64(d / unit.size)
+			if n == 1 {
+				return "1 " + unit.name
+			}
+			return fmt.Sprintf("%d %ss", n, unit.name)
+		}
+	}
+
+	return d.String()
+}
+
 func (app *application) background(fn func()) {
 	app.wg.Add(1)
 
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"greenlight/internal/data"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadJSONStrictContentLength(t *testing.T) {
+	tests := []struct {
+		name          string
+		strict        string
+		body          string
+		contentLength int64
+		wantErr       string
+	}{
+		{"matching", "true", `{"title": "Alien"}`, 18, ""},
+		{"truncated", "true", `{"title": "Ali`, 18, "body is shorter than its Content-Length (14 of 18 bytes)"},
+		{"padded", "true", `{"title": "Alien"}` + "\n\n", 18, "body length of 20 bytes does not match its Content-Length of 18 bytes"},
+		{"chunked", "true", `{"title": "Alien"}`, -1, ""},
+		{"truncated chunked", "true", `{"title": "Ali`, -1, "body contains badly-formed JSON"},
+		{"truncated when not strict", "false", `{"title": "Ali`, 18, "body contains badly-formed JSON"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := newConfiguredTestApplication(t, map[string]string{"STRICT_CONTENT_LENGTH": tt.strict})
+
+			r := httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(tt.body))
+			r.ContentLength = tt.contentLength
+
+			var input struct {
+				Title string `json:"title"`
+			}
+
+			err := app.readJSON(httptest.NewRecorder(), r, &input)
+
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("got error %q; want none", err)
+			case tt.wantErr == "" && input.Title != "Alien":
+				t.Errorf("got title %q; want the body decoded", input.Title)
+			case tt.wantErr != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.wantErr)):
+				t.Errorf("got error %v; want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPaginationMetadataFlags(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		t.Run(strconv.FormatBool(enabled), func(t *testing.T) {
+			app, _ := newTestApplication(t)
+			app.config.pagination.flags = enabled
+
+			metadata := data.Metadata{CurrentPage: 1, PageFlags: &data.PageFlags{TotalPages: 2, HasNextPage: true}}
+
+			js, err := json.Marshal(app.paginationMetadata(httptest.NewRequest(http.MethodGet, "/v1/movies", nil), metadata))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := strings.Contains(string(js), `"has_next_page":true`); got != enabled {
+				t.Errorf("got metadata %s; want the flags only when enabled", js)
+			}
+			if !strings.Contains(string(js), `"current_page":1`) {
+				t.Errorf("got metadata %s; want the existing fields kept", js)
+			}
+		})
+	}
+}
+
+func TestHumanDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{72 * time.Hour, "3 days"},
+		{24 * time.Hour, "1 day"},
+		{36 * time.Hour, "36 hours"},
+		{45 * time.Minute, "45 minutes"},
+		{90 * time.Second, "1m30s"},
+	}
+
+	for _, tt := range tests {
+		if got := humanDuration(tt.d); got != tt.want {
+			t.Errorf("humanDuration(%s): got %q; want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestOversizedBodiesAreRejectedWith413(t *testing.T) {
+	body := `{"title": "Alien", "year": 1979, "runtime": "117 mins", "genres": ["Horror"]}`
+
+	tests := []struct {
+		name       string
+		maxBytes   string
+		schema     string
+		wantStatus int
+	}{
+		{"within the limit", "1024", "false", http.StatusOK},
+		{"over the limit", "32", "false", http.StatusRequestEntityTooLarge},
+		{"over the limit with schemas", "32", "true", http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := newConfiguredTestApplication(t, map[string]string{
+				"MAX_REQUEST_BODY_BYTES": tt.maxBytes,
+				"JSON_SCHEMA_ENABLED":    tt.schema,
+			})
+
+			h := app.validateSchema("create_movie", func(w http.ResponseWriter, r *http.Request) {
+				var input map[string]any
+
+				if err := app.readJSON(w, r, &input); err != nil {
+					app.badRequestResponse(w, r, err)
+				}
+			})
+
+			rr := serve(t, h, httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(body)))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d; want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+
+			if tt.wantStatus == http.StatusOK {
+				return
+			}
+
+			var got struct {
+				Code  string `json:"code"`
+				Error string `json:"error"`
+			}
+
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+
+			if got.Code != errCodeRequestTooLarge || got.Error != "body must not be larger than 32 bytes" {
+				t.Errorf("got code %q and error %q", got.Code, got.Error)
+			}
+		})
+	}
+}
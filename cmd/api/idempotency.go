@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"greenlight/internal/idempotency"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// idempotentResponseWriter passes the response through to the client while keeping a copy
+// of it, so that it can be stored for replay.
+type idempotentResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	header     http.Header
+	body       bytes.Buffer
+}
+
+func (iw *idempotentResponseWriter) WriteHeader(statusCode int) {
+	if iw.statusCode == 0 {
+		iw.statusCode = statusCode
+		iw.header = idempotency.ReplayedHeader(iw.ResponseWriter.Header())
+	}
+
+	iw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (iw *idempotentResponseWriter) Write(b []byte) (int, error) {
+	if iw.statusCode == 0 {
+		iw.WriteHeader(http.StatusOK)
+	}
+
+	iw.body.Write(b)
+
+	return iw.ResponseWriter.Write(b)
+}
+
+func (iw *idempotentResponseWriter) Unwrap() http.ResponseWriter {
+	return iw.ResponseWriter
+}
+
+// credentialPaths take passwords or tokens in their bodies. Along with everything under
+// /v1/tokens/, whose responses hold new tokens, they are never handled idempotently, so that
+// no credential is kept in the idempotency store.
+var credentialPaths = []string{
+	"/v1/users/activated",
+	"/v1/users/password",
+	"/v1/users/email",
+	"/v1/users/me",
+}
+
+// idempotent executes each unsafe request sent with an Idempotency-Key header once. While
+// the first request with a key is running, others with the same key get a 409; once it has
+// completed, they get its response replayed. Keys are scoped to the user, method and path,
+// and a key reused with a different body gets a 422 rather than the response to the other
+// body. Responses with a 5xx status are not stored, so that failed requests can be retried.
+func (app *application) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Idempotency-Key")
+
+		if app.idempotency == nil || header == "" || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions ||
+			strings.HasPrefix(r.URL.Path, "/v1/tokens/") || slices.Contains(credentialPaths, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(header) > 255 {
+			app.badRequestResponse(w, r, errors.New("Idempotency-Key must not be more than 255 bytes long"))
+			return
+		}
+
+		maxBytes := max(app.config.requests.maxBodyBytes, app.config.posters.maxBytes)
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxBytes)))
+		if err != nil {
+			app.requestEntityTooLargeResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxBytes))
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := idempotency.Fingerprint([]byte(app.config.idempotency.secret), r.Method, r.URL.Path, body)
+
+		user := app.contextGetUser(r)
+		key := fmt.Sprintf("idempotency:%d:%s:%s:%s", user.ID, r.Method, r.URL.Path, header)
+		ttl := app.config.idempotency.ttl
+
+		resp, err := app.idempotency.Begin(r.Context(), key, ttl)
+		if err != nil {
+			switch {
+			case errors.Is(err, idempotency.ErrInProgress):
+				app.idempotencyInProgressResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if resp != nil {
+			if resp.Fingerprint != fingerprint {
+				app.idempotencyKeyReusedResponse(w, r)
+				return
+			}
+
+			for k, v := range idempotency.ReplayedHeader(resp.Header) {
+				w.Header()[k] = v
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(resp.Status)
+			w.Write(resp.Body)
+			return
+		}
+
+		iw := &idempotentResponseWriter{ResponseWriter: w}
+
+		// The key is released if the handler panics, before recoverPanic sends the 500.
+		completed := false
+		defer func() {
+			if !completed {
+				app.releaseIdempotencyKey(key)
+			}
+		}()
+
+		next.ServeHTTP(iw, r)
+
+		if iw.statusCode == 0 || iw.statusCode >= http.StatusInternalServerError {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		err = app.idempotency.Complete(ctx, key, &idempotency.Response{
+			Status:      iw.statusCode,
+			Header:      iw.header,
+			Body:        iw.body.Bytes(),
+			Fingerprint: fingerprint,
+		}, ttl)
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+
+		completed = true
+	})
+}
+
+func (app *application) releaseIdempotencyKey(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := app.idempotency.Release(ctx, key)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"idempotency_key": key})
+	}
+}
+
+// sweepIdempotencyKeys removes expired keys every interval until ctx is cancelled, for the
+// stores which do not expire them on their own.
+func (app *application) sweepIdempotencyKeys(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := app.models.Idempotency.DeleteExpired()
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			continue
+		}
+
+		if n > 0 {
+			app.logger.PrintInfo("expired idempotency keys deleted", map[string]string{"count": strconv.FormatInt(n, 10)})
+		}
+	}
+}
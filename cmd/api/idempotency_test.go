@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"greenlight/internal/data"
+	"greenlight/internal/idempotency"
+	"greenlight/internal/sqlfake"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryIdempotencyStore is an in-memory idempotency.Store for tests.
+type memoryIdempotencyStore struct {
+	mu        sync.Mutex
+	responses map[string]*idempotency.Response
+}
+
+func (s *memoryIdempotencyStore) Begin(ctx context.Context, key string, ttl time.Duration) (*idempotency.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resp, ok := s.responses[key]
+	if !ok {
+		s.responses[key] = nil
+		return nil, nil
+	}
+	if resp == nil {
+		return nil, idempotency.ErrInProgress
+	}
+
+	return resp, nil
+}
+
+func (s *memoryIdempotencyStore) Complete(ctx context.Context, key string, resp *idempotency.Response, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.responses[key] = resp
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.responses, key)
+	return nil
+}
+
+func TestIdempotentReplay(t *testing.T) {
+	app, _ := newTestApplication(t)
+	app.idempotency = &memoryIdempotencyStore{responses: map[string]*idempotency.Response{}}
+	app.config.idempotency.ttl = time.Hour
+	app.config.requests.maxBodyBytes = 1024
+
+	calls := 0
+	h := app.idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/v1/movies/1")
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Header().Set("X-RateLimit-Remaining", "3")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(body)
+	}))
+
+	request := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(body))
+		r.Header.Set("Idempotency-Key", "key-1")
+		r = app.contextSetUser(r, &data.User{ID: 1})
+
+		return serve(t, h, r)
+	}
+
+	first := request(`{"title":"Moana"}`)
+	if first.Code != http.StatusCreated || first.Body.String() != `{"title":"Moana"}` {
+		t.Fatalf("first request: got %d %q; the handler should see the whole body", first.Code, first.Body.String())
+	}
+
+	replay := request(`{"title":"Moana"}`)
+	if calls != 1 {
+		t.Fatalf("handler called %d times; want 1", calls)
+	}
+	if replay.Code != http.StatusCreated || replay.Body.String() != `{"title":"Moana"}` {
+		t.Errorf("replay: got %d %q", replay.Code, replay.Body.String())
+	}
+	if got := replay.Header().Get("Idempotent-Replayed"); got != "true" {
+		t.Errorf("Idempotent-Replayed = %q; want true", got)
+	}
+	if got := replay.Header().Get("Location"); got != "/v1/movies/1" {
+		t.Errorf("Location = %q; want it replayed", got)
+	}
+	for _, key := range []string{"Set-Cookie", "X-RateLimit-Remaining"} {
+		if got := replay.Header().Get(key); got != "" {
+			t.Errorf("%s = %q; want it not replayed", key, got)
+		}
+	}
+
+	reused := request(`{"title":"Frozen"}`)
+	if reused.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reused key: got %d; want %d", reused.Code, http.StatusUnprocessableEntity)
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times; want 1", calls)
+	}
+}
+
+func TestIdempotencySkipsCredentialRoutes(t *testing.T) {
+	for _, path := range []string{"/v1/tokens/authentication", "/v1/tokens/api-key", "/v1/users/password", "/v1/users/me"} {
+		t.Run(path, func(t *testing.T) {
+			app, _ := newTestApplication(t)
+			store := &memoryIdempotencyStore{responses: map[string]*idempotency.Response{}}
+			app.idempotency = store
+			app.config.idempotency.ttl = time.Hour
+			app.config.requests.maxBodyBytes = 1024
+
+			calls := 0
+			h := app.idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"authentication_token": {"token": "secret"}}`))
+			}))
+
+			for i := 0; i < 2; i++ {
+				r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"password": "pa55word"}`))
+				r.Header.Set("Idempotency-Key", "key-1")
+				serve(t, h, app.contextSetUser(r, &data.User{ID: 1}))
+			}
+
+			if calls != 2 {
+				t.Errorf("handler called %d times; want every request handled", calls)
+			}
+			if len(store.responses) != 0 {
+				t.Errorf("got %d stored responses; want none", len(store.responses))
+			}
+		})
+	}
+}
+
+func TestSweepIdempotencyKeysStopsWhenCancelled(t *testing.T) {
+	app, clk := newTestApplication(t)
+
+	calls := make(chan struct{}, 1)
+
+	useTestDB(t, app, clk, func(query string, args []any) (*sqlfake.Result, error) {
+		if !strings.Contains(query, "DELETE FROM idempotency_keys") || !args[0].(time.Time).Equal(clk.Now()) {
+			t.Errorf("unexpected query %q %v", query, args)
+		}
+		select {
+		case calls <- struct{}{}:
+		default:
+		}
+		return &sqlfake.Result{RowsAffected: 1}, nil
+	})
+
+	runUntilCancelled(t, calls, func(ctx context.Context) {
+		app.sweepIdempotencyKeys(ctx, time.Millisecond)
+	})
+}
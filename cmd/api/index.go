@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// indexEndpoint describes one endpoint in the API index.
+type indexEndpoint struct {
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	Access      string `json:"access"`
+	Deprecated  bool   `json:"deprecated,omitempty"`
+	Sunset      string `json:"sunset,omitempty"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// indexHandler serves a machine readable index of the API: its version, where to find the
+// OpenAPI description and error catalog, how to authenticate, the rate limit, and every
+// endpoint grouped by resource along with its deprecation. Like the OpenAPI description,
+// it is generated from the route table, so it always matches what is served.
+func (app *application) indexHandler(w http.ResponseWriter, r *http.Request) {
+	resources := make(map[string][]indexEndpoint)
+
+	for _, rt := range app.routeTable() {
+		resource, ok := indexResource(rt.pattern)
+		if !ok {
+			continue
+		}
+
+		endpoint := indexEndpoint{
+			Method: rt.method,
+			Path:   openAPIPath(rt.pattern),
+			Access: rt.policy,
+		}
+
+		if d, ok := app.deprecationFor(rt); ok {
+			endpoint.Deprecated = true
+			endpoint.Sunset = d.sunset.UTC().Format(time.DateOnly)
+			endpoint.Replacement = d.replacement
+		}
+
+		resources[resource] = append(resources[resource], endpoint)
+	}
+
+	links := map[string]string{
+		"self":    app.absoluteURL(r, "/v1"),
+		"openapi": app.absoluteURL(r, "/v1/openapi.json"),
+	}
+
+	if app.config.errors.catalog {
+		links["errors"] = app.absoluteURL(r, "/v1/errors")
+	}
+
+	rateLimit := map[string]any{"enabled": app.config.limiter.enabled}
+
+	if app.config.limiter.enabled {
+		rateLimit["requests_per_second"] = app.config.limiter.rps
+		rateLimit["burst"] = app.config.limiter.burst
+		rateLimit["key"] = app.config.limiter.key
+	}
+
+	env := envelope{
+		"name":           "Greenlight API",
+		"version":        version,
+		"links":          links,
+		"authentication": map[string]any{"schemes": app.config.auth.schemes},
+		"rate_limit":     rateLimit,
+		"resources":      resources,
+	}
+
+	err := app.writeResponse(w, r, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// indexResource returns the resource a /v1 route belongs to, which is the first segment of
+// its path after /v1, such as movies for /v1/movies/:id.
+func indexResource(pattern string) (string, bool) {
+	rest, ok := strings.CutPrefix(pattern, "/v1/")
+	if !ok {
+		return "", false
+	}
+
+	resource, _, _ := strings.Cut(rest, "/")
+
+	return resource, true
+}
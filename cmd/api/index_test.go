@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIndexListsTheResources(t *testing.T) {
+	app := newFullyConfiguredApplication(t)
+
+	deprecations, err := parseDeprecations("GET /v1/users/me/searches 2025-06-30 /v2/searches")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.config.deprecations = deprecations
+
+	rr := serve(t, http.HandlerFunc(app.indexHandler), httptest.NewRequest(http.MethodGet, "/v1", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d", rr.Code, http.StatusOK)
+	}
+
+	var body struct {
+		Version   string                     `json:"version"`
+		Links     map[string]string          `json:"links"`
+		Resources map[string][]indexEndpoint `json:"resources"`
+	}
+
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	if body.Version != version {
+		t.Errorf("got version %q; want %q", body.Version, version)
+	}
+	if body.Links["openapi"] == "" || body.Links["errors"] == "" {
+		t.Errorf("got links %v; want the OpenAPI description and error catalog", body.Links)
+	}
+
+	for _, resource := range []string{"movies", "users", "tokens", "healthcheck"} {
+		if len(body.Resources[resource]) == 0 {
+			t.Errorf("got no endpoints for %s", resource)
+		}
+	}
+	for _, endpoint := range body.Resources["debug"] {
+		if endpoint.Path == "/debug/metrics" {
+			t.Error("got /debug/metrics listed; want only /v1 routes")
+		}
+	}
+
+	tests := []struct {
+		resource string
+		want     indexEndpoint
+	}{
+		{"movies", indexEndpoint{Method: http.MethodGet, Path: "/v1/movies/{id}", Access: "movies:read"}},
+		{"users", indexEndpoint{Method: http.MethodPost, Path: "/v1/users/me/searches", Access: policyAuthenticated}},
+		{"users", indexEndpoint{Method: http.MethodGet, Path: "/v1/users/me/searches", Access: policyAuthenticated, Deprecated: true, Sunset: "2025-06-30", Replacement: "/v2/searches"}},
+	}
+
+	for _, tt := range tests {
+		found := false
+		for _, endpoint := range body.Resources[tt.resource] {
+			if endpoint.Method == tt.want.Method && endpoint.Path == tt.want.Path {
+				found = true
+				if endpoint != tt.want {
+					t.Errorf("got %+v; want %+v", endpoint, tt.want)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("got no %s %s endpoint under %s", tt.want.Method, tt.want.Path, tt.resource)
+		}
+	}
+}
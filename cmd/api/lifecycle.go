@@ -0,0 +1,40 @@
+package main
+
+import (
+	"sync/atomic"
+)
+
+// Server lifecycle states. A server starts out starting, moves to serving once it listens
+// for requests, to draining when a shutdown signal arrives, and to stopped once every
+// in-flight request and background task is done.
+const (
+	stateStarting = "starting"
+	stateServing  = "serving"
+	stateDraining = "draining"
+	stateStopped  = "stopped"
+)
+
+// lifecycle holds the current server state. The zero value is starting.
+type lifecycle struct {
+	state atomic.Value
+}
+
+func (l *lifecycle) get() string {
+	state, ok := l.state.Load().(string)
+	if !ok {
+		return stateStarting
+	}
+
+	return state
+}
+
+// setState moves the server to a new state, logging the transition.
+func (app *application) setState(state string) {
+	previous := app.lifecycle.get()
+	app.lifecycle.state.Store(state)
+
+	app.logger.PrintInfo("server state changed", map[string]string{
+		"from": previous,
+		"to":   state,
+	})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"greenlight/internal/jsonlog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// probe sends a GET to the liveness or readiness handler and returns the status code and
+// the reported status.
+func probe(t *testing.T, h http.HandlerFunc, target string) (int, string) {
+	t.Helper()
+
+	rr := serve(t, h, httptest.NewRequest(http.MethodGet, target, nil))
+
+	var body struct {
+		Status string `json:"status"`
+	}
+
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	return rr.Code, body.Status
+}
+
+func TestReadinessReportsDraining(t *testing.T) {
+	app, _ := newTestApplication(t)
+
+	var out bytes.Buffer
+	app.logger = jsonlog.New(&out, jsonlog.LevelInfo)
+
+	tests := []struct {
+		state      string
+		wantStatus int
+	}{
+		{stateServing, http.StatusOK},
+		{stateDraining, http.StatusServiceUnavailable},
+		{stateStopped, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		app.setState(tt.state)
+
+		if code, status := probe(t, app.readinessHandler, "/v1/healthcheck/ready"); code != tt.wantStatus || status != tt.state {
+			t.Errorf("%s: got ready %d %q; want %d %q", tt.state, code, status, tt.wantStatus, tt.state)
+		}
+
+		// Live keeps succeeding while the server drains, so that it is not restarted with
+		// requests still in flight.
+		if code, status := probe(t, app.livenessHandler, "/v1/healthcheck/live"); code != http.StatusOK || status != "alive" {
+			t.Errorf("%s: got live %d %q; want %d \"alive\"", tt.state, code, status, http.StatusOK)
+		}
+	}
+
+	for _, want := range []string{
+		`"from":"starting","to":"serving"`,
+		`"from":"serving","to":"draining"`,
+		`"from":"draining","to":"stopped"`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("got log %q; want the transition %s", out.String(), want)
+		}
+	}
+}
+
+func TestHealthzProbes(t *testing.T) {
+	app, _ := newTestApplication(t)
+	app.logger = jsonlog.New(&bytes.Buffer{}, jsonlog.LevelInfo)
+
+	// The server is not ready until it listens, while the process is already alive.
+	if code, status := probe(t, app.readinessHandler, "/v1/healthz/ready"); code != http.StatusServiceUnavailable || status != stateStarting {
+		t.Errorf("before listening: got ready %d %q; want %d %q", code, status, http.StatusServiceUnavailable, stateStarting)
+	}
+	if code, _ := probe(t, app.livenessHandler, "/v1/healthz/live"); code != http.StatusOK {
+		t.Errorf("before listening: got live %d; want %d", code, http.StatusOK)
+	}
+
+	app.setState(stateServing)
+
+	if code, _ := probe(t, app.readinessHandler, "/v1/healthz/ready"); code != http.StatusOK {
+		t.Errorf("listening: got ready %d; want %d", code, http.StatusOK)
+	}
+
+	want := map[string]bool{"/v1/healthz/live": false, "/v1/healthz/ready": false}
+
+	for _, rt := range app.routeTable() {
+		if _, ok := want[rt.pattern]; !ok {
+			continue
+		}
+
+		want[rt.pattern] = true
+		if rt.method != http.MethodGet || rt.policy != policyPublic {
+			t.Errorf("got %s %s behind %q; want a public GET", rt.method, rt.pattern, rt.policy)
+		}
+	}
+
+	for pattern, routed := range want {
+		if !routed {
+			t.Errorf("got no route for %s", pattern)
+		}
+	}
+}
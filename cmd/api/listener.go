@@ -0,0 +1,93 @@
+package main
+
+import (
+	"greenlight/internal/clock"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// connLimitListener wraps a net.Listener and drops new connections from any client IP that
+// opens them faster than the configured rate. Unlike the rateLimit middleware this also
+// catches clients which open connections but never complete a request.
+type connLimitListener struct {
+	net.Listener
+	clock clock.Clock
+	rps   float64
+	burst int
+
+	mu      sync.Mutex
+	clients map[string]*connLimitClient
+}
+
+type connLimitClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newConnLimitListener(ln net.Listener, clk clock.Clock, rps float64, burst int) *connLimitListener {
+	l := &connLimitListener{
+		Listener: ln,
+		clock:    clk,
+		rps:      rps,
+		burst:    burst,
+		clients:  make(map[string]*connLimitClient),
+	}
+
+	go l.cleanup()
+
+	return l
+}
+
+func (l *connLimitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		if l.allow(conn.RemoteAddr()) {
+			return conn, nil
+		}
+
+		conn.Close()
+	}
+}
+
+func (l *connLimitListener) allow(addr net.Addr) bool {
+	ip, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		ip = addr.String()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, found := l.clients[ip]; !found {
+		l.clients[ip] = &connLimitClient{limiter: rate.NewLimiter(rate.Limit(l.rps), l.burst)}
+	}
+
+	now := l.clock.Now()
+
+	l.clients[ip].lastSeen = now
+
+	return l.clients[ip].limiter.AllowN(now, 1)
+}
+
+func (l *connLimitListener) cleanup() {
+	for {
+		time.Sleep(time.Minute)
+
+		l.mu.Lock()
+
+		for ip, client := range l.clients {
+			if l.clock.Now().Sub(client.lastSeen) > 3*time.Minute {
+				delete(l.clients, ip)
+			}
+		}
+
+		l.mu.Unlock()
+	}
+}
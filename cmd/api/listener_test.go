@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeListener hands out queued connections, then fails once the queue runs out.
+type fakeListener struct {
+	net.Listener
+	queue []*fakeConn
+}
+
+func (l *fakeListener) Accept() (net.Conn, error) {
+	if len(l.queue) == 0 {
+		return nil, errors.New("no more connections")
+	}
+
+	conn := l.queue[0]
+	l.queue = l.queue[1:]
+
+	return conn, nil
+}
+
+type fakeConn struct {
+	net.Conn
+	addr   net.Addr
+	closed bool
+}
+
+func (c *fakeConn) RemoteAddr() net.Addr { return c.addr }
+
+func (c *fakeConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestConnLimitListenerDropsConnectionFloods(t *testing.T) {
+	app, clk := newTestApplication(t)
+
+	dial := func(ip string) *fakeConn {
+		return &fakeConn{addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}}
+	}
+
+	flood := []*fakeConn{dial("192.0.2.1"), dial("192.0.2.1"), dial("192.0.2.1"), dial("192.0.2.1")}
+	other := dial("192.0.2.2")
+
+	inner := &fakeListener{queue: append(flood, other)}
+	ln := newConnLimitListener(inner, app.clock, 1, 2)
+
+	var accepted []net.Conn
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			break
+		}
+		accepted = append(accepted, conn)
+	}
+
+	if len(accepted) != 3 || accepted[0] != flood[0] || accepted[1] != flood[1] || accepted[2] != other {
+		t.Fatalf("got %d connections accepted; want the first two from the flooding IP and the other IP's", len(accepted))
+	}
+	for i, conn := range flood {
+		if want := i >= 2; conn.closed != want {
+			t.Errorf("flood connection %d: got closed %t; want %t", i+1, conn.closed, want)
+		}
+	}
+
+	clk.Advance(time.Second)
+
+	refilled := dial("192.0.2.1")
+	inner.queue = []*fakeConn{refilled}
+
+	if conn, err := ln.Accept(); err != nil || conn != refilled {
+		t.Errorf("after refill: got %v, %v; want the connection accepted", conn, err)
+	}
+}
@@ -2,23 +2,47 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"database/sql"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
+	"greenlight/internal/cache"
+	"greenlight/internal/clock"
 	"greenlight/internal/data"
+	"greenlight/internal/fieldcrypt"
+	"greenlight/internal/idempotency"
 	"greenlight/internal/jsonlog"
 	"greenlight/internal/mailer"
+	"greenlight/internal/metadata"
+	"greenlight/internal/objectstore"
+	"greenlight/internal/outbound"
+	"greenlight/internal/pubsub"
+	"greenlight/internal/ratelimit"
+	"greenlight/internal/redis"
+	"greenlight/internal/storage"
+	"greenlight/internal/tracing"
+	"greenlight/internal/validator"
 	"greenlight/internal/vcs"
+	"greenlight/internal/webhook"
+	"maps"
+	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/joho/godotenv"
+	"golang.org/x/time/rate"
 )
 
 var version = vcs.Version()
@@ -32,31 +56,313 @@ type config struct {
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  string
+		slowQuery    time.Duration
+		explainSlow  bool
+		// replicaURLs are read replicas which reads are spread over. Writes always go to
+		// the primary at url.
+		replicaURLs    []string
+		connectTimeout time.Duration
+		healthInterval time.Duration
 	}
 	limiter struct {
 		rps     float64
 		burst   int
 		enabled bool
+		key     string
+		backend string
+		// redisURL is the Redis server shared by every instance for the redis backend.
+		redisURL string
+	}
+	connLimiter struct {
+		rps     float64
+		burst   int
+		enabled bool
+	}
+	// concurrency caps the requests in flight at once per client IP and per authenticated
+	// user. Zero disables a cap.
+	concurrency struct {
+		perIP   int
+		perUser int
 	}
 	smtp struct {
-		host     string
-		port     int
-		username string
-		password string
-		sender   string
+		host         string
+		port         int
+		username     string
+		password     string
+		sender       string
+		maxAttempts  int
+		retryBackoff time.Duration
 	}
+	// cors holds which origins may make cross-origin requests, and what preflight responses
+	// allow them to send. maxAge is how long browsers may cache a preflight response.
 	cors struct {
-		trustedOrigins []string
+		trustedOrigins   []originPattern
+		allowedMethods   []string
+		allowedHeaders   []string
+		allowCredentials bool
+		maxAge           time.Duration
+	}
+	// tokens holds how long activation, authentication and password reset tokens are
+	// valid for.
+	tokens struct {
+		activationTTL     time.Duration
+		authenticationTTL time.Duration
+		passwordResetTTL  time.Duration
+	}
+	auth struct {
+		schemes     []string
+		jwtSecret   string
+		jwtIssuer   string
+		jwtAudience string
+		jwtTTL      time.Duration
+		apiKeyTTL   time.Duration
+		// clockSkew is how far token timestamps may be off and still be accepted. When
+		// ntpServer is set, the local clock is compared with it at startup and a warning is
+		// logged if they differ by more than clockSkew.
+		clockSkew time.Duration
+		ntpServer string
+	}
+	proxy struct {
+		trusted         []netip.Prefix
+		externalBaseURL string
+		redirectHTTPS   bool
+	}
+	// securityHeaders holds the Content-Security-Policy sent with every response, and the
+	// HSTS max-age sent with responses over HTTPS. An empty policy or a zero max-age leaves
+	// out the header.
+	securityHeaders struct {
+		csp        string
+		hstsMaxAge time.Duration
+	}
+	genres struct {
+		casing   string
+		taxonomy string
+	}
+	movies struct {
+		yearMin     data.YearBound
+		yearMax     data.YearBound
+		slugAliases bool
+		softDelete  bool
+		acl         bool
+	}
+	deprecations          []deprecation
+	dependencyErrorStatus int
+	requests              struct {
+		strictContentLength bool
+		maxBodyBytes        int
+		timeout             time.Duration
+	}
+	responses struct {
+		maxBytes    int
+		invalidUTF8 string
+		xml         bool
+	}
+	compression struct {
+		enabled  bool
+		minBytes int
+	}
+	pagination struct {
+		maxOffset        int
+		rejectDeepOffset bool
+		flags            bool
+		links            bool
+	}
+	savedSearches struct {
+		maxPerUser int
+	}
+	methodOverride struct {
+		enabled bool
+	}
+	errors struct {
+		docsBaseURL string
+		incidentIDs bool
+		// quietCancel skips the error response for requests whose client has gone away,
+		// logging them at info level instead of as server errors.
+		quietCancel bool
+		catalog     bool
+	}
+	jsonSchema struct {
+		enabled bool
+	}
+	validationRules struct {
+		enabled bool
+	}
+	index struct {
+		enabled bool
+	}
+	// popularity counts movie views. With a flushInterval they are buffered in memory and
+	// written that often, instead of on every view.
+	popularity struct {
+		countOnGet    bool
+		flushInterval time.Duration
+	}
+	webhooks struct {
+		timeout      time.Duration
+		maxAttempts  int
+		retryBackoff time.Duration
+	}
+	deadLetter struct {
+		enabled     bool
+		replayLimit int
+	}
+	tracing struct {
+		enabled       bool
+		sampleRate    float64
+		slowThreshold time.Duration
+	}
+	exports struct {
+		endpoint  string
+		region    string
+		bucket    string
+		accessKey string
+		secretKey string
+		timeout   time.Duration
+	}
+	metadata struct {
+		provider string
+		baseURL  string
+		apiKey   string
+		timeout  time.Duration
+	}
+	flatten struct {
+		delimiter string
 	}
+	healthcheck struct {
+		timeout      time.Duration
+		smtpCritical bool
+	}
+	events struct {
+		heartbeat time.Duration
+	}
+	shutdown struct {
+		drainDelay time.Duration
+		timeout    time.Duration
+	}
+	batch struct {
+		maxItems       int
+		upsertOutcomes bool
+	}
+	emailPrefs struct {
+		enabled        bool
+		unsubscribeTTL time.Duration
+	}
+	outbox struct {
+		batchSize     int
+		workers       int
+		queueCapacity int
+		queueWait     time.Duration
+		pollInterval  time.Duration
+		maxAttempts   int
+	}
+	idempotency struct {
+		backend       string
+		ttl           time.Duration
+		secret        string
+		redisAddr     string
+		redisPassword string
+		redisDB       int
+	}
+	reindex struct {
+		batchSize int
+	}
+	// movieCache holds movies shown by id for ttl. Expired entries are kept for staleTTL
+	// longer, to be served according to the stale mode when the database is slow or down.
+	movieCache struct {
+		ttl         time.Duration
+		staleTTL    time.Duration
+		stale       string
+		readTimeout time.Duration
+		maxEntries  int
+	}
+	// tls serves HTTPS with certFile and keyFile when both are set, and HTTP/3 over QUIC on
+	// the same port when http3 is set too. config is built from the minimum version and
+	// cipher policy after the flags are parsed.
+	tls struct {
+		certFile string
+		keyFile  string
+		http3    bool
+		config   *tls.Config
+	}
+	// encryption holds the keys sensitive fields are encrypted with in the database, or nil
+	// when field encryption is disabled.
+	encryption struct {
+		keys *fieldcrypt.Keyring
+	}
+	// activation controls how an activation token used a second time, within retention of
+	// the first, is answered.
+	activation struct {
+		repeat    string
+		retention time.Duration
+	}
+	// users controls the soft deletion of users, who are purged once they have been deleted
+	// for deletedRetention. A zero retention keeps them until restored.
+	users struct {
+		softDelete       bool
+		deletedRetention time.Duration
+	}
+	// roles enables permissions granted through roles, and the endpoints managing them.
+	roles struct {
+		enabled bool
+	}
+	// listCache holds pages of movies for ttl. Every movie write invalidates all of
+	// them, so the ttl only bounds how long an unused page takes up room.
+	listCache struct {
+		ttl        time.Duration
+		maxEntries int
+	}
+	// outbound controls the structured logging of every call made to an external dependency.
+	outbound struct {
+		logging bool
+	}
+	// audit controls the recording of access denials, to the log, the access_denials table
+	// or both, at no more than rps (with bursts of burst) so that probing cannot flood them.
+	audit struct {
+		denials string
+		rps     float64
+		burst   int
+	}
+	posters struct {
+		backend   string
+		dir       string
+		endpoint  string
+		region    string
+		bucket    string
+		accessKey string
+		secretKey string
+		serve     string
+		maxBytes  int
+		ranges    bool
+	}
+	// displayVersion is set by the -version flag, to print the version and exit.
+	displayVersion bool
 }
 
 // application struct holds the dependencies for our HTTP handlers, helpers, and middleware.
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config      config
+	logger      *jsonlog.Logger
+	db          *data.DB
+	models      data.Models
+	mailer      mailer.Mailer
+	mailQueue   *mailer.Queue
+	clock       clock.Clock
+	objectStore *objectstore.Client
+	posters     storage.BlobStore
+	webhooks    webhook.Client
+	tracer      *tracing.Provider
+	reindex     reindexJob
+	metadata    metadata.Provider
+	idempotency idempotency.Store
+	limiter     ratelimit.Limiter
+	views       viewBuffer
+	denials     denialAuditor
+	movieCache  *cache.Cache[int64, *data.Movie]
+	listCache   *cache.Cache[string, movieListPage]
+	movieEvents *pubsub.Hub[movieEvent]
+	// listGeneration is part of every listCache key, and is bumped to invalidate them all.
+	listGeneration atomic.Uint64
+	lifecycle      lifecycle
+	wg             sync.WaitGroup
 }
 
 func main() {
@@ -67,163 +373,1248 @@ func main() {
 		logger.PrintFatal(err, nil)
 	}
 
-	var cfg config
+	cfg, err := parseConfig(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	if cfg.displayVersion {
+		fmt.Printf("Version:\t%s\n", version)
+		os.Exit(0)
+	}
+
+	db, err := openDB(cfg, cfg.db.url)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	defer db.Close()
+
+	err = ping(db)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	logger.PrintInfo("database connection pool established", nil)
+
+	// Replicas are not pinged here, as the API can run without them until they come up.
+	var replicas []*sql.DB
+
+	for _, dsn := range cfg.db.replicaURLs {
+		replica, err := openDB(cfg, dsn)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		defer replica.Close()
+
+		replicas = append(replicas, replica)
+	}
+
+	appDB := &data.DB{
+		DB:            db,
+		Replicas:      replicas,
+		Logger:        logger,
+		SlowThreshold: cfg.db.slowQuery,
+		Explain:       cfg.db.explainSlow,
+	}
+
+	monitorCtx, stopMonitor := context.WithCancel(context.Background())
+	defer stopMonitor()
+
+	appDB.Monitor(monitorCtx, cfg.db.healthInterval, cfg.db.connectTimeout)
+
+	expvar.NewString("version").Set(version)
+
+	expvar.Publish("goroutines", expvar.Func(func() any {
+		return runtime.NumGoroutine()
+	}))
+
+	expvar.Publish("database", expvar.Func(func() any {
+		return db.Stats()
+	}))
+
+	expvar.Publish("timestamp", expvar.Func(func() any {
+		return time.Now().Unix()
+	}))
+
+	clk := clock.Real{}
+
+	var recorder *outbound.Recorder
+	if cfg.outbound.logging {
+		recorder = &outbound.Recorder{Logger: logger}
+	}
+
+	app := &application{
+		config:   cfg,
+		logger:   logger,
+		db:       appDB,
+		models:   data.NewModels(appDB, clk),
+		mailer:   mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		clock:    clk,
+		webhooks: webhook.New(cfg.webhooks.timeout),
+	}
+
+	app.movieEvents = pubsub.New[movieEvent]()
+
+	app.mailer.Recorder = recorder
+	app.mailer.MaxAttempts = cfg.smtp.maxAttempts
+	app.mailer.RetryBackoff = cfg.smtp.retryBackoff
+	app.mailQueue = mailer.NewQueue(app.sendEmail, cfg.outbox.workers, cfg.outbox.queueCapacity, cfg.outbox.queueWait)
+
+	// The email backlog gauges are read when the metrics are, so that the outbox is only
+	// counted when somebody is looking rather than on every poll of the dispatcher.
+	expvar.Publish("email_outbox_pending", expvar.Func(func() any {
+		pending, err := app.models.EmailOutbox.Pending()
+		if err != nil {
+			return nil
+		}
+		return pending
+	}))
+
+	expvar.Publish("email_queue_depth", expvar.Func(func() any {
+		return app.mailQueue.Len()
+	}))
+
+	expvar.Publish("emails_in_flight", expvar.Func(func() any {
+		return app.mailQueue.InFlight()
+	}))
+	app.webhooks.HTTPClient = outbound.Instrument(app.webhooks.HTTPClient, "webhook", recorder)
+
+	app.models.Movies.KeepSlugAliases = cfg.movies.slugAliases
+	app.models.Movies.SoftDelete = cfg.movies.softDelete
+	app.models.Users.ClockSkew = cfg.auth.clockSkew
+	app.models.Permissions.IncludeRoles = cfg.roles.enabled
+	app.models.EmailOutbox.Keys = cfg.encryption.keys
+
+	if cfg.auth.ntpServer != "" {
+		go app.checkClock()
+	}
+
+	if cfg.tracing.enabled {
+		app.tracer = newTracer(cfg, logger)
+	}
+
+	if cfg.exports.endpoint != "" {
+		app.objectStore = objectstore.New(cfg.exports.endpoint, cfg.exports.region, cfg.exports.bucket, cfg.exports.accessKey, cfg.exports.secretKey)
+		app.objectStore.HTTPClient = outbound.Instrument(app.objectStore.HTTPClient, "exports", recorder)
+	}
+
+	switch cfg.limiter.backend {
+	case "memory":
+		app.limiter = ratelimit.NewMemory(cfg.limiter.rps, cfg.limiter.burst, clk)
+	case "redis":
+		client, err := redis.ParseURL(cfg.limiter.redisURL)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		app.limiter = ratelimit.NewRedis(client, cfg.limiter.rps, cfg.limiter.burst)
+	}
+
+	switch cfg.idempotency.backend {
+	case "postgres":
+		app.idempotency = app.models.Idempotency
+	case "redis":
+		app.idempotency = idempotency.NewRedisStore(cfg.idempotency.redisAddr, cfg.idempotency.redisPassword, cfg.idempotency.redisDB)
+	}
+
+	if cfg.movieCache.ttl > 0 {
+		app.movieCache = cache.New[int64, *data.Movie](cfg.movieCache.maxEntries, cfg.movieCache.ttl+cfg.movieCache.staleTTL, clk)
+	}
+
+	if cfg.listCache.ttl > 0 {
+		app.listCache = cache.New[string, movieListPage](cfg.listCache.maxEntries, cfg.listCache.ttl, clk)
+	}
+
+	app.denials.limiter = rate.NewLimiter(rate.Limit(cfg.audit.rps), cfg.audit.burst)
+
+	if cfg.metadata.baseURL != "" {
+		provider := metadata.NewHTTPProvider(cfg.metadata.provider, cfg.metadata.baseURL, cfg.metadata.apiKey, cfg.metadata.timeout)
+		provider.HTTPClient = outbound.Instrument(provider.HTTPClient, "metadata", recorder)
+
+		app.metadata = provider
+	}
+
+	switch cfg.posters.backend {
+	case "s3":
+		client := objectstore.New(cfg.posters.endpoint, cfg.posters.region, cfg.posters.bucket, cfg.posters.accessKey, cfg.posters.secretKey)
+		client.HTTPClient = outbound.Instrument(client.HTTPClient, "posters", recorder)
+
+		app.posters = storage.NewS3Store(client)
+	default:
+		app.posters, err = storage.NewFileStore(cfg.posters.dir)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	}
+
+	err = app.serve()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+}
+
+// parseConfig reads the configuration from the environment, through lookupEnv, and the flags
+// in args, which override it. Every invalid setting is reported in the returned error, so
+// that they can all be fixed in one go.
+func parseConfig(args []string, lookupEnv func(string) (string, bool)) (config, error) {
+	// Settings from the config file are only used for variables which are not set in the
+	// environment, and flags override both. The environment itself is never changed.
+	configFile := configFileArg(args)
+
+	fileSettings, err := loadConfigFile(configFile)
+	if err != nil {
+		return config{}, fmt.Errorf("invalid config file %s", err)
+	}
+
+	env := settings{lookupEnv: lookupEnv, file: fileSettings}
+
+	// The flags are parsed on a first pass, and the values of those given are looked up
+	// ahead of the environment on a second one. That way flags go through the same checks
+	// as every other source, and an invalid variable overridden by a flag is not reported.
+	cfg, fs, _, err := readConfig(env, configFile, args)
+	if err != nil || cfg.displayVersion {
+		return cfg, err
+	}
+
+	env.flags = map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		env.flags[f.Name] = f.Value.String()
+	})
+
+	cfg, fs, configErrors, err := readConfig(env, configFile, nil)
+	if err != nil {
+		return config{}, err
+	}
+
+	var unknown []string
+	for name := range fileSettings {
+		if fs.Lookup(name) == nil {
+			unknown = append(unknown, name)
+		}
+	}
+	slices.Sort(unknown)
+
+	for _, name := range unknown {
+		configErrors = append(configErrors, fmt.Errorf("unknown setting %s in config file", name))
+	}
+
+	return cfg, errors.Join(configErrors...)
+}
+
+// readConfig defines every setting as a flag of the returned flag set, defaulting to its
+// value in env, parses args and checks the settings. Invalid settings are returned as
+// config errors, while err is only set when args cannot be parsed.
+func readConfig(env settings, configFile string, args []string) (cfg config, fs *flag.FlagSet, configErrors []error, err error) {
+	fs = flag.NewFlagSet("api", flag.ContinueOnError)
+
+	fs.String("config", configFile, "JSON file of settings keyed by their environment variable names, which the environment overrides")
+
+	port, err := strconv.Atoi(env.Get("PORT"))
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid port %s", err))
+	}
+	fs.IntVar(&cfg.port, "PORT", port, "API server port")
+
+	tlsCertFile := env.Get("TLS_CERT_FILE")
+	fs.StringVar(&cfg.tls.certFile, "TLS_CERT_FILE", tlsCertFile, "TLS certificate file, to serve HTTPS")
+
+	tlsKeyFile := env.Get("TLS_KEY_FILE")
+	fs.StringVar(&cfg.tls.keyFile, "TLS_KEY_FILE", tlsKeyFile, "TLS private key file, to serve HTTPS")
 
-	port, err := strconv.Atoi(os.Getenv("PORT"))
+	http3Enabled, err := env.Bool("HTTP3_ENABLED", false)
 	if err != nil {
-		logger.PrintFatal(fmt.Errorf("invalid port %s", err), nil)
+		configErrors = append(configErrors, fmt.Errorf("invalid HTTP3_ENABLED %s", err))
 	}
-	flag.IntVar(&cfg.port, "PORT", port, "API server port")
+	fs.BoolVar(&cfg.tls.http3, "HTTP3_ENABLED", http3Enabled, "Also serve HTTP/3 over QUIC on the UDP port, advertised with Alt-Svc (requires TLS)")
+
+	fieldEncryptionKeys := env.Get("FIELD_ENCRYPTION_KEYS")
+	fs.StringVar(&fieldEncryptionKeys, "FIELD_ENCRYPTION_KEYS", fieldEncryptionKeys, "Keys encrypting sensitive database fields, current first (space separated id:base64 AES-256 keys)")
+
+	tlsMinVersion := env.String("TLS_MIN_VERSION", "1.2")
+	fs.StringVar(&tlsMinVersion, "TLS_MIN_VERSION", tlsMinVersion, "Minimum TLS version (1.2|1.3)")
 
-	environment := os.Getenv("ENVIRONEMNT")
+	tlsCipherPolicy := env.String("TLS_CIPHER_POLICY", cipherPolicyIntermediate)
+	fs.StringVar(&tlsCipherPolicy, "TLS_CIPHER_POLICY", tlsCipherPolicy, "TLS cipher suite policy (modern|intermediate)")
+
+	environment := env.Get("ENVIRONEMNT")
 	if _, ok := map[string]bool{"development": true, "staging": true, "production": true}[environment]; !ok {
-		logger.PrintFatal(fmt.Errorf("invalid environment %s", environment), nil)
+		configErrors = append(configErrors, fmt.Errorf("invalid environment %s", environment))
 	}
-	flag.StringVar(&cfg.env, "ENVIRONEMNT", "development", "Environment (development|staging|production)")
+	fs.StringVar(&cfg.env, "ENVIRONEMNT", environment, "Environment (development|staging|production)")
 
-	postgresUrl := os.Getenv("POSTGRESQL_URL")
+	postgresUrl := env.Get("POSTGRESQL_URL")
 	if postgresUrl == "" {
-		logger.PrintFatal(fmt.Errorf("POSTGRESQL_URL is not set"), nil)
+		configErrors = append(configErrors, fmt.Errorf("POSTGRESQL_URL is not set"))
 	}
-	flag.StringVar(&cfg.db.url, "POSTGRESQL_URL", postgresUrl, "PostgreSQL DSN")
+	fs.StringVar(&cfg.db.url, "POSTGRESQL_URL", postgresUrl, "PostgreSQL DSN")
 
-	postgresMaxOpenConns, err := strconv.Atoi(os.Getenv("POSTGRESQL_MAX_OPEN_CONNS"))
+	postgresMaxOpenConns, err := strconv.Atoi(env.Get("POSTGRESQL_MAX_OPEN_CONNS"))
 	if err != nil {
-		logger.PrintFatal(fmt.Errorf("invalid POSTGRESQL_MAX_OPEN_CONNS %s", err), nil)
+		configErrors = append(configErrors, fmt.Errorf("invalid POSTGRESQL_MAX_OPEN_CONNS %s", err))
 	}
-	flag.IntVar(&cfg.db.maxOpenConns, "POSTGRESQL_MAX_OPEN_CONNS", postgresMaxOpenConns, "PostgreSQL max open connections")
+	fs.IntVar(&cfg.db.maxOpenConns, "POSTGRESQL_MAX_OPEN_CONNS", postgresMaxOpenConns, "PostgreSQL max open connections")
 
-	postgresMaxIdleConns, err := strconv.Atoi(os.Getenv("POSTGRESQL_MAX_IDLE_CONNS"))
+	postgresMaxIdleConns, err := strconv.Atoi(env.Get("POSTGRESQL_MAX_IDLE_CONNS"))
 	if err != nil {
-		logger.PrintFatal(fmt.Errorf("invalid POSTGRESQL_MAX_IDLE_CONNS %s", err), nil)
+		configErrors = append(configErrors, fmt.Errorf("invalid POSTGRESQL_MAX_IDLE_CONNS %s", err))
 	}
-	flag.IntVar(&cfg.db.maxIdleConns, "POSTGRESQL_MAX_IDLE_CONNS", postgresMaxIdleConns, "PostgreSQL max idle connections")
+	fs.IntVar(&cfg.db.maxIdleConns, "POSTGRESQL_MAX_IDLE_CONNS", postgresMaxIdleConns, "PostgreSQL max idle connections")
 
-	postgresMaxIdleTime := os.Getenv("POSTGRESQL_MAX_IDLE_TIME")
+	postgresMaxIdleTime := env.Get("POSTGRESQL_MAX_IDLE_TIME")
 	if postgresMaxIdleTime == "" {
-		logger.PrintFatal(fmt.Errorf("invalid POSTGRESQL_MAX_IDLE_TIME %s", err), nil)
+		configErrors = append(configErrors, fmt.Errorf("invalid POSTGRESQL_MAX_IDLE_TIME %s", err))
+	}
+	fs.StringVar(&cfg.db.maxIdleTime, "POSTGRESQL_MAX_IDLE_TIME", postgresMaxIdleTime, "PostgreSQL max connection idle time")
+
+	postgresSlowQuery, err := env.Duration("POSTGRESQL_SLOW_QUERY_THRESHOLD", 500*time.Millisecond)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid POSTGRESQL_SLOW_QUERY_THRESHOLD %s", err))
+	}
+	fs.DurationVar(&cfg.db.slowQuery, "POSTGRESQL_SLOW_QUERY_THRESHOLD", postgresSlowQuery, "Log queries slower than this duration (0 disables)")
+
+	postgresExplainSlow, err := env.Bool("POSTGRESQL_EXPLAIN_SLOW_QUERIES", false)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid POSTGRESQL_EXPLAIN_SLOW_QUERIES %s", err))
+	}
+	fs.BoolVar(&cfg.db.explainSlow, "POSTGRESQL_EXPLAIN_SLOW_QUERIES", postgresExplainSlow, "Attach EXPLAIN output to slow query logs (ignored in production)")
+
+	postgresReplicaURLs := env.Get("POSTGRESQL_REPLICA_URLS")
+	fs.StringVar(&postgresReplicaURLs, "POSTGRESQL_REPLICA_URLS", postgresReplicaURLs, "PostgreSQL read replica DSNs (space separated)")
+
+	postgresConnectTimeout, err := env.Duration("POSTGRESQL_CONNECT_TIMEOUT", 2*time.Second)
+	if err != nil || postgresConnectTimeout <= 0 {
+		configErrors = append(configErrors, fmt.Errorf("invalid POSTGRESQL_CONNECT_TIMEOUT %s", env.Get("POSTGRESQL_CONNECT_TIMEOUT")))
+	}
+	fs.DurationVar(&cfg.db.connectTimeout, "POSTGRESQL_CONNECT_TIMEOUT", postgresConnectTimeout, "PostgreSQL connect timeout, after which the database is treated as down")
+
+	postgresHealthInterval, err := env.Duration("POSTGRESQL_HEALTH_INTERVAL", 5*time.Second)
+	if err != nil || postgresHealthInterval <= 0 {
+		configErrors = append(configErrors, fmt.Errorf("invalid POSTGRESQL_HEALTH_INTERVAL %s", env.Get("POSTGRESQL_HEALTH_INTERVAL")))
 	}
-	flag.StringVar(&cfg.db.maxIdleTime, "POSTGRESQL_MAX_IDLE_TIME", postgresMaxIdleTime, "PostgreSQL max connection idle time")
+	fs.DurationVar(&cfg.db.healthInterval, "POSTGRESQL_HEALTH_INTERVAL", postgresHealthInterval, "How often the primary and replicas are checked")
 
-	limiterRps, err := strconv.ParseFloat(os.Getenv("LIMITER_RPS"), 64)
+	limiterRps, err := strconv.ParseFloat(env.Get("LIMITER_RPS"), 64)
 	if err != nil {
-		logger.PrintFatal(fmt.Errorf("invalid LIMITER_RPS %s", err), nil)
+		configErrors = append(configErrors, fmt.Errorf("invalid LIMITER_RPS %s", err))
 	}
-	flag.Float64Var(&cfg.limiter.rps, "LIMITER_RPS", limiterRps, "Rate limiter maximum requests per second")
+	fs.Float64Var(&cfg.limiter.rps, "LIMITER_RPS", limiterRps, "Rate limiter maximum requests per second")
 
-	limiterBurst, err := strconv.Atoi(os.Getenv("LIMITER_BURST"))
+	limiterBurst, err := strconv.Atoi(env.Get("LIMITER_BURST"))
 	if err != nil {
-		logger.PrintFatal(fmt.Errorf("invalid LIMITER_BURST %s", err), nil)
+		configErrors = append(configErrors, fmt.Errorf("invalid LIMITER_BURST %s", err))
 	}
-	flag.IntVar(&cfg.limiter.burst, "LIMITER_BURST", limiterBurst, "Rate limiter maximum burst")
+	fs.IntVar(&cfg.limiter.burst, "LIMITER_BURST", limiterBurst, "Rate limiter maximum burst")
 
-	limiterEnabled, err := strconv.ParseBool(os.Getenv("LIMITER_ENABLED"))
+	limiterEnabled, err := strconv.ParseBool(env.Get("LIMITER_ENABLED"))
 	if err != nil {
-		logger.PrintFatal(fmt.Errorf("invalid LIMITER_ENABLED %s", err), nil)
+		configErrors = append(configErrors, fmt.Errorf("invalid LIMITER_ENABLED %s", err))
+	}
+	fs.BoolVar(&cfg.limiter.enabled, "LIMITER_ENABLED", limiterEnabled, "Enable rate limiter")
+
+	limiterKey := env.String("LIMITER_KEY", limiterKeyIP)
+	if !validator.PermittedValue(limiterKey, limiterKeyIP, limiterKeyUser) {
+		configErrors = append(configErrors, fmt.Errorf("invalid LIMITER_KEY %s", limiterKey))
+	}
+	limiterBackend := env.String("LIMITER_BACKEND", "memory")
+	if !validator.PermittedValue(limiterBackend, "memory", "redis") {
+		configErrors = append(configErrors, fmt.Errorf("invalid LIMITER_BACKEND %s", limiterBackend))
+	}
+	fs.StringVar(&cfg.limiter.backend, "LIMITER_BACKEND", limiterBackend, "Where rate limiter buckets are kept: in each instance, or in Redis to enforce the limit across instances (memory|redis)")
+
+	redisURL := env.Get("REDIS_URL")
+	fs.StringVar(&cfg.limiter.redisURL, "REDIS_URL", redisURL, "Redis URL for the redis rate limiter backend, as redis://[:password@]host[:port][/db]")
+
+	fs.StringVar(&cfg.limiter.key, "LIMITER_KEY", limiterKey, "What requests are rate limited by: the client IP, or the authenticated user with anonymous requests limited by IP (ip|user)")
+
+	connLimiterRps, err := env.Float("CONN_LIMITER_RPS", 5)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid CONN_LIMITER_RPS %s", err))
+	}
+	fs.Float64Var(&cfg.connLimiter.rps, "CONN_LIMITER_RPS", connLimiterRps, "Connection limiter maximum new connections per second per IP")
+
+	connLimiterBurst, err := env.Int("CONN_LIMITER_BURST", 10)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid CONN_LIMITER_BURST %s", err))
+	}
+	fs.IntVar(&cfg.connLimiter.burst, "CONN_LIMITER_BURST", connLimiterBurst, "Connection limiter maximum burst")
+
+	connLimiterEnabled, err := env.Bool("CONN_LIMITER_ENABLED", false)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid CONN_LIMITER_ENABLED %s", err))
+	}
+	fs.BoolVar(&cfg.connLimiter.enabled, "CONN_LIMITER_ENABLED", connLimiterEnabled, "Enable connection limiter")
+
+	concurrencyPerIP, err := env.Int("CONCURRENCY_LIMIT_PER_IP", 100)
+	if err != nil || concurrencyPerIP < 0 {
+		configErrors = append(configErrors, fmt.Errorf("invalid CONCURRENCY_LIMIT_PER_IP %s", env.Get("CONCURRENCY_LIMIT_PER_IP")))
 	}
-	flag.BoolVar(&cfg.limiter.enabled, "LIMITER_ENABLED", limiterEnabled, "Enable rate limiter")
+	fs.IntVar(&cfg.concurrency.perIP, "CONCURRENCY_LIMIT_PER_IP", concurrencyPerIP, "Maximum requests in flight at once per client IP (0 disables the limit)")
 
-	smtpHost := os.Getenv("SMTP_HOST")
+	concurrencyPerUser, err := env.Int("CONCURRENCY_LIMIT_PER_USER", 50)
+	if err != nil || concurrencyPerUser < 0 {
+		configErrors = append(configErrors, fmt.Errorf("invalid CONCURRENCY_LIMIT_PER_USER %s", env.Get("CONCURRENCY_LIMIT_PER_USER")))
+	}
+	fs.IntVar(&cfg.concurrency.perUser, "CONCURRENCY_LIMIT_PER_USER", concurrencyPerUser, "Maximum requests in flight at once per authenticated user (0 disables the limit)")
+
+	smtpHost := env.Get("SMTP_HOST")
 	if smtpHost == "" {
-		logger.PrintFatal(fmt.Errorf("SMTP_HOST is not set"), nil)
+		configErrors = append(configErrors, fmt.Errorf("SMTP_HOST is not set"))
 	}
-	flag.StringVar(&cfg.smtp.host, "SMTP_HOST", smtpHost, "SMTP server host")
+	fs.StringVar(&cfg.smtp.host, "SMTP_HOST", smtpHost, "SMTP server host")
 
-	smtpPort, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+	smtpPort, err := strconv.Atoi(env.Get("SMTP_PORT"))
 	if err != nil {
-		logger.PrintFatal(fmt.Errorf("invalid SMTP_PORT %s", err), nil)
+		configErrors = append(configErrors, fmt.Errorf("invalid SMTP_PORT %s", err))
 	}
-	flag.IntVar(&cfg.smtp.port, "SMTP_PORT", smtpPort, "SMTP server port")
+	fs.IntVar(&cfg.smtp.port, "SMTP_PORT", smtpPort, "SMTP server port")
 
-	smtpUsername := os.Getenv("SMTP_USERNAME")
+	smtpUsername := env.Get("SMTP_USERNAME")
 	if smtpUsername == "" {
-		logger.PrintFatal(fmt.Errorf("SMTP_USERNAME is not set"), nil)
+		configErrors = append(configErrors, fmt.Errorf("SMTP_USERNAME is not set"))
 	}
-	flag.StringVar(&cfg.smtp.username, "SMTP_USERNAME", smtpUsername, "SMTP server username")
+	fs.StringVar(&cfg.smtp.username, "SMTP_USERNAME", smtpUsername, "SMTP server username")
 
-	smtpPassword := os.Getenv("SMTP_PASSWORD")
+	smtpPassword := env.Get("SMTP_PASSWORD")
 	if smtpPassword == "" {
-		logger.PrintFatal(fmt.Errorf("SMTP_PASSWORD is not set"), nil)
+		configErrors = append(configErrors, fmt.Errorf("SMTP_PASSWORD is not set"))
 	}
-	flag.StringVar(&cfg.smtp.password, "SMTP_PASSWORD", smtpPassword, "SMTP server password")
+	fs.StringVar(&cfg.smtp.password, "SMTP_PASSWORD", smtpPassword, "SMTP server password")
 
-	smtpSender := os.Getenv("SMTP_SENDER")
+	smtpSender := env.Get("SMTP_SENDER")
 	if smtpSender == "" {
-		logger.PrintFatal(fmt.Errorf("SMTP_SENDER is not set"), nil)
+		configErrors = append(configErrors, fmt.Errorf("SMTP_SENDER is not set"))
+	}
+	fs.StringVar(&cfg.smtp.sender, "SMTP_SENDER", smtpSender, "SMTP sender")
+
+	smtpMaxAttempts, err := env.Int("SMTP_MAX_ATTEMPTS", 3)
+	if err != nil || smtpMaxAttempts < 1 {
+		configErrors = append(configErrors, fmt.Errorf("invalid SMTP_MAX_ATTEMPTS %s", env.Get("SMTP_MAX_ATTEMPTS")))
 	}
-	flag.StringVar(&cfg.smtp.sender, "SMTP_SENDER", smtpSender, "SMTP sender")
+	fs.IntVar(&cfg.smtp.maxAttempts, "SMTP_MAX_ATTEMPTS", smtpMaxAttempts, "Attempts at sending an email before giving up on transient SMTP failures")
 
-	trustedOrigins := os.Getenv("CORS_TRUSTED_ORIGINS")
-	flag.StringVar(&trustedOrigins, "CORS_TRUSTED_ORIGINS", trustedOrigins, "List of trusted CORS origins (space separated)")
-	cfg.cors.trustedOrigins = strings.Fields(trustedOrigins)
+	smtpRetryBackoff, err := env.Duration("SMTP_RETRY_BACKOFF", 500*time.Millisecond)
+	if err != nil || smtpRetryBackoff < 0 {
+		configErrors = append(configErrors, fmt.Errorf("invalid SMTP_RETRY_BACKOFF %s", env.Get("SMTP_RETRY_BACKOFF")))
+	}
+	fs.DurationVar(&cfg.smtp.retryBackoff, "SMTP_RETRY_BACKOFF", smtpRetryBackoff, "Wait before the first retry of a failed email send, doubling with each retry")
 
-	displayVersion := flag.Bool("version", false, "Display the version and exit")
+	trustedOrigins := env.Get("CORS_TRUSTED_ORIGINS")
+	fs.StringVar(&trustedOrigins, "CORS_TRUSTED_ORIGINS", trustedOrigins, "List of trusted CORS origins (space separated), where * as the first label of the host matches any single label, as in https://*.example.com")
 
-	flag.Parse()
+	corsMethods := env.String("CORS_ALLOWED_METHODS", "GET POST PUT PATCH DELETE OPTIONS")
+	fs.StringVar(&corsMethods, "CORS_ALLOWED_METHODS", corsMethods, "Methods preflight responses allow trusted origins to use (space separated)")
 
-	if *displayVersion {
-		fmt.Printf("Version:\t%s\n", version)
-		os.Exit(0)
-	}
+	corsHeaders := env.String("CORS_ALLOWED_HEADERS", "Authorization Content-Type Idempotency-Key If-Match If-None-Match X-API-Key X-HTTP-Method-Override X-Request-ID traceparent")
+	fs.StringVar(&corsHeaders, "CORS_ALLOWED_HEADERS", corsHeaders, "Request headers preflight responses allow trusted origins to send (space separated)")
 
-	db, err := openDB(cfg)
+	corsCredentials, err := env.Bool("CORS_ALLOW_CREDENTIALS", false)
 	if err != nil {
-		logger.PrintFatal(err, nil)
+		configErrors = append(configErrors, fmt.Errorf("invalid CORS_ALLOW_CREDENTIALS %s", err))
 	}
-	defer db.Close()
+	fs.BoolVar(&cfg.cors.allowCredentials, "CORS_ALLOW_CREDENTIALS", corsCredentials, "Allow trusted origins to send credentials, such as cookies, with cross-origin requests")
 
-	logger.PrintInfo("database connection pool established", nil)
+	corsMaxAge, err := env.Duration("CORS_MAX_AGE", 10*time.Minute)
+	if err != nil || corsMaxAge < 0 {
+		configErrors = append(configErrors, fmt.Errorf("invalid CORS_MAX_AGE %s", env.Get("CORS_MAX_AGE")))
+	}
+	fs.DurationVar(&cfg.cors.maxAge, "CORS_MAX_AGE", corsMaxAge, "How long browsers may cache preflight responses")
 
-	expvar.NewString("version").Set(version)
+	authSchemes := env.String("AUTH_SCHEMES", authSchemeToken)
+	fs.StringVar(&authSchemes, "AUTH_SCHEMES", authSchemes, "Enabled authentication schemes in order of precedence (space separated token|jwt|apikey)")
 
-	expvar.Publish("goroutines", expvar.Func(func() any {
-		return runtime.NumGoroutine()
-	}))
+	jwtSecret := env.Get("JWT_SECRET")
+	fs.StringVar(&cfg.auth.jwtSecret, "JWT_SECRET", jwtSecret, "HMAC secret for signing and verifying HS256 JWTs with the jwt scheme")
 
-	expvar.Publish("database", expvar.Func(func() any {
-		return db.Stats()
-	}))
+	jwtIssuer := env.Get("JWT_ISSUER")
+	fs.StringVar(&cfg.auth.jwtIssuer, "JWT_ISSUER", jwtIssuer, "iss claim of the JWTs issued, which verified JWTs must carry (required for the jwt scheme)")
 
-	expvar.Publish("timestamp", expvar.Func(func() any {
-		return time.Now().Unix()
-	}))
+	jwtAudience := env.Get("JWT_AUDIENCE")
+	fs.StringVar(&cfg.auth.jwtAudience, "JWT_AUDIENCE", jwtAudience, "aud claim of the JWTs issued, which verified JWTs must carry (required for the jwt scheme)")
 
-	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+	jwtTTL, err := env.Duration("JWT_TTL", 15*time.Minute)
+	if err != nil || jwtTTL <= 0 {
+		configErrors = append(configErrors, fmt.Errorf("invalid JWT_TTL %s", env.Get("JWT_TTL")))
 	}
+	fs.DurationVar(&cfg.auth.jwtTTL, "JWT_TTL", jwtTTL, "How long JWTs issued by POST /v1/tokens/jwt are valid for")
 
-	err = app.serve()
+	apiKeyTTL, err := env.Duration("API_KEY_TTL", defaultAPIKeyTTL)
 	if err != nil {
-		logger.PrintFatal(err, nil)
+		configErrors = append(configErrors, fmt.Errorf("invalid API_KEY_TTL %s", err))
 	}
-}
+	fs.DurationVar(&cfg.auth.apiKeyTTL, "API_KEY_TTL", apiKeyTTL, "How long newly created API keys are valid for")
 
-func openDB(cfg config) (*sql.DB, error) {
-	db, err := sql.Open("pgx", cfg.db.url)
+	tokenActivationTTL, err := env.Duration("TOKEN_ACTIVATION_TTL", 3*24*time.Hour)
 	if err != nil {
-		return nil, err
+		configErrors = append(configErrors, fmt.Errorf("invalid TOKEN_ACTIVATION_TTL %s", err))
 	}
+	fs.DurationVar(&cfg.tokens.activationTTL, "TOKEN_ACTIVATION_TTL", tokenActivationTTL, "How long activation tokens are valid for")
 
-	db.SetMaxOpenConns(cfg.db.maxOpenConns)
-	db.SetMaxIdleConns(cfg.db.maxIdleConns)
+	tokenAuthTTL, err := env.Duration("TOKEN_AUTH_TTL", 24*time.Hour)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid TOKEN_AUTH_TTL %s", err))
+	}
+	fs.DurationVar(&cfg.tokens.authenticationTTL, "TOKEN_AUTH_TTL", tokenAuthTTL, "How long authentication tokens are valid for")
 
-	duration, err := time.ParseDuration(cfg.db.maxIdleTime)
+	tokenPasswordResetTTL, err := env.Duration("TOKEN_PASSWORD_RESET_TTL", 45*time.Minute)
 	if err != nil {
-		return nil, err
+		configErrors = append(configErrors, fmt.Errorf("invalid TOKEN_PASSWORD_RESET_TTL %s", err))
 	}
+	fs.DurationVar(&cfg.tokens.passwordResetTTL, "TOKEN_PASSWORD_RESET_TTL", tokenPasswordResetTTL, "How long password reset tokens are valid for")
 
-	db.SetConnMaxIdleTime(duration)
+	authClockSkew, err := env.Duration("AUTH_CLOCK_SKEW", 30*time.Second)
+	if err != nil || authClockSkew < 0 {
+		configErrors = append(configErrors, fmt.Errorf("invalid AUTH_CLOCK_SKEW %s", env.Get("AUTH_CLOCK_SKEW")))
+	}
+	fs.DurationVar(&cfg.auth.clockSkew, "AUTH_CLOCK_SKEW", authClockSkew, "Clock skew tolerated when checking token expiry and JWT time claims")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	clockCheckNTPServer := env.Get("CLOCK_CHECK_NTP_SERVER")
+	fs.StringVar(&cfg.auth.ntpServer, "CLOCK_CHECK_NTP_SERVER", clockCheckNTPServer, "NTP server the clock is checked against at startup, such as pool.ntp.org:123 (disabled when empty)")
+
+	trustedProxies := env.Get("TRUSTED_PROXIES")
+	fs.StringVar(&trustedProxies, "TRUSTED_PROXIES", trustedProxies, "Proxies whose X-Forwarded-Proto header is trusted (space separated IPs or CIDRs)")
 
-	err = db.PingContext(ctx)
+	externalBaseURL := env.Get("EXTERNAL_BASE_URL")
+	fs.StringVar(&cfg.proxy.externalBaseURL, "EXTERNAL_BASE_URL", externalBaseURL, "External base URL used in generated absolute URLs, such as https://api.example.com")
+
+	httpsRedirect, err := env.Bool("HTTPS_REDIRECT_ENABLED", false)
 	if err != nil {
-		return nil, err
+		configErrors = append(configErrors, fmt.Errorf("invalid HTTPS_REDIRECT_ENABLED %s", err))
 	}
+	fs.BoolVar(&cfg.proxy.redirectHTTPS, "HTTPS_REDIRECT_ENABLED", httpsRedirect, "Redirect plain HTTP requests to HTTPS")
 
-	return db, nil
+	securityCSP := env.String("SECURITY_CSP", "default-src 'none'; frame-ancestors 'none'")
+	fs.StringVar(&cfg.securityHeaders.csp, "SECURITY_CSP", securityCSP, "Content-Security-Policy sent with every response (empty to leave it out)")
+
+	hstsMaxAge, err := env.Duration("HSTS_MAX_AGE", 365*24*time.Hour)
+	if err != nil || hstsMaxAge < 0 {
+		configErrors = append(configErrors, fmt.Errorf("invalid HSTS_MAX_AGE %s", env.Get("HSTS_MAX_AGE")))
+	}
+	fs.DurationVar(&cfg.securityHeaders.hstsMaxAge, "HSTS_MAX_AGE", hstsMaxAge, "Strict-Transport-Security max-age sent with responses over HTTPS (0 to leave it out)")
+
+	genresCasing := env.String("GENRES_CASING", data.GenreCasingTitle)
+	if !validator.PermittedValue(genresCasing, data.GenreCasingTitle, data.GenreCasingLower, data.GenreCasingPreserve) {
+		configErrors = append(configErrors, fmt.Errorf("invalid GENRES_CASING %s", genresCasing))
+	}
+	fs.StringVar(&cfg.genres.casing, "GENRES_CASING", genresCasing, "Stored casing for movie genres (title|lower|preserve)")
+
+	genresTaxonomy := env.String("GENRES_TAXONOMY", taxonomyOff)
+	if !validator.PermittedValue(genresTaxonomy, taxonomyOff, taxonomyPassthrough, taxonomyReject) {
+		configErrors = append(configErrors, fmt.Errorf("invalid GENRES_TAXONOMY %s", genresTaxonomy))
+	}
+	fs.StringVar(&cfg.genres.taxonomy, "GENRES_TAXONOMY", genresTaxonomy, "Map genres onto the canonical taxonomy, keeping or rejecting unknown ones (off|passthrough|reject)")
+
+	movieYearMin := env.String("MOVIE_YEAR_MIN", "1888")
+	fs.StringVar(&movieYearMin, "MOVIE_YEAR_MIN", movieYearMin, "Earliest accepted movie year, absolute or relative such as current-100")
+
+	movieYearMax := env.String("MOVIE_YEAR_MAX", "current")
+	fs.StringVar(&movieYearMax, "MOVIE_YEAR_MAX", movieYearMax, "Latest accepted movie year, absolute or relative such as current+2")
+
+	routeDeprecations := env.Get("ROUTE_DEPRECATIONS")
+	fs.StringVar(&routeDeprecations, "ROUTE_DEPRECATIONS", routeDeprecations, "Deprecated routes, as semicolon separated METHOD PATTERN SUNSET [REPLACEMENT] entries")
+
+	movieSlugAliases, err := env.Bool("MOVIE_SLUG_ALIASES", true)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid MOVIE_SLUG_ALIASES %s", err))
+	}
+	fs.BoolVar(&cfg.movies.slugAliases, "MOVIE_SLUG_ALIASES", movieSlugAliases, "Keep the old slug of a renamed movie as a redirect to the new one")
+
+	movieSoftDelete, err := env.Bool("MOVIE_SOFT_DELETE", true)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid MOVIE_SOFT_DELETE %s", err))
+	}
+	fs.BoolVar(&cfg.movies.softDelete, "MOVIE_SOFT_DELETE", movieSoftDelete, "Soft-delete movies so that they can be restored, purging them only with ?purge=true")
+
+	movieACL, err := env.Bool("MOVIE_ACL_ENABLED", false)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid MOVIE_ACL_ENABLED %s", err))
+	}
+	fs.BoolVar(&cfg.movies.acl, "MOVIE_ACL_ENABLED", movieACL, "Hide movies from users their visibility and ACL do not allow")
+
+	dependencyErrorStatus, err := env.Int("DEPENDENCY_ERROR_STATUS", http.StatusServiceUnavailable)
+	if err != nil || !validator.PermittedValue(dependencyErrorStatus, http.StatusBadGateway, http.StatusServiceUnavailable) {
+		configErrors = append(configErrors, fmt.Errorf("invalid DEPENDENCY_ERROR_STATUS %s", env.Get("DEPENDENCY_ERROR_STATUS")))
+	}
+	fs.IntVar(&cfg.dependencyErrorStatus, "DEPENDENCY_ERROR_STATUS", dependencyErrorStatus, "HTTP status returned when a downstream dependency fails (502|503)")
+
+	strictContentLength, err := env.Bool("STRICT_CONTENT_LENGTH", false)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid STRICT_CONTENT_LENGTH %s", err))
+	}
+	fs.BoolVar(&cfg.requests.strictContentLength, "STRICT_CONTENT_LENGTH", strictContentLength, "Reject JSON bodies which do not match their Content-Length header")
+
+	maxRequestBodyBytes, err := env.Int("MAX_REQUEST_BODY_BYTES", 1_048_576)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid MAX_REQUEST_BODY_BYTES %s", err))
+	}
+	fs.IntVar(&cfg.requests.maxBodyBytes, "MAX_REQUEST_BODY_BYTES", maxRequestBodyBytes, "Maximum size of a JSON request body in bytes")
+
+	requestTimeout, err := env.Duration("REQUEST_TIMEOUT", 15*time.Second)
+	if err != nil || requestTimeout < 0 {
+		configErrors = append(configErrors, fmt.Errorf("invalid REQUEST_TIMEOUT %s", env.Get("REQUEST_TIMEOUT")))
+	}
+	fs.DurationVar(&cfg.requests.timeout, "REQUEST_TIMEOUT", requestTimeout, "How long a request may run before its context is cancelled and a 503 is sent (0 disables)")
+
+	responsesMaxBytes, err := env.Int("RESPONSE_MAX_BYTES", 10<<20)
+	if err != nil || responsesMaxBytes < 0 {
+		configErrors = append(configErrors, fmt.Errorf("invalid RESPONSE_MAX_BYTES %s", env.Get("RESPONSE_MAX_BYTES")))
+	}
+	fs.IntVar(&cfg.responses.maxBytes, "RESPONSE_MAX_BYTES", responsesMaxBytes, "Maximum size of an encoded JSON response in bytes (0 disables)")
+
+	responsesInvalidUTF8 := env.String("RESPONSE_INVALID_UTF8", utf8Replace)
+	if !validator.PermittedValue(responsesInvalidUTF8, utf8Off, utf8Replace, utf8Drop) {
+		configErrors = append(configErrors, fmt.Errorf("invalid RESPONSE_INVALID_UTF8 %s", responsesInvalidUTF8))
+	}
+	fs.StringVar(&cfg.responses.invalidUTF8, "RESPONSE_INVALID_UTF8", responsesInvalidUTF8, "How invalid UTF-8 in stored movies is written out, logging each affected movie (off|replace|drop)")
+
+	responsesXML, err := env.Bool("RESPONSE_XML", true)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid RESPONSE_XML %s", err))
+	}
+	fs.BoolVar(&cfg.responses.xml, "RESPONSE_XML", responsesXML, "Send XML to clients whose Accept header prefers application/xml over JSON")
+
+	compressionEnabled, err := env.Bool("COMPRESSION_ENABLED", true)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid COMPRESSION_ENABLED %s", err))
+	}
+	fs.BoolVar(&cfg.compression.enabled, "COMPRESSION_ENABLED", compressionEnabled, "Compress responses with gzip or deflate for clients which accept them")
+
+	compressionMinBytes, err := env.Int("COMPRESSION_MIN_BYTES", 1024)
+	if err != nil || compressionMinBytes < 0 {
+		configErrors = append(configErrors, fmt.Errorf("invalid COMPRESSION_MIN_BYTES %s", env.Get("COMPRESSION_MIN_BYTES")))
+	}
+	fs.IntVar(&cfg.compression.minBytes, "COMPRESSION_MIN_BYTES", compressionMinBytes, "Responses shorter than this many bytes are sent uncompressed")
+
+	paginationMaxOffset, err := env.Int("PAGINATION_MAX_OFFSET", 10_000)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid PAGINATION_MAX_OFFSET %s", err))
+	}
+	fs.IntVar(&cfg.pagination.maxOffset, "PAGINATION_MAX_OFFSET", paginationMaxOffset, "Offset past which clients are steered to cursor pagination (0 disables)")
+
+	paginationRejectDeepOffset, err := env.Bool("PAGINATION_REJECT_DEEP_OFFSET", false)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid PAGINATION_REJECT_DEEP_OFFSET %s", err))
+	}
+	fs.BoolVar(&cfg.pagination.rejectDeepOffset, "PAGINATION_REJECT_DEEP_OFFSET", paginationRejectDeepOffset, "Reject requests past PAGINATION_MAX_OFFSET instead of serving them with a hint")
+
+	paginationFlags, err := env.Bool("PAGINATION_FLAGS", true)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid PAGINATION_FLAGS %s", err))
+	}
+	fs.BoolVar(&cfg.pagination.flags, "PAGINATION_FLAGS", paginationFlags, "Include total_pages, has_next_page, has_previous_page and is_last_page in pagination metadata")
+
+	paginationLinks, err := env.Bool("PAGINATION_LINKS", true)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid PAGINATION_LINKS %s", err))
+	}
+	fs.BoolVar(&cfg.pagination.links, "PAGINATION_LINKS", paginationLinks, "Include first, prev, next and last page URLs in pagination metadata")
+
+	savedSearchesMaxPerUser, err := env.Int("SAVED_SEARCHES_MAX_PER_USER", 25)
+	if err != nil || savedSearchesMaxPerUser < 1 {
+		configErrors = append(configErrors, fmt.Errorf("invalid SAVED_SEARCHES_MAX_PER_USER %s", env.Get("SAVED_SEARCHES_MAX_PER_USER")))
+	}
+	fs.IntVar(&cfg.savedSearches.maxPerUser, "SAVED_SEARCHES_MAX_PER_USER", savedSearchesMaxPerUser, "Maximum number of saved searches per user")
+
+	methodOverrideEnabled, err := env.Bool("METHOD_OVERRIDE_ENABLED", false)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid METHOD_OVERRIDE_ENABLED %s", err))
+	}
+	fs.BoolVar(&cfg.methodOverride.enabled, "METHOD_OVERRIDE_ENABLED", methodOverrideEnabled, "Honor X-HTTP-Method-Override on POST requests")
+
+	errorsDocsBaseURL := env.Get("ERRORS_DOCS_BASE_URL")
+	fs.StringVar(&cfg.errors.docsBaseURL, "ERRORS_DOCS_BASE_URL", errorsDocsBaseURL, "Base URL of the error documentation linked from error responses")
+
+	errorsIncidentIDs, err := env.Bool("ERRORS_INCLUDE_INCIDENT_ID", true)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid ERRORS_INCLUDE_INCIDENT_ID %s", err))
+	}
+	fs.BoolVar(&cfg.errors.incidentIDs, "ERRORS_INCLUDE_INCIDENT_ID", errorsIncidentIDs, "Include the request and trace ids in server error responses")
+
+	errorsQuietCancel, err := env.Bool("ERRORS_QUIET_CLIENT_CANCEL", true)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid ERRORS_QUIET_CLIENT_CANCEL %s", err))
+	}
+	fs.BoolVar(&cfg.errors.quietCancel, "ERRORS_QUIET_CLIENT_CANCEL", errorsQuietCancel, "Log requests cancelled by the client at info level instead of as server errors")
+
+	errorsCatalog, err := env.Bool("ERRORS_CATALOG_ENABLED", true)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid ERRORS_CATALOG_ENABLED %s", err))
+	}
+	fs.BoolVar(&cfg.errors.catalog, "ERRORS_CATALOG_ENABLED", errorsCatalog, "Serve the catalog of error codes at /v1/errors and in the OpenAPI description")
+
+	jsonSchemaEnabled, err := env.Bool("JSON_SCHEMA_ENABLED", false)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid JSON_SCHEMA_ENABLED %s", err))
+	}
+	fs.BoolVar(&cfg.jsonSchema.enabled, "JSON_SCHEMA_ENABLED", jsonSchemaEnabled, "Validate request bodies against their JSON Schema before decoding")
+
+	validationRulesEnabled, err := env.Bool("VALIDATION_RULES_ENABLED", true)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid VALIDATION_RULES_ENABLED %s", err))
+	}
+	fs.BoolVar(&cfg.validationRules.enabled, "VALIDATION_RULES_ENABLED", validationRulesEnabled, "Serve the validation rules for movies and users at /v1/schemas/movies and /v1/schemas/users")
+
+	indexEnabled, err := env.Bool("API_INDEX_ENABLED", true)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid API_INDEX_ENABLED %s", err))
+	}
+	fs.BoolVar(&cfg.index.enabled, "API_INDEX_ENABLED", indexEnabled, "Serve an index of the API and its endpoints at /v1")
+
+	popularityCountOnGet, err := env.Bool("POPULARITY_COUNT_ON_GET", false)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid POPULARITY_COUNT_ON_GET %s", err))
+	}
+	fs.BoolVar(&cfg.popularity.countOnGet, "POPULARITY_COUNT_ON_GET", popularityCountOnGet, "Count every fetch of a movie by id or slug as a view, as well as POST /v1/movies/:id/view")
+
+	popularityFlushInterval, err := env.Duration("POPULARITY_FLUSH_INTERVAL", 10*time.Second)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid POPULARITY_FLUSH_INTERVAL %s", err))
+	}
+	fs.DurationVar(&cfg.popularity.flushInterval, "POPULARITY_FLUSH_INTERVAL", popularityFlushInterval, "How often buffered movie views are written to the database (0 writes every view straight away)")
+
+	webhooksTimeout, err := env.Duration("WEBHOOKS_TIMEOUT", 5*time.Second)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid WEBHOOKS_TIMEOUT %s", err))
+	}
+	fs.DurationVar(&cfg.webhooks.timeout, "WEBHOOKS_TIMEOUT", webhooksTimeout, "Timeout for each webhook delivery")
+
+	webhooksMaxAttempts, err := env.Int("WEBHOOKS_MAX_ATTEMPTS", 3)
+	if err != nil || webhooksMaxAttempts < 1 {
+		configErrors = append(configErrors, fmt.Errorf("invalid WEBHOOKS_MAX_ATTEMPTS %s", env.Get("WEBHOOKS_MAX_ATTEMPTS")))
+	}
+	fs.IntVar(&cfg.webhooks.maxAttempts, "WEBHOOKS_MAX_ATTEMPTS", webhooksMaxAttempts, "Number of times a webhook delivery is attempted before it is dead-lettered")
+
+	webhooksRetryBackoff, err := env.Duration("WEBHOOKS_RETRY_BACKOFF", time.Second)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid WEBHOOKS_RETRY_BACKOFF %s", err))
+	}
+	fs.DurationVar(&cfg.webhooks.retryBackoff, "WEBHOOKS_RETRY_BACKOFF", webhooksRetryBackoff, "Delay before the first retry of a webhook delivery, doubled for each retry after it")
+
+	deadLetterEnabled, err := env.Bool("DEAD_LETTER_ENABLED", true)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid DEAD_LETTER_ENABLED %s", err))
+	}
+	fs.BoolVar(&cfg.deadLetter.enabled, "DEAD_LETTER_ENABLED", deadLetterEnabled, "Store emails and webhook deliveries which fail every attempt, so they can be replayed")
+
+	deadLetterReplayLimit, err := env.Int("DEAD_LETTER_REPLAY_LIMIT", 100)
+	if err != nil || deadLetterReplayLimit < 1 {
+		configErrors = append(configErrors, fmt.Errorf("invalid DEAD_LETTER_REPLAY_LIMIT %s", env.Get("DEAD_LETTER_REPLAY_LIMIT")))
+	}
+	fs.IntVar(&cfg.deadLetter.replayLimit, "DEAD_LETTER_REPLAY_LIMIT", deadLetterReplayLimit, "Maximum number of dead letters replayed by one request")
+
+	tracingEnabled, err := env.Bool("OTEL_TRACING_ENABLED", false)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid OTEL_TRACING_ENABLED %s", err))
+	}
+	fs.BoolVar(&cfg.tracing.enabled, "OTEL_TRACING_ENABLED", tracingEnabled, "Enable request tracing")
+
+	tracingSampleRate, err := env.Float("OTEL_SAMPLE_RATE", 1.0)
+	if err != nil || tracingSampleRate < 0 || tracingSampleRate > 1 {
+		configErrors = append(configErrors, fmt.Errorf("invalid OTEL_SAMPLE_RATE %s", env.Get("OTEL_SAMPLE_RATE")))
+	}
+	fs.Float64Var(&cfg.tracing.sampleRate, "OTEL_SAMPLE_RATE", tracingSampleRate, "Fraction of new traces to sample (0.0-1.0)")
+
+	tracingSlowThreshold, err := env.Duration("OTEL_SLOW_THRESHOLD", time.Second)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid OTEL_SLOW_THRESHOLD %s", err))
+	}
+	fs.DurationVar(&cfg.tracing.slowThreshold, "OTEL_SLOW_THRESHOLD", tracingSlowThreshold, "Requests slower than this are always traced")
+
+	exportsEndpoint := env.Get("EXPORTS_S3_ENDPOINT")
+	fs.StringVar(&cfg.exports.endpoint, "EXPORTS_S3_ENDPOINT", exportsEndpoint, "S3-compatible endpoint for catalog exports (exports are disabled when empty)")
+
+	exportsRegion := env.String("EXPORTS_S3_REGION", "us-east-1")
+	fs.StringVar(&cfg.exports.region, "EXPORTS_S3_REGION", exportsRegion, "Object store region for catalog exports")
+
+	exportsBucket := env.Get("EXPORTS_S3_BUCKET")
+	fs.StringVar(&cfg.exports.bucket, "EXPORTS_S3_BUCKET", exportsBucket, "Object store bucket for catalog exports")
+
+	exportsAccessKey := env.Get("EXPORTS_S3_ACCESS_KEY")
+	fs.StringVar(&cfg.exports.accessKey, "EXPORTS_S3_ACCESS_KEY", exportsAccessKey, "Object store access key for catalog exports")
+
+	exportsSecretKey := env.Get("EXPORTS_S3_SECRET_KEY")
+	fs.StringVar(&cfg.exports.secretKey, "EXPORTS_S3_SECRET_KEY", exportsSecretKey, "Object store secret key for catalog exports")
+
+	exportsTimeout, err := env.Duration("EXPORTS_TIMEOUT", 30*time.Minute)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid EXPORTS_TIMEOUT %s", err))
+	}
+	fs.DurationVar(&cfg.exports.timeout, "EXPORTS_TIMEOUT", exportsTimeout, "Maximum duration of a catalog export")
+
+	metadataProvider := env.String("METADATA_PROVIDER", "external")
+	fs.StringVar(&cfg.metadata.provider, "METADATA_PROVIDER", metadataProvider, "Name of the movie metadata provider, recorded as the source of enriched fields")
+
+	metadataBaseURL := env.Get("METADATA_BASE_URL")
+	fs.StringVar(&cfg.metadata.baseURL, "METADATA_BASE_URL", metadataBaseURL, "Base URL of the movie metadata API (enrichment is disabled when empty)")
+
+	metadataAPIKey := env.Get("METADATA_API_KEY")
+	fs.StringVar(&cfg.metadata.apiKey, "METADATA_API_KEY", metadataAPIKey, "API key for the movie metadata API")
+
+	metadataTimeout, err := env.Duration("METADATA_TIMEOUT", 3*time.Second)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid METADATA_TIMEOUT %s", err))
+	}
+	fs.DurationVar(&cfg.metadata.timeout, "METADATA_TIMEOUT", metadataTimeout, "Timeout for each movie metadata lookup")
+
+	flattenDelimiter := env.String("FLATTEN_DELIMITER", "|")
+	fs.StringVar(&cfg.flatten.delimiter, "FLATTEN_DELIMITER", flattenDelimiter, "Delimiter arrays are joined with in flattened output")
+
+	shutdownDrainDelay, err := env.Duration("SHUTDOWN_DRAIN_DELAY", 0)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid SHUTDOWN_DRAIN_DELAY %s", err))
+	}
+	fs.DurationVar(&cfg.shutdown.drainDelay, "SHUTDOWN_DRAIN_DELAY", shutdownDrainDelay, "How long to keep serving with the readiness check reporting draining before shutting down")
+
+	healthcheckTimeout, err := env.Duration("HEALTHCHECK_TIMEOUT", time.Second)
+	if err != nil || healthcheckTimeout <= 0 {
+		configErrors = append(configErrors, fmt.Errorf("invalid HEALTHCHECK_TIMEOUT %s", env.Get("HEALTHCHECK_TIMEOUT")))
+	}
+	fs.DurationVar(&cfg.healthcheck.timeout, "HEALTHCHECK_TIMEOUT", healthcheckTimeout, "How long the healthcheck waits for the database and SMTP server to respond")
+
+	healthcheckSMTPCritical, err := env.Bool("HEALTHCHECK_SMTP_CRITICAL", false)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid HEALTHCHECK_SMTP_CRITICAL %s", err))
+	}
+	fs.BoolVar(&cfg.healthcheck.smtpCritical, "HEALTHCHECK_SMTP_CRITICAL", healthcheckSMTPCritical, "Report the instance unavailable, rather than degraded, while the SMTP server cannot be reached")
+
+	eventsHeartbeat, err := env.Duration("EVENTS_HEARTBEAT_INTERVAL", 15*time.Second)
+	if err != nil || eventsHeartbeat <= 0 {
+		configErrors = append(configErrors, fmt.Errorf("invalid EVENTS_HEARTBEAT_INTERVAL %s", env.Get("EVENTS_HEARTBEAT_INTERVAL")))
+	}
+	fs.DurationVar(&cfg.events.heartbeat, "EVENTS_HEARTBEAT_INTERVAL", eventsHeartbeat, "How often the movie event stream sends a comment to keep idle connections open")
+
+	shutdownTimeout, err := env.Duration("SHUTDOWN_TIMEOUT", 20*time.Second)
+	if err != nil || shutdownTimeout <= 0 {
+		configErrors = append(configErrors, fmt.Errorf("invalid SHUTDOWN_TIMEOUT %s", env.Get("SHUTDOWN_TIMEOUT")))
+	}
+	fs.DurationVar(&cfg.shutdown.timeout, "SHUTDOWN_TIMEOUT", shutdownTimeout, "How long in-flight requests and background tasks, such as email sends, get to complete on shutdown")
+
+	batchMaxItems, err := env.Int("BATCH_MAX_ITEMS", 100)
+	if err != nil || batchMaxItems < 1 {
+		configErrors = append(configErrors, fmt.Errorf("invalid BATCH_MAX_ITEMS %s", env.Get("BATCH_MAX_ITEMS")))
+	}
+	fs.IntVar(&cfg.batch.maxItems, "BATCH_MAX_ITEMS", batchMaxItems, "Maximum number of items in a batch request")
+
+	batchUpsertOutcomes, err := env.Bool("BATCH_UPSERT_OUTCOMES", true)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid BATCH_UPSERT_OUTCOMES %s", err))
+	}
+	fs.BoolVar(&cfg.batch.upsertOutcomes, "BATCH_UPSERT_OUTCOMES", batchUpsertOutcomes, "Count the movies created, updated and left unchanged by a batch upsert, and report it for each item")
+
+	outboxBatchSize, err := env.Int("EMAIL_BATCH_SIZE", 50)
+	if err != nil || outboxBatchSize < 1 {
+		configErrors = append(configErrors, fmt.Errorf("invalid EMAIL_BATCH_SIZE %s", env.Get("EMAIL_BATCH_SIZE")))
+	}
+	fs.IntVar(&cfg.outbox.batchSize, "EMAIL_BATCH_SIZE", outboxBatchSize, "Number of emails claimed from the outbox per batch")
+
+	outboxWorkers, err := env.Int("EMAIL_WORKERS", 4)
+	if err != nil || outboxWorkers < 1 {
+		configErrors = append(configErrors, fmt.Errorf("invalid EMAIL_WORKERS %s", env.Get("EMAIL_WORKERS")))
+	}
+	fs.IntVar(&cfg.outbox.workers, "EMAIL_WORKERS", outboxWorkers, "Maximum number of emails sent concurrently")
+
+	outboxQueueCapacity, err := env.Int("EMAIL_QUEUE_CAPACITY", 100)
+	if err != nil || outboxQueueCapacity < 1 {
+		configErrors = append(configErrors, fmt.Errorf("invalid EMAIL_QUEUE_CAPACITY %s", env.Get("EMAIL_QUEUE_CAPACITY")))
+	}
+	fs.IntVar(&cfg.outbox.queueCapacity, "EMAIL_QUEUE_CAPACITY", outboxQueueCapacity, "Number of emails which can wait in memory for a free worker")
+
+	outboxQueueWait, err := env.Duration("EMAIL_QUEUE_WAIT", time.Second)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid EMAIL_QUEUE_WAIT %s", err))
+	}
+	fs.DurationVar(&cfg.outbox.queueWait, "EMAIL_QUEUE_WAIT", outboxQueueWait, "How long the outbox waits for room in a full email queue before backing off")
+
+	outboxPollInterval, err := env.Duration("EMAIL_POLL_INTERVAL", 5*time.Second)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid EMAIL_POLL_INTERVAL %s", err))
+	}
+	fs.DurationVar(&cfg.outbox.pollInterval, "EMAIL_POLL_INTERVAL", outboxPollInterval, "How often the outbox is checked for new emails when it is empty")
+
+	outboxMaxAttempts, err := env.Int("EMAIL_MAX_ATTEMPTS", 5)
+	if err != nil || outboxMaxAttempts < 1 {
+		configErrors = append(configErrors, fmt.Errorf("invalid EMAIL_MAX_ATTEMPTS %s", env.Get("EMAIL_MAX_ATTEMPTS")))
+	}
+	fs.IntVar(&cfg.outbox.maxAttempts, "EMAIL_MAX_ATTEMPTS", outboxMaxAttempts, "Number of times an email is attempted before it is marked as failed")
+
+	idempotencyBackend := env.String("IDEMPOTENCY_BACKEND", "postgres")
+	if !validator.PermittedValue(idempotencyBackend, "postgres", "redis", "disabled") {
+		configErrors = append(configErrors, fmt.Errorf("invalid IDEMPOTENCY_BACKEND %s", idempotencyBackend))
+	}
+	fs.StringVar(&cfg.idempotency.backend, "IDEMPOTENCY_BACKEND", idempotencyBackend, "Storage backend for Idempotency-Key responses (postgres|redis|disabled)")
+
+	idempotencyTTL, err := env.Duration("IDEMPOTENCY_TTL", 24*time.Hour)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid IDEMPOTENCY_TTL %s", err))
+	}
+	fs.DurationVar(&cfg.idempotency.ttl, "IDEMPOTENCY_TTL", idempotencyTTL, "How long Idempotency-Key responses are kept for replay")
+
+	idempotencySecret := env.Get("IDEMPOTENCY_SECRET")
+	fs.StringVar(&cfg.idempotency.secret, "IDEMPOTENCY_SECRET", idempotencySecret, "HMAC secret for the request fingerprints stored with Idempotency-Key responses")
+
+	idempotencyRedisAddr := env.String("IDEMPOTENCY_REDIS_ADDR", "localhost:6379")
+	fs.StringVar(&cfg.idempotency.redisAddr, "IDEMPOTENCY_REDIS_ADDR", idempotencyRedisAddr, "Redis address for the redis idempotency backend")
+
+	idempotencyRedisPassword := env.Get("IDEMPOTENCY_REDIS_PASSWORD")
+	fs.StringVar(&cfg.idempotency.redisPassword, "IDEMPOTENCY_REDIS_PASSWORD", idempotencyRedisPassword, "Redis password for the redis idempotency backend")
+
+	idempotencyRedisDB, err := env.Int("IDEMPOTENCY_REDIS_DB", 0)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid IDEMPOTENCY_REDIS_DB %s", err))
+	}
+	fs.IntVar(&cfg.idempotency.redisDB, "IDEMPOTENCY_REDIS_DB", idempotencyRedisDB, "Redis database number for the redis idempotency backend")
+
+	reindexBatchSize, err := env.Int("REINDEX_BATCH_SIZE", 500)
+	if err != nil || reindexBatchSize < 1 {
+		configErrors = append(configErrors, fmt.Errorf("invalid REINDEX_BATCH_SIZE %s", env.Get("REINDEX_BATCH_SIZE")))
+	}
+	fs.IntVar(&cfg.reindex.batchSize, "REINDEX_BATCH_SIZE", reindexBatchSize, "Number of movies updated per statement by the search reindex")
+
+	movieCacheTTL, err := env.Duration("MOVIE_CACHE_TTL", 0)
+	if err != nil || movieCacheTTL < 0 {
+		configErrors = append(configErrors, fmt.Errorf("invalid MOVIE_CACHE_TTL %s", env.Get("MOVIE_CACHE_TTL")))
+	}
+	fs.DurationVar(&cfg.movieCache.ttl, "MOVIE_CACHE_TTL", movieCacheTTL, "How long shown movies are cached for (0 disables the cache)")
+
+	movieCacheStaleTTL, err := env.Duration("MOVIE_CACHE_STALE_TTL", 5*time.Minute)
+	if err != nil || movieCacheStaleTTL < 0 {
+		configErrors = append(configErrors, fmt.Errorf("invalid MOVIE_CACHE_STALE_TTL %s", env.Get("MOVIE_CACHE_STALE_TTL")))
+	}
+	fs.DurationVar(&cfg.movieCache.staleTTL, "MOVIE_CACHE_STALE_TTL", movieCacheStaleTTL, "How long expired movies may still be served stale")
+
+	movieCacheStale := env.String("MOVIE_CACHE_STALE", staleOff)
+	if !validator.PermittedValue(movieCacheStale, staleOff, staleIfError, staleWhileRevalidate) {
+		configErrors = append(configErrors, fmt.Errorf("invalid MOVIE_CACHE_STALE %s", movieCacheStale))
+	}
+	fs.StringVar(&cfg.movieCache.stale, "MOVIE_CACHE_STALE", movieCacheStale, "When expired movies are served stale (off|stale-if-error|stale-while-revalidate)")
+
+	movieCacheReadTimeout, err := env.Duration("MOVIE_CACHE_READ_TIMEOUT", time.Second)
+	if err != nil || movieCacheReadTimeout <= 0 {
+		configErrors = append(configErrors, fmt.Errorf("invalid MOVIE_CACHE_READ_TIMEOUT %s", env.Get("MOVIE_CACHE_READ_TIMEOUT")))
+	}
+	fs.DurationVar(&cfg.movieCache.readTimeout, "MOVIE_CACHE_READ_TIMEOUT", movieCacheReadTimeout, "How long to wait for the database before serving a stale movie with stale-if-error")
+
+	movieCacheMaxEntries, err := env.Int("MOVIE_CACHE_MAX_ENTRIES", 10000)
+	if err != nil || movieCacheMaxEntries < 1 {
+		configErrors = append(configErrors, fmt.Errorf("invalid MOVIE_CACHE_MAX_ENTRIES %s", env.Get("MOVIE_CACHE_MAX_ENTRIES")))
+	}
+	fs.IntVar(&cfg.movieCache.maxEntries, "MOVIE_CACHE_MAX_ENTRIES", movieCacheMaxEntries, "Maximum number of cached movies")
+
+	emailPrefsEnabled, err := env.Bool("EMAIL_PREFERENCES_ENABLED", true)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid EMAIL_PREFERENCES_ENABLED %s", err))
+	}
+	fs.BoolVar(&cfg.emailPrefs.enabled, "EMAIL_PREFERENCES_ENABLED", emailPrefsEnabled, "Skip non-essential emails users have opted out of, and add unsubscribe links to them")
+
+	emailUnsubscribeTTL, err := env.Duration("EMAIL_UNSUBSCRIBE_TTL", 365*24*time.Hour)
+	if err != nil || emailUnsubscribeTTL <= 0 {
+		configErrors = append(configErrors, fmt.Errorf("invalid EMAIL_UNSUBSCRIBE_TTL %s", env.Get("EMAIL_UNSUBSCRIBE_TTL")))
+	}
+	fs.DurationVar(&cfg.emailPrefs.unsubscribeTTL, "EMAIL_UNSUBSCRIBE_TTL", emailUnsubscribeTTL, "How long unsubscribe links in emails stay valid")
+
+	activationRepeat := env.String("ACTIVATION_REPEAT", repeatActivationConflict)
+	if !validator.PermittedValue(activationRepeat, repeatActivationOff, repeatActivationOK, repeatActivationConflict) {
+		configErrors = append(configErrors, fmt.Errorf("invalid ACTIVATION_REPEAT %s", activationRepeat))
+	}
+	fs.StringVar(&cfg.activation.repeat, "ACTIVATION_REPEAT", activationRepeat, "How a reused activation token is answered (off|ok|conflict)")
+
+	activationRetention, err := env.Duration("ACTIVATION_CONSUMED_RETENTION", 15*time.Minute)
+	if err != nil || activationRetention <= 0 {
+		configErrors = append(configErrors, fmt.Errorf("invalid ACTIVATION_CONSUMED_RETENTION %s", env.Get("ACTIVATION_CONSUMED_RETENTION")))
+	}
+	fs.DurationVar(&cfg.activation.retention, "ACTIVATION_CONSUMED_RETENTION", activationRetention, "How long used activation tokens are remembered for")
+
+	usersSoftDelete, err := env.Bool("USERS_SOFT_DELETE_ENABLED", true)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid USERS_SOFT_DELETE_ENABLED %s", err))
+	}
+	fs.BoolVar(&cfg.users.softDelete, "USERS_SOFT_DELETE_ENABLED", usersSoftDelete, "Serve the admin endpoints soft-deleting and restoring users")
+
+	usersDeletedRetention, err := env.Duration("USERS_DELETED_RETENTION", 30*24*time.Hour)
+	if err != nil || usersDeletedRetention < 0 {
+		configErrors = append(configErrors, fmt.Errorf("invalid USERS_DELETED_RETENTION %s", env.Get("USERS_DELETED_RETENTION")))
+	}
+	fs.DurationVar(&cfg.users.deletedRetention, "USERS_DELETED_RETENTION", usersDeletedRetention, "How long soft-deleted users are kept before being purged (0 keeps them)")
+
+	rolesEnabled, err := env.Bool("ROLES_ENABLED", true)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid ROLES_ENABLED %s", err))
+	}
+	fs.BoolVar(&cfg.roles.enabled, "ROLES_ENABLED", rolesEnabled, "Grant users the permissions of the roles they hold, and serve the role admin endpoints")
+
+	listCacheTTL, err := env.Duration("MOVIE_LIST_CACHE_TTL", 0)
+	if err != nil || listCacheTTL < 0 {
+		configErrors = append(configErrors, fmt.Errorf("invalid MOVIE_LIST_CACHE_TTL %s", env.Get("MOVIE_LIST_CACHE_TTL")))
+	}
+	fs.DurationVar(&cfg.listCache.ttl, "MOVIE_LIST_CACHE_TTL", listCacheTTL, "How long pages of listed movies are cached for (0 disables the cache)")
+
+	listCacheMaxEntries, err := env.Int("MOVIE_LIST_CACHE_MAX_ENTRIES", 1000)
+	if err != nil || listCacheMaxEntries < 1 {
+		configErrors = append(configErrors, fmt.Errorf("invalid MOVIE_LIST_CACHE_MAX_ENTRIES %s", env.Get("MOVIE_LIST_CACHE_MAX_ENTRIES")))
+	}
+	fs.IntVar(&cfg.listCache.maxEntries, "MOVIE_LIST_CACHE_MAX_ENTRIES", listCacheMaxEntries, "Maximum number of cached pages of movies")
+
+	outboundLogging, err := env.Bool("OUTBOUND_LOGGING", false)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid OUTBOUND_LOGGING %s", err))
+	}
+	fs.BoolVar(&cfg.outbound.logging, "OUTBOUND_LOGGING", outboundLogging, "Log every call made to SMTP servers, webhook targets, metadata providers and object stores")
+
+	auditDenials := env.String("AUDIT_DENIALS", "log")
+	if !validator.PermittedValue(auditDenials, "off", "log", "table", "both") {
+		configErrors = append(configErrors, fmt.Errorf("invalid AUDIT_DENIALS %s", auditDenials))
+	}
+	fs.StringVar(&cfg.audit.denials, "AUDIT_DENIALS", auditDenials, "Where access denials are recorded (off|log|table|both)")
+
+	auditRps, err := env.Float("AUDIT_DENIALS_RPS", 5)
+	if err != nil || auditRps <= 0 {
+		configErrors = append(configErrors, fmt.Errorf("invalid AUDIT_DENIALS_RPS %s", env.Get("AUDIT_DENIALS_RPS")))
+	}
+	fs.Float64Var(&cfg.audit.rps, "AUDIT_DENIALS_RPS", auditRps, "Maximum access denials recorded per second")
+
+	auditBurst, err := env.Int("AUDIT_DENIALS_BURST", 20)
+	if err != nil || auditBurst < 1 {
+		configErrors = append(configErrors, fmt.Errorf("invalid AUDIT_DENIALS_BURST %s", env.Get("AUDIT_DENIALS_BURST")))
+	}
+	fs.IntVar(&cfg.audit.burst, "AUDIT_DENIALS_BURST", auditBurst, "Maximum burst of access denials recorded")
+
+	postersBackend := env.String("POSTERS_BACKEND", "filesystem")
+	if !validator.PermittedValue(postersBackend, "filesystem", "s3") {
+		configErrors = append(configErrors, fmt.Errorf("invalid POSTERS_BACKEND %s", postersBackend))
+	}
+	fs.StringVar(&cfg.posters.backend, "POSTERS_BACKEND", postersBackend, "Storage backend for movie posters (filesystem|s3)")
+
+	postersDir := env.String("POSTERS_DIR", "./uploads/posters")
+	fs.StringVar(&cfg.posters.dir, "POSTERS_DIR", postersDir, "Directory for movie posters with the filesystem backend")
+
+	postersEndpoint := env.Get("POSTERS_S3_ENDPOINT")
+	fs.StringVar(&cfg.posters.endpoint, "POSTERS_S3_ENDPOINT", postersEndpoint, "S3-compatible endpoint for movie posters with the s3 backend")
+
+	postersRegion := env.String("POSTERS_S3_REGION", "us-east-1")
+	fs.StringVar(&cfg.posters.region, "POSTERS_S3_REGION", postersRegion, "Object store region for movie posters")
+
+	postersBucket := env.Get("POSTERS_S3_BUCKET")
+	fs.StringVar(&cfg.posters.bucket, "POSTERS_S3_BUCKET", postersBucket, "Object store bucket for movie posters")
+
+	postersAccessKey := env.Get("POSTERS_S3_ACCESS_KEY")
+	fs.StringVar(&cfg.posters.accessKey, "POSTERS_S3_ACCESS_KEY", postersAccessKey, "Object store access key for movie posters")
+
+	postersSecretKey := env.Get("POSTERS_S3_SECRET_KEY")
+	fs.StringVar(&cfg.posters.secretKey, "POSTERS_S3_SECRET_KEY", postersSecretKey, "Object store secret key for movie posters")
+
+	postersServe := env.String("POSTERS_S3_SERVE", "proxy")
+	if !validator.PermittedValue(postersServe, "proxy", "redirect") {
+		configErrors = append(configErrors, fmt.Errorf("invalid POSTERS_S3_SERVE %s", postersServe))
+	}
+	fs.StringVar(&cfg.posters.serve, "POSTERS_S3_SERVE", postersServe, "Serve S3 posters by proxying the bytes or redirecting to a presigned URL (proxy|redirect)")
+
+	postersMaxBytes, err := env.Int("POSTERS_MAX_BYTES", 5<<20)
+	if err != nil || postersMaxBytes < 1 {
+		configErrors = append(configErrors, fmt.Errorf("invalid POSTERS_MAX_BYTES %s", env.Get("POSTERS_MAX_BYTES")))
+	}
+	fs.IntVar(&cfg.posters.maxBytes, "POSTERS_MAX_BYTES", postersMaxBytes, "Maximum size of an uploaded movie poster in bytes")
+
+	postersRanges, err := env.Bool("POSTERS_RANGE_REQUESTS", true)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid POSTERS_RANGE_REQUESTS %s", err))
+	}
+	fs.BoolVar(&cfg.posters.ranges, "POSTERS_RANGE_REQUESTS", postersRanges, "Answer Range requests for proxied movie posters with partial content")
+
+	fs.BoolVar(&cfg.displayVersion, "version", false, "Display the version and exit")
+
+	err = fs.Parse(args)
+	if err != nil {
+		return config{}, fs, nil, err
+	}
+
+	if cfg.displayVersion {
+		return cfg, fs, nil, nil
+	}
+
+	// EXPLAIN runs every slow query a second time, which production cannot afford.
+	if cfg.env == "production" {
+		cfg.db.explainSlow = false
+	}
+
+	cfg.movies.yearMin, err = data.ParseYearBound(movieYearMin)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid MOVIE_YEAR_MIN %s", err))
+	}
+
+	cfg.movies.yearMax, err = data.ParseYearBound(movieYearMax)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid MOVIE_YEAR_MAX %s", err))
+	}
+
+	if now := time.Now(); cfg.movies.yearMin.Resolve(now) > cfg.movies.yearMax.Resolve(now) {
+		configErrors = append(configErrors, fmt.Errorf("MOVIE_YEAR_MIN %s is after MOVIE_YEAR_MAX %s", cfg.movies.yearMin, cfg.movies.yearMax))
+	}
+
+	cfg.auth.schemes, err = parseAuthSchemes(authSchemes)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid AUTH_SCHEMES %s", err))
+	}
+
+	if slices.Contains(cfg.auth.schemes, authSchemeJWT) {
+		for _, setting := range []struct{ name, value string }{
+			{"JWT_SECRET", cfg.auth.jwtSecret},
+			{"JWT_ISSUER", cfg.auth.jwtIssuer},
+			{"JWT_AUDIENCE", cfg.auth.jwtAudience},
+		} {
+			if setting.value == "" {
+				configErrors = append(configErrors, fmt.Errorf("%s must be set for the jwt authentication scheme", setting.name))
+			}
+		}
+	}
+
+	if cfg.idempotency.backend != "disabled" && cfg.idempotency.secret == "" {
+		configErrors = append(configErrors, fmt.Errorf("IDEMPOTENCY_SECRET must be set for the %s idempotency backend", cfg.idempotency.backend))
+	}
+
+	if cfg.requests.maxBodyBytes <= 0 {
+		configErrors = append(configErrors, fmt.Errorf("MAX_REQUEST_BODY_BYTES must be positive"))
+	}
+
+	for _, setting := range []struct {
+		name string
+		ttl  time.Duration
+	}{
+		{"TOKEN_ACTIVATION_TTL", cfg.tokens.activationTTL},
+		{"TOKEN_AUTH_TTL", cfg.tokens.authenticationTTL},
+		{"TOKEN_PASSWORD_RESET_TTL", cfg.tokens.passwordResetTTL},
+	} {
+		if setting.ttl <= 0 {
+			configErrors = append(configErrors, fmt.Errorf("%s must be positive", setting.name))
+		}
+	}
+
+	cfg.db.replicaURLs = strings.Fields(postgresReplicaURLs)
+
+	cfg.cors.trustedOrigins, err = parseTrustedOrigins(trustedOrigins)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid CORS_TRUSTED_ORIGINS %s", err))
+	}
+	cfg.cors.allowedMethods = strings.Fields(strings.ToUpper(corsMethods))
+	cfg.cors.allowedHeaders = strings.Fields(corsHeaders)
+
+	if len(cfg.cors.allowedMethods) == 0 {
+		configErrors = append(configErrors, fmt.Errorf("CORS_ALLOWED_METHODS must not be empty"))
+	}
+
+	if (cfg.tls.certFile == "") != (cfg.tls.keyFile == "") {
+		configErrors = append(configErrors, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together"))
+	}
+
+	if cfg.tls.http3 && cfg.tls.certFile == "" {
+		configErrors = append(configErrors, fmt.Errorf("HTTP3_ENABLED requires TLS_CERT_FILE and TLS_KEY_FILE"))
+	}
+
+	cfg.encryption.keys, err = fieldcrypt.ParseKeys(fieldEncryptionKeys)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid FIELD_ENCRYPTION_KEYS %s", err))
+	}
+
+	cfg.tls.config, err = newTLSConfig(tlsMinVersion, tlsCipherPolicy)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid TLS configuration %s", err))
+	}
+
+	cfg.proxy.trusted, err = parseTrustedProxies(trustedProxies)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid TRUSTED_PROXIES %s", err))
+	}
+
+	if cfg.proxy.externalBaseURL != "" {
+		u, err := url.Parse(cfg.proxy.externalBaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			configErrors = append(configErrors, fmt.Errorf("invalid EXTERNAL_BASE_URL %s", cfg.proxy.externalBaseURL))
+		}
+	}
+
+	cfg.deprecations, err = parseDeprecations(routeDeprecations)
+	if err != nil {
+		configErrors = append(configErrors, fmt.Errorf("invalid ROUTE_DEPRECATIONS %s", err))
+	}
+
+	// The routes depend on the rest of the configuration, so deprecations are checked
+	// against them last.
+	routes := (&application{config: cfg}).routeTable()
+	for _, d := range cfg.deprecations {
+		if !slices.ContainsFunc(routes, func(rt route) bool { return rt.method == d.method && rt.pattern == d.pattern }) {
+			configErrors = append(configErrors, fmt.Errorf("invalid ROUTE_DEPRECATIONS no route %s %s", d.method, d.pattern))
+		}
+	}
+
+	if cfg.exports.endpoint != "" && cfg.exports.bucket == "" {
+		configErrors = append(configErrors, fmt.Errorf("EXPORTS_S3_BUCKET is not set"))
+	}
+
+	if cfg.limiter.backend == "redis" {
+		if cfg.limiter.redisURL == "" {
+			configErrors = append(configErrors, fmt.Errorf("REDIS_URL is not set"))
+		} else if _, err := redis.ParseURL(cfg.limiter.redisURL); err != nil {
+			configErrors = append(configErrors, fmt.Errorf("invalid REDIS_URL %s", err))
+		}
+	}
+
+	if cfg.posters.backend == "s3" && (cfg.posters.endpoint == "" || cfg.posters.bucket == "") {
+		configErrors = append(configErrors, fmt.Errorf("POSTERS_S3_ENDPOINT and POSTERS_S3_BUCKET must be set for the s3 posters backend"))
+	}
+
+	return cfg, fs, configErrors, nil
+}
+
+// newTracer returns a tracer sampling the configured fraction of new traces, which logs
+// each span it keeps.
+func newTracer(cfg config, logger *jsonlog.Logger) *tracing.Provider {
+	return &tracing.Provider{
+		Sampler: tracing.ParentBasedRatio{Ratio: cfg.tracing.sampleRate},
+		Export: func(span tracing.Span) {
+			properties := map[string]string{
+				"trace_id":    span.Context.TraceID.String(),
+				"span_id":     span.Context.SpanID.String(),
+				"duration_ms": strconv.FormatInt(span.Duration.Milliseconds(), 10),
+			}
+			if span.ParentSpanID != (tracing.SpanID{}) {
+				properties["parent_span_id"] = span.ParentSpanID.String()
+			}
+			maps.Copy(properties, span.Attributes)
+
+			logger.PrintInfo(span.Name, properties)
+		},
+	}
+}
+
+// openDB opens a connection pool for dsn. New connections give up after the configured connect
+// timeout, so that a database which is down is noticed quickly rather than hanging requests.
+func openDB(cfg config, dsn string) (*sql.DB, error) {
+	connConfig, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	connConfig.ConnectTimeout = cfg.db.connectTimeout
+
+	db := stdlib.OpenDB(*connConfig)
+
+	db.SetMaxOpenConns(cfg.db.maxOpenConns)
+	db.SetMaxIdleConns(cfg.db.maxIdleConns)
+
+	duration, err := time.ParseDuration(cfg.db.maxIdleTime)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetConnMaxIdleTime(duration)
+
+	return db, nil
+}
+
+func ping(db *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return db.PingContext(ctx)
 }
@@ -1,128 +1,227 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"errors"
 	"expvar"
 	"fmt"
 	"greenlight/internal/data"
+	"greenlight/internal/tracing"
 	"greenlight/internal/validator"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/tomasen/realip"
-	"golang.org/x/time/rate"
 )
 
-func (app *application) recoverPanic(next http.Handler) http.Handler {
+// requestID gives every request an id, returned in the X-Request-ID header and included in
+// the logs so that a request can be traced from a client report, and across instances. An
+// X-Request-ID sent by the client or a proxy in front of the API is kept when it is a
+// plausible id, and otherwise a random UUID is generated. It must run first, so that every
+// line logged for the request carries the id.
+func (app *application) requestID(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		defer func() {
-			if err := recover(); err != nil {
-				w.Header().Set("Connection", "close")
+		id := r.Header.Get("X-Request-ID")
 
-				app.serverErrorResponse(w, r, fmt.Errorf("%s", err))
+		if !validRequestID(id) {
+			b := make([]byte, 16)
+			_, err := rand.Read(b)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
 			}
-		}()
-		next.ServeHTTP(w, r)
+
+			// Version 4, RFC 4122 variant.
+			b[6] = b[6]&0x0f | 0x40
+			b[8] = b[8]&0x3f | 0x80
+
+			id = fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		next.ServeHTTP(w, app.contextSetRequestID(r, id))
 	})
 }
 
-func (app *application) rateLimit(next http.Handler) http.Handler {
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
+// validRequestID reports whether an incoming request id is safe to log and echo back: at
+// most 128 letters, digits, hyphens, underscores and dots.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
 	}
 
-	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client)
-	)
+	for _, c := range id {
+		if !(c == '-' || c == '_' || c == '.' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
 
-	go func() {
-		for {
-			time.Sleep(time.Minute)
+	return true
+}
 
-			mu.Lock()
+func (app *application) recoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				w.Header().Set("Connection", "close")
 
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
-				}
+				app.serverErrorResponse(w, r, fmt.Errorf("%s", err))
 			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
 
-			mu.Unlock()
-		}
-	}()
+// Ways of partitioning the rate limiter.
+const (
+	limiterKeyIP   = "ip"
+	limiterKeyUser = "user"
+)
 
+// rateLimit limits requests per client IP, or when app.config.limiter.key is user, per
+// authenticated user, with anonymous requests still limited per IP. Keyed by user, it must
+// run after authenticate.
+func (app *application) rateLimit(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if app.config.limiter.enabled {
-			ip := realip.FromRequest(r)
+			// The prefixes keep a user id from sharing a bucket with an IP.
+			key := "ip:" + realip.FromRequest(r)
 
-			mu.Lock()
+			if app.config.limiter.key == limiterKeyUser {
+				if user := app.contextGetUser(r); !user.IsAnonymous() {
+					key = "user:" + strconv.FormatInt(user.ID, 10)
+				}
+			}
 
-			if _, found := clients[ip]; !found {
-				clients[ip] = &client{limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst)}
+			res, err := app.limiter.Allow(r.Context(), key)
+			if err != nil {
+				// Requests are let through while the limiter's backend is unavailable,
+				// rather than failing them all, and without headers since the state of
+				// the bucket is unknown.
+				app.logError(r, err)
+				next.ServeHTTP(w, r)
+				return
 			}
 
-			clients[ip].lastSeen = time.Now()
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			w.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
 
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
-				app.rateLimitExceededResponse(w, r)
+			if !res.Allowed {
+				app.rateLimitExceededResponse(w, r, res.RetryAfter)
 				return
 			}
-
-			mu.Unlock()
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+// ceilSeconds rounds d up to whole seconds, as used in rate limit headers.
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// authenticate resolves the user from the first enabled authentication scheme, in order of
+// precedence, whose credentials were sent with the request. Requests without credentials
+// are served as the anonymous user.
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Authorization")
+		if slices.Contains(app.config.auth.schemes, authSchemeAPIKey) {
+			w.Header().Add("Vary", "X-API-Key")
+		}
 
-		authorizationHeader := r.Header.Get("Authorization")
+		for _, scheme := range app.config.auth.schemes {
+			credential, ok := app.authCredential(scheme, r)
+			if !ok {
+				continue
+			}
+
+			if app.authFailuresExceeded(w, r) {
+				return
+			}
+
+			user, err := app.authUser(scheme, credential)
+			if err != nil {
+				switch {
+				case errors.Is(err, errInvalidCredentials):
+					app.recordAuthFailure(r)
+					app.invalidAuthenticationTokenRespose(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			r = app.contextSetUser(r, user)
 
-		if authorizationHeader == "" {
-			r = app.contextSetUser(r, data.AnonymousUser)
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		headerParts := strings.Split(authorizationHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		// An Authorization header which none of the enabled schemes understands.
+		if r.Header.Get("Authorization") != "" {
 			app.invalidAuthenticationTokenRespose(w, r)
 			return
 		}
 
-		token := headerParts[1]
+		r = app.contextSetUser(r, data.AnonymousUser)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authFailureKey returns the rate limiter bucket counting the failed authentications from
+// the client IP of the request. Failures are only counted while the limiter is keyed by
+// user: keyed by IP, rateLimit runs before authenticate and limits failed attempts itself.
+func (app *application) authFailureKey(r *http.Request) (string, bool) {
+	if !app.config.limiter.enabled || app.config.limiter.key != limiterKeyUser {
+		return "", false
+	}
 
-		v := validator.New()
+	return "authfail:" + realip.FromRequest(r), true
+}
 
-		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
-			app.invalidAuthenticationTokenRespose(w, r)
-			return
-		}
+// authFailuresExceeded answers with 429 and returns true when the client IP of the request
+// has used up its rate limit on failed authentications, before its credentials are looked
+// up, so that tokens cannot be guessed at an unlimited rate. Successful authentications
+// never count against it.
+func (app *application) authFailuresExceeded(w http.ResponseWriter, r *http.Request) bool {
+	key, ok := app.authFailureKey(r)
+	if !ok {
+		return false
+	}
 
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
-		if err != nil {
-			switch {
-			case errors.Is(err, data.ErrRecordNotFound):
-				app.invalidAuthenticationTokenRespose(w, r)
-			default:
-				app.serverErrorResponse(w, r, err)
-			}
-			return
-		}
+	res, err := app.limiter.Peek(r.Context(), key)
+	if err != nil {
+		app.logError(r, err)
+		return false
+	}
 
-		r = app.contextSetUser(r, user)
+	if !res.Allowed {
+		app.rateLimitExceededResponse(w, r, res.RetryAfter)
+		return true
+	}
 
-		next.ServeHTTP(w, r)
-	})
+	return false
+}
+
+// recordAuthFailure counts a failed authentication against the client IP of the request.
+func (app *application) recordAuthFailure(r *http.Request) {
+	key, ok := app.authFailureKey(r)
+	if !ok {
+		return
+	}
+
+	_, err := app.limiter.Allow(r.Context(), key)
+	if err != nil {
+		app.logError(r, err)
+	}
 }
 
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
@@ -143,6 +242,7 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 		user := app.contextGetUser(r)
 
 		if !user.Activated {
+			app.recordDenial(r, "", data.DenialInactiveAccount)
 			app.inactiveAccountResponse(w, r)
 			return
 		}
@@ -165,6 +265,7 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 		}
 
 		if !permissions.Include(code) {
+			app.recordDenial(r, code, data.DenialMissingPermission)
 			app.notPermittedResponse(w, r)
 			return
 		}
@@ -175,7 +276,75 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	return app.requireActivatedUser(fn)
 }
 
+// methodOverride lets clients behind proxies which block PATCH, PUT or DELETE send a POST
+// request with an X-HTTP-Method-Override header naming the intended method instead.
+func (app *application) methodOverride(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.config.methodOverride.enabled && r.Method == http.MethodPost {
+			override := strings.ToUpper(r.Header.Get("X-HTTP-Method-Override"))
+
+			if override != "" {
+				if !validator.PermittedValue(override, http.MethodPut, http.MethodPatch, http.MethodDelete) {
+					app.badRequestResponse(w, r, fmt.Errorf("invalid X-HTTP-Method-Override value %q", override))
+					return
+				}
+
+				r.Method = override
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// securityHeaders sets the security headers on every response, including error responses
+// and redirects, before the rest of the chain runs. Handlers which set one of them
+// themselves replace the default. Strict-Transport-Security is only sent over HTTPS, since
+// browsers ignore it on plain HTTP.
+func (app *application) securityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers := w.Header()
+
+		headers.Set("X-Content-Type-Options", "nosniff")
+		headers.Set("X-Frame-Options", "DENY")
+		headers.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+
+		if app.config.securityHeaders.csp != "" {
+			headers.Set("Content-Security-Policy", app.config.securityHeaders.csp)
+		}
+
+		if app.config.securityHeaders.hstsMaxAge > 0 && app.requestScheme(r) == "https" {
+			maxAge := int64(app.config.securityHeaders.hstsMaxAge / time.Second)
+			headers.Set("Strict-Transport-Security", "max-age="+strconv.FormatInt(maxAge, 10))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// advertiseHTTP3 tells clients, with the Alt-Svc header, that the API is served over HTTP/3
+// on the same port too, for a day, when HTTP/3 is enabled.
+func (app *application) advertiseHTTP3(next http.Handler) http.Handler {
+	if !app.config.tls.http3 {
+		return next
+	}
+
+	altSvc := fmt.Sprintf(`h3=":%d"; ma=86400`, app.config.port)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Alt-Svc", altSvc)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// enableCORS allows the trusted origins to make cross-origin requests. OPTIONS requests from
+// a trusted origin are answered as preflights, with the configured methods and headers and
+// a max-age for browsers to cache the answer for.
 func (app *application) enableCORS(next http.Handler) http.Handler {
+	allowMethods := strings.Join(app.config.cors.allowedMethods, ", ")
+	allowHeaders := strings.Join(app.config.cors.allowedHeaders, ", ")
+	maxAge := strconv.FormatInt(int64(app.config.cors.maxAge/time.Second), 10)
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Origin")
 		w.Header().Add("Vary", "Access-Control-Request-Method")
@@ -184,13 +353,19 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 
 		if origin != "" {
 			for _, trustedOrigin := range app.config.cors.trustedOrigins {
-				if origin == trustedOrigin {
+				if trustedOrigin.match(origin) {
 					w.Header().Set("Access-Control-Allow-Origin", origin)
 
+					if app.config.cors.allowCredentials {
+						w.Header().Set("Access-Control-Allow-Credentials", "true")
+					}
+
 					if r.Method == http.MethodOptions {
-						w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
-						w.Header().Set("Access-Control-Max-Age", "600")
+						w.Header().Set("Access-Control-Allow-Methods", allowMethods)
+						if allowHeaders != "" {
+							w.Header().Set("Access-Control-Allow-Headers", allowHeaders)
+						}
+						w.Header().Set("Access-Control-Max-Age", maxAge)
 
 						w.WriteHeader(http.StatusOK)
 						return
@@ -238,6 +413,8 @@ func (app *application) metrics(next http.Handler) http.Handler {
 		totalResponsesSent              = expvar.NewInt("total_responses_sent")
 		totalProcessingTimeMicroseconds = expvar.NewInt("total_processing_time_microseconds")
 		totalResponsesSentByStatus      = expvar.NewMap("total_responses_sent_by_status")
+		totalRequestsByRoute            = expvar.NewMap("total_requests_received_by_route")
+		processingTimeByRoute           = expvar.NewMap("total_processing_time_microseconds_by_route")
 	)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -247,7 +424,11 @@ func (app *application) metrics(next http.Handler) http.Handler {
 
 		mw := &metricsResponseWriter{ResponseWriter: w}
 
-		next.ServeHTTP(mw, r)
+		// Requests which match no route, or are answered before reaching the router, are
+		// counted together, so that scanning for URLs cannot grow the maps without bound.
+		route := "unmatched"
+
+		next.ServeHTTP(mw, app.contextSetRouteHolder(r, &route))
 
 		totalResponsesSent.Add(1)
 
@@ -255,5 +436,72 @@ func (app *application) metrics(next http.Handler) http.Handler {
 
 		duration := time.Since(start).Microseconds()
 		totalProcessingTimeMicroseconds.Add(duration)
+
+		totalRequestsByRoute.Add(route, 1)
+		processingTimeByRoute.Add(route, duration)
+	})
+}
+
+// recordRoute records the route, named by its method and pattern, for the metrics
+// middleware before calling next.
+func (app *application) recordRoute(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app.contextSetRoute(r, route)
+		next(w, r)
+	}
+}
+
+// timeout cancels the context of the request once it has run for app.config.requests.timeout,
+// which abandons the queries still in flight for it, and serverErrorResponse answers with a
+// 503. Routes which may legitimately run longer are listed in untimedRoutes instead.
+func (app *application) timeout(next http.HandlerFunc) http.HandlerFunc {
+	if app.config.requests.timeout <= 0 {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), app.config.requests.timeout)
+		defer cancel()
+
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// trace records a span for each request. Spans are sampled according to the configured
+// ratio (following the caller's decision when a traceparent header is sent), but server
+// errors and slow requests are always kept.
+func (app *application) trace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.tracer == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var parent *tracing.SpanContext
+		if sc, ok := tracing.ParseTraceparent(r.Header.Get("traceparent")); ok {
+			parent = &sc
+		}
+
+		span := app.tracer.Start(r.Method+" "+r.URL.Path, parent, app.clock.Now())
+
+		w.Header().Set("traceparent", span.Context.Traceparent())
+
+		r = app.contextSetSpan(r, span)
+
+		mw := &metricsResponseWriter{ResponseWriter: w}
+
+		next.ServeHTTP(mw, r)
+
+		span.Attributes["http.method"] = r.Method
+		span.Attributes["http.target"] = r.URL.RequestURI()
+		span.Attributes["http.status_code"] = strconv.Itoa(mw.statusCode)
+		if id := app.contextGetRequestID(r); id != "" {
+			span.Attributes["request_id"] = id
+		}
+
+		now := app.clock.Now()
+		force := mw.statusCode >= http.StatusInternalServerError || now.Sub(span.Start) >= app.config.tracing.slowThreshold
+
+		app.tracer.End(span, now, force)
 	})
 }
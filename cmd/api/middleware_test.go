@@ -0,0 +1,563 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"expvar"
+	"greenlight/internal/data"
+	"greenlight/internal/jsonlog"
+	"greenlight/internal/ratelimit"
+	"greenlight/internal/sqlfake"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestAuthFailuresRateLimitedPerIP(t *testing.T) {
+	app, clk := newTestApplication(t)
+	app.config.limiter.enabled = true
+	app.config.limiter.key = limiterKeyUser
+	app.config.auth.schemes = []string{authSchemeJWT}
+	app.config.auth.jwtSecret = "secret"
+	app.limiter = ratelimit.NewMemory(1, 2, clk)
+
+	reached := 0
+	h := app.authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached++
+	}))
+
+	request := func(ip, authorization string) int {
+		r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+		r.RemoteAddr = ip + ":1234"
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		return serve(t, h, r).Code
+	}
+
+	for i, want := range []int{http.StatusForbidden, http.StatusForbidden, http.StatusTooManyRequests} {
+		if got := request("192.0.2.1", "Bearer a.b.c"); got != want {
+			t.Fatalf("attempt %d: got status %d; want %d", i+1, got, want)
+		}
+	}
+
+	if got := request("192.0.2.2", "Bearer a.b.c"); got != http.StatusForbidden {
+		t.Errorf("another IP: got status %d; want %d", got, http.StatusForbidden)
+	}
+
+	// Requests without credentials are left to the per-user rate limiter.
+	if got := request("192.0.2.1", ""); got != http.StatusOK || reached != 1 {
+		t.Errorf("anonymous request: got status %d, handler reached %d times", got, reached)
+	}
+
+	clk.Advance(time.Second)
+
+	if got := request("192.0.2.1", "Bearer a.b.c"); got != http.StatusForbidden {
+		t.Errorf("after refill: got status %d; want %d", got, http.StatusForbidden)
+	}
+}
+
+func TestRateLimitRefillsWithTheClock(t *testing.T) {
+	app, clk := newTestApplication(t)
+	app.config.limiter.enabled = true
+	app.limiter = ratelimit.NewMemory(1, 2, clk)
+
+	h := app.rateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		return serve(t, h, r)
+	}
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if rr := request(); rr.Code != want {
+			t.Fatalf("request %d: got status %d; want %d", i+1, rr.Code, want)
+		}
+	}
+
+	clk.Advance(999 * time.Millisecond)
+
+	if rr := request(); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("before refill: got status %d; want %d", rr.Code, http.StatusTooManyRequests)
+	}
+
+	clk.Advance(time.Millisecond)
+
+	rr := request()
+	if rr.Code != http.StatusOK {
+		t.Errorf("after refill: got status %d; want %d", rr.Code, http.StatusOK)
+	}
+	if got := rr.Header().Get("X-RateLimit-Remaining"); got != "0" {
+		t.Errorf("after refill: got X-RateLimit-Remaining %q; want 0", got)
+	}
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	alice := &data.User{ID: 1, Activated: true}
+	bob := &data.User{ID: 2, Activated: true}
+
+	tests := []struct {
+		name     string
+		key      string
+		user     *data.User
+		wantCode int
+	}{
+		// Alice has used up the burst of 2 from 192.0.2.1 before each case.
+		{"ip/another user", limiterKeyIP, bob, http.StatusTooManyRequests},
+		{"ip/anonymous", limiterKeyIP, data.AnonymousUser, http.StatusTooManyRequests},
+		{"user/another user", limiterKeyUser, bob, http.StatusOK},
+		{"user/same user", limiterKeyUser, alice, http.StatusTooManyRequests},
+		{"user/anonymous", limiterKeyUser, data.AnonymousUser, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, clk := newTestApplication(t)
+			app.config.limiter.enabled = true
+			app.config.limiter.key = tt.key
+			app.limiter = ratelimit.NewMemory(1, 2, clk)
+
+			h := app.rateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			request := func(user *data.User) int {
+				r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+				r.RemoteAddr = "192.0.2.1:1234"
+				return serve(t, h, asUser(app, r, user)).Code
+			}
+
+			request(alice)
+			request(alice)
+
+			if got := request(tt.user); got != tt.wantCode {
+				t.Errorf("got status %d; want %d", got, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestMethodOverride(t *testing.T) {
+	app, _ := newTestApplication(t)
+
+	router := httprouter.New()
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("POST"))
+	})
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("PATCH"))
+	})
+	h := app.methodOverride(router)
+
+	tests := []struct {
+		name     string
+		enabled  bool
+		method   string
+		override string
+		wantCode int
+		wantBody string
+	}{
+		{"disabled", false, http.MethodPost, "PATCH", http.StatusOK, "POST"},
+		{"overridden", true, http.MethodPost, "patch", http.StatusOK, "PATCH"},
+		{"no header", true, http.MethodPost, "", http.StatusOK, "POST"},
+		{"not a POST", true, http.MethodGet, "PATCH", http.StatusMethodNotAllowed, ""},
+		{"not safelisted", true, http.MethodPost, "GET", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app.config.methodOverride.enabled = tt.enabled
+
+			r := httptest.NewRequest(tt.method, "/v1/movies/1", nil)
+			if tt.override != "" {
+				r.Header.Set("X-HTTP-Method-Override", tt.override)
+			}
+
+			rr := serve(t, h, r)
+			if rr.Code != tt.wantCode {
+				t.Errorf("got status %d; want %d", rr.Code, tt.wantCode)
+			}
+			if tt.wantBody != "" && rr.Body.String() != tt.wantBody {
+				t.Errorf("got body %q; want %q", rr.Body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	app, clk := newTestApplication(t)
+	app.config.limiter.enabled = true
+	app.limiter = ratelimit.NewMemory(0.5, 2, clk)
+
+	h := app.rateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		wantStatus     int
+		wantRemaining  string
+		wantReset      string
+		wantRetryAfter string
+	}{
+		{http.StatusOK, "1", "2", ""},
+		{http.StatusOK, "0", "4", ""},
+		{http.StatusTooManyRequests, "0", "4", "2"},
+	}
+
+	for i, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+
+		rr := serve(t, h, r)
+		if rr.Code != tt.wantStatus {
+			t.Fatalf("request %d: got status %d; want %d", i+1, rr.Code, tt.wantStatus)
+		}
+
+		got := rr.Header()
+		if got.Get("X-RateLimit-Limit") != "2" || got.Get("X-RateLimit-Remaining") != tt.wantRemaining || got.Get("X-RateLimit-Reset") != tt.wantReset || got.Get("Retry-After") != tt.wantRetryAfter {
+			t.Errorf("request %d: got limit %q, remaining %q, reset %q and Retry-After %q; want 2, %s, %s and %q", i+1,
+				got.Get("X-RateLimit-Limit"), got.Get("X-RateLimit-Remaining"), got.Get("X-RateLimit-Reset"), got.Get("Retry-After"),
+				tt.wantRemaining, tt.wantReset, tt.wantRetryAfter)
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	tests := []struct {
+		name     string
+		incoming string
+		wantKept bool
+	}{
+		{"none", "", false},
+		{"kept", "lb-1.7f3a_c9", true},
+		{"unsafe", "abc\r\nX-Injected: 1", false},
+		{"spaces", "abc def", false},
+		{"too long", strings.Repeat("a", 129), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := newTestApplication(t)
+
+			var out bytes.Buffer
+			app.logger = jsonlog.New(&out, jsonlog.LevelInfo)
+
+			var seen string
+			h := app.requestID(app.recoverPanic(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = app.contextGetRequestID(r)
+				panic("boom")
+			})))
+
+			r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+			r.Header.Set("X-Request-ID", tt.incoming)
+
+			rr := serve(t, h, r)
+
+			id := rr.Header().Get("X-Request-ID")
+			switch {
+			case tt.wantKept && id != tt.incoming:
+				t.Errorf("got id %q; want the incoming %q kept", id, tt.incoming)
+			case !tt.wantKept && !uuid.MatchString(id):
+				t.Errorf("got id %q; want a random UUID", id)
+			}
+
+			if seen != id {
+				t.Errorf("got id %q in the context; want %q", seen, id)
+			}
+
+			var entry struct {
+				Properties map[string]string `json:"properties"`
+			}
+			if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+				t.Fatalf("got log %q: %v", out.String(), err)
+			}
+			if got := entry.Properties["request_id"]; got != id {
+				t.Errorf("got the panic logged with request_id %q; want %q", got, id)
+			}
+		})
+	}
+}
+
+// metricsHandler serves GET /v1/movies/1 as the route GET /v1/movies/:id, and answers every
+// other request with a 404 before any route is recorded. The metrics middleware publishes
+// its expvars, so it is only built once however many times the tests run.
+var metricsHandler = sync.OnceValue(func() http.Handler {
+	app := &application{}
+
+	show := app.recordRoute("GET /v1/movies/:id", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	return app.metrics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/movies/1" {
+			show(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+})
+
+func TestMetricsByRouteAndStatus(t *testing.T) {
+	h := metricsHandler()
+
+	value := func(name, key string) int64 {
+		v := expvar.Get(name)
+		if m, ok := v.(*expvar.Map); ok {
+			v = m.Get(key)
+		}
+		if v == nil {
+			return 0
+		}
+		return v.(*expvar.Int).Value()
+	}
+
+	counters := []struct{ name, key string }{
+		{"total_requests_received", ""},
+		{"total_responses_sent", ""},
+		{"total_responses_sent_by_status", "200"},
+		{"total_responses_sent_by_status", "404"},
+		{"total_requests_received_by_route", "GET /v1/movies/:id"},
+		{"total_requests_received_by_route", "unmatched"},
+	}
+
+	before := make([]int64, len(counters))
+	for i, c := range counters {
+		before[i] = value(c.name, c.key)
+	}
+
+	for _, target := range []string{"/v1/movies/1", "/v1/movies/1", "/wp-admin"} {
+		serve(t, h, httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	want := []int64{3, 3, 2, 1, 2, 1}
+	for i, c := range counters {
+		if got := value(c.name, c.key) - before[i]; got != want[i] {
+			t.Errorf("%s %s: got %d more; want %d", c.name, c.key, got, want[i])
+		}
+	}
+
+	if expvar.Get("total_processing_time_microseconds_by_route").(*expvar.Map).Get("GET /v1/movies/:id") == nil {
+		t.Error("got no processing time for the route")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	tests := []struct {
+		name        string
+		timeout     string
+		queryTime   time.Duration
+		wantStatus  int
+		wantQueries int
+	}{
+		{"in time", "1s", 0, http.StatusOK, 1},
+		{"expired during the query", "20ms", 100 * time.Millisecond, http.StatusServiceUnavailable, 1},
+		{"expired before the query", "1ns", 0, http.StatusServiceUnavailable, 0},
+		{"disabled", "0s", 100 * time.Millisecond, http.StatusOK, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, clk := newConfiguredTestApplication(t, map[string]string{"REQUEST_TIMEOUT": tt.timeout})
+
+			var queries int
+			useTestDB(t, app, clk, func(query string, args []any) (*sqlfake.Result, error) {
+				queries++
+				if tt.queryTime > 0 {
+					// A database would give up on the query once its context ended.
+					time.Sleep(tt.queryTime)
+					if app.config.requests.timeout > 0 {
+						return nil, context.DeadlineExceeded
+					}
+				}
+				return &sqlfake.Result{Rows: [][]any{{
+					int64(1), testEpoch, testEpoch, "Heat", "heat", int64(1995), int64(170), "{Crime}", int64(1), "public", int64(0), float64(0),
+				}}}, nil
+			})
+
+			h := app.timeout(app.showMovieHandler)
+			rr := serve(t, h, withParams(httptest.NewRequest(http.MethodGet, "/v1/movies/1", nil), "id", "1"))
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d; want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+			if queries != tt.wantQueries {
+				t.Errorf("got %d queries; want %d", queries, tt.wantQueries)
+			}
+			if tt.wantStatus == http.StatusServiceUnavailable && !strings.Contains(rr.Body.String(), errCodeRequestTimeout) {
+				t.Errorf("got body %s; want the %s code", rr.Body, errCodeRequestTimeout)
+			}
+		})
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		hsts     string
+		tls      bool
+		handler  http.HandlerFunc
+		wantCode int
+		want     map[string]string
+	}{
+		{
+			name: "plain HTTP", hsts: "8760h",
+			handler:  func(w http.ResponseWriter, r *http.Request) {},
+			wantCode: http.StatusOK,
+			want: map[string]string{
+				"X-Content-Type-Options":    "nosniff",
+				"X-Frame-Options":           "DENY",
+				"Referrer-Policy":           "strict-origin-when-cross-origin",
+				"Content-Security-Policy":   "default-src 'none'; frame-ancestors 'none'",
+				"Strict-Transport-Security": "",
+			},
+		},
+		{
+			name: "HTTPS", hsts: "8760h", tls: true,
+			handler:  func(w http.ResponseWriter, r *http.Request) {},
+			wantCode: http.StatusOK,
+			want:     map[string]string{"Strict-Transport-Security": "max-age=31536000"},
+		},
+		{
+			name: "HSTS disabled", hsts: "0s", tls: true,
+			handler:  func(w http.ResponseWriter, r *http.Request) {},
+			wantCode: http.StatusOK,
+			want:     map[string]string{"Strict-Transport-Security": ""},
+		},
+		{
+			name: "set by the handler", hsts: "8760h",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+				w.Header().Set("Content-Security-Policy", "default-src 'self'")
+			},
+			wantCode: http.StatusOK,
+			want:     map[string]string{"X-Frame-Options": "SAMEORIGIN", "Content-Security-Policy": "default-src 'self'"},
+		},
+		{
+			name: "error response", hsts: "8760h", tls: true,
+			handler:  nil,
+			wantCode: http.StatusNotFound,
+			want:     map[string]string{"X-Content-Type-Options": "nosniff", "Strict-Transport-Security": "max-age=31536000"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := newConfiguredTestApplication(t, map[string]string{"HSTS_MAX_AGE": tt.hsts})
+
+			handler := tt.handler
+			if handler == nil {
+				handler = app.notFoundResponse
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+
+			rr := serve(t, app.securityHeaders(handler), r)
+			if rr.Code != tt.wantCode {
+				t.Fatalf("got status %d; want %d", rr.Code, tt.wantCode)
+			}
+
+			for key, want := range tt.want {
+				if got := rr.Header().Get(key); got != want {
+					t.Errorf("got %s %q; want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestEnableCORS(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      map[string]string
+		method      string
+		origin      string
+		wantReached bool
+		want        map[string]string
+	}{
+		{
+			name: "preflight", method: http.MethodOptions, origin: "https://a.example.com",
+			want: map[string]string{
+				"Access-Control-Allow-Origin":      "https://a.example.com",
+				"Access-Control-Allow-Methods":     "GET, POST, PUT, PATCH, DELETE, OPTIONS",
+				"Access-Control-Allow-Headers":     "Authorization, Content-Type, Idempotency-Key, If-Match, If-None-Match, X-API-Key, X-HTTP-Method-Override, X-Request-ID, traceparent",
+				"Access-Control-Max-Age":           "600",
+				"Access-Control-Allow-Credentials": "",
+			},
+		},
+		{
+			name: "configured preflight", method: http.MethodOptions, origin: "https://a.example.com",
+			config: map[string]string{
+				"CORS_ALLOWED_METHODS":   "get put",
+				"CORS_ALLOWED_HEADERS":   "Authorization",
+				"CORS_MAX_AGE":           "1h",
+				"CORS_ALLOW_CREDENTIALS": "true",
+			},
+			want: map[string]string{
+				"Access-Control-Allow-Methods":     "GET, PUT",
+				"Access-Control-Allow-Headers":     "Authorization",
+				"Access-Control-Max-Age":           "3600",
+				"Access-Control-Allow-Credentials": "true",
+			},
+		},
+		{
+			name: "untrusted preflight", method: http.MethodOptions, origin: "https://evil.example.org", wantReached: true,
+			want: map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Allow-Methods": ""},
+		},
+		{
+			name: "request", method: http.MethodGet, origin: "https://a.example.com", wantReached: true,
+			config: map[string]string{"CORS_ALLOW_CREDENTIALS": "true"},
+			want: map[string]string{
+				"Access-Control-Allow-Origin":      "https://a.example.com",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Allow-Methods":     "",
+				"Access-Control-Max-Age":           "",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := map[string]string{"CORS_TRUSTED_ORIGINS": "https://a.example.com"}
+			for key, value := range tt.config {
+				config[key] = value
+			}
+
+			app, _ := newConfiguredTestApplication(t, config)
+
+			reached := false
+			h := app.enableCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reached = true
+			}))
+
+			r := httptest.NewRequest(tt.method, "/v1/movies", nil)
+			r.Header.Set("Origin", tt.origin)
+			r.Header.Set("Access-Control-Request-Method", http.MethodPut)
+
+			rr := serve(t, h, r)
+
+			if reached != tt.wantReached {
+				t.Errorf("got the handler reached %t; want %t", reached, tt.wantReached)
+			}
+			if vary := rr.Header().Values("Vary"); !slices.Contains(vary, "Origin") || !slices.Contains(vary, "Access-Control-Request-Method") {
+				t.Errorf("got Vary %q; want Origin and Access-Control-Request-Method", vary)
+			}
+
+			for key, want := range tt.want {
+				if got := rr.Header().Get(key); got != want {
+					t.Errorf("got %s %q; want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
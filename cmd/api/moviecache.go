@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight/internal/data"
+	"strconv"
+	"time"
+)
+
+// Stale modes for the movie cache. With staleIfError, an expired entry is served when
+// reading the movie from the database fails or takes longer than the read timeout. With
+// staleWhileRevalidate, an expired entry is served straight away instead, and the database
+// is only read in the background.
+const (
+	staleOff             = "off"
+	staleIfError         = "stale-if-error"
+	staleWhileRevalidate = "stale-while-revalidate"
+)
+
+// staleWarning is the Warning header sent along with a stale movie.
+const staleWarning = `110 - "Response is Stale"`
+
+type movieResult struct {
+	movie *data.Movie
+	err   error
+}
+
+// getMovie returns the movie with the given id, from the cache when it is enabled. stale
+// reports whether the movie is an expired cache entry, served in place of a database read
+// which failed or was too slow, or which is happening in the background. Only public movies
+// are cached, so that cached movies can be shown to every viewer. Reads made for the request
+// are cancelled with ctx, but background reads are not.
+func (app *application) getMovie(ctx context.Context, id int64, viewer data.Viewer) (movie *data.Movie, stale bool, err error) {
+	if app.movieCache == nil {
+		movie, err = app.models.Movies.WithContext(ctx).Get(id, viewer)
+		return movie, false, err
+	}
+
+	cached, age, ok := app.movieCache.Get(id)
+	if ok && age <= app.config.movieCache.ttl {
+		return cached, false, nil
+	}
+
+	mode := app.config.movieCache.stale
+
+	if !ok || mode == staleOff {
+		movie, err = app.fetchMovie(ctx, id, viewer)
+		return movie, false, err
+	}
+
+	if mode == staleWhileRevalidate {
+		app.refreshMovie(id)
+		return cached, true, nil
+	}
+
+	// The read carries on in the background after timing out, so the entry still gets
+	// refreshed once the database answers.
+	result := make(chan movieResult, 1)
+
+	app.background(func() {
+		movie, err := app.fetchMovie(context.Background(), id, viewer)
+		result <- movieResult{movie, err}
+	})
+
+	select {
+	case res := <-result:
+		if res.err == nil || errors.Is(res.err, data.ErrRecordNotFound) {
+			return res.movie, false, res.err
+		}
+
+		app.logger.PrintError(res.err, map[string]string{"movie_id": strconv.FormatInt(id, 10), "cache": "serving stale"})
+		return cached, true, nil
+	case <-time.After(app.config.movieCache.readTimeout):
+		return cached, true, nil
+	}
+}
+
+// fetchMovie reads the movie from the database for the viewer and updates its cache entry.
+func (app *application) fetchMovie(ctx context.Context, id int64, viewer data.Viewer) (*data.Movie, error) {
+	movie, err := app.models.Movies.WithContext(ctx).Get(id, viewer)
+
+	switch {
+	case err == nil && movie.Visibility == data.VisibilityPublic:
+		app.movieCache.Set(id, movie)
+	case err == nil || errors.Is(err, data.ErrRecordNotFound):
+		app.movieCache.Delete(id)
+	}
+
+	return movie, err
+}
+
+// refreshMovie updates the cache entry for the movie in the background, unless a refresh
+// is already under way.
+func (app *application) refreshMovie(id int64) {
+	if !app.movieCache.StartRefresh(id) {
+		return
+	}
+
+	app.background(func() {
+		defer app.movieCache.EndRefresh(id)
+
+		_, err := app.fetchMovie(context.Background(), id, data.Viewer{All: true})
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			app.logger.PrintError(err, map[string]string{"movie_id": strconv.FormatInt(id, 10)})
+		}
+	})
+}
+
+// invalidateMovie drops the cache entry for a movie which has been created, changed or
+// deleted, along with every cached movie list.
+func (app *application) invalidateMovie(id int64) {
+	if app.movieCache != nil {
+		app.movieCache.Delete(id)
+	}
+
+	app.invalidateMovieLists()
+}
+
+// invalidateMovieLists drops every cached movie list at once by moving on to a new
+// generation. Entries from older generations are never looked up again, and are evicted
+// as the cache fills up or they expire.
+func (app *application) invalidateMovieLists() {
+	app.listGeneration.Add(1)
+}
+
+// movieListKey identifies a page of movies for the viewer in the current generation of the
+// movie list cache. The generation must be read before the movies are, so that a list read
+// while a movie is being written is cached under the generation which is about to end.
+func (app *application) movieListKey(viewer data.Viewer, title string, genres data.GenreFilter, years data.YearRange, filters data.Filters, flatten bool) string {
+	who := "anonymous"
+	switch {
+	case viewer.All:
+		who = "all"
+	case viewer.UserID != 0:
+		who = strconv.FormatInt(viewer.UserID, 10)
+	}
+
+	return fmt.Sprintf("%d|%s|%q|%q|%q|%q|%d|%d|%d|%d|%d|%q|%q|%t", app.listGeneration.Load(), who, title, genres.Genres, genres.Exclude, genres.Mode,
+		years.Exact, years.From, years.To, filters.Page, filters.PageSize, filters.Sort, filters.Cursor, flatten)
+}
+
+// movieListPage is a cached page of listed movies. The movies are kept encoded, and the
+// metadata without the page links, which are built from the URL of each request.
+type movieListPage struct {
+	movies   json.RawMessage
+	metadata data.Metadata
+}
+
+// cachedMovieList returns the page cached under key, if it is still fresh.
+func (app *application) cachedMovieList(key string) (movieListPage, bool) {
+	if app.listCache == nil {
+		return movieListPage{}, false
+	}
+
+	page, _, ok := app.listCache.Get(key)
+	return page, ok
+}
+
+func (app *application) cacheMovieList(key string, page movieListPage) {
+	if app.listCache != nil {
+		app.listCache.Set(key, page)
+	}
+}
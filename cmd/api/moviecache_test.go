@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"greenlight/internal/cache"
+	"greenlight/internal/data"
+	"greenlight/internal/sqlfake"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowMovieDB answers movie lookups with the current title, after release is closed when it
+// is set, or with err.
+type slowMovieDB struct {
+	mu      sync.Mutex
+	title   string
+	err     error
+	release chan struct{}
+	reads   int
+}
+
+func (db *slowMovieDB) handle(query string, args []any) (*sqlfake.Result, error) {
+	if !strings.Contains(query, "FROM movies") || !strings.Contains(query, "id = $1") {
+		return nil, nil
+	}
+
+	db.mu.Lock()
+	release := db.release
+	db.reads++
+	db.mu.Unlock()
+
+	if release != nil {
+		<-release
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.err != nil {
+		return nil, db.err
+	}
+
+	return &sqlfake.Result{Rows: [][]any{{
+		int64(1), testEpoch, testEpoch, db.title, "alien", int64(1979), int64(117), "{Horror}", int64(1), "public", int64(0), float64(0),
+	}}}, nil
+}
+
+func (db *slowMovieDB) set(title string, err error, release chan struct{}) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.title, db.err, db.release = title, err, release
+}
+
+func TestShowMovieServesStaleEntries(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        string
+		slow        bool
+		err         error
+		wantTitle   string
+		wantStale   bool
+		wantRefresh bool
+	}{
+		{"stale-if-error with a slow database", staleIfError, true, nil, "Alien", true, true},
+		{"stale-if-error with a failing database", staleIfError, false, errors.New("connection reset"), "Alien", true, false},
+		{"stale-if-error with a healthy database", staleIfError, false, nil, "Aliens", false, true},
+		{"stale-while-revalidate", staleWhileRevalidate, false, nil, "Alien", true, true},
+		{"off", staleOff, false, nil, "Aliens", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, clk := newConfiguredTestApplication(t, map[string]string{
+				"MOVIE_CACHE_TTL":          "1m",
+				"MOVIE_CACHE_STALE":        tt.mode,
+				"MOVIE_CACHE_READ_TIMEOUT": "20ms",
+			})
+			app.movieCache = cache.New[int64, *data.Movie](app.config.movieCache.maxEntries, app.config.movieCache.ttl+app.config.movieCache.staleTTL, clk)
+
+			db := &slowMovieDB{title: "Alien"}
+			useTestDB(t, app, clk, db.handle)
+
+			show := func() (string, string) {
+				r := withParams(asUser(app, httptest.NewRequest(http.MethodGet, "/v1/movies/1", nil), testUser), "id", "1")
+				rr := serve(t, http.HandlerFunc(app.showMovieHandler), r)
+				if rr.Code != http.StatusOK {
+					t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+				}
+
+				var body struct {
+					Movie data.Movie `json:"movie"`
+				}
+				if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+					t.Fatal(err)
+				}
+
+				return body.Movie.Title, rr.Header().Get("Warning")
+			}
+
+			show()
+
+			// Within the TTL the cached movie is served without reading the database.
+			db.set("Aliens", nil, nil)
+			if title, warning := show(); title != "Alien" || warning != "" || db.reads != 1 {
+				t.Fatalf("fresh: got %q with warning %q after %d reads; want the cached movie", title, warning, db.reads)
+			}
+
+			clk.Advance(2 * time.Minute)
+
+			var release chan struct{}
+			if tt.slow {
+				release = make(chan struct{})
+			}
+			db.set("Aliens", tt.err, release)
+
+			title, warning := show()
+			if title != tt.wantTitle {
+				t.Errorf("expired: got title %q; want %q", title, tt.wantTitle)
+			}
+			if stale := warning == staleWarning; stale != tt.wantStale {
+				t.Errorf("expired: got warning %q; want stale %t", warning, tt.wantStale)
+			}
+
+			if release != nil {
+				close(release)
+			}
+			app.wg.Wait()
+
+			cached, _, ok := app.movieCache.Get(1)
+			if refreshed := ok && cached.Title == "Aliens"; refreshed != tt.wantRefresh {
+				t.Errorf("got cached movie %+v; want refreshed %t", cached, tt.wantRefresh)
+			}
+		})
+	}
+}
+
+// cachedCatalog answers the queries about a single movie from memory, counting the lists
+// read from the database.
+type cachedCatalog struct {
+	mu      sync.Mutex
+	title   string
+	version int64
+	deleted bool
+	lists   int
+}
+
+func (c *cachedCatalog) handle(query string, args []any) (*sqlfake.Result, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	movie := &data.Movie{ID: 1, Title: c.title, Slug: "alien", Year: 1979, Runtime: 117, Genres: []string{"Horror"}, Version: int32(c.version)}
+
+	switch {
+	case strings.Contains(query, "WITH updated AS"):
+		c.title = args[0].(string)
+		c.version++
+		return &sqlfake.Result{Rows: [][]any{{c.version, testEpoch}}}, nil
+
+	case strings.Contains(query, "SET deleted_at"):
+		c.deleted = true
+		return &sqlfake.Result{RowsAffected: 1}, nil
+
+	case strings.Contains(query, "DELETE FROM movies"):
+		c.deleted = true
+		return &sqlfake.Result{Rows: [][]any{{false}}}, nil
+
+	case strings.Contains(query, "count(id) OVER()"):
+		c.lists++
+		if c.deleted {
+			return nil, nil
+		}
+		return listRows(1, movie), nil
+
+	case strings.Contains(query, "FROM movies") && strings.Contains(query, "id = $1") && !c.deleted:
+		return &sqlfake.Result{Rows: [][]any{{
+			int64(1), testEpoch, testEpoch, c.title, "alien", int64(1979), int64(117), "{Horror}", c.version, "public", int64(0), float64(0),
+		}}}, nil
+	}
+
+	return nil, nil
+}
+
+func TestMovieWritesInvalidateCachedResults(t *testing.T) {
+	app, clk := newConfiguredTestApplication(t, map[string]string{
+		"MOVIE_CACHE_TTL":      "1m",
+		"MOVIE_LIST_CACHE_TTL": "1m",
+	})
+	app.movieCache = cache.New[int64, *data.Movie](app.config.movieCache.maxEntries, app.config.movieCache.ttl+app.config.movieCache.staleTTL, clk)
+	app.listCache = cache.New[string, movieListPage](app.config.listCache.maxEntries, app.config.listCache.ttl, clk)
+
+	catalog := &cachedCatalog{title: "Alien", version: 1}
+	useTestDB(t, app, clk, catalog.handle)
+
+	list := func(query string) []string {
+		rr := serve(t, http.HandlerFunc(app.listMoviesHandler), asUser(app, httptest.NewRequest(http.MethodGet, "/v1/movies"+query, nil), testUser))
+
+		var body struct {
+			Movies []data.Movie `json:"movies"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+
+		var titles []string
+		for _, m := range body.Movies {
+			titles = append(titles, m.Title)
+		}
+		return titles
+	}
+
+	show := func() (int, string) {
+		rr := serve(t, http.HandlerFunc(app.showMovieHandler), withParams(asUser(app, httptest.NewRequest(http.MethodGet, "/v1/movies/1", nil), testUser), "id", "1"))
+
+		var body struct {
+			Movie data.Movie `json:"movie"`
+		}
+		_ = json.Unmarshal(rr.Body.Bytes(), &body)
+
+		return rr.Code, body.Movie.Title
+	}
+
+	list("")
+	list("")
+	list("?page_size=5")
+	show()
+
+	if catalog.lists != 2 {
+		t.Fatalf("got %d lists read; want 2, one per distinct query", catalog.lists)
+	}
+
+	r := withParams(asUser(app, httptest.NewRequest(http.MethodPatch, "/v1/movies/1", strings.NewReader(`{"title": "Aliens"}`)), testUser), "id", "1")
+	if rr := serve(t, http.HandlerFunc(app.updateMovieHandler), r); rr.Code != http.StatusOK {
+		t.Fatalf("update: got status %d: %s", rr.Code, rr.Body)
+	}
+
+	if got := list(""); len(got) != 1 || got[0] != "Aliens" {
+		t.Errorf("list after update: got %q; want Aliens", got)
+	}
+	if got := list("?page_size=5"); len(got) != 1 || got[0] != "Aliens" {
+		t.Errorf("other list after update: got %q; want Aliens", got)
+	}
+	if _, title := show(); title != "Aliens" {
+		t.Errorf("show after update: got %q; want Aliens", title)
+	}
+
+	r = withParams(asUser(app, httptest.NewRequest(http.MethodDelete, "/v1/movies/1", nil), testUser), "id", "1")
+	if rr := serve(t, http.HandlerFunc(app.deleteMovieHandler), r); rr.Code != http.StatusOK {
+		t.Fatalf("delete: got status %d: %s", rr.Code, rr.Body)
+	}
+
+	if got := list(""); len(got) != 0 {
+		t.Errorf("list after delete: got %q; want none", got)
+	}
+	if code, _ := show(); code != http.StatusNotFound {
+		t.Errorf("show after delete: got status %d; want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestMovieListCacheCanBeDisabled(t *testing.T) {
+	app, clk := newConfiguredTestApplication(t, nil)
+
+	catalog := &cachedCatalog{title: "Alien", version: 1}
+	useTestDB(t, app, clk, catalog.handle)
+
+	for i := 0; i < 3; i++ {
+		serve(t, http.HandlerFunc(app.listMoviesHandler), asUser(app, httptest.NewRequest(http.MethodGet, "/v1/movies", nil), testUser))
+	}
+
+	if catalog.lists != 3 {
+		t.Errorf("got %d lists read; want every request to read it", catalog.lists)
+	}
+}
+
+func TestCachedMovieListsLinkToEachRequest(t *testing.T) {
+	app, clk := newConfiguredTestApplication(t, map[string]string{"MOVIE_LIST_CACHE_TTL": "1m", "PAGINATION_LINKS": "true"})
+	app.listCache = cache.New[string, movieListPage](app.config.listCache.maxEntries, app.config.listCache.ttl, clk)
+
+	catalog := &cachedCatalog{title: "Alien", version: 1}
+	useTestDB(t, app, clk, catalog.handle)
+
+	firstLink := func(host, query string) string {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodGet, "/v1/movies"+query, nil)
+		r.Host = host
+
+		rr := serve(t, http.HandlerFunc(app.listMoviesHandler), asUser(app, r, testUser))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+		}
+
+		var body struct {
+			Metadata struct {
+				First string `json:"first"`
+			} `json:"metadata"`
+		}
+
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+
+		return body.Metadata.First
+	}
+
+	// A forged host and unrelated parameters must not reach the links served to others.
+	if got := firstLink("evil.example", "?sort=title&utm=x"); got != "http://evil.example/v1/movies?page=1&sort=title&utm=x" {
+		t.Errorf("got first link %q for the forged request", got)
+	}
+	if got := firstLink("api.example.com", "?sort=title"); got != "http://api.example.com/v1/movies?page=1&sort=title" {
+		t.Errorf("got first link %q; want one to the host of the request", got)
+	}
+
+	if catalog.lists != 1 {
+		t.Errorf("got %d lists read; want the requests to share the cached page", catalog.lists)
+	}
+}
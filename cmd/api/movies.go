@@ -1,11 +1,16 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"greenlight/internal/data"
 	"greenlight/internal/validator"
 	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
 )
 
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
@@ -22,30 +27,69 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	taxonomy, err := app.genreTaxonomy()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
 	movie := &data.Movie{
 		Title:   input.Title,
 		Year:    input.Year,
 		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		Genres:  app.normalizeGenres(v, taxonomy, input.Genres),
+		OwnerID: app.contextGetUser(r).ID,
 	}
 
-	v := validator.New()
+	enrich := app.readBool(r.URL.Query(), "enrich", false, v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var source *data.MetadataSource
+	if enrich {
+		source = app.enrichMovie(r.Context(), movie, taxonomy)
+	}
+
+	minYear, maxYear := app.movieYearBounds()
 
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if data.ValidateMovie(v, movie, minYear, maxYear); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Movies.Insert(movie)
+	err = app.models.Movies.WithContext(r.Context()).Insert(movie)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	env := envelope{"movie": movie}
+
+	if source != nil {
+		source.MovieID = movie.ID
+
+		err = app.models.MetadataSources.Insert(source)
+		if err != nil {
+			app.logError(r, err)
+		} else {
+			env["metadata_source"] = source
+		}
+	}
+
+	totalMoviesCreated.Add(1)
+	app.invalidateMovie(movie.ID)
+
+	app.movieChanged(data.EventMovieCreated, movie)
+
 	headers := make(http.Header)
-	headers.Set("location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("Location", app.absoluteURL(r, fmt.Sprintf("/v1/movies/%d", movie.ID)))
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
+	err = app.writeResponse(w, r, http.StatusCreated, env, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -58,7 +102,13 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	viewer, err := app.movieViewer(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	movie, stale, err := app.getMovie(r.Context(), id, viewer)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -69,28 +119,92 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	headers := make(http.Header)
+	if stale {
+		headers.Set("Warning", staleWarning)
+	}
+
+	if app.config.popularity.countOnGet {
+		app.recordView(movie.ID)
+	}
+
+	etag := movieETag(movie)
+	headers.Set("ETag", etag)
+
+	if notModified(r, etag) {
+		for key, value := range headers {
+			w.Header()[key] = value
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	movie = app.sanitizeMovie(r, movie)
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := app.readIDParam(r)
-	if err != nil {
+// showMovieBySlugHandler returns the movie with the given slug. An old slug of a renamed
+// movie is permanently redirected to its current one.
+func (app *application) showMovieBySlugHandler(w http.ResponseWriter, r *http.Request) {
+	slug := httprouter.ParamsFromContext(r.Context()).ByName("slug")
+
+	if !validator.Matches(slug, data.SlugRX) {
 		app.notFoundResponse(w, r)
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	viewer, err := app.movieViewer(r)
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
-		default:
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	movie, err := app.models.Movies.WithContext(r.Context()).GetBySlug(slug, viewer)
+	if err != nil {
+		if !errors.Is(err, data.ErrRecordNotFound) {
 			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		current, err := app.models.Movies.WithContext(r.Context()).GetSlugAlias(slug)
+		if err == nil && !viewer.All {
+			// Only redirect to movies the user may see, so that the alias does not
+			// reveal that the movie exists.
+			_, err = app.models.Movies.WithContext(r.Context()).GetBySlug(current, viewer)
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
 		}
 
+		http.Redirect(w, r, app.absoluteURL(r, "/v1/movies-by-slug/"+current), http.StatusMovedPermanently)
+		return
+	}
+
+	if app.config.popularity.countOnGet {
+		app.recordView(movie.ID)
+	}
+
+	movie = app.sanitizeMovie(r, movie)
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Request) {
+	movie := app.visibleMovie(w, r)
+	if movie == nil {
 		return
 	}
 
@@ -99,14 +213,45 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		Year    *int32        `json:"year"`
 		Runtime *data.Runtime `json:"runtime"`
 		Genres  []string      `json:"genres"`
+		Version *int32        `json:"version"`
 	}
 
-	err = app.readJSON(w, r, &input)
+	err := app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
 
+	// The expected version may be sent in the body, or as the ETag of the movie in If-Match.
+	// A mismatch with the body is an edit conflict, while one with the header fails the
+	// precondition, as RFC 9110 requires.
+	ifMatch := r.Header.Get("If-Match")
+
+	if ifMatch != "" {
+		versions, ok := etagVersions(ifMatch, movie.ID)
+		if !ok {
+			app.preconditionFailedResponse(w, r)
+			return
+		}
+
+		if input.Version != nil && versions != nil && !slices.Contains(versions, *input.Version) {
+			v := validator.New()
+			v.AddError("version", "does not match the version in the If-Match header")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		if versions != nil && !slices.Contains(versions, movie.Version) {
+			app.preconditionFailedResponse(w, r)
+			return
+		}
+	}
+
+	if input.Version != nil && *input.Version != movie.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
 	if input.Title != nil {
 		movie.Title = *input.Title
 	}
@@ -116,20 +261,31 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	if input.Runtime != nil {
 		movie.Runtime = *input.Runtime
 	}
+
+	v := validator.New()
+
 	if input.Genres != nil {
-		movie.Genres = input.Genres
+		taxonomy, err := app.genreTaxonomy()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		movie.Genres = app.normalizeGenres(v, taxonomy, input.Genres)
 	}
 
-	v := validator.New()
+	minYear, maxYear := app.movieYearBounds()
 
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if data.ValidateMovie(v, movie, minYear, maxYear); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Movies.Update(movie)
+	err = app.models.Movies.WithContext(r.Context()).Update(movie)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrEditConflict) && ifMatch != "":
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
 		default:
@@ -138,20 +294,131 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	totalMoviesUpdated.Add(1)
+	app.invalidateMovie(movie.ID)
+
+	app.movieChanged(data.EventMovieUpdated, movie)
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// movieETag returns the entity tag of the movie. The version changes on every update, but
+// the popularity changes with views without the version being incremented, so both are
+// part of the tag along with the ID.
+func movieETag(movie *data.Movie) string {
+	return fmt.Sprintf(`W/"%d-%d-%d"`, movie.ID, movie.Version, movie.Popularity)
+}
+
+// etagVersions returns the versions of the movie with the given ID named by the entity tags
+// in an If-Match header. It returns nil and true for *, which matches any version, and false
+// when no tag belongs to the movie. Weak tags are accepted, unlike the strong comparison
+// RFC 9110 asks for, because movieETag only issues weak ones and the version alone decides
+// whether an update is based on the current movie. The popularity in a tag is ignored.
+func etagVersions(header string, id int64) ([]int32, bool) {
+	var versions []int32
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return nil, true
+		}
+
+		var tagID, popularity int64
+		var version int32
+
+		_, err := fmt.Sscanf(strings.TrimPrefix(tag, "W/"), `"%d-%d-%d"`, &tagID, &version, &popularity)
+		if err == nil && tagID == id {
+			versions = append(versions, version)
+		}
+	}
+
+	return versions, versions != nil
+}
+
+// deleteMovieHandler deletes the movie, which is only soft-deleted when soft deletes are
+// enabled. Holders of admin:movies can permanently delete a movie, even one which has been
+// soft-deleted already, with ?purge=true.
 func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	purge := app.readBool(r.URL.Query(), "purge", false, v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if purge {
+		app.purgeMovie(w, r)
+		return
+	}
+
+	movie := app.visibleMovie(w, r)
+	if movie == nil {
+		return
+	}
+
+	id := movie.ID
+
+	err := app.models.Movies.WithContext(r.Context()).Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	totalMoviesDeleted.Add(1)
+	app.invalidateMovie(id)
+
+	app.movieChanged(data.EventMovieDeleted, movie)
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// movieYearBounds returns the configured range of accepted movie years, resolved against
+// the current time.
+func (app *application) movieYearBounds() (int32, int32) {
+	now := app.clock.Now()
+	return app.config.movies.yearMin.Resolve(now), app.config.movies.yearMax.Resolve(now)
+}
+
+// movieSortSafeList holds the sort values accepted by the movie list endpoints.
+// Sorting by relevance needs a title to search for.
+var movieSortSafeList = []string{"id", "title", "year", "runtime", "popularity", "relevance", "-id", "-title", "-year", "-runtime", "-popularity", "-relevance"}
+
+func (app *application) purgeMovie(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	permissions, err := app.models.Permissions.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !permissions.Include("admin:movies") {
+		app.recordDenial(r, "admin:movies", data.DenialMissingPermission)
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	alreadyDeleted, err := app.models.Movies.WithContext(r.Context()).Purge(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -162,7 +429,50 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+	app.invalidateMovie(id)
+
+	// A soft-deleted movie was counted and announced as deleted when it was soft-deleted.
+	if !alreadyDeleted {
+		totalMoviesDeleted.Add(1)
+		app.movieChanged(data.EventMovieDeleted, &data.Movie{ID: id})
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "movie permanently deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// restoreMovieHandler reinstates a soft-deleted movie.
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	viewer, err := app.movieViewer(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	movie, err := app.models.Movies.WithContext(r.Context()).Restore(id, viewer)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.invalidateMovie(movie.ID)
+
+	app.movieChanged(data.EventMovieRestored, movie)
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -171,7 +481,8 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Title  string
-		Genres []string
+		Genres data.GenreFilter
+		Years  data.YearRange
 		data.Filters
 	}
 
@@ -180,26 +491,123 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	qs := r.URL.Query()
 
 	input.Title = app.readString(qs, "title", "")
-	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.Genres.Genres = app.readCSV(qs, "genres", []string{})
+	input.Genres.Exclude = app.readCSV(qs, "exclude_genres", []string{})
+	input.Genres.Mode = app.readString(qs, "genres_mode", data.GenresModeAll)
+
+	input.Years.Exact = app.readInt(qs, "year", 0, v)
+	input.Years.From = app.readInt(qs, "year_from", 0, v)
+	input.Years.To = app.readInt(qs, "year_to", 0, v)
 
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 
 	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafeList = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Filters.SortSafeList = movieSortSafeList
+
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+
+	app.listMovies(w, r, v, input.Title, input.Genres, input.Years, input.Filters)
+}
+
+// listMovies validates the filters and writes a page of matching movies. It is shared by
+// every endpoint that returns a movie list so that they all behave the same way.
+func (app *application) listMovies(w http.ResponseWriter, r *http.Request, v *validator.Validator, title string, genres data.GenreFilter, years data.YearRange, filters data.Filters) {
+	filters.MaxOffset = app.config.pagination.maxOffset
+
+	flatten := app.readBool(r.URL.Query(), "flatten", false, v)
+
+	data.ValidateGenreFilter(v, genres)
+	data.ValidateYearRange(v, years)
+	data.ValidateMovieSearch(v, title, filters)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if filters.DeepOffset() && app.config.pagination.rejectDeepOffset {
+		app.deepOffsetResponse(w, r)
+		return
+	}
+
+	viewer, err := app.movieViewer(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	key := app.movieListKey(viewer, title, genres, years, filters, flatten)
+
+	page, ok := app.cachedMovieList(key)
+	if !ok {
+		movies, metadata, err := app.models.Movies.WithContext(r.Context()).GetAll(title, genres, years, filters, viewer)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		for i := range movies {
+			movies[i] = app.sanitizeMovie(r, movies[i])
+		}
+
+		var records any = movies
+		if flatten {
+			records, err = app.flattenAll(movies)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		page.metadata = metadata
+		page.movies, err = json.Marshal(records)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.cacheMovieList(key, page)
+	}
 
-	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+	// The page links repeat the URL of the request, so they are added to the cached page
+	// for each request rather than cached with it.
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movies": page.movies, "metadata": app.paginationMetadata(r, page.metadata)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) fixMovieGenresHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	dryRun := app.readBool(r.URL.Query(), "dry_run", false, v)
+
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	fixes, err := app.models.Movies.WithContext(r.Context()).TrimGenres(dryRun)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	if !dryRun {
+		for _, fix := range fixes {
+			app.invalidateMovie(fix.ID)
+		}
+	}
+
+	env := envelope{
+		"dry_run":    dryRun,
+		"max_genres": data.MaxGenres,
+		"fixed":      len(fixes),
+		"movies":     fixes,
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
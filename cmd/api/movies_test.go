@@ -0,0 +1,894 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"greenlight/internal/data"
+	"greenlight/internal/sqlfake"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testUser is an activated user holding every permission the movie handlers need.
+var testUser = &data.User{ID: 1, Name: "Alice", Email: "alice@example.com", Activated: true}
+
+// movieRow answers the RETURNING clause of a movie insert.
+func movieRow() *sqlfake.Result {
+	return &sqlfake.Result{Rows: [][]any{{int64(1), testEpoch, testEpoch, int64(1), "public"}}}
+}
+
+func TestCreateMovieStoresNormalizedGenres(t *testing.T) {
+	tests := []struct {
+		casing string
+		want   []string
+	}{
+		{data.GenreCasingTitle, []string{"Drama", "Science Fiction"}},
+		{data.GenreCasingLower, []string{"drama", "science fiction"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.casing, func(t *testing.T) {
+			app, clk := newConfiguredTestApplication(t, map[string]string{"GENRES_CASING": tt.casing})
+
+			var stored []string
+
+			useTestDB(t, app, clk, func(query string, args []any) (*sqlfake.Result, error) {
+				if strings.Contains(query, "INSERT INTO movies") {
+					stored = args[3].([]string)
+					return movieRow(), nil
+				}
+				return nil, nil
+			})
+
+			body := `{"title": "Arrival", "year": 2016, "runtime": "116 mins", "genres": ["Drama", "drama", " Drama ", "science  fiction"]}`
+			r := asUser(app, httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(body)), testUser)
+
+			rr := serve(t, http.HandlerFunc(app.createMovieHandler), r)
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusCreated, rr.Body)
+			}
+
+			if !slices.Equal(stored, tt.want) {
+				t.Errorf("stored genres %q; want %q", stored, tt.want)
+			}
+		})
+	}
+}
+
+// listRows answers a GetAll query with the movies, out of total matching ones.
+func listRows(total int, movies ...*data.Movie) *sqlfake.Result {
+	res := &sqlfake.Result{}
+
+	for _, m := range movies {
+		res.Rows = append(res.Rows, []any{
+			int64(total), m.ID, testEpoch, testEpoch, m.Title, m.Slug, int64(m.Year), int64(m.Runtime),
+			"{" + strings.Join(m.Genres, ",") + "}", int64(m.Version), "public", int64(0), m.Popularity, float64(0),
+		})
+	}
+
+	return res
+}
+
+func TestListMoviesSteersDeepPagesToCursors(t *testing.T) {
+	tests := []struct {
+		name       string
+		reject     string
+		wantStatus int
+	}{
+		{"hint", "false", http.StatusOK},
+		{"reject", "true", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, clk := newConfiguredTestApplication(t, map[string]string{
+				"PAGINATION_MAX_OFFSET":         "1000",
+				"PAGINATION_REJECT_DEEP_OFFSET": tt.reject,
+			})
+
+			var offset int
+
+			useTestDB(t, app, clk, func(query string, args []any) (*sqlfake.Result, error) {
+				offset = args[3].(int)
+				return listRows(5000, &data.Movie{ID: 2001, Title: "Deep", Slug: "deep", Year: 2000, Runtime: 90, Genres: []string{"Drama"}, Version: 1}), nil
+			})
+
+			get := func(url string) map[string]any {
+				r := asUser(app, httptest.NewRequest(http.MethodGet, url, nil), testUser)
+				rr := serve(t, http.HandlerFunc(app.listMoviesHandler), r)
+
+				var body map[string]any
+				if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+					t.Fatal(err)
+				}
+				body["status"] = rr.Code
+				return body
+			}
+
+			// A page within the threshold is served as usual.
+			body := get("/v1/movies?page=50&page_size=20")
+			if body["status"] != http.StatusOK {
+				t.Fatalf("shallow page: got status %v", body["status"])
+			}
+			if hint := body["metadata"].(map[string]any)["hint"]; hint != nil {
+				t.Errorf("shallow page: got hint %v", hint)
+			}
+
+			body = get("/v1/movies?page=101&page_size=20")
+			if body["status"] != tt.wantStatus {
+				t.Fatalf("deep page: got status %v; want %d", body["status"], tt.wantStatus)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				if body["code"] != errCodeDeepOffset {
+					t.Errorf("deep page: got code %v; want %s", body["code"], errCodeDeepOffset)
+				}
+				return
+			}
+
+			metadata := body["metadata"].(map[string]any)
+			if metadata["hint"] == nil || metadata["next_cursor"] == nil {
+				t.Errorf("deep page: got metadata %v; want a hint and next_cursor", metadata)
+			}
+			if offset != 2000 {
+				t.Errorf("deep page: queried offset %d; want 2000", offset)
+			}
+
+			// Following the cursor is never steered, however deep it is.
+			body = get("/v1/movies?page_size=20&cursor=" + metadata["next_cursor"].(string))
+			if body["status"] != http.StatusOK || body["metadata"].(map[string]any)["hint"] != nil {
+				t.Errorf("cursor page: got status %v and metadata %v", body["status"], body["metadata"])
+			}
+		})
+	}
+}
+
+func TestOversizedLegacyGenres(t *testing.T) {
+	app, clk := newConfiguredTestApplication(t, nil)
+
+	// The movie was stored before genres were capped at data.MaxGenres.
+	genres := []string{"Action", "Adventure", "Comedy", "Drama", "Fantasy", "Horror", "Mystery"}
+	version := int64(1)
+
+	var updated []string
+
+	useTestDB(t, app, clk, func(query string, args []any) (*sqlfake.Result, error) {
+		switch {
+		case strings.Contains(query, "cardinality(genres)"):
+			old := "{" + strings.Join(genres, ",") + "}"
+			genres = genres[:data.MaxGenres]
+			version++
+			return &sqlfake.Result{Rows: [][]any{{int64(1), old, "{" + strings.Join(genres, ",") + "}"}}}, nil
+		case strings.Contains(query, "WITH updated AS"):
+			updated = args[3].([]string)
+			return &sqlfake.Result{Rows: [][]any{{version + 1, testEpoch}}}, nil
+		case strings.Contains(query, "FROM movies") && strings.Contains(query, "id = $1"):
+			return &sqlfake.Result{Rows: [][]any{{
+				int64(1), testEpoch, testEpoch, "Legacy", "legacy", int64(1999), int64(100),
+				"{" + strings.Join(genres, ",") + "}", version, "public", int64(0), float64(0),
+			}}}, nil
+		}
+		return nil, nil
+	})
+
+	show := func() []string {
+		rr := serve(t, http.HandlerFunc(app.showMovieHandler), withParams(httptest.NewRequest(http.MethodGet, "/v1/movies/1", nil), "id", "1"))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("show: got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+		}
+
+		var body struct {
+			Movie data.Movie `json:"movie"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+
+		return body.Movie.Genres
+	}
+
+	update := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPatch, "/v1/movies/1", strings.NewReader(`{"year": 2000}`))
+		return serve(t, http.HandlerFunc(app.updateMovieHandler), withParams(asUser(app, r, testUser), "id", "1"))
+	}
+
+	if got := show(); len(got) != 7 {
+		t.Errorf("show: got %d genres; want all 7 stored", len(got))
+	}
+
+	rr := update()
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), `"genres"`) {
+		t.Fatalf("update before the fix: got status %d: %s; want the genres rejected", rr.Code, rr.Body)
+	}
+
+	rr = serve(t, http.HandlerFunc(app.fixMovieGenresHandler), asUser(app, httptest.NewRequest(http.MethodPost, "/v1/admin/movies/fix-genres", nil), testUser))
+
+	var report struct {
+		Fixed  int             `json:"fixed"`
+		Movies []data.GenreFix `json:"movies"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Fixed != 1 || !slices.Equal(report.Movies[0].Removed, []string{"Horror", "Mystery"}) {
+		t.Errorf("fix: got report %+v", report)
+	}
+
+	if got := show(); len(got) != data.MaxGenres {
+		t.Errorf("show after the fix: got %d genres; want %d", len(got), data.MaxGenres)
+	}
+
+	if rr := update(); rr.Code != http.StatusOK || len(updated) != data.MaxGenres {
+		t.Errorf("update after the fix: got status %d, stored %q: %s", rr.Code, updated, rr.Body)
+	}
+}
+
+func TestCreateMovieYearBounds(t *testing.T) {
+	tests := []struct {
+		name       string
+		yearMax    string
+		wantStatus int
+	}{
+		{"default", "", http.StatusUnprocessableEntity},
+		{"raised", "current+2", http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			overrides := map[string]string{}
+			if tt.yearMax != "" {
+				overrides["MOVIE_YEAR_MAX"] = tt.yearMax
+			}
+
+			app, clk := newConfiguredTestApplication(t, overrides)
+
+			useTestDB(t, app, clk, func(query string, args []any) (*sqlfake.Result, error) {
+				if strings.Contains(query, "INSERT INTO movies") {
+					return movieRow(), nil
+				}
+				return nil, nil
+			})
+
+			body := fmt.Sprintf(`{"title": "Announced", "year": %d, "runtime": "100 mins", "genres": ["Drama"]}`, clk.Now().Year()+1)
+			r := asUser(app, httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(body)), testUser)
+
+			if rr := serve(t, http.HandlerFunc(app.createMovieHandler), r); rr.Code != tt.wantStatus {
+				t.Errorf("got status %d; want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+		})
+	}
+}
+
+func TestListMoviesGuardsTheResponseSize(t *testing.T) {
+	tests := []struct {
+		name       string
+		movies     int
+		wantStatus int
+	}{
+		{"normal page", 2, http.StatusOK},
+		{"huge page", 100, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, clk := newConfiguredTestApplication(t, map[string]string{"RESPONSE_MAX_BYTES": "4096"})
+
+			var movies []*data.Movie
+			for i := range tt.movies {
+				movies = append(movies, &data.Movie{ID: int64(i + 1), Title: strings.Repeat("Long title ", 5), Slug: "long-title", Year: 2000, Runtime: 90, Genres: []string{"Drama"}, Version: 1})
+			}
+
+			useTestDB(t, app, clk, func(query string, args []any) (*sqlfake.Result, error) {
+				return listRows(tt.movies, movies...), nil
+			})
+
+			rr := serve(t, http.HandlerFunc(app.listMoviesHandler), asUser(app, httptest.NewRequest(http.MethodGet, "/v1/movies?page_size=100", nil), testUser))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d; want %d", rr.Code, tt.wantStatus)
+			}
+
+			if tt.wantStatus == http.StatusRequestEntityTooLarge && !strings.Contains(rr.Body.String(), errCodeResponseTooLarge) {
+				t.Errorf("got body %s; want the %s code", rr.Body, errCodeResponseTooLarge)
+			}
+		})
+	}
+}
+
+// deletableMovie answers the queries for a single movie, with id 1, from memory, keeping
+// it apart once soft-deleted as the deleted_at conditions do.
+type deletableMovie struct {
+	mu        sync.Mutex
+	version   int64
+	deleted   bool
+	deletedAt time.Time
+	purged    bool
+}
+
+func (m *deletableMovie) row() []any {
+	return []any{int64(1), testEpoch, testEpoch, "Heat", "heat", int64(1995), int64(170), "{Crime}", m.version, "public", int64(0), int64(0)}
+}
+
+func (m *deletableMovie) handle(query string, args []any) (*sqlfake.Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case strings.Contains(query, "FROM permissions"):
+		if args[0] == int64(9) {
+			return &sqlfake.Result{Rows: [][]any{{"movies:write"}, {"admin:movies"}}}, nil
+		}
+		return &sqlfake.Result{Rows: [][]any{{"movies:write"}}}, nil
+
+	case m.purged || len(args) == 0 || args[0] != int64(1):
+		return nil, nil
+
+	case strings.Contains(query, "DELETE FROM movies"):
+		m.purged = true
+		return &sqlfake.Result{Rows: [][]any{{m.deleted}}}, nil
+
+	case strings.Contains(query, "SET deleted_at = $2"):
+		if m.deleted {
+			return nil, nil
+		}
+		m.deleted = true
+		m.deletedAt = args[1].(time.Time)
+		m.version++
+		return &sqlfake.Result{RowsAffected: 1}, nil
+
+	case strings.Contains(query, "SET deleted_at = NULL"):
+		if !m.deleted {
+			return nil, nil
+		}
+		m.deleted = false
+		m.version++
+		return &sqlfake.Result{Rows: [][]any{m.row()}}, nil
+
+	case strings.Contains(query, "FROM movies") && !m.deleted:
+		return &sqlfake.Result{Rows: [][]any{m.row()}}, nil
+	}
+
+	return nil, nil
+}
+
+func TestSoftDeletedMovies(t *testing.T) {
+	app, clk := newConfiguredTestApplication(t, map[string]string{"AUDIT_DENIALS": "off"})
+	movie := &deletableMovie{version: 1}
+	useTestDB(t, app, clk, movie.handle)
+	app.models.Movies.SoftDelete = true
+
+	admin := &data.User{ID: 9, Activated: true}
+
+	call := func(h http.HandlerFunc, method, target string, user *data.User) *httptest.ResponseRecorder {
+		t.Helper()
+		return serve(t, h, withParams(asUser(app, httptest.NewRequest(method, target, nil), user), "id", "1"))
+	}
+
+	shown := func() int {
+		t.Helper()
+		return call(app.showMovieHandler, http.MethodGet, "/v1/movies/1", testUser).Code
+	}
+
+	events := app.movieEvents.Subscribe(8)
+	defer events.Close()
+
+	published := func() []string {
+		var types []string
+		for len(events.C) > 0 {
+			types = append(types, (<-events.C).Type)
+		}
+		return types
+	}
+
+	deleted := totalMoviesDeleted.Value()
+
+	clk.Advance(time.Hour)
+
+	if rr := call(app.deleteMovieHandler, http.MethodDelete, "/v1/movies/1", testUser); rr.Code != http.StatusOK {
+		t.Fatalf("delete: got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	if movie.purged {
+		t.Fatal("the movie was purged; want it soft-deleted")
+	}
+	if want := testEpoch.Add(time.Hour); !movie.deletedAt.Equal(want) {
+		t.Errorf("got deleted_at %v; want the clock's %v", movie.deletedAt, want)
+	}
+	if got := shown(); got != http.StatusNotFound {
+		t.Errorf("after deleting: got status %d; want %d", got, http.StatusNotFound)
+	}
+	if rr := call(app.deleteMovieHandler, http.MethodDelete, "/v1/movies/1", testUser); rr.Code != http.StatusNotFound {
+		t.Errorf("second delete: got status %d; want %d", rr.Code, http.StatusNotFound)
+	}
+
+	rr := call(app.restoreMovieHandler, http.MethodPost, "/v1/movies/1/restore", testUser)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("restore: got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	var body struct {
+		Movie data.Movie `json:"movie"`
+	}
+
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	// Deleting and restoring both bumped the version, so edits made before are stale.
+	if body.Movie.Version != 3 {
+		t.Errorf("got version %d after restoring; want 3", body.Movie.Version)
+	}
+	if got := shown(); got != http.StatusOK {
+		t.Errorf("after restoring: got status %d; want %d", got, http.StatusOK)
+	}
+
+	r := withParams(asUser(app, httptest.NewRequest(http.MethodPatch, "/v1/movies/1", strings.NewReader(`{"title": "Heat (1995)", "version": 1}`)), testUser), "id", "1")
+	if rr := serve(t, http.HandlerFunc(app.updateMovieHandler), r); rr.Code != http.StatusConflict {
+		t.Errorf("stale update: got status %d; want %d", rr.Code, http.StatusConflict)
+	}
+
+	// Purging a soft-deleted movie neither counts nor announces its deletion a second time.
+	if rr := call(app.deleteMovieHandler, http.MethodDelete, "/v1/movies/1", testUser); rr.Code != http.StatusOK {
+		t.Fatalf("delete again: got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	if rr := call(app.deleteMovieHandler, http.MethodDelete, "/v1/movies/1?purge=true", testUser); rr.Code != http.StatusForbidden || movie.purged {
+		t.Errorf("purge without admin:movies: got status %d; want %d", rr.Code, http.StatusForbidden)
+	}
+	if rr := call(app.deleteMovieHandler, http.MethodDelete, "/v1/movies/1?purge=true", admin); rr.Code != http.StatusOK || !movie.purged {
+		t.Errorf("purge: got status %d; want %d and the movie purged", rr.Code, http.StatusOK)
+	}
+	if rr := call(app.restoreMovieHandler, http.MethodPost, "/v1/movies/1/restore", testUser); rr.Code != http.StatusNotFound {
+		t.Errorf("restore after purging: got status %d; want %d", rr.Code, http.StatusNotFound)
+	}
+
+	want := []string{data.EventMovieDeleted, data.EventMovieRestored, data.EventMovieDeleted}
+	if got := published(); !slices.Equal(got, want) {
+		t.Errorf("got events %v; want %v", got, want)
+	}
+	if got := totalMoviesDeleted.Value() - deleted; got != 2 {
+		t.Errorf("got %d movies deleted; want 2", got)
+	}
+}
+
+// versionedMovie answers the queries for movie 1 from memory, at its current version.
+// While raced is set, every update loses to a concurrent one.
+type versionedMovie struct {
+	mu         sync.Mutex
+	version    int64
+	popularity int64
+	raced      bool
+}
+
+func (m *versionedMovie) handle(query string, args []any) (*sqlfake.Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case strings.Contains(query, "WITH updated AS"):
+		if m.raced || int64(args[5].(int32)) != m.version {
+			return nil, nil
+		}
+		m.version++
+		return &sqlfake.Result{Rows: [][]any{{m.version, testEpoch}}}, nil
+
+	case strings.Contains(query, "FROM movies") && strings.Contains(query, "id = $1"):
+		return &sqlfake.Result{Rows: [][]any{{
+			int64(1), testEpoch, testEpoch, "Heat", "heat", int64(1995), int64(170), "{Crime}", m.version, "public", int64(0), m.popularity,
+		}}}, nil
+	}
+
+	return nil, nil
+}
+
+func TestShowMovieETag(t *testing.T) {
+	tests := []struct {
+		ifNoneMatch string
+		wantStatus  int
+	}{
+		{"", http.StatusOK},
+		{`W/"1-2-7"`, http.StatusNotModified},
+		{`"1-2-7"`, http.StatusNotModified},
+		{`W/"1-1-7", W/"1-2-7"`, http.StatusNotModified},
+		{"*", http.StatusNotModified},
+		{`W/"1-1-7"`, http.StatusOK},
+		{`W/"2-2-7"`, http.StatusOK},
+		// The movie has been viewed since, which changed its popularity but not its version.
+		{`W/"1-2-6"`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ifNoneMatch, func(t *testing.T) {
+			app, clk := newConfiguredTestApplication(t, nil)
+			useTestDB(t, app, clk, (&versionedMovie{version: 2, popularity: 7}).handle)
+
+			r := withParams(httptest.NewRequest(http.MethodGet, "/v1/movies/1", nil), "id", "1")
+			if tt.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+
+			rr := serve(t, http.HandlerFunc(app.showMovieHandler), r)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d; want %d", rr.Code, tt.wantStatus)
+			}
+
+			if got := rr.Header().Get("ETag"); got != `W/"1-2-7"` {
+				t.Errorf(`got ETag %q; want W/"1-2-7"`, got)
+			}
+			if empty := rr.Body.Len() == 0; empty != (tt.wantStatus == http.StatusNotModified) {
+				t.Errorf("got body %q with status %d", rr.Body, rr.Code)
+			}
+		})
+	}
+}
+
+func TestUpdateMovieIfMatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		ifMatch    string
+		body       string
+		raced      bool
+		wantStatus int
+		wantCode   string
+	}{
+		{"current", `W/"1-2-7"`, `{"year": 1996}`, false, http.StatusOK, ""},
+		{"viewed since", `W/"1-2-3"`, `{"year": 1996}`, false, http.StatusOK, ""},
+		{"any", "*", `{"year": 1996}`, false, http.StatusOK, ""},
+		{"one of several", `W/"1-1-7", "1-2-7"`, `{"year": 1996}`, false, http.StatusOK, ""},
+		{"stale", `W/"1-1-7"`, `{"year": 1996}`, false, http.StatusPreconditionFailed, errCodePreconditionFailed},
+		{"another movie", `W/"9-2-7"`, `{"year": 1996}`, false, http.StatusPreconditionFailed, errCodePreconditionFailed},
+		{"lost a race", `W/"1-2-7"`, `{"year": 1996}`, true, http.StatusPreconditionFailed, errCodePreconditionFailed},
+		{"disagrees with the body", `W/"1-2-7"`, `{"year": 1996, "version": 1}`, false, http.StatusUnprocessableEntity, errCodeValidationFailed},
+		{"body only", "", `{"year": 1996, "version": 1}`, false, http.StatusConflict, errCodeEditConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, clk := newConfiguredTestApplication(t, nil)
+			useTestDB(t, app, clk, (&versionedMovie{version: 2, popularity: 7, raced: tt.raced}).handle)
+
+			r := httptest.NewRequest(http.MethodPatch, "/v1/movies/1", strings.NewReader(tt.body))
+			if tt.ifMatch != "" {
+				r.Header.Set("If-Match", tt.ifMatch)
+			}
+
+			rr := serve(t, http.HandlerFunc(app.updateMovieHandler), withParams(asUser(app, r, testUser), "id", "1"))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d; want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+
+			if tt.wantStatus == http.StatusOK {
+				if got := rr.Header().Get("ETag"); got != `W/"1-3-7"` {
+					t.Errorf(`got ETag %q; want W/"1-3-7"`, got)
+				}
+				return
+			}
+
+			var body struct {
+				Code string `json:"code"`
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Code != tt.wantCode {
+				t.Errorf("got code %q; want %q", body.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestListMoviesByRelevance(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantOrder  string
+		wantRank   bool
+	}{
+		{"most relevant first", "?title=the+matrix&sort=-relevance", http.StatusOK, "ORDER BY ts_rank(search_vector, plainto_tsquery('simple', $1)) DESC", true},
+		{"least relevant first", "?title=the+matrix&sort=relevance", http.StatusOK, "ORDER BY ts_rank(search_vector, plainto_tsquery('simple', $1)) ASC", true},
+		{"title without relevance", "?title=the+matrix", http.StatusOK, "ORDER BY id ASC", false},
+		{"relevance without a title", "?sort=-relevance", http.StatusUnprocessableEntity, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, clk := newConfiguredTestApplication(t, nil)
+
+			var query string
+
+			useTestDB(t, app, clk, func(q string, args []any) (*sqlfake.Result, error) {
+				query = q
+
+				res := listRows(1, &data.Movie{ID: 1, Title: "The Matrix", Slug: "the-matrix", Year: 1999, Runtime: 136, Genres: []string{"Action"}, Version: 1})
+				res.Rows[0][len(res.Rows[0])-1] = float64(0.5)
+				return res, nil
+			})
+
+			rr := serve(t, http.HandlerFunc(app.listMoviesHandler), httptest.NewRequest(http.MethodGet, "/v1/movies"+tt.query, nil))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d; want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+
+			var body struct {
+				Movies []data.Movie      `json:"movies"`
+				Error  map[string]string `json:"error"`
+			}
+
+			if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				if body.Error["sort"] != "must not be relevance without a title" {
+					t.Errorf("got errors %v; want sort rejected", body.Error)
+				}
+				return
+			}
+
+			if !strings.Contains(query, tt.wantOrder) {
+				t.Errorf("got query %q; want it to contain %q", query, tt.wantOrder)
+			}
+
+			rank := body.Movies[0].Rank
+			if tt.wantRank && (rank == nil || *rank != 0.5) {
+				t.Errorf("got rank %v; want 0.5", rank)
+			}
+			if !tt.wantRank && rank != nil {
+				t.Errorf("got rank %v; want none outside relevance sorting", *rank)
+			}
+		})
+	}
+}
+
+func TestListMoviesGenreFilters(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		wantStatus  int
+		wantGenres  []string
+		wantAny     bool
+		wantExclude []string
+		wantError   string
+	}{
+		{"none", "", http.StatusOK, []string{}, false, []string{}, ""},
+		{"all", "?genres=Drama,Crime", http.StatusOK, []string{"Drama", "Crime"}, false, []string{}, ""},
+		{"any", "?genres=Drama,Crime&genres_mode=any", http.StatusOK, []string{"Drama", "Crime"}, true, []string{}, ""},
+		{"excluded", "?exclude_genres=Horror", http.StatusOK, []string{}, false, []string{"Horror"}, ""},
+		{"unknown mode", "?genres=Drama&genres_mode=some", http.StatusUnprocessableEntity, nil, false, nil, "genres_mode"},
+		{"included and excluded", "?genres=Drama,Horror&exclude_genres=Horror", http.StatusUnprocessableEntity, nil, false, nil, "exclude_genres"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, clk := newConfiguredTestApplication(t, nil)
+
+			var args []any
+
+			useTestDB(t, app, clk, func(query string, a []any) (*sqlfake.Result, error) {
+				args = a
+				return listRows(0), nil
+			})
+
+			rr := serve(t, http.HandlerFunc(app.listMoviesHandler), httptest.NewRequest(http.MethodGet, "/v1/movies"+tt.query, nil))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d; want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				var body struct {
+					Error map[string]string `json:"error"`
+				}
+
+				if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+					t.Fatal(err)
+				}
+
+				if body.Error[tt.wantError] == "" {
+					t.Errorf("got errors %v; want one for %s", body.Error, tt.wantError)
+				}
+				return
+			}
+
+			if got := args[1].([]string); !slices.Equal(got, tt.wantGenres) {
+				t.Errorf("got genres %q; want %q", got, tt.wantGenres)
+			}
+			if args[7] != tt.wantAny {
+				t.Errorf("got any mode %v; want %t", args[7], tt.wantAny)
+			}
+			// A nil list is sent as NULL, which would exclude every movie.
+			if got := args[8].([]string); got == nil || !slices.Equal(got, tt.wantExclude) {
+				t.Errorf("got excluded genres %#v; want %q", got, tt.wantExclude)
+			}
+		})
+	}
+}
+
+func TestListMoviesYearRange(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantYears  [3]int
+		wantError  string
+	}{
+		{"none", "", http.StatusOK, [3]int{0, 0, 0}, ""},
+		{"exact", "?year=1995", http.StatusOK, [3]int{1995, 0, 0}, ""},
+		{"from", "?year_from=1990", http.StatusOK, [3]int{0, 1990, 0}, ""},
+		{"to", "?year_to=2000", http.StatusOK, [3]int{0, 0, 2000}, ""},
+		{"range", "?year_from=1990&year_to=2000", http.StatusOK, [3]int{0, 1990, 2000}, ""},
+		{"single year range", "?year_from=1995&year_to=1995", http.StatusOK, [3]int{0, 1995, 1995}, ""},
+		{"reversed", "?year_from=2000&year_to=1990", http.StatusUnprocessableEntity, [3]int{}, "year_from"},
+		{"exact and range", "?year=1995&year_from=1990", http.StatusUnprocessableEntity, [3]int{}, "year"},
+		{"negative", "?year_to=-1", http.StatusUnprocessableEntity, [3]int{}, "year_to"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, clk := newConfiguredTestApplication(t, nil)
+
+			var query string
+			var args []any
+
+			useTestDB(t, app, clk, func(q string, a []any) (*sqlfake.Result, error) {
+				query, args = q, a
+				return listRows(0), nil
+			})
+
+			rr := serve(t, http.HandlerFunc(app.listMoviesHandler), httptest.NewRequest(http.MethodGet, "/v1/movies"+tt.query, nil))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d; want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				var body struct {
+					Error map[string]string `json:"error"`
+				}
+
+				if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+					t.Fatal(err)
+				}
+
+				if body.Error[tt.wantError] == "" {
+					t.Errorf("got errors %v; want one for %s", body.Error, tt.wantError)
+				}
+				return
+			}
+
+			if got := [3]int{args[4].(int), args[5].(int), args[6].(int)}; got != tt.wantYears {
+				t.Errorf("got year, year_from and year_to %v; want %v", got, tt.wantYears)
+			}
+
+			// A zero bound is left out of the query rather than compared with the year.
+			for _, guard := range []string{"($5 = 0 OR year = $5)", "($6 = 0 OR year >= $6)", "($7 = 0 OR year <= $7)"} {
+				if !strings.Contains(query, guard) {
+					t.Errorf("got a query without %s", guard)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateMovieRuntimeForms(t *testing.T) {
+	tests := []struct {
+		name       string
+		runtime    string
+		schema     string
+		wantStatus int
+	}{
+		{"minutes", `116`, "false", http.StatusCreated},
+		{"mins string", `"116 mins"`, "false", http.StatusCreated},
+		{"minutes against the schema", `116`, "true", http.StatusCreated},
+		{"hours", `"2 hours"`, "false", http.StatusBadRequest},
+		{"zero", `0`, "false", http.StatusUnprocessableEntity},
+		{"negative", `-116`, "false", http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, clk := newConfiguredTestApplication(t, map[string]string{"JSON_SCHEMA_ENABLED": tt.schema})
+
+			var stored any
+
+			useTestDB(t, app, clk, func(query string, args []any) (*sqlfake.Result, error) {
+				if strings.Contains(query, "INSERT INTO movies") {
+					stored = args[2]
+					return movieRow(), nil
+				}
+				return nil, nil
+			})
+
+			body := `{"title": "Arrival", "year": 2016, "runtime": ` + tt.runtime + `, "genres": ["Drama"]}`
+			r := asUser(app, httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(body)), testUser)
+
+			rr := serve(t, app.validateSchema("create_movie", app.createMovieHandler), r)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d; want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+
+			if tt.wantStatus == http.StatusBadRequest && !strings.Contains(rr.Body.String(), "invalid runtime format") {
+				t.Errorf("got body %s; want the runtime format explained", rr.Body)
+			}
+			if tt.wantStatus == http.StatusCreated && stored != data.Runtime(116) {
+				t.Errorf("stored runtime %v; want 116", stored)
+			}
+		})
+	}
+}
+
+func TestMovieTimestamps(t *testing.T) {
+	app, clk := newConfiguredTestApplication(t, nil)
+
+	updatedAt := testEpoch.Add(time.Hour)
+	var update string
+
+	useTestDB(t, app, clk, func(query string, args []any) (*sqlfake.Result, error) {
+		switch {
+		case strings.Contains(query, "WITH updated AS"):
+			update = query
+			updatedAt = testEpoch.Add(2 * time.Hour)
+			return &sqlfake.Result{Rows: [][]any{{int64(2), updatedAt}}}, nil
+
+		case strings.Contains(query, "count(id) OVER()"):
+			return listRows(1, &data.Movie{ID: 1, Title: "Heat", Slug: "heat", Year: 1995, Runtime: 170, Genres: []string{"Crime"}, Version: 1}), nil
+
+		case strings.Contains(query, "FROM movies"):
+			return &sqlfake.Result{Rows: [][]any{{
+				int64(1), testEpoch, updatedAt, "Heat", "heat", int64(1995), int64(170), "{Crime}", int64(1), "public", int64(0), float64(0),
+			}}}, nil
+		}
+		return nil, nil
+	})
+
+	// timestamps returns the created_at and updated_at of the movie, or of the first of the
+	// movies, in the response.
+	timestamps := func(rr *httptest.ResponseRecorder) (string, string) {
+		t.Helper()
+
+		type movie struct {
+			CreatedAt string `json:"created_at"`
+			UpdatedAt string `json:"updated_at"`
+		}
+
+		var body struct {
+			Movie  movie   `json:"movie"`
+			Movies []movie `json:"movies"`
+		}
+
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(body.Movies) > 0 {
+			body.Movie = body.Movies[0]
+		}
+
+		return body.Movie.CreatedAt, body.Movie.UpdatedAt
+	}
+
+	rr := serve(t, http.HandlerFunc(app.showMovieHandler), withParams(httptest.NewRequest(http.MethodGet, "/v1/movies/1", nil), "id", "1"))
+	if created, updated := timestamps(rr); created != testEpoch.Format(time.RFC3339) || updated != testEpoch.Add(time.Hour).Format(time.RFC3339) {
+		t.Errorf("show: got created_at %q and updated_at %q", created, updated)
+	}
+
+	rr = serve(t, http.HandlerFunc(app.listMoviesHandler), httptest.NewRequest(http.MethodGet, "/v1/movies", nil))
+	if created, updated := timestamps(rr); created != testEpoch.Format(time.RFC3339) || updated != testEpoch.Format(time.RFC3339) {
+		t.Errorf("list: got created_at %q and updated_at %q", created, updated)
+	}
+
+	r := httptest.NewRequest(http.MethodPatch, "/v1/movies/1", strings.NewReader(`{"year": 1996}`))
+	rr = serve(t, http.HandlerFunc(app.updateMovieHandler), withParams(asUser(app, r, testUser), "id", "1"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("update: got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	if !strings.Contains(update, "updated_at = NOW()") {
+		t.Errorf("got update %q; want it to set updated_at", update)
+	}
+	if created, updated := timestamps(rr); created != testEpoch.Format(time.RFC3339) || updated != testEpoch.Add(2*time.Hour).Format(time.RFC3339) {
+		t.Errorf("update: got created_at %q and updated_at %q; want updated_at as returned by the update", created, updated)
+	}
+}
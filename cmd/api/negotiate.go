@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// writeResponse sends data in the format the client prefers according to its Accept
+// header: XML when it asks for application/xml over JSON, and JSON otherwise, including
+// for Accept values the API does not support.
+func (app *application) writeResponse(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	js, err := app.encodeJSON(data)
+	if err != nil {
+		return err
+	}
+
+	app.writeEncoded(w, r, status, js, headers)
+
+	return nil
+}
+
+// writeEncoded sends a response body which has already been encoded by encodeJSON in the
+// format the client prefers. Should the body not convert to XML for a client which asked
+// for it, a 406 is sent in JSON instead.
+func (app *application) writeEncoded(w http.ResponseWriter, r *http.Request, status int, js []byte, headers http.Header) {
+	if !app.config.responses.xml {
+		app.writeJSONBytes(w, status, js, headers)
+		return
+	}
+
+	w.Header().Add("Vary", "Accept")
+
+	if !prefersXML(r) {
+		app.writeJSONBytes(w, status, js, headers)
+		return
+	}
+
+	body, err := jsonToXML(js)
+	if err != nil {
+		app.logError(r, err)
+
+		message := "the response cannot be represented as XML, request it as application/json instead"
+
+		js, err = app.encodeJSON(app.errorEnvelope(r, http.StatusNotAcceptable, errCodeNotAcceptable, message))
+		if err != nil {
+			app.logError(r, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		app.writeJSONBytes(w, http.StatusNotAcceptable, js, nil)
+		return
+	}
+
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// prefersXML reports whether the Accept header of r gives an XML media type a higher
+// quality than JSON. Wildcards count towards JSON, so that only clients which name XML
+// explicitly get it, and ties go to JSON.
+func prefersXML(r *http.Request) bool {
+	var xmlQ, jsonQ float64
+
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		switch mediaType {
+		case "application/xml", "text/xml":
+			xmlQ = max(xmlQ, q)
+		case "application/json", "application/*", "*/*":
+			jsonQ = max(jsonQ, q)
+		}
+	}
+
+	return xmlQ > 0 && xmlQ > jsonQ
+}
+
+// errXMLName is returned by jsonToXML for an object key which is not a valid element name.
+var errXMLName = errors.New("key is not a valid XML element name")
+
+// jsonToXML converts a JSON response body to XML under a <response> root element. Object
+// keys become elements in the order they were encoded, array elements become <item>
+// elements, and null becomes an empty element. Since the XML is derived from the JSON
+// encoding, it uses the same field names and custom marshalers, so runtimes read
+// "107 mins" in both.
+func jsonToXML(js []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "\t")
+
+	err := encodeXMLValue(dec, enc, "response")
+	if err != nil {
+		return nil, err
+	}
+
+	err = enc.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	buf.WriteByte('\n')
+
+	return buf.Bytes(), nil
+}
+
+// encodeXMLValue reads the next JSON value from dec and writes it as an element called name.
+func encodeXMLValue(dec *json.Decoder, enc *xml.Encoder, name string) error {
+	if !validXMLName(name) {
+		return fmt.Errorf("%w: %q", errXMLName, name)
+	}
+
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	err = enc.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+
+	switch token := token.(type) {
+	case json.Delim:
+		for dec.More() {
+			child := "item"
+
+			if token == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				child = key.(string)
+			}
+
+			err = encodeXMLValue(dec, enc, child)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Consume the closing delimiter.
+		_, err = dec.Token()
+		if err != nil {
+			return err
+		}
+	case nil:
+	case string:
+		err = enc.EncodeToken(xml.CharData(token))
+	case json.Number:
+		err = enc.EncodeToken(xml.CharData(token.String()))
+	case bool:
+		err = enc.EncodeToken(xml.CharData(strconv.FormatBool(token)))
+	default:
+		err = fmt.Errorf("unexpected JSON token %v", token)
+	}
+	if err != nil {
+		return err
+	}
+
+	return enc.EncodeToken(start.End())
+}
+
+// validXMLName reports whether name can be used as an element name: it starts with a letter
+// or underscore, continues with letters, digits, underscores, hyphens and dots, and does not
+// start with "xml", which is reserved.
+func validXMLName(name string) bool {
+	if name == "" || strings.HasPrefix(strings.ToLower(name), "xml") {
+		return false
+	}
+
+	for i, c := range name {
+		switch {
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		case i > 0 && (c == '-' || c == '.' || c >= '0' && c <= '9'):
+		default:
+			return false
+		}
+	}
+
+	return true
+}
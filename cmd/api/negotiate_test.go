@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"greenlight/internal/data"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPrefersXML(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"application/xml", true},
+		{"text/xml", true},
+		{"application/json", false},
+		{"*/*", false},
+		{"text/html", false},
+		{"application/xml, application/json", false},
+		{"application/json;q=0.5, application/xml", true},
+		{"application/xml;q=0.9, */*", false},
+		{"application/xml;q=0", false},
+		{"application/xml;q=oops, text/xml;q=0.1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+			r.Header.Set("Accept", tt.accept)
+
+			if got := prefersXML(r); got != tt.want {
+				t.Errorf("got %t; want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONToXML(t *testing.T) {
+	movie := data.Movie{ID: 1, Title: "Heat", Year: 1995, Runtime: 170, Genres: []string{"Crime", "Drama"}, Version: 1}
+
+	js, err := json.Marshal(envelope{"movie": movie, "next": nil, "ok": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := jsonToXML(js)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"<response>",
+		"<id>1</id>",
+		"<title>Heat</title>",
+		"<runtime>170 mins</runtime>",
+		"<genres>\n\t\t\t<item>Crime</item>\n\t\t\t<item>Drama</item>\n\t\t</genres>",
+		"<next></next>",
+		"<ok>true</ok>",
+	} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("got %s; want it to contain %q", got, want)
+		}
+	}
+
+	if _, err := jsonToXML([]byte(`{"1st": true}`)); err == nil {
+		t.Error("got no error for a key which is not an element name")
+	}
+}
+
+func TestWriteResponseNegotiates(t *testing.T) {
+	tests := []struct {
+		name            string
+		enabled         bool
+		accept          string
+		data            envelope
+		wantStatus      int
+		wantContentType string
+	}{
+		{"xml", true, "application/xml", envelope{"title": "Heat"}, http.StatusOK, "application/xml"},
+		{"unsupported", true, "text/html", envelope{"title": "Heat"}, http.StatusOK, "application/json"},
+		{"disabled", false, "application/xml", envelope{"title": "Heat"}, http.StatusOK, "application/json"},
+		{"not representable", true, "application/xml", envelope{"1st": "Heat"}, http.StatusNotAcceptable, "application/json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := newTestApplication(t)
+			app.config.responses.xml = tt.enabled
+
+			r := httptest.NewRequest(http.MethodGet, "/v1/movies/1", nil)
+			r.Header.Set("Accept", tt.accept)
+
+			rr := httptest.NewRecorder()
+			if err := app.writeResponse(rr, r, http.StatusOK, tt.data, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("got status %d; want %d", rr.Code, tt.wantStatus)
+			}
+			if got := rr.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("got Content-Type %q; want %q", got, tt.wantContentType)
+			}
+			if got := rr.Header().Get("Vary"); (got == "Accept") != tt.enabled {
+				t.Errorf("got Vary %q with XML responses enabled %t", got, tt.enabled)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// openAPIHandler serves an OpenAPI description of the API paths and their security
+// requirements, generated from the same route table that enforces them.
+func (app *application) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	paths := make(map[string]map[string]any)
+
+	for _, rt := range app.routeTable() {
+		path := openAPIPath(rt.pattern)
+
+		if paths[path] == nil {
+			paths[path] = make(map[string]any)
+		}
+
+		operation := map[string]any{
+			"security": []map[string][]string{},
+		}
+
+		if rt.policy != policyPublic {
+			operation["security"] = []map[string][]string{{"bearerAuth": {}}}
+		}
+
+		if rt.policy != policyPublic && rt.policy != policyAuthenticated {
+			operation["x-required-permission"] = rt.policy
+		}
+
+		paths[path][strings.ToLower(rt.method)] = operation
+	}
+
+	env := envelope{
+		"openapi": "3.0.3",
+		"info": map[string]string{
+			"title":   "Greenlight API",
+			"version": version,
+		},
+		"components": map[string]any{
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]string{
+					"type":   "http",
+					"scheme": "bearer",
+				},
+			},
+		},
+		"paths": paths,
+	}
+
+	if app.config.errors.catalog {
+		env["components"].(map[string]any)["schemas"] = map[string]any{"Error": app.errorSchema()}
+	}
+
+	err := app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// openAPIPath converts an httprouter pattern such as /v1/movies/:id into the OpenAPI form
+// /v1/movies/{id}.
+func openAPIPath(pattern string) string {
+	segments := strings.Split(pattern, "/")
+
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"greenlight/internal/data"
+	"greenlight/internal/mailer"
+	"strconv"
+	"time"
+)
+
+// enqueueEmail adds an email for the user to the outbox, from which the dispatcher sends it.
+func (app *application) enqueueEmail(userID int64, recipient, templateFile string, data map[string]any) error {
+	return app.models.EmailOutbox.Enqueue(userID, recipient, templateFile, data)
+}
+
+// dispatchOutbox hands the emails in the outbox to the mail queue until ctx is cancelled.
+// Only as many emails are claimed as the queue has room for, up to
+// app.config.outbox.batchSize, so that claimed emails do not wait in the queue long enough
+// for their claim to expire. While the queue stays full the dispatcher backs off for the
+// poll interval, leaving the emails in the outbox. Once ctx is cancelled no new emails are
+// claimed, and those of the current batch which were not queued are returned to the outbox.
+func (app *application) dispatchOutbox(ctx context.Context) {
+	for {
+		size := min(app.config.outbox.batchSize, max(app.mailQueue.Free(), 1))
+
+		emails, err := app.models.EmailOutbox.ClaimBatch(size)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"job": "email_outbox"})
+		}
+
+		if err != nil || len(emails) == 0 || !app.queueEmails(ctx, emails) {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(app.config.outbox.pollInterval):
+				continue
+			}
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// queueEmails adds claimed emails to the mail queue, returning those it does not accept to
+// the outbox. It reports whether every email was queued.
+func (app *application) queueEmails(ctx context.Context, emails []*data.OutboxEmail) bool {
+	var unsent []int64
+
+	for i, email := range emails {
+		err := app.mailQueue.Send(ctx, mailer.Message{
+			Recipient: email.Recipient,
+			Template:  email.Template,
+			Data:      email.Data,
+			Done:      func(err error) { app.outboxEmailSent(email, err) },
+		})
+		if err != nil {
+			if errors.Is(err, mailer.ErrQueueFull) {
+				app.logger.PrintInfo("email queue full", map[string]string{"job": "email_outbox", "capacity": strconv.Itoa(app.config.outbox.queueCapacity)})
+			}
+
+			for _, email := range emails[i:] {
+				unsent = append(unsent, email.ID)
+			}
+			break
+		}
+	}
+
+	if len(unsent) > 0 {
+		err := app.models.EmailOutbox.Release(unsent)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"job": "email_outbox"})
+		}
+	}
+
+	return len(unsent) == 0
+}
+
+// outboxEmailSent records the result of sending an email from the outbox. Emails which the
+// queue was shut down before sending are returned to the outbox.
+func (app *application) outboxEmailSent(email *data.OutboxEmail, sendErr error) {
+	if errors.Is(sendErr, mailer.ErrQueueClosed) {
+		err := app.models.EmailOutbox.Release([]int64{email.ID})
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"job": "email_outbox"})
+		}
+		return
+	}
+
+	if sendErr == nil {
+		err := app.models.EmailOutbox.MarkSent(email.ID)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"job": "email_outbox"})
+		}
+		return
+	}
+
+	// The recipient is left out, as log lines are not encrypted like the outbox.
+	app.logger.PrintError(sendErr, map[string]string{"job": "email_outbox", "template": email.Template, "email_id": strconv.FormatInt(email.ID, 10)})
+
+	failed, attempts, err := app.models.EmailOutbox.MarkFailed(email.ID, sendErr, app.config.outbox.maxAttempts)
+	if err == nil && failed {
+		err = app.deadLetter(&data.DeadLetter{
+			Kind:      data.DeadLetterEmail,
+			SourceID:  email.ID,
+			Target:    email.Template,
+			Attempts:  attempts,
+			LastError: sendErr.Error(),
+		})
+	}
+
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"job": "email_outbox"})
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"greenlight/internal/mailer"
+	"greenlight/internal/sqlfake"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// outboxStore answers the email outbox queries from memory.
+type outboxStore struct {
+	mu      sync.Mutex
+	status  map[int64]string
+	batches []int
+	claimed chan struct{}
+}
+
+func newOutboxStore(emails int) *outboxStore {
+	s := &outboxStore{status: map[int64]string{}, claimed: make(chan struct{}, 100)}
+	for id := int64(1); id <= int64(emails); id++ {
+		s.status[id] = "pending"
+	}
+	return s
+}
+
+func (s *outboxStore) count(status string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, st := range s.status {
+		if st == status {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *outboxStore) handle(query string, args []any) (*sqlfake.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case strings.Contains(query, "RETURNING id, recipient, template, data, attempts"):
+		res := &sqlfake.Result{}
+		for id := int64(1); id <= int64(len(s.status)) && len(res.Rows) < args[0].(int); id++ {
+			if s.status[id] == "pending" {
+				s.status[id] = "sending"
+				res.Rows = append(res.Rows, []any{id, "alice@example.com", "user_welcome.tmpl", []byte(`{"userID": 1}`), int64(1)})
+			}
+		}
+		if len(res.Rows) > 0 {
+			s.batches = append(s.batches, len(res.Rows))
+			select {
+			case s.claimed <- struct{}{}:
+			default:
+			}
+		}
+		return res, nil
+
+	case strings.Contains(query, "SET status = 'sent'"):
+		s.status[args[0].(int64)] = "sent"
+
+	case strings.Contains(query, "attempts = attempts - 1"):
+		for _, id := range args[0].([]int64) {
+			s.status[id] = "pending"
+		}
+	}
+
+	return nil, nil
+}
+
+func TestDispatchOutboxBoundsParallelism(t *testing.T) {
+	app, clk := newConfiguredTestApplication(t, map[string]string{
+		"EMAIL_WORKERS":        "3",
+		"EMAIL_BATCH_SIZE":     "4",
+		"EMAIL_QUEUE_CAPACITY": "8",
+		"EMAIL_POLL_INTERVAL":  "10ms",
+	})
+
+	store := newOutboxStore(20)
+	useTestDB(t, app, clk, store.handle)
+
+	var inFlight, maxInFlight atomic.Int64
+
+	app.mailQueue = mailer.NewQueue(func(ctx context.Context, recipient, templateFile string, data any) error {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+
+		for m := maxInFlight.Load(); n > m && !maxInFlight.CompareAndSwap(m, n); m = maxInFlight.Load() {
+		}
+
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	}, app.config.outbox.workers, app.config.outbox.queueCapacity, app.config.outbox.queueWait)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		app.dispatchOutbox(ctx)
+	}()
+
+	for deadline := time.Now().Add(5 * time.Second); store.count("sent") < 20; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d of 20 emails sent", store.count("sent"))
+		}
+	}
+
+	cancel()
+	<-done
+	app.mailQueue.Shutdown(context.Background())
+
+	if got := maxInFlight.Load(); got > 3 {
+		t.Errorf("got %d emails sent at once; want at most 3", got)
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for i, size := range store.batches {
+		if size > 4 {
+			t.Errorf("batch %d: got %d emails claimed; want at most 4", i+1, size)
+		}
+	}
+}
+
+func TestDispatchOutboxStopsClaimingOnShutdown(t *testing.T) {
+	app, clk := newConfiguredTestApplication(t, map[string]string{
+		"EMAIL_WORKERS":        "1",
+		"EMAIL_BATCH_SIZE":     "2",
+		"EMAIL_QUEUE_CAPACITY": "2",
+		"EMAIL_QUEUE_WAIT":     "10ms",
+		"EMAIL_POLL_INTERVAL":  "10ms",
+	})
+
+	store := newOutboxStore(50)
+	useTestDB(t, app, clk, store.handle)
+
+	// Sends block until the test ends, so the batches in flight never finish.
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+
+	app.mailQueue = mailer.NewQueue(func(ctx context.Context, recipient, templateFile string, data any) error {
+		<-release
+		return nil
+	}, app.config.outbox.workers, app.config.outbox.queueCapacity, app.config.outbox.queueWait)
+
+	runUntilCancelled(t, store.claimed, app.dispatchOutbox)
+
+	store.mu.Lock()
+	claims := len(store.batches)
+	store.mu.Unlock()
+
+	time.Sleep(50 * time.Millisecond)
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if len(store.batches) != claims {
+		t.Errorf("got %d more batches claimed after shutdown", len(store.batches)-claims)
+	}
+
+	pending := 0
+	for _, status := range store.status {
+		if status == "pending" {
+			pending++
+		}
+	}
+	if pending < 40 {
+		t.Errorf("got %d emails left pending; want the outbox left alone once shut down", pending)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// viewBuffer counts movie views in memory until they are flushed to the database, so that a
+// popular movie costs one write per flush rather than one per view.
+type viewBuffer struct {
+	mu    sync.Mutex
+	views map[int64]int64
+}
+
+func (b *viewBuffer) add(id int64) {
+	b.addAll(map[int64]int64{id: 1})
+}
+
+func (b *viewBuffer) addAll(views map[int64]int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.views == nil {
+		b.views = make(map[int64]int64)
+	}
+
+	for id, n := range views {
+		b.views[id] += n
+	}
+}
+
+// take returns the buffered views and empties the buffer.
+func (b *viewBuffer) take() map[int64]int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	views := b.views
+	b.views = nil
+
+	return views
+}
+
+// recordView counts a view of the movie towards its popularity. When views are buffered
+// it is only written by the next flush.
+func (app *application) recordView(id int64) {
+	if app.config.popularity.flushInterval > 0 {
+		app.views.add(id)
+		return
+	}
+
+	err := app.models.Movies.AddViews(map[int64]int64{id: 1})
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"job": "movie_views"})
+	}
+}
+
+// flushViews writes the buffered views to the database every interval until ctx is
+// cancelled, and a final time after that, so that no views are lost on shutdown. Views
+// which fail to be written are put back to be retried by the next flush.
+func (app *application) flushViews(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	flush := func() {
+		views := app.views.take()
+
+		err := app.models.Movies.AddViews(views)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"job": "movie_views"})
+			app.views.addAll(views)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			flush()
+			return
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// viewMovieHandler counts a view of the movie, for clients which show movies without
+// fetching them from the API each time.
+func (app *application) viewMovieHandler(w http.ResponseWriter, r *http.Request) {
+	movie := app.visibleMovie(w, r)
+	if movie == nil {
+		return
+	}
+
+	app.recordView(movie.ID)
+
+	err := app.writeResponse(w, r, http.StatusAccepted, envelope{"message": "view recorded"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
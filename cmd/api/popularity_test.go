@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"greenlight/internal/sqlfake"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// viewCounter adds up the views written by AddViews, after failing its first failures writes.
+type viewCounter struct {
+	mu       sync.Mutex
+	views    map[int64]int64
+	writes   int
+	failures int
+}
+
+func (c *viewCounter) handle(query string, args []any) (*sqlfake.Result, error) {
+	if !strings.Contains(query, "SET popularity") {
+		return nil, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.failures > 0 {
+		c.failures--
+		return nil, errors.New("connection reset by peer")
+	}
+
+	c.writes++
+	ids, counts := args[0].([]int64), args[1].([]int64)
+	for i, id := range ids {
+		c.views[id] += counts[i]
+	}
+
+	return &sqlfake.Result{RowsAffected: int64(len(ids))}, nil
+}
+
+// viewConcurrently records n views of each movie from as many goroutines.
+func viewConcurrently(app *application, n int, ids ...int64) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		for _, id := range ids {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				app.recordView(id)
+			}()
+		}
+	}
+
+	wg.Wait()
+}
+
+func TestConcurrentViewsAllCount(t *testing.T) {
+	app, clk := newConfiguredTestApplication(t, map[string]string{"POPULARITY_FLUSH_INTERVAL": "0"})
+
+	counter := &viewCounter{views: map[int64]int64{}}
+	useTestDB(t, app, clk, counter.handle)
+
+	viewConcurrently(app, 50, 1, 2)
+
+	if counter.views[1] != 50 || counter.views[2] != 50 {
+		t.Errorf("got views %v; want 50 of each movie", counter.views)
+	}
+}
+
+func TestBufferedViewsAreFlushedInTotal(t *testing.T) {
+	app, clk := newConfiguredTestApplication(t, map[string]string{"POPULARITY_FLUSH_INTERVAL": "1h"})
+
+	counter := &viewCounter{views: map[int64]int64{}, failures: 1}
+	useTestDB(t, app, clk, counter.handle)
+
+	viewConcurrently(app, 50, 1, 2)
+
+	if counter.writes != 0 {
+		t.Fatalf("got %d writes before the flush; want the views buffered", counter.writes)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// The first flush fails, and its views are kept for the next one.
+	app.flushViews(ctx, app.config.popularity.flushInterval)
+	viewConcurrently(app, 10, 1)
+	app.flushViews(ctx, app.config.popularity.flushInterval)
+
+	if counter.writes != 1 || counter.views[1] != 60 || counter.views[2] != 50 {
+		t.Errorf("got %d writes of views %v; want one write of 60 and 50 views", counter.writes, counter.views)
+	}
+}
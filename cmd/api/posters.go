@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"greenlight/internal/storage"
+	"greenlight/internal/validator"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// posterContentTypes lists the image types accepted for movie posters. The type is sniffed
+// from the uploaded bytes rather than taken from the request's Content-Type header.
+var posterContentTypes = []string{"image/jpeg", "image/png", "image/webp"}
+
+func posterKey(movieID int64) string {
+	return fmt.Sprintf("posters/%d", movieID)
+}
+
+// movieExists responds with 404 and returns false when the movie in the request path does
+// not exist or the user may not see it.
+func (app *application) movieExists(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	movie := app.visibleMovie(w, r)
+	if movie == nil {
+		return 0, false
+	}
+
+	return movie.ID, true
+}
+
+func (app *application) uploadPosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.movieExists(w, r)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, int64(app.config.posters.maxBytes))
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.requestEntityTooLargeResponse(w, r, fmt.Errorf("poster must not be larger than %d bytes", maxBytesError.Limit))
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	if len(body) == 0 {
+		app.badRequestResponse(w, r, errors.New("poster must not be empty"))
+		return
+	}
+
+	contentType := http.DetectContentType(body)
+	if !validator.PermittedValue(contentType, posterContentTypes...) {
+		app.badRequestResponse(w, r, fmt.Errorf("poster must be a JPEG, PNG or WebP image, got %s", contentType))
+		return
+	}
+
+	err = app.posters.Put(r.Context(), posterKey(id), bytes.NewReader(body), contentType)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", app.absoluteURL(r, fmt.Sprintf("/v1/movies/%d/poster", id)))
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"poster": envelope{"content_type": contentType, "size": len(body)}}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showPosterHandler proxies the poster bytes through the API, or, for backends which can
+// presign URLs and when configured to, redirects the client to fetch it from the backend.
+func (app *application) showPosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if presigner, ok := app.posters.(storage.Presigner); ok && app.config.posters.serve == "redirect" {
+		url, err := presigner.PresignGet(posterKey(id), 15*time.Minute)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		http.Redirect(w, r, url, http.StatusFound)
+		return
+	}
+
+	blob, err := app.posters.Get(r.Context(), posterKey(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer blob.Body.Close()
+
+	w.Header().Set("Content-Type", blob.ContentType)
+
+	if app.config.posters.ranges {
+		app.servePosterContent(w, r, blob)
+		return
+	}
+
+	if blob.Size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(blob.Size, 10))
+	}
+	if !blob.ModTime.IsZero() {
+		w.Header().Set("Last-Modified", blob.ModTime.UTC().Format(http.TimeFormat))
+	}
+
+	_, err = io.Copy(w, blob.Body)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// servePosterContent sends the poster with http.ServeContent, which answers Range requests
+// with 206 Partial Content. Blobs from the filesystem backend can be seeked directly. Other
+// backends stream the body, so a poster no larger than the upload limit is read into memory
+// first, and anything else is sent whole.
+func (app *application) servePosterContent(w http.ResponseWriter, r *http.Request, blob *storage.Blob) {
+	content, ok := blob.Body.(io.ReadSeeker)
+
+	if !ok {
+		if blob.Size < 0 || blob.Size > int64(app.config.posters.maxBytes) {
+			w.Header().Set("Accept-Ranges", "none")
+			if blob.Size >= 0 {
+				w.Header().Set("Content-Length", strconv.FormatInt(blob.Size, 10))
+			}
+
+			_, err := io.Copy(w, blob.Body)
+			if err != nil {
+				app.logError(r, err)
+			}
+			return
+		}
+
+		body, err := io.ReadAll(blob.Body)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		content = bytes.NewReader(body)
+	}
+
+	http.ServeContent(w, r, "", blob.ModTime, content)
+}
+
+func (app *application) deletePosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.posters.Delete(r.Context(), posterKey(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "poster successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
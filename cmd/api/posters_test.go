@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"greenlight/internal/objectstore"
+	"greenlight/internal/sqlfake"
+	"greenlight/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// pngPoster is the start of a PNG file, which is enough for it to be sniffed as one.
+var pngPoster = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR poster")
+
+func TestPostersOnTheFilesystem(t *testing.T) {
+	app, clk := newConfiguredTestApplication(t, map[string]string{"POSTERS_MAX_BYTES": "64"})
+
+	useTestDB(t, app, clk, func(query string, args []any) (*sqlfake.Result, error) {
+		if strings.Contains(query, "FROM movies") && strings.Contains(query, "id = $1") && args[0] == int64(1) {
+			return &sqlfake.Result{Rows: [][]any{{
+				int64(1), testEpoch, testEpoch, "Alien", "alien", int64(1979), int64(117), "{Horror}", int64(1), "public", int64(0), float64(0),
+			}}}, nil
+		}
+		return nil, nil
+	})
+
+	var err error
+	app.posters, err = storage.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upload := func(id string, body []byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPut, "/v1/movies/"+id+"/poster", bytes.NewReader(body))
+		return serve(t, http.HandlerFunc(app.uploadPosterHandler), withParams(asUser(app, r, testUser), "id", id))
+	}
+	show := func() *httptest.ResponseRecorder {
+		return serve(t, http.HandlerFunc(app.showPosterHandler), withParams(httptest.NewRequest(http.MethodGet, "/v1/movies/1/poster", nil), "id", "1"))
+	}
+
+	if rr := show(); rr.Code != http.StatusNotFound {
+		t.Errorf("before upload: got status %d; want %d", rr.Code, http.StatusNotFound)
+	}
+
+	tests := []struct {
+		name       string
+		id         string
+		body       []byte
+		wantStatus int
+	}{
+		{"not an image", "1", []byte("just some text"), http.StatusBadRequest},
+		{"too large", "1", append(pngPoster, make([]byte, 64)...), http.StatusRequestEntityTooLarge},
+		{"unknown movie", "2", pngPoster, http.StatusNotFound},
+		{"png", "1", pngPoster, http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		if rr := upload(tt.id, tt.body); rr.Code != tt.wantStatus {
+			t.Errorf("upload %s: got status %d; want %d: %s", tt.name, rr.Code, tt.wantStatus, rr.Body)
+		}
+	}
+
+	rr := show()
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/png" || !bytes.Equal(rr.Body.Bytes(), pngPoster) {
+		t.Errorf("show: got status %d, content type %q and %d bytes", rr.Code, rr.Header().Get("Content-Type"), rr.Body.Len())
+	}
+
+	rr = serve(t, http.HandlerFunc(app.deletePosterHandler), withParams(asUser(app, httptest.NewRequest(http.MethodDelete, "/v1/movies/1/poster", nil), testUser), "id", "1"))
+	if rr.Code != http.StatusOK {
+		t.Errorf("delete: got status %d; want %d", rr.Code, http.StatusOK)
+	}
+
+	if rr := show(); rr.Code != http.StatusNotFound {
+		t.Errorf("after delete: got status %d; want %d", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestPostersFromS3(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/posters/posters/1" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(pngPoster)
+	}))
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		serveMode  string
+		wantStatus int
+	}{
+		{"proxy", http.StatusOK},
+		{"redirect", http.StatusFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.serveMode, func(t *testing.T) {
+			app, _ := newConfiguredTestApplication(t, map[string]string{"POSTERS_S3_SERVE": tt.serveMode})
+			app.posters = storage.NewS3Store(objectstore.New(srv.URL, "us-east-1", "posters", "access", "secret"))
+
+			rr := serve(t, http.HandlerFunc(app.showPosterHandler), withParams(httptest.NewRequest(http.MethodGet, "/v1/movies/1/poster", nil), "id", "1"))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d; want %d", rr.Code, tt.wantStatus)
+			}
+
+			switch tt.serveMode {
+			case "proxy":
+				if !bytes.Equal(rr.Body.Bytes(), pngPoster) {
+					t.Errorf("got %d bytes; want the poster", rr.Body.Len())
+				}
+			case "redirect":
+				if location := rr.Header().Get("Location"); !strings.HasPrefix(location, srv.URL+"/posters/posters/1?") || !strings.Contains(location, "X-Amz-Signature=") {
+					t.Errorf("got Location %q; want a presigned URL", location)
+				}
+			}
+		})
+	}
+}
+
+func TestPosterRangeRequests(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Content-Length", strconv.Itoa(len(pngPoster)))
+		w.Write(pngPoster)
+	}))
+	t.Cleanup(srv.Close)
+
+	backends := map[string]func(t *testing.T) storage.BlobStore{
+		"filesystem": func(t *testing.T) storage.BlobStore {
+			store, err := storage.NewFileStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Put(context.Background(), posterKey(1), bytes.NewReader(pngPoster), "image/png"); err != nil {
+				t.Fatal(err)
+			}
+			return store
+		},
+		"s3": func(t *testing.T) storage.BlobStore {
+			return storage.NewS3Store(objectstore.New(srv.URL, "us-east-1", "posters", "access", "secret"))
+		},
+	}
+
+	tests := []struct {
+		name             string
+		ranges           string
+		rangeHeader      string
+		wantStatus       int
+		wantBody         []byte
+		wantContentRange string
+		wantAcceptRanges string
+	}{
+		{"full", "true", "", http.StatusOK, pngPoster, "", "bytes"},
+		{"range", "true", "bytes=0-3", http.StatusPartialContent, pngPoster[:4], "bytes 0-3/" + strconv.Itoa(len(pngPoster)), "bytes"},
+		{"suffix range", "true", "bytes=-6", http.StatusPartialContent, pngPoster[len(pngPoster)-6:], fmt.Sprintf("bytes %d-%d/%d", len(pngPoster)-6, len(pngPoster)-1, len(pngPoster)), "bytes"},
+		{"unsatisfiable", "true", "bytes=1000-", http.StatusRequestedRangeNotSatisfiable, nil, "bytes */" + strconv.Itoa(len(pngPoster)), ""},
+		{"ranges disabled", "false", "bytes=0-3", http.StatusOK, pngPoster, "", ""},
+	}
+
+	for backend, newStore := range backends {
+		for _, tt := range tests {
+			t.Run(backend+" "+tt.name, func(t *testing.T) {
+				app, _ := newConfiguredTestApplication(t, map[string]string{"POSTERS_RANGE_REQUESTS": tt.ranges, "POSTERS_MAX_BYTES": "1024"})
+				app.posters = newStore(t)
+
+				r := withParams(httptest.NewRequest(http.MethodGet, "/v1/movies/1/poster", nil), "id", "1")
+				if tt.rangeHeader != "" {
+					r.Header.Set("Range", tt.rangeHeader)
+				}
+
+				rr := serve(t, http.HandlerFunc(app.showPosterHandler), r)
+
+				if rr.Code != tt.wantStatus {
+					t.Fatalf("got status %d; want %d", rr.Code, tt.wantStatus)
+				}
+				if tt.wantBody != nil && !bytes.Equal(rr.Body.Bytes(), tt.wantBody) {
+					t.Errorf("got body %q; want %q", rr.Body.Bytes(), tt.wantBody)
+				}
+				if got := rr.Header().Get("Content-Range"); got != tt.wantContentRange {
+					t.Errorf("got Content-Range %q; want %q", got, tt.wantContentRange)
+				}
+				if tt.wantAcceptRanges != "" && rr.Header().Get("Accept-Ranges") != tt.wantAcceptRanges {
+					t.Errorf("got Accept-Ranges %q; want %q", rr.Header().Get("Accept-Ranges"), tt.wantAcceptRanges)
+				}
+			})
+		}
+	}
+}
+
+func TestLargeStreamedPostersAreSentWhole(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Content-Length", strconv.Itoa(len(pngPoster)))
+		w.Write(pngPoster)
+	}))
+	t.Cleanup(srv.Close)
+
+	// The poster is larger than the upload limit, so it is not read into memory to be seeked.
+	app, _ := newConfiguredTestApplication(t, map[string]string{"POSTERS_MAX_BYTES": "8"})
+	app.posters = storage.NewS3Store(objectstore.New(srv.URL, "us-east-1", "posters", "access", "secret"))
+
+	r := withParams(httptest.NewRequest(http.MethodGet, "/v1/movies/1/poster", nil), "id", "1")
+	r.Header.Set("Range", "bytes=0-3")
+
+	rr := serve(t, http.HandlerFunc(app.showPosterHandler), r)
+
+	if rr.Code != http.StatusOK || !bytes.Equal(rr.Body.Bytes(), pngPoster) {
+		t.Errorf("got status %d with %d bytes; want the whole poster", rr.Code, rr.Body.Len())
+	}
+	if got := rr.Header().Get("Accept-Ranges"); got != "none" {
+		t.Errorf("got Accept-Ranges %q; want none", got)
+	}
+}
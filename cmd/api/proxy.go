@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// parseTrustedProxies parses a space separated list of IP addresses and CIDR prefixes.
+func parseTrustedProxies(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+
+	for _, field := range strings.Fields(s) {
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, err
+			}
+
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, err
+		}
+
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// fromTrustedProxy reports whether the request's peer is one of the trusted proxies, whose
+// X-Forwarded-* headers can be believed.
+func (app *application) fromTrustedProxy(r *http.Request) bool {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+
+	addr := addrPort.Addr().Unmap()
+
+	for _, prefix := range app.config.proxy.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requestScheme returns the scheme the client used to reach the API, which for requests
+// from a trusted proxy terminating TLS is taken from X-Forwarded-Proto.
+func (app *application) requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+
+	if app.fromTrustedProxy(r) {
+		proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+		if proto = strings.ToLower(strings.TrimSpace(proto)); proto == "https" || proto == "http" {
+			return proto
+		}
+	}
+
+	return "http"
+}
+
+// absoluteURL returns the external URL of path, under the configured base URL when there is
+// one and otherwise under the scheme and host the request was made to.
+func (app *application) absoluteURL(r *http.Request, path string) string {
+	if app.config.proxy.externalBaseURL != "" {
+		return strings.TrimRight(app.config.proxy.externalBaseURL, "/") + path
+	}
+
+	return fmt.Sprintf("%s://%s%s", app.requestScheme(r), r.Host, path)
+}
+
+// requireHTTPS permanently redirects plain HTTP requests to HTTPS. The healthchecks are
+// exempt so that load balancers can probe the API directly.
+func (app *application) requireHTTPS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.proxy.redirectHTTPS || r.URL.Path == "/debug/healthcheck" || strings.HasPrefix(r.URL.Path, "/v1/healthcheck/") || strings.HasPrefix(r.URL.Path, "/v1/healthz/") || app.requestScheme(r) == "https" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		target := "https://" + r.Host + r.URL.RequestURI()
+		if app.config.proxy.externalBaseURL != "" {
+			target = strings.TrimRight(app.config.proxy.externalBaseURL, "/") + r.URL.RequestURI()
+		}
+
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireHTTPS(t *testing.T) {
+	app, _ := newConfiguredTestApplication(t, map[string]string{"HTTPS_REDIRECT_ENABLED": "true", "TRUSTED_PROXIES": "10.0.0.0/8"})
+
+	h := app.requireHTTPS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name         string
+		path         string
+		remoteAddr   string
+		proto        string
+		wantStatus   int
+		wantLocation string
+	}{
+		{"trusted proxy with HTTPS", "/v1/movies", "10.0.0.5:1234", "https", http.StatusOK, ""},
+		{"trusted proxy with HTTP", "/v1/movies?page=2", "10.0.0.5:1234", "http", http.StatusPermanentRedirect, "https://api.example.com/v1/movies?page=2"},
+		{"untrusted peer claiming HTTPS", "/v1/movies", "192.0.2.1:1234", "https", http.StatusPermanentRedirect, "https://api.example.com/v1/movies"},
+		{"liveness probe", "/v1/healthcheck/live", "10.0.0.5:1234", "", http.StatusOK, ""},
+		{"readiness probe", "/v1/healthz/ready", "10.0.0.5:1234", "", http.StatusOK, ""},
+		{"dependency check", "/debug/healthcheck", "192.0.2.1:1234", "", http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://api.example.com"+tt.path, nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+
+			rr := serve(t, h, r)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d; want %d", rr.Code, tt.wantStatus)
+			}
+			if got := rr.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("got Location %q; want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestAbsoluteURLUsesTheExternalScheme(t *testing.T) {
+	tests := []struct {
+		name    string
+		baseURL string
+		proto   string
+		want    string
+	}{
+		{"forwarded HTTPS", "", "https", "https://api.example.com/v1/movies/1"},
+		{"plain HTTP", "", "", "http://api.example.com/v1/movies/1"},
+		{"base URL", "https://movies.example.com/api/", "http", "https://movies.example.com/api/v1/movies/1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := newConfiguredTestApplication(t, map[string]string{"TRUSTED_PROXIES": "10.0.0.5", "EXTERNAL_BASE_URL": tt.baseURL})
+
+			r := httptest.NewRequest(http.MethodPost, "http://api.example.com/v1/movies", nil)
+			r.RemoteAddr = "10.0.0.5:1234"
+			if tt.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+
+			if got := app.absoluteURL(r, "/v1/movies/1"); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	prefixes, err := parseTrustedProxies("10.0.0.1 192.168.1.7/16 ::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"10.0.0.1/32", "192.168.0.0/16", "::1/128"}
+	if len(prefixes) != len(want) {
+		t.Fatalf("got %v; want %v", prefixes, want)
+	}
+	for i := range want {
+		if prefixes[i].String() != want[i] {
+			t.Errorf("got %v; want %v", prefixes, want)
+		}
+	}
+
+	if _, err := parseTrustedProxies("10.0.0.300"); err == nil {
+		t.Error("got an invalid address accepted")
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// reindexStatus reports the progress of the most recent search reindex.
+type reindexStatus struct {
+	Status      string     `json:"status"`
+	Total       int        `json:"total"`
+	Processed   int        `json:"processed"`
+	StartedAt   time.Time  `json:"started_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// reindexJob tracks the search reindex, of which at most one runs at a time.
+type reindexJob struct {
+	mu     sync.Mutex
+	status *reindexStatus
+}
+
+func (j *reindexJob) get() *reindexStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.status == nil {
+		return nil
+	}
+
+	status := *j.status
+	return &status
+}
+
+func (j *reindexJob) update(fn func(*reindexStatus)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	fn(j.status)
+}
+
+// start records a new running reindex, returning false when one is already running.
+func (j *reindexJob) start(total int, now time.Time) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.status != nil && j.status.Status == "running" {
+		return false
+	}
+
+	j.status = &reindexStatus{Status: "running", Total: total, StartedAt: now}
+	return true
+}
+
+func (app *application) startReindexHandler(w http.ResponseWriter, r *http.Request) {
+	total, err := app.models.Movies.Count()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !app.reindex.start(total, app.clock.Now()) {
+		app.reindexInProgressResponse(w, r)
+		return
+	}
+
+	app.background(app.runReindex)
+
+	headers := make(http.Header)
+	headers.Set("Location", app.absoluteURL(r, "/v1/admin/reindex"))
+
+	err = app.writeResponse(w, r, http.StatusAccepted, envelope{"reindex": app.reindex.get()}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showReindexHandler(w http.ResponseWriter, r *http.Request) {
+	status := app.reindex.get()
+	if status == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err := app.writeResponse(w, r, http.StatusOK, envelope{"reindex": status}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// runReindex recomputes the search vector of every movie in batches of
+// app.config.reindex.batchSize, so that no single statement locks the whole table.
+func (app *application) runReindex() {
+	var afterID int64
+
+	for {
+		lastID, processed, err := app.models.Movies.ReindexBatch(afterID, app.config.reindex.batchSize)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"job": "reindex"})
+
+			app.reindex.update(func(s *reindexStatus) {
+				now := app.clock.Now()
+				s.Status, s.Error, s.CompletedAt = "failed", err.Error(), &now
+			})
+			return
+		}
+
+		if processed == 0 {
+			break
+		}
+
+		afterID = lastID
+		app.invalidateMovieLists()
+
+		app.reindex.update(func(s *reindexStatus) {
+			s.Processed += processed
+		})
+	}
+
+	app.reindex.update(func(s *reindexStatus) {
+		now := app.clock.Now()
+		s.Status, s.CompletedAt = "succeeded", &now
+	})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"greenlight/internal/sqlfake"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestReindexPopulatesEverySearchVector(t *testing.T) {
+	app, clk := newConfiguredTestApplication(t, map[string]string{"REINDEX_BATCH_SIZE": "3"})
+
+	var mu sync.Mutex
+	ids := []int64{1, 2, 4, 5, 8, 9, 12}
+	indexed := map[int64]bool{}
+	var batches [][]int64
+
+	useTestDB(t, app, clk, func(query string, args []any) (*sqlfake.Result, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case strings.Contains(query, "SELECT count(*) FROM movies"):
+			return &sqlfake.Result{Rows: [][]any{{int64(len(ids))}}}, nil
+		case strings.Contains(query, "SET search_vector = to_tsvector"):
+			afterID, size := args[0].(int64), args[1].(int)
+
+			var batch []int64
+			res := &sqlfake.Result{}
+			for _, id := range ids {
+				if id > afterID && len(batch) < size {
+					indexed[id] = true
+					batch = append(batch, id)
+					res.Rows = append(res.Rows, []any{id})
+				}
+			}
+			if len(batch) > 0 {
+				batches = append(batches, batch)
+			}
+			return res, nil
+		}
+		return nil, nil
+	})
+
+	rr := serve(t, http.HandlerFunc(app.startReindexHandler), asUser(app, httptest.NewRequest(http.MethodPost, "/v1/admin/reindex", nil), testUser))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusAccepted, rr.Body)
+	}
+
+	app.wg.Wait()
+
+	for _, id := range ids {
+		if !indexed[id] {
+			t.Errorf("movie %d was not reindexed", id)
+		}
+	}
+	if want := [][]int64{{1, 2, 4}, {5, 8, 9}, {12}}; !slices.EqualFunc(batches, want, slices.Equal) {
+		t.Errorf("got batches %v; want %v", batches, want)
+	}
+
+	rr = serve(t, http.HandlerFunc(app.showReindexHandler), httptest.NewRequest(http.MethodGet, "/v1/admin/reindex", nil))
+
+	var body struct {
+		Reindex reindexStatus `json:"reindex"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	status := body.Reindex
+	if status.Status != "succeeded" || status.Total != len(ids) || status.Processed != len(ids) || status.CompletedAt == nil {
+		t.Errorf("got status %+v", status)
+	}
+}
+
+func TestReindexRunsOneAtATime(t *testing.T) {
+	app, _ := newConfiguredTestApplication(t, nil)
+
+	if !app.reindex.start(10, testEpoch) {
+		t.Fatal("got the first reindex refused")
+	}
+	if app.reindex.start(10, testEpoch) {
+		t.Error("got a second reindex started while the first runs")
+	}
+
+	app.reindex.update(func(s *reindexStatus) { s.Status = "succeeded" })
+
+	if !app.reindex.start(10, testEpoch) {
+		t.Error("got a reindex refused after the last one finished")
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"greenlight/internal/sqlfake"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// roleStore answers the role, permission and user queries from memory.
+type roleStore struct {
+	mu        sync.Mutex
+	codes     []string
+	roles     map[string][]string
+	userRoles map[int64][]string
+	direct    map[int64][]string
+}
+
+func newRoleStore() *roleStore {
+	return &roleStore{
+		codes:     []string{"movies:read", "movies:write"},
+		roles:     map[string][]string{"editor": {"movies:read", "movies:write"}},
+		userRoles: map[int64][]string{},
+		direct:    map[int64][]string{1: {"movies:read"}},
+	}
+}
+
+func (s *roleStore) permissionRows(userID int64, includeRoles bool) *sqlfake.Result {
+	codes := slices.Clone(s.direct[userID])
+	if includeRoles {
+		for _, role := range s.userRoles[userID] {
+			codes = append(codes, s.roles[role]...)
+		}
+	}
+
+	slices.Sort(codes)
+
+	res := &sqlfake.Result{}
+	for _, code := range slices.Compact(codes) {
+		res.Rows = append(res.Rows, []any{code})
+	}
+	return res
+}
+
+func (s *roleStore) handle(query string, args []any) (*sqlfake.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case query == "":
+		return nil, nil
+
+	case strings.Contains(query, "password_hash"):
+		return &sqlfake.Result{Rows: [][]any{
+			{args[0].(int64), testEpoch, "Alice", "alice@example.com", []byte("hash"), true, int64(1)},
+		}}, nil
+
+	case strings.Contains(query, "INSERT INTO roles"):
+		s.roles[args[0].(string)] = slices.Clone(args[1].([]string))
+		return &sqlfake.Result{Rows: [][]any{{testEpoch}}}, nil
+
+	case strings.Contains(query, "DELETE FROM users_roles"):
+		s.userRoles[args[0].(int64)] = slices.Clone(args[1].([]string))
+		return &sqlfake.Result{RowsAffected: 1}, nil
+
+	case strings.Contains(query, "SELECT id FROM roles WHERE name = $2"):
+		codes, ok := s.roles[args[1].(string)]
+		if !ok {
+			return nil, nil
+		}
+		s.direct[args[0].(int64)] = append(s.direct[args[0].(int64)], codes...)
+		return &sqlfake.Result{Rows: [][]any{{int64(1)}}}, nil
+
+	case strings.Contains(query, "INNER JOIN users_roles ON users_roles.role_id = roles.id"):
+		res := &sqlfake.Result{}
+		for _, name := range s.userRoles[args[0].(int64)] {
+			res.Rows = append(res.Rows, []any{name})
+		}
+		return res, nil
+
+	case strings.Contains(query, "FROM roles"):
+		res := &sqlfake.Result{}
+		for name, codes := range s.roles {
+			res.Rows = append(res.Rows, []any{name, testEpoch, "{" + strings.Join(codes, ",") + "}"})
+		}
+		return res, nil
+
+	case strings.Contains(query, "users_permissions"):
+		return s.permissionRows(args[0].(int64), strings.Contains(query, "UNION")), nil
+
+	case strings.Contains(query, "FROM permissions"):
+		res := &sqlfake.Result{}
+		for _, code := range s.codes {
+			res.Rows = append(res.Rows, []any{code})
+		}
+		return res, nil
+	}
+
+	return nil, nil
+}
+
+func TestRolesGrantTheirPermissions(t *testing.T) {
+	app, clk := newConfiguredTestApplication(t, map[string]string{"AUDIT_DENIALS": "off"})
+	useTestDB(t, app, clk, newRoleStore().handle)
+	app.models.Permissions.IncludeRoles = true
+
+	canWrite := func() bool {
+		t.Helper()
+
+		h := app.requirePermission("movies:write", func(w http.ResponseWriter, r *http.Request) {})
+		rr := serve(t, h, asUser(app, httptest.NewRequest(http.MethodPost, "/v1/movies", nil), testUser))
+
+		switch rr.Code {
+		case http.StatusOK:
+			return true
+		case http.StatusForbidden:
+			return false
+		}
+
+		t.Fatalf("got status %d; want %d or %d", rr.Code, http.StatusOK, http.StatusForbidden)
+		return false
+	}
+
+	put := func(h http.HandlerFunc, target, body string, params ...string) {
+		t.Helper()
+
+		r := withParams(httptest.NewRequest(http.MethodPut, target, strings.NewReader(body)), params...)
+		if rr := serve(t, h, r); rr.Code != http.StatusOK {
+			t.Fatalf("PUT %s: got status %d; want %d: %s", target, rr.Code, http.StatusOK, rr.Body)
+		}
+	}
+
+	if canWrite() {
+		t.Fatal("user can write before holding a role")
+	}
+
+	put(app.updateUserRolesHandler, "/v1/admin/users/1/roles", `{"roles": ["editor"]}`, "id", "1")
+	if !canWrite() {
+		t.Fatal("user cannot write after being given the editor role")
+	}
+
+	// Taking the permission away from the role takes it away from the user.
+	put(app.putRoleHandler, "/v1/admin/roles/editor", `{"permissions": ["movies:read"]}`, "name", "editor")
+	if canWrite() {
+		t.Fatal("user can write after the editor role lost movies:write")
+	}
+
+	put(app.putRoleHandler, "/v1/admin/roles/editor", `{"permissions": ["movies:read", "movies:write"]}`, "name", "editor")
+	if !canWrite() {
+		t.Fatal("user cannot write after the editor role regained movies:write")
+	}
+
+	put(app.updateUserRolesHandler, "/v1/admin/users/1/roles", `{"roles": []}`, "id", "1")
+	if canWrite() {
+		t.Fatal("user can write after the editor role was taken away")
+	}
+}
+
+func TestRolesCanBeDisabled(t *testing.T) {
+	store := newRoleStore()
+	store.userRoles[1] = []string{"editor"}
+
+	app, clk := newConfiguredTestApplication(t, map[string]string{"AUDIT_DENIALS": "off", "ROLES_ENABLED": "false"})
+	useTestDB(t, app, clk, store.handle)
+
+	h := app.requirePermission("movies:write", func(w http.ResponseWriter, r *http.Request) {})
+	rr := serve(t, h, asUser(app, httptest.NewRequest(http.MethodPost, "/v1/movies", nil), testUser))
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("got status %d; want %d", rr.Code, http.StatusForbidden)
+	}
+}
+
+func TestGrantedRolePermissionsOutliveTheRole(t *testing.T) {
+	store := newRoleStore()
+
+	app, clk := newConfiguredTestApplication(t, nil)
+	useTestDB(t, app, clk, store.handle)
+	app.models.Permissions.IncludeRoles = true
+
+	r := withParams(httptest.NewRequest(http.MethodPost, "/v1/admin/users/1/permissions", strings.NewReader(`{"role": "editor"}`)), "id", "1")
+	if rr := serve(t, http.HandlerFunc(app.grantUserRoleHandler), r); rr.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	r = withParams(httptest.NewRequest(http.MethodPut, "/v1/admin/roles/editor", strings.NewReader(`{"permissions": []}`)), "name", "editor")
+	if rr := serve(t, http.HandlerFunc(app.putRoleHandler), r); rr.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !permissions.Include("movies:write") {
+		t.Errorf("got permissions %v; want movies:write kept after the role changed", permissions)
+	}
+}
+
+func TestUpdateUserRolesValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"missing", `{}`, "must be provided"},
+		{"duplicates", `{"roles": ["editor", "editor"]}`, "must not contain duplicate values"},
+		{"unknown", `{"roles": ["owner"]}`, "must only contain existing roles"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, clk := newConfiguredTestApplication(t, nil)
+			useTestDB(t, app, clk, newRoleStore().handle)
+
+			r := withParams(httptest.NewRequest(http.MethodPut, "/v1/admin/users/1/roles", strings.NewReader(tt.body)), "id", "1")
+			rr := serve(t, http.HandlerFunc(app.updateUserRolesHandler), r)
+
+			if rr.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("got status %d; want %d", rr.Code, http.StatusUnprocessableEntity)
+			}
+
+			var body struct {
+				Error map[string]string `json:"error"`
+			}
+
+			if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+
+			if body.Error["roles"] != tt.want {
+				t.Errorf("got error %q; want %q", body.Error["roles"], tt.want)
+			}
+		})
+	}
+}
+
+func TestGrantingARole(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantError  string
+		wantWrite  bool
+	}{
+		{"editor", `{"role": "editor"}`, http.StatusOK, "", true},
+		{"missing", `{}`, http.StatusUnprocessableEntity, "must be provided", false},
+		{"unknown", `{"role": "owner"}`, http.StatusUnprocessableEntity, "must be an existing role", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The granted codes are checked by requirePermission as they always were, with
+			// roles themselves disabled.
+			app, clk := newConfiguredTestApplication(t, map[string]string{"AUDIT_DENIALS": "off", "ROLES_ENABLED": "false"})
+			useTestDB(t, app, clk, newRoleStore().handle)
+
+			r := withParams(httptest.NewRequest(http.MethodPost, "/v1/admin/users/1/permissions", strings.NewReader(tt.body)), "id", "1")
+			rr := serve(t, http.HandlerFunc(app.grantUserRoleHandler), r)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d; want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+
+			if tt.wantError != "" {
+				var body struct {
+					Error map[string]string `json:"error"`
+				}
+
+				if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+					t.Fatal(err)
+				}
+
+				if body.Error["role"] != tt.wantError {
+					t.Errorf("got error %q; want %q", body.Error["role"], tt.wantError)
+				}
+			}
+
+			h := app.requirePermission("movies:write", func(w http.ResponseWriter, r *http.Request) {})
+			rr = serve(t, h, asUser(app, httptest.NewRequest(http.MethodPost, "/v1/movies", nil), testUser))
+
+			if got := rr.Code == http.StatusOK; got != tt.wantWrite {
+				t.Errorf("got movies:write %t; want %t", got, tt.wantWrite)
+			}
+		})
+	}
+}
@@ -2,31 +2,231 @@ package main
 
 import (
 	"expvar"
+	"fmt"
 	"net/http"
+	"slices"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
 )
 
+// Access policies for routes which do not require a specific permission. Any other policy
+// value is treated as the permission code that the user must hold.
+const (
+	policyPublic        = "public"
+	policyAuthenticated = "authenticated"
+)
+
+// route describes a single endpoint together with its access policy. Every endpoint the
+// API exposes is listed in routeTable, so the whole access policy can be audited in one
+// place.
+type route struct {
+	method  string
+	pattern string
+	policy  string
+	handler http.HandlerFunc
+}
+
+func (app *application) routeTable() []route {
+	routes := []route{
+		{http.MethodGet, "/v1/movies", "movies:read", app.listMoviesHandler},
+		{http.MethodPost, "/v1/movies", "movies:write", app.validateSchema("create_movie", app.createMovieHandler)},
+		{http.MethodGet, "/v1/movies/:id", "movies:read", app.showMovieHandler},
+		{http.MethodPatch, "/v1/movies/:id", "movies:write", app.validateSchema("update_movie", app.updateMovieHandler)},
+		{http.MethodDelete, "/v1/movies/:id", "movies:write", app.deleteMovieHandler},
+
+		{http.MethodGet, "/v1/movies/:id/acl", "movies:write", app.showMovieACLHandler},
+		{http.MethodPut, "/v1/movies/:id/acl", "movies:write", app.updateMovieACLHandler},
+
+		{http.MethodPost, "/v1/movies/:id/view", "movies:read", app.viewMovieHandler},
+
+		{http.MethodGet, "/v1/movies-by-slug/:slug", "movies:read", app.showMovieBySlugHandler},
+
+		// The event stream lives under /v1/events, as httprouter cannot route
+		// /v1/movies/events alongside /v1/movies/:id.
+		{http.MethodGet, "/v1/events/movies", "movies:read", app.movieEventsHandler},
+
+		{http.MethodPost, "/v1/batch/movies", "movies:write", app.batchCreateMoviesHandler},
+		{http.MethodPut, "/v1/batch/movies", "movies:write", app.batchUpsertMoviesHandler},
+		{http.MethodDelete, "/v1/batch/movies", "movies:write", app.batchDeleteMoviesHandler},
+
+		{http.MethodPut, "/v1/movies/:id/poster", "movies:write", app.uploadPosterHandler},
+		{http.MethodGet, "/v1/movies/:id/poster", "movies:read", app.showPosterHandler},
+		{http.MethodDelete, "/v1/movies/:id/poster", "movies:write", app.deletePosterHandler},
+
+		{http.MethodPost, "/v1/admin/movies/fix-genres", "admin:movies", app.fixMovieGenresHandler},
+		{http.MethodGet, "/v1/admin/genres/mappings", "admin:movies", app.listGenreMappingsHandler},
+		{http.MethodPut, "/v1/admin/genres/mappings/:alias", "admin:movies", app.putGenreMappingHandler},
+		{http.MethodDelete, "/v1/admin/genres/mappings/:alias", "admin:movies", app.deleteGenreMappingHandler},
+		{http.MethodPost, "/v1/admin/reindex", "admin:movies", app.startReindexHandler},
+		{http.MethodGet, "/v1/admin/reindex", "admin:movies", app.showReindexHandler},
+
+		{http.MethodGet, "/v1/audit/denials", "admin:audit", app.listDenialsHandler},
+
+		{http.MethodPost, "/v1/admin/webhooks", "admin:webhooks", app.createWebhookHandler},
+		{http.MethodGet, "/v1/admin/webhooks", "admin:webhooks", app.listWebhooksHandler},
+		{http.MethodDelete, "/v1/admin/webhooks/:id", "admin:webhooks", app.deleteWebhookHandler},
+
+		// Granting the permissions of a role does not depend on ROLES_ENABLED, since it
+		// grants them to the user directly.
+		{http.MethodPost, "/v1/admin/users/:id/permissions", "admin:roles", app.grantUserRoleHandler},
+
+		{http.MethodPost, "/v1/users", policyPublic, app.validateSchema("register_user", app.registerUserHandler)},
+		{http.MethodGet, "/v1/users", "users:read", app.listUsersHandler},
+		{http.MethodDelete, "/v1/users/me", policyAuthenticated, app.deleteCurrentUserHandler},
+
+		{http.MethodPost, "/v1/users/me/searches", policyAuthenticated, app.validateSchema("create_saved_search", app.createSavedSearchHandler)},
+		{http.MethodGet, "/v1/users/me/searches", policyAuthenticated, app.listSavedSearchesHandler},
+		{http.MethodGet, "/v1/users/me/searches/:id/results", "movies:read", app.savedSearchResultsHandler},
+
+		{http.MethodGet, "/v1/users/me/email-preferences", policyAuthenticated, app.showEmailPreferencesHandler},
+		{http.MethodPut, "/v1/users/me/email-preferences", policyAuthenticated, app.updateEmailPreferencesHandler},
+		{http.MethodGet, "/v1/email/unsubscribe", policyPublic, app.unsubscribeHandler},
+
+		{http.MethodPost, "/v1/tokens/activation", policyPublic, app.createActivationTokenHandler},
+		{http.MethodPut, "/v1/users/activated", policyPublic, app.activateUserHandler},
+		{http.MethodPost, "/v1/tokens/password-reset", policyPublic, app.createPasswordResetTokenHandler},
+		{http.MethodPut, "/v1/users/password", policyPublic, app.updateUserPasswordHandler},
+		{http.MethodPut, "/v1/users/email", policyAuthenticated, app.updateUserEmailHandler},
+		{http.MethodPost, "/v1/tokens/authentication", policyPublic, app.createAuthenticationTokenHandler},
+		{http.MethodPost, "/v1/tokens/api-key", policyAuthenticated, app.createAPIKeyHandler},
+
+		{http.MethodGet, "/v1/openapi.json", policyPublic, app.openAPIHandler},
+
+		// The liveness and readiness probes. live answers 200 for as long as the process runs,
+		// while ready answers 503 until the server is listening and once it starts draining.
+		// /v1/healthz is the Kubernetes spelling of the same probes, and /debug/healthcheck
+		// remains the dependency check.
+		{http.MethodGet, "/v1/healthcheck/live", policyPublic, app.livenessHandler},
+		{http.MethodGet, "/v1/healthcheck/ready", policyPublic, app.readinessHandler},
+		{http.MethodGet, "/v1/healthz/live", policyPublic, app.livenessHandler},
+		{http.MethodGet, "/v1/healthz/ready", policyPublic, app.readinessHandler},
+
+		{http.MethodGet, "/debug/healthcheck", policyPublic, app.healthcheckHandler},
+		{http.MethodGet, "/debug/metrics", policyPublic, expvar.Handler().ServeHTTP},
+		{http.MethodGet, "/v1/debug/buildinfo", "metrics:read", app.buildInfoHandler},
+		{http.MethodPut, "/v1/debug/loglevel", "admin:logs", app.updateLogLevelHandler},
+	}
+
+	if app.objectStore != nil {
+		routes = append(routes,
+			route{http.MethodPost, "/v1/admin/exports", "admin:exports", app.createExportHandler},
+			route{http.MethodGet, "/v1/admin/exports/:id", "admin:exports", app.showExportHandler},
+		)
+	}
+
+	if slices.Contains(app.config.auth.schemes, authSchemeJWT) {
+		routes = append(routes, route{http.MethodPost, "/v1/tokens/jwt", policyPublic, app.createJWTHandler})
+	}
+
+	if app.config.errors.catalog {
+		routes = append(routes, route{http.MethodGet, "/v1/errors", policyPublic, app.listErrorCodesHandler})
+	}
+
+	if app.config.index.enabled {
+		routes = append(routes, route{http.MethodGet, "/v1", policyPublic, app.indexHandler})
+	}
+
+	// The rules are served under /v1/schemas, because httprouter cannot route
+	// /v1/movies/schema alongside /v1/movies/:id.
+	if app.config.validationRules.enabled {
+		routes = append(routes,
+			route{http.MethodGet, "/v1/schemas/movies", policyPublic, app.movieRulesHandler},
+			route{http.MethodGet, "/v1/schemas/users", policyPublic, app.userRulesHandler},
+		)
+	}
+
+	if app.config.movies.softDelete {
+		routes = append(routes, route{http.MethodPost, "/v1/movies/:id/restore", "movies:write", app.restoreMovieHandler})
+	}
+
+	// Users are managed under /v1/admin, because httprouter cannot route /v1/users/:id
+	// alongside /v1/users/activated.
+	if app.config.users.softDelete {
+		routes = append(routes,
+			route{http.MethodDelete, "/v1/admin/users/:id", "admin:users", app.deleteUserHandler},
+			route{http.MethodPost, "/v1/admin/users/:id/restore", "admin:users", app.restoreUserHandler},
+		)
+	}
+
+	if app.config.deadLetter.enabled {
+		routes = append(routes,
+			route{http.MethodGet, "/v1/admin/dead-letters", "admin:dead_letters", app.listDeadLettersHandler},
+			route{http.MethodPost, "/v1/admin/dead-letters/replay", "admin:dead_letters", app.replayDeadLettersHandler},
+		)
+	}
+
+	if app.config.roles.enabled {
+		routes = append(routes,
+			route{http.MethodGet, "/v1/admin/roles", "admin:roles", app.listRolesHandler},
+			route{http.MethodPut, "/v1/admin/roles/:name", "admin:roles", app.putRoleHandler},
+			route{http.MethodDelete, "/v1/admin/roles/:name", "admin:roles", app.deleteRoleHandler},
+			route{http.MethodPut, "/v1/admin/users/:id/roles", "admin:roles", app.updateUserRolesHandler},
+		)
+	}
+
+	return routes
+}
+
+// untimedRoutes are not subject to the request timeout, as they can legitimately take
+// longer: batches of movies, posters sent over slow connections, admin maintenance and the
+// movie event stream, which stays open for as long as the client listens.
+var untimedRoutes = map[string]bool{
+	"GET /v1/events/movies":            true,
+	"POST /v1/batch/movies":            true,
+	"PUT /v1/batch/movies":             true,
+	"DELETE /v1/batch/movies":          true,
+	"PUT /v1/movies/:id/poster":        true,
+	"GET /v1/movies/:id/poster":        true,
+	"POST /v1/admin/movies/fix-genres": true,
+}
+
 func (app *application) routes() http.Handler {
 	router := httprouter.New()
 
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
-	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	for _, rt := range app.routeTable() {
+		handler := app.requirePolicy(rt, rt.handler)
 
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
+		if d, ok := app.deprecationFor(rt); ok {
+			handler = app.deprecated(d, handler)
+		}
 
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+		if !untimedRoutes[rt.method+" "+rt.pattern] {
+			handler = app.timeout(handler)
+		}
 
-	router.HandlerFunc(http.MethodGet, "/debug/healthcheck", app.healthcheckHandler)
-	router.Handler(http.MethodGet, "/debug/metrics", expvar.Handler())
+		router.HandlerFunc(rt.method, rt.pattern, app.recordRoute(rt.method+" "+rt.pattern, handler))
+	}
+
+	handler := app.limitUserConcurrency(app.methodOverride(app.idempotent(router)))
+
+	// Keyed by user, the rate limiter has to run once authenticate has resolved the user,
+	// and authenticate limits failed authentications per IP itself. Keyed by IP, it runs
+	// first so that requests with invalid credentials are limited along with the rest.
+	if app.config.limiter.key == limiterKeyUser {
+		handler = app.limitConcurrency(app.authenticate(app.rateLimit(handler)))
+	} else {
+		handler = app.rateLimit(app.limitConcurrency(app.authenticate(handler)))
+	}
+
+	return app.requestID(app.securityHeaders(app.advertiseHTTP3(app.metrics(app.trace(app.compress(app.recoverPanic(app.requireHTTPS(app.enableCORS(handler)))))))))
+}
 
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+// requirePolicy wraps next with the middleware enforcing the route's access policy. It
+// panics when a route has no policy, so that a new route cannot be registered unprotected
+// by accident.
+func (app *application) requirePolicy(rt route, next http.HandlerFunc) http.HandlerFunc {
+	switch {
+	case rt.policy == policyPublic:
+		return next
+	case rt.policy == policyAuthenticated:
+		return app.requireActivatedUser(next)
+	case strings.Contains(rt.policy, ":"):
+		return app.requirePermission(rt.policy, next)
+	default:
+		panic(fmt.Sprintf("missing or invalid access policy %q for %s %s", rt.policy, rt.method, rt.pattern))
+	}
 }
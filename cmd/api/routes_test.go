@@ -0,0 +1,121 @@
+package main
+
+import (
+	"greenlight/internal/objectstore"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// newFullyConfiguredApplication returns a test application with every optional group of
+// routes enabled.
+func newFullyConfiguredApplication(t *testing.T) *application {
+	t.Helper()
+
+	app, _ := newTestApplication(t)
+	app.objectStore = &objectstore.Client{}
+	app.config.auth.schemes = []string{authSchemeToken, authSchemeJWT, authSchemeAPIKey}
+	app.config.errors.catalog = true
+	app.config.index.enabled = true
+	app.config.validationRules.enabled = true
+	app.config.movies.softDelete = true
+	app.config.users.softDelete = true
+	app.config.deadLetter.enabled = true
+	app.config.roles.enabled = true
+
+	return app
+}
+
+// migrationPermissions returns the permission codes the migrations create.
+func migrationPermissions(t *testing.T) map[string]bool {
+	t.Helper()
+
+	files, err := filepath.Glob("../../migrations/*.sql")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no migrations found: %v", err)
+	}
+
+	insert := regexp.MustCompile(`(?s)INSERT INTO permissions \(code\)\s*VALUES(.*?);`)
+	code := regexp.MustCompile(`'([a-z_]+:[a-z_]+)'`)
+
+	permissions := make(map[string]bool)
+
+	for _, file := range files {
+		sql, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, values := range insert.FindAllSubmatch(sql, -1) {
+			for _, m := range code.FindAllSubmatch(values[1], -1) {
+				permissions[string(m[1])] = true
+			}
+		}
+	}
+
+	return permissions
+}
+
+func TestEveryRouteHasAKnownPolicy(t *testing.T) {
+	app := newFullyConfiguredApplication(t)
+	permissions := migrationPermissions(t)
+
+	seen := make(map[string]bool)
+
+	for _, rt := range app.routeTable() {
+		name := rt.method + " " + rt.pattern
+
+		if seen[name] {
+			t.Errorf("%s is listed more than once", name)
+		}
+		seen[name] = true
+
+		switch {
+		case rt.policy == policyPublic || rt.policy == policyAuthenticated:
+		case strings.Contains(rt.policy, ":"):
+			if !permissions[rt.policy] {
+				t.Errorf("%s requires %q, which no migration creates", name, rt.policy)
+			}
+		default:
+			t.Errorf("%s has the unknown policy %q", name, rt.policy)
+		}
+	}
+
+	for name := range untimedRoutes {
+		if !seen[name] {
+			t.Errorf("untimed route %s is not in the route table", name)
+		}
+	}
+
+	// routes itself cannot be built more than once per test binary, as it publishes the
+	// metrics, so the policies are applied the way it applies them.
+	for _, rt := range app.routeTable() {
+		func() {
+			defer func() {
+				if err := recover(); err != nil {
+					t.Errorf("%s %s: %v", rt.method, rt.pattern, err)
+				}
+			}()
+
+			app.requirePolicy(rt, rt.handler)
+		}()
+	}
+}
+
+func TestRequirePolicyPanicsWithoutAPolicy(t *testing.T) {
+	app, _ := newTestApplication(t)
+
+	for _, policy := range []string{"", "admin"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("policy %q: requirePolicy did not panic", policy)
+				}
+			}()
+
+			app.requirePolicy(route{method: "GET", pattern: "/v1/new", policy: policy}, app.notFoundResponse)
+		}()
+	}
+}
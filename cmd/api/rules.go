@@ -0,0 +1,25 @@
+package main
+
+import (
+	"greenlight/internal/data"
+	"net/http"
+)
+
+// movieRulesHandler returns the rules movies are validated against when they are created or
+// updated, with the year bounds as currently configured.
+func (app *application) movieRulesHandler(w http.ResponseWriter, r *http.Request) {
+	minYear, maxYear := app.movieYearBounds()
+
+	err := app.writeResponse(w, r, http.StatusOK, envelope{"fields": data.MovieRules(minYear, maxYear)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// userRulesHandler returns the rules users are validated against when they register.
+func (app *application) userRulesHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeResponse(w, r, http.StatusOK, envelope{"fields": data.UserRules()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
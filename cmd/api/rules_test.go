@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMovieRulesReportTheConfiguredYearBounds(t *testing.T) {
+	app, clk := newTestApplication(t)
+	app.config.movies.yearMin = data.YearBound{Year: 1888}
+	app.config.movies.yearMax = data.YearBound{Year: 2, Relative: true}
+
+	rulesYear := func() (int64, int64) {
+		rr := serve(t, http.HandlerFunc(app.movieRulesHandler), httptest.NewRequest(http.MethodGet, "/v1/schemas/movies", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d; want %d", rr.Code, http.StatusOK)
+		}
+
+		var body struct {
+			Fields map[string]data.FieldRule `json:"fields"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+
+		year := body.Fields["year"]
+		if year.Minimum == nil || year.Maximum == nil {
+			t.Fatalf("year rule has no bounds: %+v", year)
+		}
+
+		return *year.Minimum, *year.Maximum
+	}
+
+	validYear := func(year int32) bool {
+		minYear, maxYear := app.movieYearBounds()
+
+		v := validator.New()
+		data.ValidateMovie(v, &data.Movie{Title: "Moana", Year: year, Runtime: 107, Genres: []string{"animation"}}, minYear, maxYear)
+
+		return v.Valid()
+	}
+
+	minYear, maxYear := rulesYear()
+	if minYear != 1888 || maxYear != 2026 {
+		t.Fatalf("got year bounds %d-%d; want 1888-2026", minYear, maxYear)
+	}
+
+	for year, want := range map[int32]bool{1887: false, 1888: true, 2026: true, 2027: false} {
+		if got := validYear(year); got != want {
+			t.Errorf("year %d: valid = %t; want %t", year, got, want)
+		}
+	}
+
+	// The relative maximum moves with the clock, in the rules and in validation alike.
+	clk.Set(testEpoch.AddDate(1, 0, 0))
+
+	if _, maxYear := rulesYear(); maxYear != 2027 {
+		t.Errorf("a year later: got maximum %d; want 2027", maxYear)
+	}
+	if !validYear(2027) {
+		t.Error("a year later: 2027 is rejected")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"greenlight/internal/data"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Ways of handling invalid UTF-8 in stored movies, such as rows from a legacy import, when
+// they are written out. With utf8Replace each invalid sequence becomes U+FFFD, and with
+// utf8Drop it is removed.
+const (
+	utf8Off     = "off"
+	utf8Replace = "replace"
+	utf8Drop    = "drop"
+)
+
+var errInvalidUTF8 = errors.New("stored movie contains invalid UTF-8")
+
+// sanitizeMovie returns the movie with any invalid UTF-8 in its text fields replaced or
+// dropped, logging which fields of which movie were affected. The movie itself is never
+// changed, as it may be shared through the movie cache, so a copy is returned when there
+// is anything to fix.
+func (app *application) sanitizeMovie(r *http.Request, movie *data.Movie) *data.Movie {
+	mode := app.config.responses.invalidUTF8
+	if mode == utf8Off {
+		return movie
+	}
+
+	var fields []string
+
+	if !utf8.ValidString(movie.Title) {
+		fields = append(fields, "title")
+	}
+	if !utf8.ValidString(movie.Slug) {
+		fields = append(fields, "slug")
+	}
+	for _, genre := range movie.Genres {
+		if !utf8.ValidString(genre) {
+			fields = append(fields, "genres")
+			break
+		}
+	}
+
+	if fields == nil {
+		return movie
+	}
+
+	replacement := "\uFFFD"
+	if mode == utf8Drop {
+		replacement = ""
+	}
+
+	sanitized := *movie
+	sanitized.Title = strings.ToValidUTF8(movie.Title, replacement)
+	sanitized.Slug = strings.ToValidUTF8(movie.Slug, replacement)
+	sanitized.Genres = make([]string, len(movie.Genres))
+	for i, genre := range movie.Genres {
+		sanitized.Genres[i] = strings.ToValidUTF8(genre, replacement)
+	}
+
+	properties := app.requestProperties(r)
+	properties["movie_id"] = strconv.FormatInt(movie.ID, 10)
+	properties["fields"] = strings.Join(fields, ",")
+	properties["action"] = mode
+
+	app.logger.PrintError(errInvalidUTF8, properties)
+
+	return &sanitized
+}
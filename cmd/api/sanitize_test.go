@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"greenlight/internal/data"
+	"greenlight/internal/jsonlog"
+	"greenlight/internal/sqlfake"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestListMoviesSanitizesInvalidUTF8(t *testing.T) {
+	tests := []struct {
+		mode      string
+		wantTitle string
+	}{
+		{utf8Replace, "Am\uFFFDlie"},
+		{utf8Drop, "Amlie"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			app, clk := newConfiguredTestApplication(t, map[string]string{"RESPONSE_INVALID_UTF8": tt.mode})
+
+			var out bytes.Buffer
+			app.logger = jsonlog.New(&out, jsonlog.LevelInfo)
+
+			// The first movie was imported as Latin-1.
+			legacy := &data.Movie{ID: 7, Title: "Am\xe9lie", Slug: "amelie", Year: 2001, Runtime: 122, Genres: []string{"Drama"}, Version: 1}
+			clean := &data.Movie{ID: 8, Title: "Heat", Slug: "heat", Year: 1995, Runtime: 170, Genres: []string{"Crime"}, Version: 1}
+
+			useTestDB(t, app, clk, func(query string, args []any) (*sqlfake.Result, error) {
+				return listRows(2, legacy, clean), nil
+			})
+
+			rr := serve(t, http.HandlerFunc(app.listMoviesHandler), httptest.NewRequest(http.MethodGet, "/v1/movies", nil))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+			}
+
+			var body struct {
+				Movies []data.Movie `json:"movies"`
+			}
+
+			if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(body.Movies) != 2 || body.Movies[1].Title != "Heat" {
+				t.Fatalf("got movies %+v; want both, the clean one untouched", body.Movies)
+			}
+			if got := body.Movies[0].Title; got != tt.wantTitle {
+				t.Errorf("got title %q; want %q", got, tt.wantTitle)
+			}
+
+			var entry struct {
+				Level      string            `json:"level"`
+				Message    string            `json:"message"`
+				Properties map[string]string `json:"properties"`
+			}
+
+			if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+				t.Fatalf("got log %q; want a single entry: %v", out.String(), err)
+			}
+
+			if entry.Message != errInvalidUTF8.Error() || entry.Properties["movie_id"] != "7" || entry.Properties["fields"] != "title" || entry.Properties["action"] != tt.mode {
+				t.Errorf("got log entry %+v; want movie 7, its title, and action %s", entry, tt.mode)
+			}
+		})
+	}
+}
+
+func TestSanitizeMovieLeavesTheOriginalAlone(t *testing.T) {
+	app, _ := newConfiguredTestApplication(t, map[string]string{"RESPONSE_INVALID_UTF8": utf8Drop})
+	app.logger = jsonlog.New(&bytes.Buffer{}, jsonlog.LevelInfo)
+
+	movie := &data.Movie{ID: 7, Title: "Am\xe9lie", Slug: "am\xe9lie", Genres: []string{"Com\xe9die", "Drama"}}
+
+	sanitized := app.sanitizeMovie(httptest.NewRequest(http.MethodGet, "/v1/movies/7", nil), movie)
+
+	if sanitized.Title != "Amlie" || sanitized.Slug != "amlie" || !slices.Equal(sanitized.Genres, []string{"Comdie", "Drama"}) {
+		t.Errorf("got %+v; want every text field sanitized", sanitized)
+	}
+	if movie.Title != "Am\xe9lie" || movie.Genres[0] != "Com\xe9die" {
+		t.Errorf("got the cached movie changed to %+v; want a sanitized copy", movie)
+	}
+
+	clean := &data.Movie{ID: 8, Title: "Heat", Genres: []string{"Crime"}}
+
+	if got := app.sanitizeMovie(httptest.NewRequest(http.MethodGet, "/v1/movies/8", nil), clean); got != clean {
+		t.Error("got a copy of a clean movie; want it returned as it is")
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"greenlight/internal/jsonschema"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+)
+
+//go:embed "schemas"
+var schemaFS embed.FS
+
+// schemas holds the embedded JSON Schemas for request bodies, keyed by file name without the
+// .json extension. They are parsed once at startup, so a broken schema fails fast.
+var schemas = mustLoadSchemas()
+
+func mustLoadSchemas() map[string]*jsonschema.Schema {
+	entries, err := schemaFS.ReadDir("schemas")
+	if err != nil {
+		panic(err)
+	}
+
+	loaded := make(map[string]*jsonschema.Schema)
+
+	for _, entry := range entries {
+		data, err := schemaFS.ReadFile(path.Join("schemas", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+
+		schema, err := jsonschema.Parse(data)
+		if err != nil {
+			panic(fmt.Sprintf("invalid schema %s: %s", entry.Name(), err))
+		}
+
+		loaded[strings.TrimSuffix(entry.Name(), ".json")] = schema
+	}
+
+	return loaded
+}
+
+// validateSchema checks the request body against the named schema before next runs. This
+// only checks the shape of the payload, with errors keyed by JSON pointer; business rules
+// are still enforced by the data package validators. Bodies which are not valid JSON at all
+// are passed through so that readJSON reports them as usual.
+func (app *application) validateSchema(name string, next http.HandlerFunc) http.HandlerFunc {
+	schema, ok := schemas[name]
+	if !ok {
+		panic("unknown request schema: " + name)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.jsonSchema.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		maxBytes := app.config.requests.maxBodyBytes
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxBytes)))
+		if err != nil {
+			app.requestEntityTooLargeResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxBytes))
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		errs, err := schema.ValidateJSON(body)
+		if err == nil && len(errs) > 0 {
+			app.failedValidationResponse(w, r, errs)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
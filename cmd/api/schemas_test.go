@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSchemaValidationReportsTypeMismatches(t *testing.T) {
+	body := `{"title": "Arrival", "year": "2016", "runtime": "116 mins", "genres": ["Drama"]}`
+
+	tests := []struct {
+		name      string
+		enabled   string
+		wantCode  int
+		wantError string
+	}{
+		{"generic decode error", "false", http.StatusBadRequest, `body contains an incorrect JSON type for field \"year\"`},
+		{"schema error", "true", http.StatusUnprocessableEntity, `{"/year":"must be of type integer"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := newConfiguredTestApplication(t, map[string]string{"JSON_SCHEMA_ENABLED": tt.enabled})
+
+			r := asUser(app, httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(body)), testUser)
+
+			rr := serve(t, app.validateSchema("create_movie", app.createMovieHandler), r)
+			if rr.Code != tt.wantCode {
+				t.Fatalf("got status %d; want %d: %s", rr.Code, tt.wantCode, rr.Body)
+			}
+
+			var got struct {
+				Error json.RawMessage `json:"error"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(string(got.Error), tt.wantError) {
+				t.Errorf("got error %s; want %s", got.Error, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestSchemaValidationPassesTheBodyOn(t *testing.T) {
+	app, _ := newConfiguredTestApplication(t, map[string]string{"JSON_SCHEMA_ENABLED": "true"})
+
+	var input struct {
+		Title string `json:"title"`
+	}
+
+	h := app.validateSchema("update_movie", func(w http.ResponseWriter, r *http.Request) {
+		if err := app.readJSON(w, r, &input); err != nil {
+			t.Errorf("readJSON: %s", err)
+		}
+	})
+
+	serve(t, h, httptest.NewRequest(http.MethodPatch, "/v1/movies/1", strings.NewReader(`{"title": "Arrival"}`)))
+
+	if input.Title != "Arrival" {
+		t.Errorf("got title %q; want the body decoded after validation", input.Title)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+	"net/http"
+)
+
+func (app *application) createSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name     string   `json:"name"`
+		Title    string   `json:"title"`
+		Genres   []string `json:"genres"`
+		Sort     string   `json:"sort"`
+		PageSize int      `json:"page_size"`
+	}
+
+	input.Genres = []string{}
+	input.Sort = "id"
+	input.PageSize = 20
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	search := &data.SavedSearch{
+		UserID:   user.ID,
+		Name:     input.Name,
+		Title:    input.Title,
+		Genres:   input.Genres,
+		Sort:     input.Sort,
+		PageSize: input.PageSize,
+	}
+
+	v := validator.New()
+
+	if data.ValidateSavedSearch(v, search, movieSortSafeList); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	count, err := app.models.SavedSearches.CountForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if count >= app.config.savedSearches.maxPerUser {
+		v.AddError("name", fmt.Sprintf("must not have more than %d saved searches", app.config.savedSearches.maxPerUser))
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.SavedSearches.Insert(search)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", app.absoluteURL(r, fmt.Sprintf("/v1/users/me/searches/%d/results", search.ID)))
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"saved_search": search}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listSavedSearchesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	searches, err := app.models.SavedSearches.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"saved_searches": searches}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) savedSearchResultsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	search, err := app.models.SavedSearches.GetForUser(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	filters := search.Filters(app.readInt(qs, "page", 1, v), movieSortSafeList)
+	filters.Cursor = app.readString(qs, "cursor", "")
+
+	app.listMovies(w, r, v, search.Title, data.GenreFilter{Genres: search.Genres, Mode: data.GenresModeAll}, data.YearRange{}, filters)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"greenlight/internal/data"
+	"greenlight/internal/sqlfake"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// savedSearchStore answers the saved search queries from memory.
+type savedSearchStore struct {
+	searches []*data.SavedSearch
+	// listArgs are the arguments of the last movie list query.
+	listArgs []any
+}
+
+func (s *savedSearchStore) row(search *data.SavedSearch) []any {
+	return []any{search.ID, testEpoch, search.UserID, search.Name, search.Title, "{" + strings.Join(search.Genres, ",") + "}", search.Sort, int64(search.PageSize)}
+}
+
+func (s *savedSearchStore) handle(query string, args []any) (*sqlfake.Result, error) {
+	switch {
+	case strings.Contains(query, "INSERT INTO saved_searches"):
+		search := &data.SavedSearch{
+			ID: int64(len(s.searches) + 1), UserID: args[0].(int64), Name: args[1].(string), Title: args[2].(string),
+			Genres: args[3].([]string), Sort: args[4].(string), PageSize: args[5].(int),
+		}
+		s.searches = append(s.searches, search)
+		return &sqlfake.Result{Rows: [][]any{{search.ID, testEpoch}}}, nil
+
+	case strings.Contains(query, "SELECT count(*)") && strings.Contains(query, "saved_searches"):
+		count := 0
+		for _, search := range s.searches {
+			if search.UserID == args[0].(int64) {
+				count++
+			}
+		}
+		return &sqlfake.Result{Rows: [][]any{{int64(count)}}}, nil
+
+	case strings.Contains(query, "FROM saved_searches") && strings.Contains(query, "id = $1 AND user_id = $2"):
+		res := &sqlfake.Result{}
+		for _, search := range s.searches {
+			if search.ID == args[0].(int64) && search.UserID == args[1].(int64) {
+				res.Rows = append(res.Rows, s.row(search))
+			}
+		}
+		return res, nil
+
+	case strings.Contains(query, "FROM saved_searches"):
+		res := &sqlfake.Result{}
+		for _, search := range s.searches {
+			if search.UserID == args[0].(int64) {
+				res.Rows = append(res.Rows, s.row(search))
+			}
+		}
+		return res, nil
+
+	case strings.Contains(query, "FROM movies"):
+		s.listArgs = args
+		return listRows(1, &data.Movie{ID: 1, Title: "Alien", Slug: "alien", Year: 1979, Runtime: 117, Genres: []string{"Horror"}, Version: 1}), nil
+	}
+
+	return nil, nil
+}
+
+func TestSavedSearches(t *testing.T) {
+	app, clk := newConfiguredTestApplication(t, nil)
+
+	store := &savedSearchStore{}
+	useTestDB(t, app, clk, store.handle)
+
+	alice := testUser
+	bob := &data.User{ID: 2, Name: "Bob", Email: "bob@example.com", Activated: true}
+
+	do := func(handler http.HandlerFunc, user *data.User, method, url, body string, params ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		return serve(t, handler, withParams(asUser(app, r, user), params...))
+	}
+
+	if rr := do(app.createSavedSearchHandler, alice, http.MethodPost, "/v1/users/me/searches", `{"name": "bad", "sort": "-budget"}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("invalid sort: got status %d; want %d", rr.Code, http.StatusUnprocessableEntity)
+	}
+
+	rr := do(app.createSavedSearchHandler, alice, http.MethodPost, "/v1/users/me/searches", `{"name": "scary", "title": "alien", "genres": ["Horror"], "sort": "-year", "page_size": 5}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("save: got status %d; want %d: %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+	if got := rr.Header().Get("Location"); !strings.HasSuffix(got, "/v1/users/me/searches/1/results") {
+		t.Errorf("save: got Location %q", got)
+	}
+
+	var list struct {
+		SavedSearches []data.SavedSearch `json:"saved_searches"`
+	}
+
+	rr = do(app.listSavedSearchesHandler, alice, http.MethodGet, "/v1/users/me/searches", "")
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.SavedSearches) != 1 || list.SavedSearches[0].Name != "scary" || !slices.Equal(list.SavedSearches[0].Genres, []string{"Horror"}) {
+		t.Errorf("list: got %+v", list.SavedSearches)
+	}
+
+	rr = do(app.listSavedSearchesHandler, bob, http.MethodGet, "/v1/users/me/searches", "")
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.SavedSearches) != 0 {
+		t.Errorf("another user's list: got %+v", list.SavedSearches)
+	}
+
+	rr = do(app.savedSearchResultsHandler, alice, http.MethodGet, "/v1/users/me/searches/1/results?page=2", "", "id", "1")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("results: got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	args := store.listArgs
+	if args[0] != "alien" || !slices.Equal(args[1].([]string), []string{"Horror"}) || args[2] != 5 || args[3] != 5 {
+		t.Errorf("results: queried title %v, genres %v, limit %v and offset %v", args[0], args[1], args[2], args[3])
+	}
+	if !strings.Contains(rr.Body.String(), `"Alien"`) {
+		t.Errorf("results: got body %s", rr.Body)
+	}
+
+	if rr := do(app.savedSearchResultsHandler, bob, http.MethodGet, "/v1/users/me/searches/1/results", "", "id", "1"); rr.Code != http.StatusNotFound {
+		t.Errorf("another user's results: got status %d; want %d", rr.Code, http.StatusNotFound)
+	}
+}
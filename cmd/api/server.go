@@ -5,11 +5,15 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
+
+	"github.com/quic-go/quic-go/http3"
 )
 
 func (app *application) serve() error {
@@ -20,10 +24,49 @@ func (app *application) serve() error {
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
+		TLSConfig:    app.config.tls.config,
+	}
+
+	var h3 *http3.Server
+	if app.config.tls.http3 {
+		h3 = newHTTP3Server(srv)
 	}
 
 	shutdownError := make(chan error)
 
+	dispatchCtx, stopDispatch := context.WithCancel(context.Background())
+
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		app.dispatchOutbox(dispatchCtx)
+	}()
+
+	if app.config.popularity.flushInterval > 0 {
+		app.wg.Add(1)
+		go func() {
+			defer app.wg.Done()
+			app.flushViews(dispatchCtx, app.config.popularity.flushInterval)
+		}()
+	}
+
+	// Only the Postgres store needs its expired keys removed, as Redis expires them itself.
+	if app.config.idempotency.backend == "postgres" {
+		app.wg.Add(1)
+		go func() {
+			defer app.wg.Done()
+			app.sweepIdempotencyKeys(dispatchCtx, time.Hour)
+		}()
+	}
+
+	if app.config.users.softDelete && app.config.users.deletedRetention > 0 {
+		app.wg.Add(1)
+		go func() {
+			defer app.wg.Done()
+			app.purgeDeletedUsers(dispatchCtx, time.Hour)
+		}()
+	}
+
 	go func() {
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -33,28 +76,118 @@ func (app *application) serve() error {
 			"signal": s.String(),
 		})
 
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		// Keep serving for the drain delay while the readiness check reports draining, so
+		// that load balancers stop routing new requests here before the listener closes.
+		app.setState(stateDraining)
+		time.Sleep(app.config.shutdown.drainDelay)
+
+		// Closing the server and completing the background tasks share the shutdown timeout,
+		// so that a slow email send cannot keep the process from exiting past it.
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdown.timeout)
 		defer cancel()
 
-		err := srv.Shutdown(ctx)
-		if err != nil {
-			shutdownError <- err
+		// Event streams only end when the client disconnects, so they are closed for the
+		// shutdown not to wait on them.
+		app.movieEvents.Close()
+
+		// HTTP/3 clients are sent a GOAWAY, and are expected to close their connections once
+		// their requests complete. The connections clients keep open past the shutdown timeout
+		// are closed, without failing the shutdown since HTTP/3 is only an alternative.
+		h3ShutdownError := make(chan error, 1)
+		if h3 != nil {
+			go func() {
+				h3ShutdownError <- h3.Shutdown(ctx)
+			}()
+		} else {
+			h3ShutdownError <- nil
+		}
+
+		shutdownErr := srv.Shutdown(ctx)
+
+		if err := <-h3ShutdownError; err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"addr":     srv.Addr,
+				"protocol": "http3",
+			})
 		}
 
 		app.logger.PrintInfo("completing background tasks", map[string]string{
 			"addr": srv.Addr,
 		})
 
-		app.wg.Wait()
-		shutdownError <- nil
+		stopDispatch()
+
+		if !app.completeBackgroundTasks(ctx) {
+			app.logger.PrintError(errors.New("background tasks did not complete within the shutdown timeout"), map[string]string{
+				"timeout": app.config.shutdown.timeout.String(),
+			})
+		}
+
+		shutdownError <- shutdownErr
 	}()
 
+	useTLS := app.config.tls.certFile != ""
+
+	// The certificate is served through GetCertificate, so that SIGHUP can swap it.
+	if useTLS {
+		reloader, err := newCertReloader(app.config.tls.certFile, app.config.tls.keyFile)
+		if err != nil {
+			return err
+		}
+
+		srv.TLSConfig = srv.TLSConfig.Clone()
+		srv.TLSConfig.GetCertificate = reloader.getCertificate
+
+		if h3 != nil {
+			h3.TLSConfig = srv.TLSConfig
+		}
+
+		go app.reloadCertificates(dispatchCtx, reloader)
+	}
+
 	app.logger.PrintInfo("Starting server", map[string]string{
-		"addr": srv.Addr,
-		"env":  app.config.env,
+		"addr":  srv.Addr,
+		"env":   app.config.env,
+		"tls":   strconv.FormatBool(useTLS),
+		"http3": strconv.FormatBool(h3 != nil),
 	})
 
-	err := srv.ListenAndServe()
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+
+	if app.config.connLimiter.enabled {
+		ln = newConnLimitListener(ln, app.clock, app.config.connLimiter.rps, app.config.connLimiter.burst)
+	}
+
+	// HTTP/3 is served on the UDP port with the same number, which every response
+	// advertises with Alt-Svc.
+	if h3 != nil {
+		conn, err := net.ListenPacket("udp", srv.Addr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		go func() {
+			err := h3.Serve(conn)
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.PrintError(err, map[string]string{
+					"addr":     srv.Addr,
+					"protocol": "http3",
+				})
+			}
+		}()
+	}
+
+	app.setState(stateServing)
+
+	if useTLS {
+		err = srv.ServeTLS(ln, "", "")
+	} else {
+		err = srv.Serve(ln)
+	}
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
@@ -64,9 +197,41 @@ func (app *application) serve() error {
 		return err
 	}
 
+	app.setState(stateStopped)
+
 	app.logger.PrintInfo("stopped server", map[string]string{
 		"addr": srv.Addr,
 	})
 
 	return nil
 }
+
+// newHTTP3Server returns a server answering HTTP/3 requests with the handler of srv, on the
+// same address. Its TLS configuration must be set before it serves.
+func newHTTP3Server(srv *http.Server) *http3.Server {
+	return &http3.Server{
+		Addr:        srv.Addr,
+		Handler:     srv.Handler,
+		IdleTimeout: srv.IdleTimeout,
+	}
+}
+
+// completeBackgroundTasks waits for the background tasks and the mail queue to complete,
+// until ctx is done. It reports whether they completed in time. The dispatcher has stopped
+// queueing emails once the wait group is done, and the emails still in the queue are
+// returned to the outbox by its shutdown.
+func (app *application) completeBackgroundTasks(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		app.wg.Wait()
+		app.mailQueue.Shutdown(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"greenlight/internal/mailer"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+func TestCompleteBackgroundTasksGivesUpAtTheTimeout(t *testing.T) {
+	app, _ := newTestApplication(t)
+
+	release := make(chan struct{})
+	sending := make(chan struct{})
+
+	// The send ignores its context, as a stuck SMTP connection would.
+	app.mailQueue = mailer.NewQueue(func(ctx context.Context, recipient, templateFile string, data any) error {
+		close(sending)
+		<-release
+		return nil
+	}, 1, 1, time.Second)
+
+	if err := app.mailQueue.Send(context.Background(), mailer.Message{Recipient: "alice@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	<-sending
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	if app.completeBackgroundTasks(ctx) {
+		t.Fatal("got the tasks completed while an email was still being sent")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("got the wait to end after %s; want it bounded by the 50ms timeout", elapsed)
+	}
+
+	close(release)
+}
+
+func TestCompleteBackgroundTasksWaitsForThem(t *testing.T) {
+	app, _ := newTestApplication(t)
+	app.mailQueue = mailer.NewQueue(func(ctx context.Context, recipient, templateFile string, data any) error {
+		return nil
+	}, 1, 1, time.Second)
+
+	finished := false
+
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		time.Sleep(10 * time.Millisecond)
+		finished = true
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !app.completeBackgroundTasks(ctx) || !finished {
+		t.Error("got the wait to end before the background task completed")
+	}
+}
+
+func TestAdvertiseHTTP3(t *testing.T) {
+	app, _ := newTestApplication(t)
+	app.config.port = 4000
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	if rr := serve(t, app.advertiseHTTP3(next), httptest.NewRequest(http.MethodGet, "/", nil)); rr.Header().Get("Alt-Svc") != "" {
+		t.Errorf("disabled: got Alt-Svc %q; want none", rr.Header().Get("Alt-Svc"))
+	}
+
+	app.config.tls.http3 = true
+
+	if rr := serve(t, app.advertiseHTTP3(next), httptest.NewRequest(http.MethodGet, "/", nil)); rr.Header().Get("Alt-Svc") != `h3=":4000"; ma=86400` {
+		t.Errorf("enabled: got Alt-Svc %q; want h3 on port 4000", rr.Header().Get("Alt-Svc"))
+	}
+}
+
+func TestParseConfigHTTP3RequiresTLS(t *testing.T) {
+	_, err := parseConfig([]string{"-HTTP3_ENABLED=true"}, testEnv(nil))
+	if err == nil || !strings.Contains(err.Error(), "HTTP3_ENABLED requires TLS_CERT_FILE and TLS_KEY_FILE") {
+		t.Errorf("got error %v; want HTTP/3 without TLS to fail startup", err)
+	}
+
+	cfg, err := parseConfig([]string{"-HTTP3_ENABLED=true", "-TLS_CERT_FILE=cert.pem", "-TLS_KEY_FILE=key.pem"}, testEnv(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.tls.http3 {
+		t.Error("got HTTP/3 disabled; want it enabled")
+	}
+}
+
+func TestHTTP3ServesTheSameHandler(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	writeCertificate(t, certFile, keyFile, 1)
+
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app, _ := newTestApplication(t)
+	app.config.port = 4000
+	app.config.tls.http3 = true
+
+	app.config.tls.config, err = newTLSConfig("1.2", cipherPolicyIntermediate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &http.Server{
+		Handler: app.advertiseHTTP3(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, r.Proto)
+		})),
+		TLSConfig: app.config.tls.config.Clone(),
+	}
+	srv.TLSConfig.GetCertificate = reloader.getCertificate
+
+	h3 := newHTTP3Server(srv)
+	h3.TLSConfig = srv.TLSConfig
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	served := make(chan error, 1)
+	go func() {
+		served <- h3.Serve(conn)
+	}()
+
+	pem, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(pem)
+
+	transport := &http3.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: "localhost"}}
+
+	client := &http.Client{Transport: transport, Timeout: 5 * time.Second}
+
+	res, err := client.Get("https://" + conn.LocalAddr().String() + "/v1/healthcheck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != "HTTP/3.0" {
+		t.Errorf("got the request served over %q; want HTTP/3.0", body)
+	}
+	if got := res.Header.Get("Alt-Svc"); got != `h3=":4000"; ma=86400` {
+		t.Errorf("got Alt-Svc %q; want h3 on port 4000", got)
+	}
+
+	// The connection is closed by the client, for the shutdown not to wait on it.
+	transport.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h3.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("got %v after shutting down; want %v", err, http.ErrServerClosed)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"greenlight/internal/clock"
+	"greenlight/internal/data"
+	"greenlight/internal/jsonlog"
+	"greenlight/internal/pubsub"
+	"greenlight/internal/sqlfake"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// testEpoch is the time the fake clocks of test applications start at.
+var testEpoch = time.Date(2024, time.April, 1, 12, 0, 0, 0, time.UTC)
+
+// newTestApplication returns an application with a fake clock and a logger which discards
+// everything, and no database. Tests set the config and dependencies they need.
+func newTestApplication(t *testing.T) (*application, *clock.Fake) {
+	t.Helper()
+
+	clk := clock.NewFake(testEpoch)
+
+	app := &application{
+		logger: jsonlog.New(io.Discard, jsonlog.LevelOff),
+		clock:  clk,
+	}
+
+	return app, clk
+}
+
+// newConfiguredTestApplication is like newTestApplication, but with every setting at its
+// default as parsed from testEnv, with the overrides applied.
+func newConfiguredTestApplication(t *testing.T, overrides map[string]string) (*application, *clock.Fake) {
+	t.Helper()
+
+	cfg, err := parseConfig(nil, testEnv(overrides))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app, clk := newTestApplication(t)
+	app.config = cfg
+	app.movieEvents = pubsub.New[movieEvent]()
+
+	return app, clk
+}
+
+// asUser returns a copy of r made by user.
+func asUser(app *application, r *http.Request, user *data.User) *http.Request {
+	return app.contextSetUser(r, user)
+}
+
+// withParams returns a copy of r carrying the route parameters, given as name, value pairs.
+func withParams(r *http.Request, pairs ...string) *http.Request {
+	var params httprouter.Params
+	for i := 0; i+1 < len(pairs); i += 2 {
+		params = append(params, httprouter.Param{Key: pairs[i], Value: pairs[i+1]})
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, params))
+}
+
+// useTestDB gives the application models whose queries are answered by h.
+func useTestDB(t *testing.T, app *application, clk clock.Clock, h sqlfake.Handler) {
+	t.Helper()
+
+	db := sqlfake.Open(h)
+
+	// Background tasks, such as webhook dispatch, may still be querying the database.
+	t.Cleanup(func() {
+		app.wg.Wait()
+		db.Close()
+	})
+
+	app.db = &data.DB{DB: db}
+	app.models = data.NewModels(app.db, clk)
+}
+
+// serve sends the request to the handler and returns the recorded response.
+func serve(t *testing.T, h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+
+	return rr
+}
+
+// runUntilCancelled runs job in the background until it has signalled calls twice, then
+// cancels its context and fails the test unless it returns promptly.
+func runUntilCancelled(t *testing.T, calls <-chan struct{}, job func(ctx context.Context)) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		job(ctx)
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-calls:
+		case <-time.After(5 * time.Second):
+			cancel()
+			t.Fatal("the job did not run")
+		}
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the job did not stop once its context was cancelled")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// Cipher suite policies, named after the Mozilla server side TLS recommendations. The
+// modern policy only allows TLS 1.3, whose cipher suites Go does not let us configure as
+// they are all considered secure.
+const (
+	cipherPolicyModern       = "modern"
+	cipherPolicyIntermediate = "intermediate"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// intermediateCipherSuites are the TLS 1.2 suites allowed by the intermediate policy: only
+// forward secret AEAD suites.
+var intermediateCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// newTLSConfig builds the server TLS configuration for a minimum version and a cipher suite
+// policy, rejecting combinations which would not be applied as configured.
+func newTLSConfig(minVersion, policy string) (*tls.Config, error) {
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return nil, fmt.Errorf("unsupported minimum TLS version %q", minVersion)
+	}
+
+	config := &tls.Config{MinVersion: version}
+
+	switch policy {
+	case cipherPolicyModern:
+		if version != tls.VersionTLS13 {
+			return nil, fmt.Errorf("the %s cipher policy requires a minimum TLS version of 1.3", policy)
+		}
+	case cipherPolicyIntermediate:
+		if version == tls.VersionTLS12 {
+			config.CipherSuites = intermediateCipherSuites
+		}
+	default:
+		return nil, fmt.Errorf("unknown cipher policy %q", policy)
+	}
+
+	return config, nil
+}
+
+// certReloader serves the certificate loaded from certFile and keyFile, which can be loaded
+// again while the server runs so that certificates are rotated without a restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+
+	err := reloader.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+// reload loads the certificate and key again. When either cannot be loaded the certificate
+// served before is kept.
+func (cr *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+
+	cr.cert.Store(&cert)
+
+	return nil
+}
+
+// getCertificate is used as the GetCertificate callback of the server TLS configuration.
+func (cr *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return cr.cert.Load(), nil
+}
+
+// reloadCertificates reloads the certificate of cr each time the process receives SIGHUP,
+// until ctx is cancelled.
+func (app *application) reloadCertificates(ctx context.Context, cr *certReloader) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	properties := map[string]string{"cert_file": cr.certFile, "key_file": cr.keyFile}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			err := cr.reload()
+			if err != nil {
+				app.logger.PrintError(err, properties)
+				continue
+			}
+
+			app.logger.PrintInfo("TLS certificate reloaded", properties)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNewTLSConfig(t *testing.T) {
+	tests := []struct {
+		minVersion  string
+		policy      string
+		wantVersion uint16
+		wantSuites  []uint16
+	}{
+		{"1.2", cipherPolicyIntermediate, tls.VersionTLS12, intermediateCipherSuites},
+		{"1.3", cipherPolicyIntermediate, tls.VersionTLS13, nil},
+		{"1.3", cipherPolicyModern, tls.VersionTLS13, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.minVersion+"/"+tt.policy, func(t *testing.T) {
+			config, err := newTLSConfig(tt.minVersion, tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if config.MinVersion != tt.wantVersion {
+				t.Errorf("got minimum version %#x; want %#x", config.MinVersion, tt.wantVersion)
+			}
+			if !slices.Equal(config.CipherSuites, tt.wantSuites) {
+				t.Errorf("got cipher suites %v; want %v", config.CipherSuites, tt.wantSuites)
+			}
+		})
+	}
+}
+
+func TestNewTLSConfigRejectsInsecureCombinations(t *testing.T) {
+	tests := []struct {
+		minVersion string
+		policy     string
+		want       string
+	}{
+		{"1.0", cipherPolicyIntermediate, `unsupported minimum TLS version "1.0"`},
+		{"1.1", cipherPolicyIntermediate, `unsupported minimum TLS version "1.1"`},
+		{"1.2", cipherPolicyModern, "requires a minimum TLS version of 1.3"},
+		{"1.2", "old", `unknown cipher policy "old"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.minVersion+"/"+tt.policy, func(t *testing.T) {
+			_, err := newTLSConfig(tt.minVersion, tt.policy)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v; want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseConfigTLSPolicy(t *testing.T) {
+	cfg, err := parseConfig([]string{"-TLS_MIN_VERSION=1.3", "-TLS_CIPHER_POLICY=modern"}, testEnv(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.tls.config.MinVersion != tls.VersionTLS13 {
+		t.Errorf("got minimum version %#x; want TLS 1.3", cfg.tls.config.MinVersion)
+	}
+
+	_, err = parseConfig(nil, testEnv(map[string]string{"TLS_CIPHER_POLICY": "legacy"}))
+	if err == nil || !strings.Contains(err.Error(), "invalid TLS configuration") {
+		t.Errorf("got error %v; want the invalid policy to fail startup", err)
+	}
+}
+
+// writeCertificate writes a new self-signed certificate for localhost, with the serial
+// number, and its key to certFile and keyFile.
+func writeCertificate(t *testing.T, certFile, keyFile string, serial int64) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// servedSerial returns the serial number of the certificate the reloader serves.
+func servedSerial(t *testing.T, cr *certReloader) int64 {
+	t.Helper()
+
+	cert, err := cr.getCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return leaf.SerialNumber.Int64()
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	if _, err := newCertReloader(certFile, keyFile); err == nil {
+		t.Fatal("got a reloader for missing files")
+	}
+
+	writeCertificate(t, certFile, keyFile, 1)
+
+	cr, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeCertificate(t, certFile, keyFile, 2)
+
+	if got := servedSerial(t, cr); got != 1 {
+		t.Errorf("before reloading: got serial %d; want 1", got)
+	}
+	if err := cr.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := servedSerial(t, cr); got != 2 {
+		t.Errorf("after reloading: got serial %d; want 2", got)
+	}
+
+	// A broken certificate is rejected, and the one served before is kept.
+	if err := os.WriteFile(certFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := cr.reload(); err == nil {
+		t.Error("got no error reloading a broken certificate")
+	}
+	if got := servedSerial(t, cr); got != 2 {
+		t.Errorf("after a failed reload: got serial %d; want 2", got)
+	}
+}
+
+func TestCertificatesReloadOnSIGHUP(t *testing.T) {
+	// SIGHUP ends the process unless it is being notified, which the reloader may not have
+	// started doing yet when the first one is sent.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	writeCertificate(t, certFile, keyFile, 1)
+
+	cr, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app, _ := newTestApplication(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		app.reloadCertificates(ctx, cr)
+	}()
+
+	writeCertificate(t, certFile, keyFile, 2)
+
+	for deadline := time.Now().Add(5 * time.Second); servedSerial(t, cr) != 2; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the certificate was not reloaded on SIGHUP")
+		}
+		syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	}
+
+	cancel()
+	<-done
+
+	// The server presents the reloaded certificate in new handshakes.
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{GetCertificate: cr.getCertificate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if got := conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(); got != 2 {
+		t.Errorf("got serial %d in the handshake; want 2", got)
+	}
+}
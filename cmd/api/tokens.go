@@ -3,8 +3,10 @@ package main
 import (
 	"errors"
 	"greenlight/internal/data"
+	"greenlight/internal/jwt"
 	"greenlight/internal/validator"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -44,32 +46,93 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	token, err := app.models.Tokens.New(user.ID, app.config.tokens.activationTTL, data.ScopeActivation)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	app.background(func() {
-		data := map[string]any{
-			"activationToken": token.Plaintext,
+	err = app.enqueueUserEmail(user, data.EmailEssential, "token_activation.tmpl", map[string]any{
+		"activationToken": token.Plaintext,
+		"tokenExpiry":     humanDuration(app.config.tokens.activationTTL),
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"message": "an email will be sent to you containing activation instructions"}
+
+	err = app.writeResponse(w, r, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createPasswordResetTokenHandler mails a password reset token to the user with the email
+// address, if they are activated. The response is the same whether or not they are, so that
+// it does not reveal which addresses are registered.
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	env := envelope{"message": "if an activated account exists for this email address, an email will be sent to it containing password reset instructions"}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeResponse(w, r, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
 		}
+		return
+	}
 
-		err = app.mailer.Send(user.Email, "token_activation.tmpl", data)
+	if user.Activated {
+		token, err := app.models.Tokens.New(user.ID, app.config.tokens.passwordResetTTL, data.ScopePasswordReset)
 		if err != nil {
-			app.logger.PrintError(err, nil)
+			app.serverErrorResponse(w, r, err)
+			return
 		}
-	})
 
-	env := envelope{"message": "an email will be sent to you containing activation instructions"}
+		err = app.enqueueUserEmail(user, data.EmailEssential, "token_password_reset.tmpl", map[string]any{
+			"passwordResetToken": token.Plaintext,
+			"tokenExpiry":        humanDuration(app.config.tokens.passwordResetTTL),
+		})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
 
-	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	err = app.writeResponse(w, r, http.StatusAccepted, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+// readCredentials reads an email address and password from the request body and returns
+// the user they belong to. When they are invalid an error response has been written and
+// the returned user is nil.
+func (app *application) readCredentials(w http.ResponseWriter, r *http.Request) *data.User {
 	var input struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
@@ -78,7 +141,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	err := app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
-		return
+		return nil
 	}
 
 	v := validator.New()
@@ -88,7 +151,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
-		return
+		return nil
 	}
 
 	user, err := app.models.Users.GetByEmail(input.Email)
@@ -99,27 +162,74 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
-		return
+		return nil
 	}
 
 	match, err := user.Password.Matches(input.Password)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
-		return
+		return nil
 	}
 
 	if !match {
 		app.invalidCredentialsResponse(w, r)
+		return nil
+	}
+
+	return user
+}
+
+func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.readCredentials(w, r)
+	if user == nil {
+		return
+	}
+
+	token, err := app.models.Tokens.New(user.ID, app.config.tokens.authenticationTTL, data.ScopeAuthentication)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	totalLogins.Add(1)
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"authenticaton_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createJWTHandler issues a signed JWT for the user with the email address and password. It
+// is checked without a database lookup of the token, so it cannot be revoked before it
+// expires, which is why its lifetime is configured separately from stateful tokens.
+func (app *application) createJWTHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.readCredentials(w, r)
+	if user == nil {
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	now := app.clock.Now()
+	expiry := now.Add(app.config.auth.jwtTTL)
+
+	claims := jwt.Claims{
+		Subject:   strconv.FormatInt(user.ID, 10),
+		Issuer:    app.config.auth.jwtIssuer,
+		ExpiresAt: expiry.Unix(),
+		NotBefore: now.Unix(),
+		IssuedAt:  now.Unix(),
+		Audience:  jwt.Audience{app.config.auth.jwtAudience},
+		Scope:     data.ScopeAuthentication,
+	}
+
+	token, err := jwt.Sign(claims, []byte(app.config.auth.jwtSecret))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authenticaton_token": token}, nil)
+	totalLogins.Add(1)
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"jwt": envelope{"token": token, "expiry": time.Unix(expiry.Unix(), 0)}}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
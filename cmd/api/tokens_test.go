@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"greenlight/internal/sqlfake"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokensUseTheConfiguredLifetimes(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    func(*application) http.HandlerFunc
+		body       string
+		wantStatus int
+		wantTTL    time.Duration
+		wantEmail  string
+	}{
+		{"authentication", func(app *application) http.HandlerFunc { return app.createAuthenticationTokenHandler }, `{"email": "alice@example.com", "password": "pa55word"}`, http.StatusCreated, 10 * time.Minute, ""},
+		{"password reset", func(app *application) http.HandlerFunc { return app.createPasswordResetTokenHandler }, `{"email": "alice@example.com"}`, http.StatusAccepted, 5 * time.Minute, "5 minutes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, clk := newConfiguredTestApplication(t, map[string]string{
+				"TOKEN_AUTH_TTL":           "10m",
+				"TOKEN_PASSWORD_RESET_TTL": "5m",
+			})
+
+			var expiry time.Time
+			var emailData []byte
+
+			store := newEmailStore(t)
+			useTestDB(t, app, clk, func(query string, args []any) (*sqlfake.Result, error) {
+				switch {
+				case strings.Contains(query, "INSERT INTO tokens"):
+					expiry = args[2].(time.Time)
+				case strings.Contains(query, "INSERT INTO email_outbox"):
+					emailData = args[3].([]byte)
+				}
+				return store.handle(query, args)
+			})
+
+			r := httptest.NewRequest(http.MethodPost, "/v1/tokens", strings.NewReader(tt.body))
+			if rr := serve(t, tt.handler(app), r); rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d; want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+
+			if want := clk.Now().Add(tt.wantTTL); !expiry.Equal(want) {
+				t.Errorf("got expiry %s; want %s", expiry, want)
+			}
+
+			if tt.wantEmail == "" {
+				return
+			}
+
+			var sent map[string]any
+			if err := json.Unmarshal(emailData, &sent); err != nil {
+				t.Fatal(err)
+			}
+
+			if sent["tokenExpiry"] != tt.wantEmail {
+				t.Errorf("got the email saying the token expires in %v; want %q", sent["tokenExpiry"], tt.wantEmail)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"greenlight/internal/jsonlog"
+	"greenlight/internal/tracing"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTracingSamplesErrorsAndSlowRequests(t *testing.T) {
+	tests := []struct {
+		name        string
+		rate        string
+		status      int
+		duration    time.Duration
+		traceparent string
+		wantSampled bool
+	}{
+		{"unsampled", "0", http.StatusOK, 0, "", false},
+		{"server error", "0", http.StatusInternalServerError, 0, "", true},
+		{"client error", "0", http.StatusNotFound, 0, "", false},
+		{"slow", "0", http.StatusOK, 2 * time.Second, "", true},
+		{"sampled parent", "0", http.StatusOK, 0, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", true},
+		{"unsampled parent", "1", http.StatusOK, 0, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00", false},
+		{"every trace", "1", http.StatusOK, 0, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, clk := newConfiguredTestApplication(t, map[string]string{"OTEL_SAMPLE_RATE": tt.rate, "OTEL_SLOW_THRESHOLD": "1s"})
+
+			var out bytes.Buffer
+			app.tracer = newTracer(app.config, jsonlog.New(&out, jsonlog.LevelInfo))
+
+			h := app.trace(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				clk.Advance(tt.duration)
+				w.WriteHeader(tt.status)
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+			if tt.traceparent != "" {
+				r.Header.Set("traceparent", tt.traceparent)
+			}
+
+			rr := serve(t, h, r)
+
+			if sampled := strings.Contains(out.String(), `"GET /v1/movies"`); sampled != tt.wantSampled {
+				t.Errorf("got span exported %t; want %t", sampled, tt.wantSampled)
+			}
+			if tt.traceparent != "" && !strings.Contains(rr.Header().Get("traceparent"), "0af7651916cd43dd8448eb211c80319c") {
+				t.Errorf("got traceparent %q; want the parent's trace", rr.Header().Get("traceparent"))
+			}
+		})
+	}
+}
+
+func TestNewTracerUsesTheConfiguredSampleRate(t *testing.T) {
+	app, _ := newConfiguredTestApplication(t, map[string]string{"OTEL_SAMPLE_RATE": "0.25"})
+
+	tracer := newTracer(app.config, app.logger)
+
+	if want := (tracing.ParentBasedRatio{Ratio: 0.25}); tracer.Sampler != want {
+		t.Errorf("got sampler %#v; want %#v", tracer.Sampler, want)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+	"greenlight/internal/webhook"
+	"maps"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (app *application) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+		Fields []string `json:"fields"`
+		Filter string   `json:"filter"`
+	}
+
+	input.Fields = []string{}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	hook := &data.Webhook{
+		URL:    input.URL,
+		Events: input.Events,
+		Fields: input.Fields,
+		Filter: input.Filter,
+	}
+
+	v := validator.New()
+
+	if data.ValidateWebhook(v, hook); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Webhooks.Insert(hook)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"webhook": hook}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	hooks, err := app.models.Webhooks.GetAll("")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"webhooks": hooks}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Webhooks.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "webhook successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// dispatchWebhooks notifies every webhook subscribed to event in the background. Each
+// webhook only fires when the movie matches its filter, and only receives its selected
+// fields.
+func (app *application) dispatchWebhooks(event string, movie *data.Movie) {
+	payload, err := webhook.ToPayload(movie)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	// Filters compare the runtime in minutes, rather than as the "107 mins" string that the
+	// payload carries.
+	fields := maps.Clone(payload)
+	if movie.Runtime != 0 {
+		fields["runtime"] = float64(movie.Runtime)
+	}
+
+	timestamp := app.clock.Now().UTC()
+
+	app.background(func() {
+		hooks, err := app.models.Webhooks.GetAll(event)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			return
+		}
+
+		for _, hook := range hooks {
+			filter, err := webhook.ParseFilter(hook.Filter, data.WebhookFields)
+			if err != nil || !filter.Match(fields) {
+				continue
+			}
+
+			app.deliverWebhook(hook.ID, hook.URL, webhook.Event{
+				Type:      event,
+				Timestamp: timestamp,
+				Data:      webhook.Project(payload, hook.Fields),
+			})
+		}
+	})
+}
+
+// deliverWebhook sends event to the webhook's URL, retrying failed deliveries up to
+// app.config.webhooks.maxAttempts times in all with a doubling delay in between. A delivery
+// which fails every attempt is dead-lettered. It blocks, so it should be run in the
+// background.
+func (app *application) deliverWebhook(hookID int64, url string, event webhook.Event) {
+	backoff := app.config.webhooks.retryBackoff
+
+	var sendErr error
+
+	for attempt := 1; attempt <= app.config.webhooks.maxAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.webhooks.timeout)
+		sendErr = app.webhooks.Send(ctx, url, event)
+		cancel()
+
+		if sendErr == nil {
+			return
+		}
+
+		app.logger.PrintError(sendErr, map[string]string{
+			"webhook_id": fmt.Sprint(hookID),
+			"event":      event.Type,
+			"attempt":    strconv.Itoa(attempt),
+		})
+	}
+
+	js, err := json.Marshal(event)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	err = app.deadLetter(&data.DeadLetter{
+		Kind:      data.DeadLetterWebhook,
+		SourceID:  hookID,
+		Target:    url,
+		Payload:   js,
+		Attempts:  app.config.webhooks.maxAttempts,
+		LastError: sendErr.Error(),
+	})
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"webhook_id": fmt.Sprint(hookID)})
+	}
+}
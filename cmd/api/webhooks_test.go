@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"greenlight/internal/data"
+	"greenlight/internal/sqlfake"
+	"greenlight/internal/webhook"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDispatchWebhooksFiltersAndProjects(t *testing.T) {
+	var mu sync.Mutex
+	received := map[string]map[string]any{}
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event webhook.Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Error(err)
+		}
+
+		mu.Lock()
+		received[r.URL.Path] = event.Data
+		mu.Unlock()
+	}))
+	defer target.Close()
+
+	app, clk := newConfiguredTestApplication(t, map[string]string{"WEBHOOKS_MAX_ATTEMPTS": "1"})
+	app.webhooks = webhook.New(time.Second)
+
+	// The first filter was stored before array values were rejected, and must not keep the
+	// webhooks after it from firing.
+	hooks := [][]any{
+		{int64(1), testEpoch, target.URL + "/array", "{movie.created}", "{}", `$.genres == ["Drama"]`},
+		{int64(2), testEpoch, target.URL + "/drama", "{movie.created}", "{title}", `$.genres contains "Drama"`},
+		{int64(3), testEpoch, target.URL + "/horror", "{movie.created}", "{}", `$.genres contains "Horror"`},
+		{int64(4), testEpoch, target.URL + "/long", "{movie.created}", "{id,runtime}", `$.runtime > 100 && $.year >= 2000`},
+		{int64(5), testEpoch, target.URL + "/epic", "{movie.created}", "{}", `$.runtime > 200`},
+		{int64(6), testEpoch, target.URL + "/all", "{movie.created}", "{}", ""},
+	}
+
+	useTestDB(t, app, clk, func(query string, args []any) (*sqlfake.Result, error) {
+		return &sqlfake.Result{Rows: hooks}, nil
+	})
+
+	movie := &data.Movie{ID: 7, Title: "Amélie", Slug: "amelie", Year: 2001, Runtime: 122, Genres: []string{"Comedy", "Drama"}, Version: 1}
+
+	app.dispatchWebhooks(data.EventMovieCreated, movie)
+	app.wg.Wait()
+
+	want := map[string]map[string]any{
+		"/drama": {"title": "Amélie"},
+		"/long":  {"id": float64(7), "runtime": "122 mins"},
+	}
+
+	for path, fields := range want {
+		if got := received[path]; !reflect.DeepEqual(got, fields) {
+			t.Errorf("%s: got %v; want %v", path, got, fields)
+		}
+	}
+
+	if got := received["/all"]; got["title"] != "Amélie" || got["runtime"] != "122 mins" || len(got["genres"].([]any)) != 2 {
+		t.Errorf("/all: got %v; want every field", got)
+	}
+
+	for _, path := range []string{"/array", "/horror", "/epic"} {
+		if _, ok := received[path]; ok {
+			t.Errorf("%s: got an event for a movie its filter does not match", path)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"greenlight/internal/clock"
+	"sync"
+	"time"
+)
+
+type entry[V any] struct {
+	value    V
+	storedAt time.Time
+}
+
+// Cache is an in-memory cache which is safe for concurrent use. Entries do not expire on
+// their own: Get reports how old an entry is, and the caller decides whether it is still
+// fresh enough to use. Once MaxEntries is reached, entries older than MaxAge are dropped
+// to make room, and failing that an arbitrary entry is.
+type Cache[K comparable, V any] struct {
+	MaxEntries int
+	MaxAge     time.Duration
+	Clock      clock.Clock
+
+	mu         sync.Mutex
+	entries    map[K]entry[V]
+	refreshing map[K]bool
+}
+
+func New[K comparable, V any](maxEntries int, maxAge time.Duration, clk clock.Clock) *Cache[K, V] {
+	return &Cache[K, V]{
+		MaxEntries: maxEntries,
+		MaxAge:     maxAge,
+		Clock:      clk,
+		entries:    make(map[K]entry[V]),
+		refreshing: make(map[K]bool),
+	}
+}
+
+// Get returns the value stored under key and its age. Entries older than MaxAge are never
+// returned.
+func (c *Cache[K, V]) Get(key K) (V, time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		var zero V
+		return zero, 0, false
+	}
+
+	age := c.Clock.Now().Sub(e.storedAt)
+	if age > c.MaxAge {
+		delete(c.entries, key)
+
+		var zero V
+		return zero, 0, false
+	}
+
+	return e.value, age, true
+}
+
+func (c *Cache[K, V]) Set(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.Clock.Now()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.MaxEntries {
+		c.evict(now)
+	}
+
+	c.entries[key] = entry[V]{value: value, storedAt: now}
+}
+
+func (c *Cache[K, V]) evict(now time.Time) {
+	for key, e := range c.entries {
+		if now.Sub(e.storedAt) > c.MaxAge {
+			delete(c.entries, key)
+		}
+	}
+
+	for key := range c.entries {
+		if len(c.entries) < c.MaxEntries {
+			return
+		}
+
+		delete(c.entries, key)
+	}
+}
+
+func (c *Cache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+}
+
+// Clear removes every entry.
+func (c *Cache[K, V]) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.entries)
+}
+
+// StartRefresh reports whether the caller should refresh the entry for key, which is the
+// case unless a refresh is already under way. Every successful call must be followed by a
+// call to EndRefresh.
+func (c *Cache[K, V]) StartRefresh(key K) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.refreshing[key] {
+		return false
+	}
+
+	c.refreshing[key] = true
+	return true
+}
+
+func (c *Cache[K, V]) EndRefresh(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.refreshing, key)
+}
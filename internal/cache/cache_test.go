@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"greenlight/internal/clock"
+	"testing"
+	"time"
+)
+
+func TestGetReportsTheAgeOfEntries(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, time.April, 1, 12, 0, 0, 0, time.UTC))
+	c := New[string, int](10, time.Minute, clk)
+
+	c.Set("a", 1)
+	clk.Advance(30 * time.Second)
+
+	if v, age, ok := c.Get("a"); !ok || v != 1 || age != 30*time.Second {
+		t.Errorf("got %d, %s, %t; want 1, 30s, true", v, age, ok)
+	}
+
+	clk.Advance(31 * time.Second)
+
+	if _, _, ok := c.Get("a"); ok {
+		t.Error("got an entry older than MaxAge")
+	}
+	if _, _, ok := c.Get("missing"); ok {
+		t.Error("got an entry which was never set")
+	}
+
+	c.Set("b", 2)
+	c.Delete("b")
+
+	if _, _, ok := c.Get("b"); ok {
+		t.Error("got a deleted entry")
+	}
+
+	c.Set("c", 3)
+	c.Clear()
+
+	if _, _, ok := c.Get("c"); ok {
+		t.Error("got an entry after Clear")
+	}
+}
+
+func TestSetEvictsExpiredEntriesFirst(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, time.April, 1, 12, 0, 0, 0, time.UTC))
+	c := New[string, int](2, time.Minute, clk)
+
+	c.Set("old", 1)
+	clk.Advance(2 * time.Minute)
+	c.Set("fresh", 2)
+	c.Set("new", 3)
+
+	if _, _, ok := c.Get("fresh"); !ok {
+		t.Error("evicted a fresh entry while an expired one was left")
+	}
+	if _, _, ok := c.Get("new"); !ok {
+		t.Error("the new entry was not stored")
+	}
+
+	// Without expired entries, some entry makes room.
+	c.Set("newest", 4)
+
+	if n := len(c.entries); n != 2 {
+		t.Errorf("got %d entries; want MaxEntries of 2", n)
+	}
+	if _, _, ok := c.Get("newest"); !ok {
+		t.Error("the newest entry was not stored")
+	}
+
+	// Replacing an entry never evicts another.
+	c.Set("newest", 5)
+
+	if n := len(c.entries); n != 2 {
+		t.Errorf("after replacing: got %d entries; want 2", n)
+	}
+}
+
+func TestOnlyOneRefreshAtATime(t *testing.T) {
+	c := New[string, int](10, time.Minute, clock.Real{})
+
+	if !c.StartRefresh("a") {
+		t.Fatal("first refresh: got false; want true")
+	}
+	if c.StartRefresh("a") {
+		t.Error("second refresh while the first is under way: got true; want false")
+	}
+	if !c.StartRefresh("b") {
+		t.Error("refresh of another key: got false; want true")
+	}
+
+	c.EndRefresh("a")
+
+	if !c.StartRefresh("a") {
+		t.Error("refresh after the first ended: got false; want true")
+	}
+}
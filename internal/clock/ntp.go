@@ -0,0 +1,65 @@
+package clock
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+// ntpEpochOffset is the number of seconds between the NTP epoch (1900) and the Unix epoch.
+const ntpEpochOffset = 2208988800
+
+// NTPOffset asks the NTP server at addr, such as "pool.ntp.org:123", for the time using a
+// single SNTP request, and returns how far the local clock is behind it. A negative offset
+// means that the local clock is ahead.
+func NTPOffset(ctx context.Context, addr string) (time.Duration, error) {
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Leap indicator 0, version 3, client mode.
+	request := make([]byte, 48)
+	request[0] = 0x1B
+
+	sent := time.Now()
+
+	_, err = conn.Write(request)
+	if err != nil {
+		return 0, err
+	}
+
+	response := make([]byte, 48)
+
+	n, err := conn.Read(response)
+	if err != nil {
+		return 0, err
+	}
+
+	received := time.Now()
+
+	if n < 48 || response[0]&0x07 != 4 {
+		return 0, errors.New("clock: invalid NTP response")
+	}
+
+	serverReceived := ntpTime(response[32:40])
+	serverSent := ntpTime(response[40:48])
+
+	return (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2, nil
+}
+
+func ntpTime(b []byte) time.Time {
+	seconds := int64(binary.BigEndian.Uint32(b[:4])) - ntpEpochOffset
+	fraction := int64(binary.BigEndian.Uint32(b[4:]))
+
+	return time.Unix(seconds, fraction*int64(time.Second)>>32)
+}
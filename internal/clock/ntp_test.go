@@ -0,0 +1,86 @@
+package clock
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// serveNTP answers a single SNTP request on a local UDP port with the local time moved by
+// offset, or with a response in the given mode.
+func serveNTP(t *testing.T, offset time.Duration, mode byte) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		request := make([]byte, 48)
+
+		_, addr, err := conn.ReadFrom(request)
+		if err != nil {
+			return
+		}
+
+		putTime := func(b []byte, tm time.Time) {
+			binary.BigEndian.PutUint32(b[:4], uint32(tm.Unix()+ntpEpochOffset))
+			binary.BigEndian.PutUint32(b[4:], uint32((int64(tm.Nanosecond())<<32)/int64(time.Second)))
+		}
+
+		response := make([]byte, 48)
+		response[0] = 0x18 | mode
+		now := time.Now().Add(offset)
+		putTime(response[32:40], now)
+		putTime(response[40:48], now)
+
+		conn.WriteTo(response, addr)
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestNTPOffset(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset time.Duration
+	}{
+		{"behind", time.Minute},
+		{"ahead", -time.Minute},
+		{"in sync", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := serveNTP(t, tt.offset, 4)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			offset, err := NTPOffset(ctx, addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := (offset - tt.offset).Abs(); diff > time.Second {
+				t.Errorf("got offset %s; want %s", offset, tt.offset)
+			}
+		})
+	}
+}
+
+func TestNTPOffsetRejectsInvalidResponses(t *testing.T) {
+	// Mode 3 is a client request, not a server response.
+	addr := serveNTP(t, 0, 3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := NTPOffset(ctx, addr); err == nil {
+		t.Error("got no error; want one")
+	}
+}
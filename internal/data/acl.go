@@ -0,0 +1,161 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"greenlight/internal/validator"
+	"time"
+)
+
+// Movie visibilities. Restricted movies can only be seen by their owner and the users or
+// holders of the permissions listed in their ACL.
+const (
+	VisibilityPublic        = "public"
+	VisibilityAuthenticated = "authenticated"
+	VisibilityRestricted    = "restricted"
+)
+
+// Viewer is who movies are being read for, so that movies they may not see are left out.
+// A Viewer with All set sees every movie, and the zero Viewer is an anonymous user.
+type Viewer struct {
+	UserID      int64
+	Permissions Permissions
+	All         bool
+}
+
+// condition returns an SQL condition which only holds for movies the viewer may see,
+// appending the arguments it refers to to args.
+func (v Viewer) condition(args *[]any) string {
+	if v.All {
+		return "TRUE"
+	}
+
+	*args = append(*args, v.UserID, []string(v.Permissions))
+	user, permissions := len(*args)-1, len(*args)
+
+	return fmt.Sprintf(`(movies.visibility = 'public'
+		OR (movies.visibility = 'authenticated' AND $%[1]d::bigint > 0)
+		OR (movies.visibility = 'restricted' AND $%[1]d::bigint > 0 AND (movies.owner_id = $%[1]d OR EXISTS (
+			SELECT 1 FROM movie_acl
+			WHERE movie_acl.movie_id = movies.id
+			AND (movie_acl.user_id = $%[1]d OR movie_acl.permission = ANY($%[2]d::text[]))))))`, user, permissions)
+}
+
+// CanSee reports whether the viewer may see the movie without consulting its ACL, which
+// the movie does not carry: restricted movies are only reported visible to their owner,
+// and movies with an unknown visibility only to viewers who see everything.
+func (v Viewer) CanSee(movie *Movie) bool {
+	switch {
+	case v.All:
+		return true
+	case movie.Visibility == VisibilityPublic:
+		return true
+	case movie.Visibility == VisibilityAuthenticated:
+		return v.UserID > 0
+	case movie.Visibility == VisibilityRestricted:
+		return v.UserID > 0 && movie.OwnerID == v.UserID
+	default:
+		return false
+	}
+}
+
+// MovieACL is who can see a movie.
+type MovieACL struct {
+	Visibility  string   `json:"visibility"`
+	UserIDs     []int64  `json:"user_ids"`
+	Permissions []string `json:"permissions"`
+}
+
+func ValidateMovieACL(v *validator.Validator, acl *MovieACL) {
+	v.Check(validator.PermittedValue(acl.Visibility, VisibilityPublic, VisibilityAuthenticated, VisibilityRestricted), "visibility", "must be public, authenticated or restricted")
+
+	v.Check(len(acl.UserIDs) <= 100, "user_ids", "must not contain more than 100 users")
+	v.Check(validator.Unique(acl.UserIDs), "user_ids", "must not contain duplicate values")
+	for _, id := range acl.UserIDs {
+		v.Check(id > 0, "user_ids", "must only contain positive ids")
+	}
+
+	v.Check(len(acl.Permissions) <= 20, "permissions", "must not contain more than 20 permissions")
+	v.Check(validator.Unique(acl.Permissions), "permissions", "must not contain duplicate values")
+	for _, code := range acl.Permissions {
+		v.Check(code != "", "permissions", "must not contain empty values")
+	}
+}
+
+func (m MovieModel) GetACL(movieID int64) (*MovieACL, error) {
+	acl := &MovieACL{UserIDs: []int64{}, Permissions: []string{}}
+
+	ctx, cancel := context.WithTimeout(m.parentContext(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, `SELECT visibility FROM movies WHERE id = $1 AND deleted_at IS NULL`, movieID).Scan(&acl.Visibility)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	query := `
+		SELECT COALESCE(user_id, 0), COALESCE(permission, '')
+		FROM movie_acl
+		WHERE movie_id = $1
+		ORDER BY user_id, permission`
+
+	rows, err := m.DB.ReadQueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID int64
+		var permission string
+
+		err := rows.Scan(&userID, &permission)
+		if err != nil {
+			return nil, err
+		}
+
+		if userID != 0 {
+			acl.UserIDs = append(acl.UserIDs, userID)
+		} else {
+			acl.Permissions = append(acl.Permissions, permission)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return acl, nil
+}
+
+// SetACL replaces the visibility and ACL of the movie in a single statement.
+func (m MovieModel) SetACL(movieID int64, acl *MovieACL) error {
+	query := `
+		WITH updated AS (
+			UPDATE movies
+			SET visibility = $2, version = version + 1, updated_at = NOW()
+			WHERE id = $1 AND deleted_at IS NULL
+			RETURNING id
+		), cleared AS (
+			DELETE FROM movie_acl
+			WHERE movie_id IN (SELECT id FROM updated)
+		)
+		INSERT INTO movie_acl (movie_id, user_id, permission)
+		SELECT updated.id, u, NULL FROM updated, unnest($3::bigint[]) AS u
+		UNION ALL
+		SELECT updated.id, NULL, p FROM updated, unnest($4::text[]) AS p`
+
+	ctx, cancel := context.WithTimeout(m.parentContext(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, movieID, acl.Visibility, acl.UserIDs, acl.Permissions)
+	return err
+}
@@ -0,0 +1,51 @@
+package data
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestViewerCanSee(t *testing.T) {
+	public := &Movie{Visibility: VisibilityPublic}
+	members := &Movie{Visibility: VisibilityAuthenticated}
+	restricted := &Movie{Visibility: VisibilityRestricted, OwnerID: 5}
+	unknown := &Movie{Visibility: "secret"}
+
+	tests := []struct {
+		name   string
+		viewer Viewer
+		want   []bool
+	}{
+		{"anonymous", Viewer{}, []bool{true, false, false, false}},
+		{"user", Viewer{UserID: 8}, []bool{true, true, false, false}},
+		{"owner", Viewer{UserID: 5}, []bool{true, true, true, false}},
+		{"everything", Viewer{All: true}, []bool{true, true, true, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []bool
+			for _, movie := range []*Movie{public, members, restricted, unknown} {
+				got = append(got, tt.viewer.CanSee(movie))
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v for public, authenticated, restricted and unknown; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestViewerConditionArgs(t *testing.T) {
+	args := []any{int64(1)}
+
+	if got := (Viewer{All: true}).condition(&args); got != "TRUE" || len(args) != 1 {
+		t.Errorf("everything: got %q with args %v; want TRUE and no new args", got, args)
+	}
+
+	(Viewer{UserID: 7, Permissions: Permissions{"movies:staff"}}).condition(&args)
+
+	if len(args) != 3 || args[1] != int64(7) || !slices.Equal(args[2].([]string), []string{"movies:staff"}) {
+		t.Errorf("got args %v; want the user id and permissions appended", args)
+	}
+}
@@ -0,0 +1,305 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"greenlight/internal/jsonlog"
+	"greenlight/internal/outbound"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// maxPlanBytes caps the size of query plans attached to slow query log entries.
+const maxPlanBytes = 4096
+
+// ErrWriteUnavailable is returned by writes, and by lookups made on the primary, when the
+// primary database cannot be reached. Lists and searches may still succeed from the replicas.
+var ErrWriteUnavailable = errors.New("database writes are currently unavailable")
+
+// DB wraps a *sql.DB so that every query made by the models is timed. Queries slower than
+// SlowThreshold are logged, and when Explain is set the log entry also carries the output
+// of EXPLAIN for the query. Explain should never be enabled in production.
+//
+// The embedded *sql.DB is the primary, which all writes go to. Reads made through the Read
+// methods are spread over the Replicas which are up, falling back to the primary. Monitor
+// keeps track of which databases are up, so that while the primary is known to be down
+// writes fail straight away with ErrWriteUnavailable instead of waiting to time out.
+//
+// Only lists and searches go through the Read methods. Lookups of a single record, such as
+// a movie by id or the user owning a token, stay on the primary so that a client always
+// sees its own writes regardless of replication lag.
+type DB struct {
+	*sql.DB
+	Replicas      []*sql.DB
+	Logger        *jsonlog.Logger
+	SlowThreshold time.Duration
+	Explain       bool
+
+	primaryDown atomic.Bool
+	replicaDown []atomic.Bool
+	next        atomic.Uint64
+}
+
+// Row is the result of QueryRowContext. Like *sql.Row, errors are deferred until Scan.
+type Row struct {
+	row *sql.Row
+	err error
+}
+
+func (r *Row) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+
+	return dependencyError(r.row.Scan(dest...))
+}
+
+func (db *DB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	if db.primaryDown.Load() {
+		return nil, ErrWriteUnavailable
+	}
+
+	start := time.Now()
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	db.logSlow(query, args, time.Since(start))
+
+	return rows, db.writeError(err)
+}
+
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...any) *Row {
+	if db.primaryDown.Load() {
+		return &Row{err: ErrWriteUnavailable}
+	}
+
+	start := time.Now()
+	row := db.DB.QueryRowContext(ctx, query, args...)
+	db.logSlow(query, args, time.Since(start))
+
+	return &Row{row: row, err: db.writeError(row.Err())}
+}
+
+func (db *DB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if db.primaryDown.Load() {
+		return nil, ErrWriteUnavailable
+	}
+
+	start := time.Now()
+	result, err := db.DB.ExecContext(ctx, query, args...)
+	db.logSlow(query, args, time.Since(start))
+
+	return result, db.writeError(err)
+}
+
+// BeginTx starts a transaction on the primary. The queries made in it are not timed.
+func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	if db.primaryDown.Load() {
+		return nil, ErrWriteUnavailable
+	}
+
+	tx, err := db.DB.BeginTx(ctx, opts)
+
+	return tx, db.writeError(err)
+}
+
+// ReadQueryContext runs a read-only query on a replica when one is up.
+func (db *DB) ReadQueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := db.reader().QueryContext(ctx, query, args...)
+	db.logSlow(query, args, time.Since(start))
+
+	return rows, dependencyError(err)
+}
+
+// ReadQueryRowContext runs a read-only query returning one row on a replica when one is up.
+func (db *DB) ReadQueryRowContext(ctx context.Context, query string, args ...any) *Row {
+	start := time.Now()
+	row := db.reader().QueryRowContext(ctx, query, args...)
+	db.logSlow(query, args, time.Since(start))
+
+	return &Row{row: row}
+}
+
+// reader picks the next replica which is up, round robin, or the primary when there is none.
+func (db *DB) reader() *sql.DB {
+	n := len(db.Replicas)
+
+	for i := 0; i < n; i++ {
+		j := int(db.next.Add(1) % uint64(n))
+		if j < len(db.replicaDown) && db.replicaDown[j].Load() {
+			continue
+		}
+
+		return db.Replicas[j]
+	}
+
+	return db.DB
+}
+
+// writeError maps a failure to reach the primary to ErrWriteUnavailable.
+func (db *DB) writeError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var connectError *pgconn.ConnectError
+	var opError *net.OpError
+
+	if errors.As(err, &connectError) || errors.As(err, &opError) || errors.Is(err, driver.ErrBadConn) {
+		return fmt.Errorf("%w: %w", ErrWriteUnavailable, &outbound.DependencyError{Dependency: "database", Err: err})
+	}
+
+	return dependencyError(err)
+}
+
+// dependencyError marks err as a failure of the database when the database could not be
+// reached or did not answer in time. Other errors, such as sql.ErrNoRows, are returned as
+// they are.
+func dependencyError(err error) error {
+	var connectError *pgconn.ConnectError
+	var opError *net.OpError
+
+	if errors.As(err, &connectError) || errors.As(err, &opError) || errors.Is(err, driver.ErrBadConn) || errors.Is(err, context.DeadlineExceeded) {
+		return &outbound.DependencyError{Dependency: "database", Err: err}
+	}
+
+	return err
+}
+
+// isUniqueViolation reports whether err is a violation of the named unique constraint.
+func isUniqueViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == constraint
+}
+
+// Health reports whether the primary is up and how many replicas are.
+type Health struct {
+	PrimaryUp  bool `json:"primary_up"`
+	ReplicasUp int  `json:"replicas_up"`
+	Replicas   int  `json:"replicas"`
+}
+
+func (db *DB) Health() Health {
+	health := Health{PrimaryUp: !db.primaryDown.Load(), Replicas: len(db.Replicas)}
+
+	for i := range db.Replicas {
+		if i >= len(db.replicaDown) || !db.replicaDown[i].Load() {
+			health.ReplicasUp++
+		}
+	}
+
+	return health
+}
+
+// Monitor checks the primary and every replica straight away, then again each interval in
+// the background until ctx is cancelled, logging whenever one of them goes down or comes
+// back up. Each check is a ping with the given timeout. Monitor must be called before the
+// DB is used, and at most once.
+func (db *DB) Monitor(ctx context.Context, interval, timeout time.Duration) {
+	db.replicaDown = make([]atomic.Bool, len(db.Replicas))
+
+	checkAll := func() {
+		db.check(&db.primaryDown, db.DB, "primary", timeout)
+
+		for i, replica := range db.Replicas {
+			db.check(&db.replicaDown[i], replica, fmt.Sprintf("replica %d", i), timeout)
+		}
+	}
+
+	checkAll()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				checkAll()
+			}
+		}
+	}()
+}
+
+func (db *DB) check(down *atomic.Bool, sqlDB *sql.DB, name string, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := sqlDB.PingContext(ctx)
+
+	if wasDown := down.Swap(err != nil); wasDown != (err != nil) && db.Logger != nil {
+		if err != nil {
+			db.Logger.PrintError(err, map[string]string{"database": name, "state": "down"})
+		} else {
+			db.Logger.PrintInfo("database is back up", map[string]string{"database": name})
+		}
+	}
+}
+
+func (db *DB) logSlow(query string, args []any, duration time.Duration) {
+	if db.Logger == nil || db.SlowThreshold <= 0 || duration < db.SlowThreshold {
+		return
+	}
+
+	properties := map[string]string{
+		"query":    strings.Join(strings.Fields(query), " "),
+		"duration": duration.String(),
+	}
+
+	if db.Explain {
+		plan, err := db.explain(query, args)
+		if err != nil {
+			properties["plan_error"] = err.Error()
+		} else {
+			properties["plan"] = plan
+		}
+	}
+
+	db.Logger.PrintInfo("slow query", properties)
+}
+
+// explain returns the plan for query. Plain EXPLAIN is used rather than EXPLAIN ANALYZE so
+// that the statement is planned but never executed a second time.
+func (db *DB) explain(query string, args []any) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx, "EXPLAIN "+query, args...)
+	if err != nil {
+		return "", err
+	}
+
+	defer rows.Close()
+
+	var lines []string
+
+	for rows.Next() {
+		var line string
+
+		err := rows.Scan(&line)
+		if err != nil {
+			return "", err
+		}
+
+		lines = append(lines, line)
+	}
+
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+
+	plan := strings.Join(lines, "\n")
+	if len(plan) > maxPlanBytes {
+		plan = plan[:maxPlanBytes] + "...(truncated)"
+	}
+
+	return plan, nil
+}
@@ -0,0 +1,248 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight/internal/clock"
+	"greenlight/internal/jsonlog"
+	"greenlight/internal/sqlfake"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestIsSlugConflict(t *testing.T) {
+	slugErr := &pgconn.PgError{Code: "23505", ConstraintName: "movies_slug_key"}
+
+	tests := []struct {
+		name         string
+		err          error
+		slugConflict bool
+	}{
+		{"nil", nil, false},
+		{"other error", errors.New("boom"), false},
+		{"slug", slugErr, true},
+		{"wrapped slug", fmt.Errorf("insert: %w", slugErr), true},
+		{"other constraint", &pgconn.PgError{Code: "23505", ConstraintName: "users_email_key"}, false},
+		{"other code", &pgconn.PgError{Code: "23503", ConstraintName: "movies_slug_key"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSlugConflict(tt.err); got != tt.slugConflict {
+				t.Errorf("isSlugConflict() = %t; want %t", got, tt.slugConflict)
+			}
+		})
+	}
+}
+
+func TestIsExternalIDConflict(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"external id", &pgconn.PgError{Code: "23505", ConstraintName: "movies_external_id_key"}, true},
+		{"slug", &pgconn.PgError{Code: "23505", ConstraintName: "movies_slug_key"}, false},
+		{"other code", &pgconn.PgError{Code: "23502", ConstraintName: "movies_external_id_key"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isExternalIDConflict(tt.err); got != tt.want {
+				t.Errorf("isExternalIDConflict() = %t; want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserInsertDetectsDuplicateEmails(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"duplicate email", &pgconn.PgError{Code: "23505", ConstraintName: "users_email_key", Message: "reworded by the server"}, ErrDuplicateEmail},
+		{"other constraint", &pgconn.PgError{Code: "23505", ConstraintName: "users_pkey"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, func(query string, args []any) (*sqlfake.Result, error) {
+				return nil, tt.err
+			})
+
+			user := &User{Name: "Alice", Email: "alice@example.com", Password: password{hash: []byte("hash")}}
+			models := NewModels(db, clock.Real{})
+
+			for op, err := range map[string]error{"Insert": models.Users.Insert(user), "Update": models.Users.Update(user)} {
+				switch {
+				case tt.want != nil && !errors.Is(err, tt.want):
+					t.Errorf("%s: got error %v; want %v", op, err, tt.want)
+				case tt.want == nil && (errors.Is(err, ErrDuplicateEmail) || err == nil):
+					t.Errorf("%s: got error %v; want the database error", op, err)
+				}
+			}
+		})
+	}
+}
+
+func TestSlowQueriesLogTheirPlan(t *testing.T) {
+	tests := []struct {
+		name     string
+		explain  bool
+		wantPlan bool
+	}{
+		{"explain", true, true},
+		{"no explain", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var explained []string
+
+			db := newTestDB(t, func(query string, args []any) (*sqlfake.Result, error) {
+				if strings.HasPrefix(query, "EXPLAIN ") {
+					explained = append(explained, query)
+					return &sqlfake.Result{Rows: [][]any{{"Seq Scan on movies"}, {"  Filter: (id = $1)"}}}, nil
+				}
+				return &sqlfake.Result{RowsAffected: 1}, nil
+			})
+
+			var out bytes.Buffer
+			db.Logger = jsonlog.New(&out, jsonlog.LevelInfo)
+			db.SlowThreshold = time.Nanosecond
+			db.Explain = tt.explain
+
+			if _, err := db.ExecContext(context.Background(), "DELETE FROM movies WHERE id = $1", 1); err != nil {
+				t.Fatal(err)
+			}
+
+			var entry struct {
+				Message    string            `json:"message"`
+				Properties map[string]string `json:"properties"`
+			}
+			if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+				t.Fatalf("got log %q: %s", out.String(), err)
+			}
+
+			if entry.Message != "slow query" || entry.Properties["query"] != "DELETE FROM movies WHERE id = $1" {
+				t.Errorf("got log entry %+v", entry)
+			}
+
+			plan, ok := entry.Properties["plan"]
+			if ok != tt.wantPlan {
+				t.Fatalf("got plan %t; want %t", ok, tt.wantPlan)
+			}
+			if tt.wantPlan && plan != "Seq Scan on movies\n  Filter: (id = $1)" {
+				t.Errorf("got plan %q", plan)
+			}
+			if !tt.wantPlan && len(explained) != 0 {
+				t.Errorf("got %d EXPLAIN queries; want none", len(explained))
+			}
+		})
+	}
+}
+
+func TestReadsSurviveThePrimaryGoingDown(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+	var mu sync.Mutex
+	var primaryQueries []string
+
+	db := newTestDB(t, func(query string, args []any) (*sqlfake.Result, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// Pings come with an empty query.
+		if query != "" {
+			primaryQueries = append(primaryQueries, query)
+		}
+		return nil, refused
+	})
+
+	replica := func(name string, up bool) *sql.DB {
+		replica := sqlfake.Open(func(query string, args []any) (*sqlfake.Result, error) {
+			if !up {
+				return nil, refused
+			}
+			return &sqlfake.Result{Rows: [][]any{{name}}}, nil
+		})
+		t.Cleanup(func() { replica.Close() })
+		return replica
+	}
+
+	db.Replicas = []*sql.DB{replica("replica 0", false), replica("replica 1", true)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	db.Monitor(ctx, time.Hour, time.Second)
+
+	if got, want := db.Health(), (Health{PrimaryUp: false, ReplicasUp: 1, Replicas: 2}); got != want {
+		t.Errorf("got health %+v; want %+v", got, want)
+	}
+
+	// Reads only ever go to the replica which is up.
+	for i := 0; i < 4; i++ {
+		var source string
+		if err := db.ReadQueryRowContext(context.Background(), "SELECT source").Scan(&source); err != nil {
+			t.Fatalf("read %d: %s", i, err)
+		}
+		if source != "replica 1" {
+			t.Errorf("read %d: got answer from %q; want replica 1", i, source)
+		}
+	}
+
+	// Writes, and lookups on the primary, fail without reaching it.
+	_, err := db.ExecContext(context.Background(), "DELETE FROM movies WHERE id = $1", 1)
+	if !errors.Is(err, ErrWriteUnavailable) {
+		t.Errorf("exec: got error %v; want ErrWriteUnavailable", err)
+	}
+	if err := db.QueryRowContext(context.Background(), "SELECT 1").Scan(new(string)); !errors.Is(err, ErrWriteUnavailable) {
+		t.Errorf("query row: got error %v; want ErrWriteUnavailable", err)
+	}
+	if _, err := db.BeginTx(context.Background(), nil); !errors.Is(err, ErrWriteUnavailable) {
+		t.Errorf("begin: got error %v; want ErrWriteUnavailable", err)
+	}
+
+	mu.Lock()
+	if len(primaryQueries) != 0 {
+		t.Errorf("got queries %q sent to the primary; want none", primaryQueries)
+	}
+	mu.Unlock()
+}
+
+func TestWritesFailingToConnectAreUnavailable(t *testing.T) {
+	tests := []struct {
+		name            string
+		err             error
+		wantUnavailable bool
+	}{
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, true},
+		{"connect error", &pgconn.ConnectError{}, true},
+		{"query error", &pgconn.PgError{Code: "23505"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, func(query string, args []any) (*sqlfake.Result, error) {
+				return nil, tt.err
+			})
+
+			_, err := db.ExecContext(context.Background(), "DELETE FROM movies WHERE id = $1", 1)
+			if got := errors.Is(err, ErrWriteUnavailable); got != tt.wantUnavailable {
+				t.Errorf("got error %v; want ErrWriteUnavailable %t", err, tt.wantUnavailable)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"greenlight/internal/validator"
+	"time"
+)
+
+// Kinds of deliveries which are dead-lettered once they run out of attempts.
+const (
+	DeadLetterEmail   = "email"
+	DeadLetterWebhook = "webhook"
+)
+
+// DeadLetter records a delivery which failed every attempt, so that it can be replayed once
+// the downstream service has recovered. For an email, SourceID is the id of its row in the
+// outbox, which keeps the data, and Target is its template. For a webhook delivery,
+// SourceID is the id of the webhook, Target is its URL and Payload is the event.
+type DeadLetter struct {
+	ID        int64           `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Kind      string          `json:"kind"`
+	SourceID  int64           `json:"source_id"`
+	Target    string          `json:"target"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error"`
+}
+
+// DeadLetterFilter selects dead letters. Zero values match every dead letter.
+type DeadLetterFilter struct {
+	ID       int64
+	Kind     string
+	SourceID int64
+}
+
+func ValidateDeadLetterFilter(v *validator.Validator, filter DeadLetterFilter) {
+	v.Check(filter.ID >= 0, "id", "must not be negative")
+	v.Check(filter.Kind == "" || validator.PermittedValue(filter.Kind, DeadLetterEmail, DeadLetterWebhook), "kind", "must be email or webhook")
+	v.Check(filter.SourceID >= 0, "source_id", "must not be negative")
+}
+
+type DeadLetterModel struct {
+	DB *DB
+}
+
+func (m DeadLetterModel) Insert(letter *DeadLetter) error {
+	query := `
+		INSERT INTO dead_letters (kind, source_id, target, payload, attempts, last_error)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+
+	var payload []byte
+	if len(letter.Payload) > 0 {
+		payload = letter.Payload
+	}
+
+	args := []any{letter.Kind, letter.SourceID, letter.Target, payload, letter.Attempts, letter.LastError}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&letter.ID, &letter.CreatedAt)
+}
+
+// GetAll returns a page of the dead letters matching filter, newest first unless sorted by
+// id.
+func (m DeadLetterModel) GetAll(filter DeadLetterFilter, filters Filters) ([]*DeadLetter, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, kind, source_id, target, payload, attempts, last_error
+		FROM dead_letters
+		WHERE ($1 = 0 OR id = $1)
+		AND ($2 = '' OR kind = $2)
+		AND ($3 = 0 OR source_id = $3)
+		ORDER BY %s %s
+		LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
+
+	args := []any{filter.ID, filter.Kind, filter.SourceID, filters.limit(), filters.offset()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.ReadQueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	letters := []*DeadLetter{}
+
+	for rows.Next() {
+		var letter DeadLetter
+		var payload []byte
+
+		err := rows.Scan(
+			&totalRecords,
+			&letter.ID,
+			&letter.CreatedAt,
+			&letter.Kind,
+			&letter.SourceID,
+			&letter.Target,
+			&payload,
+			&letter.Attempts,
+			&letter.LastError,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		letter.Payload = payload
+
+		letters = append(letters, &letter)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return letters, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Take deletes up to limit of the dead letters matching filter, oldest first, and returns
+// them for the caller to replay. Rows being taken by another replay are skipped, so a dead
+// letter is never replayed twice.
+func (m DeadLetterModel) Take(filter DeadLetterFilter, limit int) ([]*DeadLetter, error) {
+	query := `
+		DELETE FROM dead_letters
+		WHERE id IN (
+			SELECT id FROM dead_letters
+			WHERE ($1 = 0 OR id = $1)
+			AND ($2 = '' OR kind = $2)
+			AND ($3 = 0 OR source_id = $3)
+			ORDER BY id ASC
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, created_at, kind, source_id, target, payload, attempts, last_error`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filter.ID, filter.Kind, filter.SourceID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	letters := []*DeadLetter{}
+
+	for rows.Next() {
+		var letter DeadLetter
+		var payload []byte
+
+		err := rows.Scan(
+			&letter.ID,
+			&letter.CreatedAt,
+			&letter.Kind,
+			&letter.SourceID,
+			&letter.Target,
+			&payload,
+			&letter.Attempts,
+			&letter.LastError,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		letter.Payload = payload
+
+		letters = append(letters, &letter)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return letters, nil
+}
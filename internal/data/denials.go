@@ -0,0 +1,93 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Reasons an access denial is recorded for.
+const (
+	DenialInactiveAccount   = "inactive_account"
+	DenialMissingPermission = "missing_permission"
+)
+
+// Denial records a request which was refused with a 403 because the user was not allowed
+// to access the route.
+type Denial struct {
+	ID         int64     `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	UserID     int64     `json:"user_id"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Permission string    `json:"permission,omitempty"`
+	Reason     string    `json:"reason"`
+}
+
+type DenialModel struct {
+	DB *DB
+}
+
+func (m DenialModel) Insert(denial *Denial) error {
+	query := `
+		INSERT INTO access_denials (user_id, method, path, permission, reason)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	args := []any{denial.UserID, denial.Method, denial.Path, denial.Permission, denial.Reason}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&denial.ID, &denial.CreatedAt)
+}
+
+// GetAll returns a page of denials, newest first unless sorted by id, optionally only those
+// of the user with the given id when it is not zero.
+func (m DenialModel) GetAll(userID int64, filters Filters) ([]*Denial, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, user_id, method, path, permission, reason
+		FROM access_denials
+		WHERE ($1 = 0 OR user_id = $1)
+		ORDER BY %s %s
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.ReadQueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	denials := []*Denial{}
+
+	for rows.Next() {
+		var denial Denial
+
+		err := rows.Scan(
+			&totalRecords,
+			&denial.ID,
+			&denial.CreatedAt,
+			&denial.UserID,
+			&denial.Method,
+			&denial.Path,
+			&denial.Permission,
+			&denial.Reason,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		denials = append(denials, &denial)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return denials, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
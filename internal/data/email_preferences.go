@@ -0,0 +1,72 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Categories of email. Essential emails, such as activation emails, are always sent; the
+// others only when the user has not opted out of them.
+const (
+	EmailEssential     = "essential"
+	EmailDigests       = "digests"
+	EmailNotifications = "notifications"
+)
+
+// EmailPreferences records which non-essential emails a user wants to receive. Users who
+// have never set them receive everything.
+type EmailPreferences struct {
+	Digests       bool `json:"digests"`
+	Notifications bool `json:"notifications"`
+}
+
+// Allows reports whether emails of the category may be sent.
+func (p EmailPreferences) Allows(category string) bool {
+	switch category {
+	case EmailDigests:
+		return p.Digests
+	case EmailNotifications:
+		return p.Notifications
+	default:
+		return true
+	}
+}
+
+type EmailPreferencesModel struct {
+	DB *DB
+}
+
+func (m EmailPreferencesModel) Get(userID int64) (*EmailPreferences, error) {
+	query := `
+		SELECT digests, notifications
+		FROM email_preferences
+		WHERE user_id = $1`
+
+	prefs := EmailPreferences{Digests: true, Notifications: true}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&prefs.Digests, &prefs.Notifications)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	return &prefs, nil
+}
+
+func (m EmailPreferencesModel) Upsert(userID int64, prefs *EmailPreferences) error {
+	query := `
+		INSERT INTO email_preferences (user_id, digests, notifications)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET digests = EXCLUDED.digests, notifications = EXCLUDED.notifications, updated_at = NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, prefs.Digests, prefs.Notifications)
+	return err
+}
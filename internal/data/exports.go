@@ -0,0 +1,109 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	ExportStatusPending   = "pending"
+	ExportStatusRunning   = "running"
+	ExportStatusSucceeded = "succeeded"
+	ExportStatusFailed    = "failed"
+)
+
+type Export struct {
+	ID          int64      `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UserID      int64      `json:"-"`
+	Status      string     `json:"status"`
+	ObjectKey   string     `json:"object_key"`
+	Records     int64      `json:"records"`
+	Error       string     `json:"error,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+type ExportModel struct {
+	DB *DB
+}
+
+func (m ExportModel) Insert(export *Export) error {
+	query := `
+		INSERT INTO exports (user_id, status, object_key)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at`
+
+	args := []any{export.UserID, export.Status, export.ObjectKey}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&export.ID, &export.CreatedAt)
+}
+
+func (m ExportModel) Get(id int64) (*Export, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, coalesce(user_id, 0), status, object_key, records, error, completed_at
+		FROM exports
+		WHERE id = $1`
+
+	var export Export
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&export.ID,
+		&export.CreatedAt,
+		&export.UserID,
+		&export.Status,
+		&export.ObjectKey,
+		&export.Records,
+		&export.Error,
+		&export.CompletedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &export, nil
+}
+
+// UpdateStatus records the progress of an export. The completion time is set once the
+// export reaches a terminal status.
+func (m ExportModel) UpdateStatus(export *Export) error {
+	query := `
+		UPDATE exports
+		SET status = $1, records = $2, error = $3,
+			completed_at = CASE WHEN $1 IN ('succeeded', 'failed') THEN NOW() ELSE NULL END
+		WHERE id = $4
+		RETURNING completed_at`
+
+	args := []any{export.Status, export.Records, export.Error, export.ID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&export.CompletedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,8 +1,12 @@
 package data
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"greenlight/internal/validator"
 	"math"
+	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -11,6 +15,35 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafeList []string
+	Cursor       string
+	MaxOffset    int
+}
+
+// cursor identifies the last record of a page for keyset pagination: the value of the sort
+// column for that record, plus its id as a tie breaker. The sort the cursor was made for
+// is kept too, as the value means nothing under another sort. Cursors from before it was
+// recorded have an empty Sort, and are accepted with any sort.
+type cursor struct {
+	Sort  string `json:"s,omitempty"`
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+func encodeCursor(c cursor) string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+
+	err = json.Unmarshal(js, &c)
+	return c, err
 }
 
 func (f Filters) sortColumn() string {
@@ -36,9 +69,19 @@ func (f Filters) limit() int {
 }
 
 func (f Filters) offset() int {
+	if f.Cursor != "" {
+		return 0
+	}
+
 	return (f.Page - 1) * f.PageSize
 }
 
+// DeepOffset reports whether the filters request a page past the configured MaxOffset, where
+// an OFFSET scan becomes expensive and clients should switch to cursor pagination.
+func (f Filters) DeepOffset() bool {
+	return f.MaxOffset > 0 && f.Cursor == "" && f.offset() > f.MaxOffset
+}
+
 func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.Page > 0, "page", "must be greater than zero")
 	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10,000,000")
@@ -46,26 +89,116 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 
 	v.Check(validator.PermittedValue(f.Sort, f.SortSafeList...), "sort", "invalid sort value")
+
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor)
+		v.Check(err == nil, "cursor", "invalid cursor value")
+		v.Check(err != nil || c.Sort == "" || c.Sort == f.Sort, "cursor", "was returned for a different sort order")
+	}
 }
 
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	Hint         string `json:"hint,omitempty"`
+	// PageFlags are written out alongside the other fields. Clearing them leaves the
+	// metadata as it was before the flags were added.
+	*PageFlags
+	// PageLinks are written out alongside the other fields too, when set by WithLinks.
+	*PageLinks
+	// cursor is set when the page was fetched with a cursor, and so has no page numbers
+	// of its own to link to.
+	cursor bool
+}
+
+// PageLinks hold the URLs of the pages around the current one. Prev is null on the first
+// page and Next on the last. Cursor pages only link to the next page.
+type PageLinks struct {
+	First *string `json:"first"`
+	Prev  *string `json:"prev"`
+	Next  *string `json:"next"`
+	Last  *string `json:"last"`
+}
+
+// WithLinks returns the metadata with links to the first, previous, next and last pages
+// under baseURL. Every value in query other than page is carried through, so that the links
+// keep the filters and sort order of the request. Cursor pages have no page numbers, so
+// they only get a link to the next page, built from NextCursor. Empty results get no links.
+func (m Metadata) WithLinks(baseURL string, query url.Values) Metadata {
+	withQuery := func(key, value string) string {
+		values := url.Values{}
+		for key, value := range query {
+			values[key] = value
+		}
+		values.Del("page")
+		values.Set(key, value)
+
+		return baseURL + "?" + values.Encode()
+	}
+
+	if m.cursor {
+		if m.NextCursor != "" {
+			next := withQuery("cursor", m.NextCursor)
+			m.PageLinks = &PageLinks{Next: &next}
+		}
+		return m
+	}
+
+	if m.CurrentPage == 0 {
+		return m
+	}
+
+	link := func(page int) string {
+		return withQuery("page", strconv.Itoa(page))
+	}
+
+	first, last := link(m.FirstPage), link(m.LastPage)
+	m.PageLinks = &PageLinks{First: &first, Last: &last}
+
+	if m.CurrentPage > m.FirstPage {
+		prev := link(m.CurrentPage - 1)
+		m.PageLinks.Prev = &prev
+	}
+
+	if m.CurrentPage < m.LastPage {
+		next := link(m.CurrentPage + 1)
+		m.PageLinks.Next = &next
+	}
+
+	return m
+}
+
+// PageFlags save clients from working out where a page lies from the page numbers.
+type PageFlags struct {
+	TotalPages      int  `json:"total_pages"`
+	HasNextPage     bool `json:"has_next_page"`
+	HasPreviousPage bool `json:"has_previous_page"`
+	IsLastPage      bool `json:"is_last_page"`
 }
 
 func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 	if totalRecords == 0 {
-		return Metadata{}
+		// A page past the end still has the pages before it.
+		return Metadata{PageFlags: &PageFlags{HasPreviousPage: page > 1, IsLastPage: true}}
 	}
 
+	lastPage := int(math.Ceil(float64(totalRecords) / float64(pageSize)))
+
 	return Metadata{
 		CurrentPage:  page,
 		PageSize:     pageSize,
 		FirstPage:    1,
-		LastPage:     int(math.Ceil(float64(totalRecords) / float64(pageSize))),
+		LastPage:     lastPage,
 		TotalRecords: totalRecords,
+		PageFlags: &PageFlags{
+			TotalPages:      lastPage,
+			HasNextPage:     page < lastPage,
+			HasPreviousPage: page > 1,
+			IsLastPage:      page >= lastPage,
+		},
 	}
 }
@@ -0,0 +1,110 @@
+package data
+
+import (
+	"greenlight/internal/validator"
+	"net/url"
+	"testing"
+)
+
+func TestMetadataWithLinks(t *testing.T) {
+	const base = "https://api.example.com/v1/movies"
+	query := url.Values{"genres": {"drama"}, "page": {"2"}}
+
+	t.Run("offset page", func(t *testing.T) {
+		m := calculateMetadata(50, 2, 20).WithLinks(base, query)
+
+		want := map[string]string{
+			"first": base + "?genres=drama&page=1",
+			"prev":  base + "?genres=drama&page=1",
+			"next":  base + "?genres=drama&page=3",
+			"last":  base + "?genres=drama&page=3",
+		}
+		got := map[string]*string{"first": m.First, "prev": m.Prev, "next": m.Next, "last": m.Last}
+
+		for name, link := range got {
+			if link == nil || *link != want[name] {
+				t.Errorf("%s link = %v; want %q", name, link, want[name])
+			}
+		}
+	})
+
+	t.Run("cursor page", func(t *testing.T) {
+		m := calculateMetadata(50, 1, 20)
+		m.cursor = true
+		m.NextCursor = "next"
+
+		m = m.WithLinks(base, url.Values{"cursor": {"current"}, "page": {"1"}})
+
+		if m.PageLinks == nil || m.Next == nil || *m.Next != base+"?cursor=next" {
+			t.Fatalf("next link = %v; want %q", m.PageLinks, base+"?cursor=next")
+		}
+		if m.First != nil || m.Prev != nil || m.Last != nil {
+			t.Errorf("got page number links on a cursor page: %+v", *m.PageLinks)
+		}
+	})
+
+	t.Run("last cursor page", func(t *testing.T) {
+		m := calculateMetadata(10, 1, 20)
+		m.cursor = true
+
+		if m = m.WithLinks(base, url.Values{"cursor": {"current"}}); m.PageLinks != nil {
+			t.Errorf("got links %+v; want none", *m.PageLinks)
+		}
+	})
+
+	t.Run("empty result", func(t *testing.T) {
+		if m := calculateMetadata(0, 1, 20).WithLinks(base, query); m.PageLinks != nil {
+			t.Errorf("got links %+v; want none", *m.PageLinks)
+		}
+	})
+}
+
+func TestValidateFiltersCursor(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor string
+		want   string
+	}{
+		{"same sort", encodeCursor(cursor{Sort: "-year", Value: "2010", ID: 1}), ""},
+		{"made before sorts were recorded", encodeCursor(cursor{Value: "2010", ID: 1}), ""},
+		{"other sort", encodeCursor(cursor{Sort: "runtime", Value: "90", ID: 1}), "was returned for a different sort order"},
+		{"not a cursor", "!!", "invalid cursor value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateFilters(v, Filters{Page: 1, PageSize: 20, Sort: "-year", SortSafeList: []string{"-year", "runtime"}, Cursor: tt.cursor})
+
+			if got := v.Errors["cursor"]; got != tt.want {
+				t.Errorf("got cursor error %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalculateMetadataPageFlags(t *testing.T) {
+	tests := []struct {
+		name         string
+		totalRecords int
+		page         int
+		want         PageFlags
+	}{
+		{"first page", 45, 1, PageFlags{TotalPages: 3, HasNextPage: true}},
+		{"middle page", 45, 2, PageFlags{TotalPages: 3, HasNextPage: true, HasPreviousPage: true}},
+		{"last page", 45, 3, PageFlags{TotalPages: 3, HasPreviousPage: true, IsLastPage: true}},
+		{"only page", 10, 1, PageFlags{TotalPages: 1, IsLastPage: true}},
+		{"empty result", 0, 1, PageFlags{IsLastPage: true}},
+		{"past the end", 0, 4, PageFlags{HasPreviousPage: true, IsLastPage: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := calculateMetadata(tt.totalRecords, tt.page, 20)
+
+			if m.PageFlags == nil || *m.PageFlags != tt.want {
+				t.Errorf("got flags %+v; want %+v", m.PageFlags, tt.want)
+			}
+		})
+	}
+}
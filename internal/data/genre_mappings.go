@@ -0,0 +1,154 @@
+package data
+
+import (
+	"context"
+	"greenlight/internal/validator"
+	"time"
+)
+
+// GenreMapping maps a variant of a genre, such as "Sci-Fi", to its canonical form in the
+// taxonomy, such as "Science Fiction". Aliases are matched ignoring case and spacing.
+type GenreMapping struct {
+	Alias     string    `json:"alias"`
+	Canonical string    `json:"canonical"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func ValidateGenreMapping(v *validator.Validator, mapping *GenreMapping) {
+	v.Check(mapping.Alias != "", "alias", "must be provided")
+	v.Check(len(mapping.Alias) <= 100, "alias", "must not be more than 100 bytes long")
+	v.Check(mapping.Canonical != "", "canonical", "must be provided")
+	v.Check(len(mapping.Canonical) <= 100, "canonical", "must not be more than 100 bytes long")
+}
+
+// Taxonomy maps genre keys to canonical genres. Every canonical genre also maps to itself.
+type Taxonomy map[string]string
+
+// Map replaces each genre with its canonical form, dropping genres which become duplicates.
+// Genres outside the taxonomy are returned as unknown, and are kept as they are when
+// passthrough is set or dropped otherwise.
+func (t Taxonomy) Map(genres []string, passthrough bool) (mapped, unknown []string) {
+	if genres == nil {
+		return nil, nil
+	}
+
+	seen := make(map[string]bool)
+	mapped = []string{}
+
+	for _, genre := range genres {
+		canonical, ok := t[genreKey(genre)]
+		if !ok {
+			unknown = append(unknown, genre)
+			if !passthrough {
+				continue
+			}
+			canonical = genre
+		}
+
+		if key := genreKey(canonical); !seen[key] {
+			seen[key] = true
+			mapped = append(mapped, canonical)
+		}
+	}
+
+	return mapped, unknown
+}
+
+type GenreMappingModel struct {
+	DB *DB
+}
+
+// Upsert creates the mapping for the alias, or points an existing one at a new canonical
+// genre.
+func (m GenreMappingModel) Upsert(mapping *GenreMapping) error {
+	query := `
+		INSERT INTO genre_mappings (alias, canonical)
+		VALUES ($1, $2)
+		ON CONFLICT (alias) DO UPDATE SET canonical = EXCLUDED.canonical, updated_at = NOW()
+		RETURNING updated_at`
+
+	mapping.Alias = genreKey(mapping.Alias)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, mapping.Alias, mapping.Canonical).Scan(&mapping.UpdatedAt)
+}
+
+func (m GenreMappingModel) GetAll() ([]*GenreMapping, error) {
+	query := `
+		SELECT alias, canonical, updated_at
+		FROM genre_mappings
+		ORDER BY canonical, alias`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.ReadQueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	mappings := []*GenreMapping{}
+
+	for rows.Next() {
+		var mapping GenreMapping
+
+		err := rows.Scan(&mapping.Alias, &mapping.Canonical, &mapping.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		mappings = append(mappings, &mapping)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return mappings, nil
+}
+
+// Taxonomy returns every mapping as a Taxonomy.
+func (m GenreMappingModel) Taxonomy() (Taxonomy, error) {
+	mappings, err := m.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	taxonomy := make(Taxonomy, len(mappings)*2)
+
+	for _, mapping := range mappings {
+		taxonomy[mapping.Alias] = mapping.Canonical
+		taxonomy[genreKey(mapping.Canonical)] = mapping.Canonical
+	}
+
+	return taxonomy, nil
+}
+
+func (m GenreMappingModel) Delete(alias string) error {
+	query := `
+		DELETE FROM genre_mappings
+		WHERE alias = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, genreKey(alias))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package data
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxGenres is the maximum number of genres a movie can be created or updated with. Rows
+// written before the cap was enforced may hold more, and are still returned by reads.
+const MaxGenres = 5
+
+const (
+	GenreCasingTitle    = "title"
+	GenreCasingLower    = "lower"
+	GenreCasingPreserve = "preserve"
+)
+
+// NormalizeGenres trims surrounding whitespace from each genre, drops empty values and
+// collapses genres that only differ by case or spacing into a single entry. The stored form
+// is chosen by casing: title-cased, lower-cased, or the first spelling seen when preserving.
+// A nil slice is returned unchanged so that the "must be provided" validation still applies.
+func NormalizeGenres(genres []string, casing string) []string {
+	if genres == nil {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	normalized := []string{}
+
+	for _, genre := range genres {
+		genre = strings.Join(strings.Fields(genre), " ")
+		if genre == "" {
+			continue
+		}
+
+		key := genreKey(genre)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		switch casing {
+		case GenreCasingLower:
+			genre = key
+		case GenreCasingTitle:
+			genre = titleCase(genre)
+		}
+
+		normalized = append(normalized, genre)
+	}
+
+	return normalized
+}
+
+// genreKey returns the form of a genre used to compare it with others, ignoring case and
+// spacing.
+func genreKey(genre string) string {
+	return strings.ToLower(strings.Join(strings.Fields(genre), " "))
+}
+
+func titleCase(s string) string {
+	words := strings.Split(strings.ToLower(s), " ")
+
+	for i, word := range words {
+		r, size := utf8.DecodeRuneInString(word)
+		words[i] = string(unicode.ToUpper(r)) + word[size:]
+	}
+
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,70 @@
+package data
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNormalizeGenres(t *testing.T) {
+	input := []string{"Drama", "drama", " Drama ", "science  fiction", "", "Science Fiction", "  "}
+
+	tests := []struct {
+		casing string
+		want   []string
+	}{
+		{GenreCasingTitle, []string{"Drama", "Science Fiction"}},
+		{GenreCasingLower, []string{"drama", "science fiction"}},
+		{GenreCasingPreserve, []string{"Drama", "science fiction"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.casing, func(t *testing.T) {
+			if got := NormalizeGenres(input, tt.casing); !slices.Equal(got, tt.want) {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+
+	if got := NormalizeGenres(nil, GenreCasingTitle); got != nil {
+		t.Errorf("nil genres: got %q; want nil", got)
+	}
+	if got := NormalizeGenres([]string{" "}, GenreCasingTitle); got == nil || len(got) != 0 {
+		t.Errorf("blank genres: got %#v; want an empty slice", got)
+	}
+}
+
+func TestTaxonomyMap(t *testing.T) {
+	taxonomy := Taxonomy{
+		"sci-fi":          "Science Fiction",
+		"scifi":           "Science Fiction",
+		"science fiction": "Science Fiction",
+		"drama":           "Drama",
+	}
+
+	tests := []struct {
+		name        string
+		genres      []string
+		passthrough bool
+		wantMapped  []string
+		wantUnknown []string
+	}{
+		{"variants", []string{"Sci-Fi", "SCIFI", "science  fiction"}, false, []string{"Science Fiction"}, nil},
+		{"unknown dropped", []string{"Drama", "Western"}, false, []string{"Drama"}, []string{"Western"}},
+		{"unknown kept", []string{"Western", "sci-fi"}, true, []string{"Western", "Science Fiction"}, []string{"Western"}},
+		{"empty", []string{}, false, []string{}, nil},
+		{"nil", nil, false, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapped, unknown := taxonomy.Map(tt.genres, tt.passthrough)
+
+			if !slices.Equal(mapped, tt.wantMapped) || (mapped == nil) != (tt.wantMapped == nil) {
+				t.Errorf("got mapped %#v; want %#v", mapped, tt.wantMapped)
+			}
+			if !slices.Equal(unknown, tt.wantUnknown) {
+				t.Errorf("got unknown %q; want %q", unknown, tt.wantUnknown)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"greenlight/internal/clock"
+	"greenlight/internal/idempotency"
+	"time"
+)
+
+// IdempotencyModel is the PostgreSQL idempotency.Store. Expired keys are not removed on
+// their own, DeleteExpired must be called periodically.
+type IdempotencyModel struct {
+	DB    *DB
+	Clock clock.Clock
+}
+
+func (m IdempotencyModel) Begin(ctx context.Context, key string, ttl time.Duration) (*idempotency.Response, error) {
+	// A key whose previous claim has expired is claimed again, as if it were new.
+	query := `
+		INSERT INTO idempotency_keys (key, expires_at)
+		VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE
+		SET created_at = NOW(), expires_at = EXCLUDED.expires_at, response = NULL
+		WHERE idempotency_keys.expires_at <= $3
+		RETURNING key`
+
+	now := m.Clock.Now()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var claimed string
+
+	err := m.DB.QueryRowContext(ctx, query, key, now.Add(ttl), now).Scan(&claimed)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	var response []byte
+
+	err = m.DB.QueryRowContext(ctx, `SELECT response FROM idempotency_keys WHERE key = $1`, key).Scan(&response)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, idempotency.ErrInProgress
+		}
+		return nil, err
+	}
+
+	if response == nil {
+		return nil, idempotency.ErrInProgress
+	}
+
+	var resp idempotency.Response
+
+	err = json.Unmarshal(response, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+func (m IdempotencyModel) Complete(ctx context.Context, key string, resp *idempotency.Response, ttl time.Duration) error {
+	response, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE idempotency_keys
+		SET response = $2, expires_at = $3
+		WHERE key = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, key, response, m.Clock.Now().Add(ttl))
+	return err
+}
+
+func (m IdempotencyModel) Release(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE key = $1 AND response IS NULL`, key)
+	return err
+}
+
+// DeleteExpired removes every expired key, returning how many were removed.
+func (m IdempotencyModel) DeleteExpired() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= $1`, m.Clock.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,34 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// MetadataSource records which fields of a movie were filled in by an external metadata
+// provider.
+type MetadataSource struct {
+	ID        int64     `json:"-"`
+	MovieID   int64     `json:"-"`
+	Provider  string    `json:"provider"`
+	Fields    []string  `json:"fields"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+type MetadataSourceModel struct {
+	DB *DB
+}
+
+func (m MetadataSourceModel) Insert(source *MetadataSource) error {
+	query := `
+		INSERT INTO movie_metadata_sources (movie_id, provider, fields)
+		VALUES ($1, $2, $3)
+		RETURNING id, fetched_at`
+
+	args := []any{source.MovieID, source.Provider, source.Fields}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&source.ID, &source.FetchedAt)
+}
@@ -3,6 +3,11 @@ package data
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"greenlight/internal/clock"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 var (
@@ -11,17 +16,84 @@ var (
 )
 
 type Models struct {
-	Movies      MovieModel
-	Users       UserModel
-	Tokens      TokenModel
-	Permissions PermissionModel
+	Movies          MovieModel
+	Users           UserModel
+	Tokens          TokenModel
+	Permissions     PermissionModel
+	SavedSearches   SavedSearchModel
+	Exports         ExportModel
+	Webhooks        WebhookModel
+	MetadataSources MetadataSourceModel
+	Idempotency     IdempotencyModel
+	EmailOutbox     EmailOutboxModel
+	Denials         DenialModel
+	GenreMappings   GenreMappingModel
+	EmailPrefs      EmailPreferencesModel
+	Roles           RoleModel
+	DeadLetters     DeadLetterModel
 }
 
-func NewModels(db *sql.DB) Models {
+func NewModels(db *DB, clk clock.Clock) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Users:       UserModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Permissions: PermissionModel{DB: db},
+		Movies:          MovieModel{DB: db, Clock: clk},
+		Users:           UserModel{DB: db, Clock: clk},
+		Tokens:          TokenModel{DB: db, Clock: clk},
+		Permissions:     PermissionModel{DB: db},
+		SavedSearches:   SavedSearchModel{DB: db},
+		Exports:         ExportModel{DB: db},
+		Webhooks:        WebhookModel{DB: db},
+		MetadataSources: MetadataSourceModel{DB: db},
+		Idempotency:     IdempotencyModel{DB: db, Clock: clk},
+		EmailOutbox:     EmailOutboxModel{DB: db},
+		Denials:         DenialModel{DB: db},
+		GenreMappings:   GenreMappingModel{DB: db},
+		EmailPrefs:      EmailPreferencesModel{DB: db},
+		Roles:           RoleModel{DB: db},
+		DeadLetters:     DeadLetterModel{DB: db},
+	}
+}
+
+// typeMaps holds the pgtype maps used to decode values scanned through database/sql, which
+// are not safe for concurrent use.
+var typeMaps = sync.Pool{New: func() any { return pgtype.NewMap() }}
+
+// textArray returns a scanner decoding a PostgreSQL text[] into dst. Elements which
+// PostgreSQL quotes in its output, such as "Science Fiction", are unquoted and unescaped. A
+// NULL or empty array gives an empty, non-nil slice.
+func textArray(dst *[]string) sql.Scanner {
+	return textArrayScanner{dst: dst}
+}
+
+type textArrayScanner struct {
+	dst *[]string
+}
+
+func (s textArrayScanner) Scan(src any) error {
+	var buf []byte
+
+	switch src := src.(type) {
+	case nil:
+		*s.dst = []string{}
+		return nil
+	case string:
+		buf = []byte(src)
+	case []byte:
+		buf = src
+	default:
+		return fmt.Errorf("cannot scan %T into a text array", src)
+	}
+
+	m := typeMaps.Get().(*pgtype.Map)
+	defer typeMaps.Put(m)
+
+	var array pgtype.FlatArray[string]
+
+	err := m.Scan(pgtype.TextArrayOID, pgtype.TextFormatCode, buf, &array)
+	if err != nil {
+		return err
 	}
+
+	*s.dst = append([]string{}, array...)
+
+	return nil
 }
@@ -0,0 +1,44 @@
+package data
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTextArrayScan(t *testing.T) {
+	tests := []struct {
+		name string
+		src  any
+		want []string
+	}{
+		{"simple", "{Drama,Comedy}", []string{"Drama", "Comedy"}},
+		{"multi-word genre", `{Drama,"Science Fiction"}`, []string{"Drama", "Science Fiction"}},
+		{"comma and quotes", `{"Drama, Romance","say \"hi\"","back\\slash"}`, []string{"Drama, Romance", `say "hi"`, `back\slash`}},
+		{"bytes", []byte(`{"Science Fiction"}`), []string{"Science Fiction"}},
+		{"empty", "{}", []string{}},
+		{"null", nil, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+
+			err := textArray(&got).Scan(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got == nil || !slices.Equal(got, tt.want) {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTextArrayScanInvalid(t *testing.T) {
+	var got []string
+
+	if err := textArray(&got).Scan(42); err == nil {
+		t.Error("expected an error scanning an int")
+	}
+}
@@ -5,80 +5,313 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"greenlight/internal/clock"
 	"greenlight/internal/validator"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 )
 
 type Movie struct {
 	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 	Title     string    `json:"title"`
+	Slug      string    `json:"slug"`
 	Year      int32     `json:"year,omitempty"`
 	Runtime   Runtime   `json:"runtime,omitempty"`
 	Genres    []string  `json:"genres,omitempty"`
 	Version   int32     `json:"version"`
+	// Visibility is only read by Get, GetBySlug and GetAll, and OwnerID is the user who
+	// created the movie, if they still exist.
+	Visibility string `json:"visibility,omitempty"`
+	OwnerID    int64  `json:"-"`
+	// Popularity counts the views of the movie.
+	Popularity int64 `json:"popularity"`
+	// ExternalID identifies the movie in the system it was ingested from. It is only read
+	// by Upsert.
+	ExternalID string `json:"external_id,omitempty"`
+	// Rank is how well the title matches the title searched for. It is only read by GetAll
+	// when sorting by relevance.
+	Rank *float32 `json:"rank,omitempty"`
 }
 
-func ValidateMovie(v *validator.Validator, movie *Movie) {
+// ValidateMovie checks the movie, accepting years between minYear and maxYear inclusive.
+func ValidateMovie(v *validator.Validator, movie *Movie, minYear, maxYear int32) {
 	v.Check(movie.Title != "", "title", "must be provided")
-	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
+	v.Check(len(movie.Title) <= MaxTitleBytes, "title", fmt.Sprintf("must not be more than %d bytes long", MaxTitleBytes))
 
 	v.Check(movie.Year != 0, "year", "must be provided")
-	v.Check(movie.Year >= 1888, "year", "must be greater than 1888")
-	v.Check(movie.Year <= int32(time.Now().Year()), "year", "must not be in the future")
+	v.Check(movie.Year >= minYear, "year", fmt.Sprintf("must not be before %d", minYear))
+	v.Check(movie.Year <= maxYear, "year", fmt.Sprintf("must not be after %d", maxYear))
 
 	v.Check(movie.Runtime != 0, "runtime", "must be provided")
 	v.Check(movie.Runtime > 0, "runtime", "must be a positive integer")
 
 	v.Check(movie.Genres != nil, "genres", "must be provided")
 	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
-	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
+	v.Check(len(movie.Genres) <= MaxGenres, "genres", fmt.Sprintf("must not contain more than %d genres", MaxGenres))
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
 }
 
+// searchDocument is the expression the search_vector column is computed from. Insert and
+// Update compute it the same way from the new title, so that movies written while a reindex
+// is running are indexed too.
+const searchDocument = "to_tsvector('simple', title)"
+
 type MovieModel struct {
-	DB *sql.DB
+	DB *DB
+	// KeepSlugAliases keeps the previous slug of a movie whose title changes as an alias,
+	// which GetSlugAlias resolves to the new slug.
+	KeepSlugAliases bool
+	// SoftDelete makes Delete mark movies as deleted, hiding them until they are restored,
+	// instead of removing them. Purge always removes them.
+	SoftDelete bool
+	Clock      clock.Clock
+
+	ctx context.Context
+}
+
+// WithContext returns a copy of the model whose queries are also cancelled with ctx, such as
+// when the request they are made for times out or its client goes away.
+func (m MovieModel) WithContext(ctx context.Context) MovieModel {
+	m.ctx = ctx
+	return m
+}
+
+// parentContext returns the context set by WithContext, which the timeouts of the queries
+// are derived from.
+func (m MovieModel) parentContext() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+
+	return m.ctx
 }
 
+// Insert inserts the movie with a slug generated from its title. When a concurrent write takes
+// the same slug first, a new one is generated and the insert is retried.
 func (m MovieModel) Insert(movie *Movie) error {
 	query := `
-		INSERT INTO movies (title, year, runtime, genres)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id, created_at, version`
+		INSERT INTO movies (title, year, runtime, genres, search_vector, slug, owner_id, external_id)
+		VALUES ($1, $2, $3, $4, to_tsvector('simple', $1), $5, NULLIF($6, 0), NULLIF($7, ''))
+		RETURNING id, created_at, updated_at, version, visibility`
+
+	ctx, cancel := context.WithTimeout(m.parentContext(), 3*time.Second)
+	defer cancel()
+
+	for attempt := 1; ; attempt++ {
+		slug, err := m.uniqueSlug(ctx, movie.Title, 0)
+		if err != nil {
+			return err
+		}
+
+		args := []any{movie.Title, movie.Year, movie.Runtime, movie.Genres, slug, movie.OwnerID, movie.ExternalID}
+
+		err = m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.UpdatedAt, &movie.Version, &movie.Visibility)
+		if isSlugConflict(err) && attempt < 3 {
+			continue
+		}
+		if err != nil {
+			return err
+		}
 
-	args := []any{movie.Title, movie.Year, movie.Runtime, movie.Genres}
+		movie.Slug = slug
+		return nil
+	}
+}
+
+// Outcomes of upserting a movie by its external id.
+const (
+	UpsertCreated   = "created"
+	UpsertUpdated   = "updated"
+	UpsertUnchanged = "unchanged"
+)
+
+// ErrMovieDeleted is returned by Upsert when the movie with the external id has been
+// soft-deleted, and has to be restored before it can be updated.
+var ErrMovieDeleted = errors.New("movie has been deleted")
+
+// errMovieHidden is returned by getByExternalID for a movie the viewer may not see, which
+// Upsert reports as not found.
+var errMovieHidden = errors.New("movie is hidden from the viewer")
+
+func ValidateExternalID(v *validator.Validator, externalID string) {
+	v.Check(externalID != "", "external_id", "must be provided")
+	v.Check(len(externalID) <= 200, "external_id", "must not be more than 200 bytes long")
+}
+
+// Upsert inserts the movie, or updates the movie with the same external id when there is
+// one, and reports which it did. A movie whose title, year, runtime and genres all match
+// is left untouched and reported as unchanged. Either way movie is set to the stored movie.
+// A movie with the external id which the viewer may not see is reported as not found.
+func (m MovieModel) Upsert(movie *Movie, viewer Viewer) (string, error) {
+	for attempt := 1; ; attempt++ {
+		existing, err := m.getByExternalID(movie.ExternalID, viewer)
+		if errors.Is(err, ErrRecordNotFound) {
+			err = m.Insert(movie)
+			if isExternalIDConflict(err) && attempt < 3 {
+				continue
+			}
+			if err != nil {
+				return "", err
+			}
+
+			return UpsertCreated, nil
+		}
+		if errors.Is(err, errMovieHidden) {
+			return "", ErrRecordNotFound
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if existing.Title == movie.Title && existing.Year == movie.Year && existing.Runtime == movie.Runtime && slices.Equal(existing.Genres, movie.Genres) {
+			*movie = *existing
+			return UpsertUnchanged, nil
+		}
+
+		existing.Title = movie.Title
+		existing.Year = movie.Year
+		existing.Runtime = movie.Runtime
+		existing.Genres = movie.Genres
+
+		err = m.Update(existing)
+		if errors.Is(err, ErrEditConflict) && attempt < 3 {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		*movie = *existing
+		return UpsertUpdated, nil
+	}
+}
+
+// getByExternalID returns the movie with the given external id from the primary, so that
+// Upsert sees movies it has only just inserted. It returns ErrMovieDeleted for a
+// soft-deleted movie and errMovieHidden for one the viewer may not see.
+func (m MovieModel) getByExternalID(externalID string, viewer Viewer) (*Movie, error) {
+	args := []any{externalID}
+
+	query := `
+		SELECT id, created_at, updated_at, title, slug, year, runtime, genres, version, visibility, COALESCE(owner_id, 0), popularity, external_id,
+			deleted_at IS NOT NULL, ` + viewer.condition(&args) + `
+		FROM movies
+		WHERE external_id = $1`
+
+	var movie Movie
+	var deleted, visible bool
+
+	ctx, cancel := context.WithTimeout(m.parentContext(), 3*time.Second)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.UpdatedAt,
+		&movie.Title,
+		&movie.Slug,
+		&movie.Year,
+		&movie.Runtime,
+		textArray(&movie.Genres),
+		&movie.Version,
+		&movie.Visibility,
+		&movie.OwnerID,
+		&movie.Popularity,
+		&movie.ExternalID,
+		&deleted,
+		&visible,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	switch {
+	case !visible:
+		return nil, errMovieHidden
+	case deleted:
+		return nil, ErrMovieDeleted
+	}
+
+	return &movie, nil
 }
 
-func (m MovieModel) Get(id int64) (*Movie, error) {
+// isExternalIDConflict reports whether err is a violation of the unique external id
+// constraint, caused by a concurrent upsert inserting the same movie first.
+func isExternalIDConflict(err error) bool {
+	return isUniqueViolation(err, "movies_external_id_key")
+}
+
+// AddViews adds views[id] to the popularity of each movie, in a single statement which
+// neither reads the movies first nor bumps their versions, so that concurrent views are
+// never lost and do not cause edit conflicts.
+func (m MovieModel) AddViews(views map[int64]int64) error {
+	if len(views) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(views))
+	counts := make([]int64, 0, len(views))
+
+	for id, n := range views {
+		ids = append(ids, id)
+		counts = append(counts, n)
+	}
+
+	query := `
+		UPDATE movies
+		SET popularity = movies.popularity + views.n
+		FROM unnest($1::bigint[], $2::bigint[]) AS views(id, n)
+		WHERE movies.id = views.id AND movies.deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(m.parentContext(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, ids, counts)
+	return err
+}
+
+// Get returns the movie with the given id. Movies the viewer may not see are reported as
+// not found, so that their existence is not revealed.
+func (m MovieModel) Get(id int64, viewer Viewer) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
+	args := []any{id}
+
 	query := `
-		SELECT id, created_at, title, year, runtime, genres, version
+		SELECT id, created_at, updated_at, title, slug, year, runtime, genres, version, visibility, COALESCE(owner_id, 0), popularity
 		FROM movies
-		WHERE id = $1`
+		WHERE id = $1 AND deleted_at IS NULL AND ` + viewer.condition(&args)
 
 	var movie Movie
-	var genres string
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(m.parentContext(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
 		&movie.ID,
 		&movie.CreatedAt,
+		&movie.UpdatedAt,
 		&movie.Title,
+		&movie.Slug,
 		&movie.Year,
 		&movie.Runtime,
-		&genres,
+		textArray(&movie.Genres),
 		&movie.Version,
+		&movie.Visibility,
+		&movie.OwnerID,
+		&movie.Popularity,
 	)
 
 	if err != nil {
@@ -90,57 +323,138 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		}
 	}
 
-	genresStr := strings.Trim(genres, "{}")
-	movie.Genres = strings.Split(genresStr, ",")
-
 	return &movie, nil
 }
 
+// Update updates the movie, regenerating its slug when the title has changed so much that the
+// current slug no longer matches it. With KeepSlugAliases set the previous slug is kept as an
+// alias of the movie.
 func (m MovieModel) Update(movie *Movie) error {
 	query := `
-		UPDATE movies
-		SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-		WHERE id = $5 and version = $6
-		RETURNING version`
+		WITH updated AS (
+			UPDATE movies
+			SET title = $1, year = $2, runtime = $3, genres = $4, search_vector = to_tsvector('simple', $1), slug = $7, version = version + 1, updated_at = NOW()
+			WHERE id = $5 and version = $6 AND deleted_at IS NULL
+			RETURNING version, updated_at
+		), alias AS (
+			INSERT INTO movie_slug_aliases (slug, movie_id)
+			SELECT $8, $5
+			WHERE $9::boolean AND $8 <> $7 AND EXISTS (SELECT 1 FROM updated)
+			ON CONFLICT (slug) DO NOTHING
+		), reclaimed AS (
+			DELETE FROM movie_slug_aliases
+			WHERE slug = $7 AND movie_id = $5 AND EXISTS (SELECT 1 FROM updated)
+		)
+		SELECT version, updated_at FROM updated`
+
+	ctx, cancel := context.WithTimeout(m.parentContext(), 3*time.Second)
+	defer cancel()
+
+	for attempt := 1; ; attempt++ {
+		slug := movie.Slug
+
+		if !slugMatches(slug, Slugify(movie.Title)) {
+			var err error
+
+			slug, err = m.uniqueSlug(ctx, movie.Title, movie.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		args := []any{
+			movie.Title,
+			movie.Year,
+			movie.Runtime,
+			movie.Genres,
+			movie.ID,
+			movie.Version,
+			slug,
+			movie.Slug,
+			m.KeepSlugAliases,
+		}
+
+		err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version, &movie.UpdatedAt)
+		if isSlugConflict(err) && attempt < 3 {
+			continue
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
 
-	args := []any{
-		movie.Title,
-		movie.Year,
-		movie.Runtime,
-		movie.Genres,
-		movie.ID,
-		movie.Version,
+		movie.Slug = slug
+		return nil
 	}
+}
+
+// GetBySlug returns the movie with the given current slug, if the viewer may see it.
+func (m MovieModel) GetBySlug(slug string, viewer Viewer) (*Movie, error) {
+	args := []any{slug}
+
+	query := `
+		SELECT id, created_at, updated_at, title, slug, year, runtime, genres, version, visibility, COALESCE(owner_id, 0), popularity
+		FROM movies
+		WHERE slug = $1 AND deleted_at IS NULL AND ` + viewer.condition(&args)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	var movie Movie
+
+	ctx, cancel := context.WithTimeout(m.parentContext(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.UpdatedAt,
+		&movie.Title,
+		&movie.Slug,
+		&movie.Year,
+		&movie.Runtime,
+		textArray(&movie.Genres),
+		&movie.Version,
+		&movie.Visibility,
+		&movie.OwnerID,
+		&movie.Popularity,
+	)
+
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrEditConflict
+			return nil, ErrRecordNotFound
 		default:
-			return err
+			return nil, err
 		}
 	}
 
-	return nil
+	return &movie, nil
 }
 
+// Delete soft-deletes the movie when SoftDelete is set, and purges it otherwise. A soft
+// delete bumps the version, so that updates based on the movie before it was deleted fail
+// with ErrEditConflict even once it has been restored.
 func (m MovieModel) Delete(id int64) error {
+	if !m.SoftDelete {
+		_, err := m.Purge(id)
+		return err
+	}
+
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
 	query := `
-		DELETE FROM movies
-		WHERE id = $1`
+		UPDATE movies
+		SET deleted_at = $2, version = version + 1
+		WHERE id = $1 AND deleted_at IS NULL`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(m.parentContext(), 3*time.Second)
 	defer cancel()
 
-	result, err := m.DB.ExecContext(ctx, query, id)
+	result, err := m.DB.ExecContext(ctx, query, id, m.Clock.Now())
 	if err != nil {
 		return err
 	}
@@ -157,21 +471,184 @@ func (m MovieModel) Delete(id int64) error {
 	return nil
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// Restore reinstates a soft-deleted movie which the viewer may see.
+func (m MovieModel) Restore(id int64, viewer Viewer) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	args := []any{id}
+
+	query := `
+		UPDATE movies
+		SET deleted_at = NULL, version = version + 1
+		WHERE id = $1 AND deleted_at IS NOT NULL AND ` + viewer.condition(&args) + `
+		RETURNING id, created_at, updated_at, title, slug, year, runtime, genres, version, visibility, COALESCE(owner_id, 0), popularity`
+
+	var movie Movie
+
+	ctx, cancel := context.WithTimeout(m.parentContext(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.UpdatedAt,
+		&movie.Title,
+		&movie.Slug,
+		&movie.Year,
+		&movie.Runtime,
+		textArray(&movie.Genres),
+		&movie.Version,
+		&movie.Visibility,
+		&movie.OwnerID,
+		&movie.Popularity,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &movie, nil
+}
+
+// Purge permanently deletes the movie, whether or not it has been soft-deleted, and reports
+// whether it had been.
+func (m MovieModel) Purge(id int64) (alreadyDeleted bool, err error) {
+	if id < 1 {
+		return false, ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM movies
+		WHERE id = $1
+		RETURNING deleted_at IS NOT NULL`
+
+	ctx, cancel := context.WithTimeout(m.parentContext(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, id).Scan(&alreadyDeleted)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, ErrRecordNotFound
+		default:
+			return false, err
+		}
+	}
+
+	return alreadyDeleted, nil
+}
+
+// YearRange restricts listed movies to those released in Exact, or between From and To
+// inclusive. A zero value leaves that bound out.
+type YearRange struct {
+	Exact int
+	From  int
+	To    int
+}
+
+// ValidateYearRange checks that no bound is negative, that an exact year is not combined
+// with a range, and that the range does not end before it starts.
+func ValidateYearRange(v *validator.Validator, years YearRange) {
+	v.Check(years.Exact >= 0, "year", "must not be negative")
+	v.Check(years.From >= 0, "year_from", "must not be negative")
+	v.Check(years.To >= 0, "year_to", "must not be negative")
+
+	v.Check(years.Exact == 0 || (years.From == 0 && years.To == 0), "year", "must not be combined with year_from or year_to")
+	v.Check(years.From == 0 || years.To == 0 || years.From <= years.To, "year_from", "must not be after year_to")
+}
+
+// ValidateMovieSearch checks that movies are only sorted by relevance when there is a title
+// for them to be relevant to.
+func ValidateMovieSearch(v *validator.Validator, title string, filters Filters) {
+	v.Check(title != "" || strings.TrimPrefix(filters.Sort, "-") != "relevance", "sort", "must not be relevance without a title")
+}
+
+// Ways of matching the genres of a GenreFilter.
+const (
+	GenresModeAll = "all"
+	GenresModeAny = "any"
+)
+
+// GenreFilter restricts listed movies to those holding all of Genres, or any of them when
+// Mode is GenresModeAny, and none of Exclude. Empty lists leave that condition out.
+type GenreFilter struct {
+	Genres  []string
+	Exclude []string
+	Mode    string
+}
+
+func ValidateGenreFilter(v *validator.Validator, genres GenreFilter) {
+	v.Check(validator.PermittedValue(genres.Mode, GenresModeAll, GenresModeAny), "genres_mode", "must be all or any")
+
+	for _, genre := range genres.Exclude {
+		if slices.Contains(genres.Genres, genre) {
+			v.AddError("exclude_genres", fmt.Sprintf("must not contain %q, which is in genres", genre))
+			break
+		}
+	}
+}
+
+// movieRank ranks movies by how well their titles match the title query of GetAll.
+const movieRank = "ts_rank(search_vector, plainto_tsquery('simple', $1))"
+
+// GetAll returns a page of the movies the viewer may see which match the title search, the
+// genres and the years.
+func (m MovieModel) GetAll(title string, genres GenreFilter, years YearRange, filters Filters, viewer Viewer) ([]*Movie, Metadata, error) {
+	// A nil list would be sent as NULL, which excludes every movie.
+	if genres.Exclude == nil {
+		genres.Exclude = []string{}
+	}
+
+	args := []any{title, genres.Genres, filters.limit(), filters.offset(), years.Exact, years.From, years.To, genres.Mode == GenresModeAny, genres.Exclude}
+
+	sortColumn := filters.sortColumn()
+	if sortColumn == "relevance" {
+		sortColumn = movieRank
+	}
+
+	keyset := ""
+	if filters.Cursor != "" {
+		c, err := decodeCursor(filters.Cursor)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		column, cast, operator := sortColumn, movieColumnType(filters.sortColumn()), ">"
+		if filters.sortDirection() == "DESC" {
+			operator = "<"
+		}
+
+		keyset = fmt.Sprintf("AND (%[1]s %[2]s $10::%[3]s OR (%[1]s = $10::%[3]s AND id > $11))", column, operator, cast)
+		args = append(args, c.Value, c.ID)
+	}
+
+	visible := viewer.condition(&args)
+
 	query := fmt.Sprintf(`
-		SELECT count(id) OVER(), id, created_at, title, year, runtime, genres, version
+		SELECT count(id) OVER(), id, created_at, updated_at, title, slug, year, runtime, genres, version, visibility, COALESCE(owner_id, 0), popularity, %s
 		FROM movies
-		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-		AND (genres @> $2 OR $2 = '{}')
+		WHERE (search_vector @@ plainto_tsquery('simple', $1) OR $1 = '')
+		AND (genres @> $2 OR $2 = '{}' OR ($8 AND genres && $2))
+		AND NOT (genres && $9)
+		AND deleted_at IS NULL
+		AND ($5 = 0 OR year = $5)
+		AND ($6 = 0 OR year >= $6)
+		AND ($7 = 0 OR year <= $7)
+		AND %s
+		%s
 		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+		LIMIT $3 OFFSET $4`, movieRank, visible, keyset, sortColumn, filters.sortDirection())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(m.parentContext(), 3*time.Second)
 	defer cancel()
 
-	args := []any{title, genres, filters.limit(), filters.offset()}
-
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	rows, err := m.DB.ReadQueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -183,24 +660,31 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 
 	for rows.Next() {
 		var movie Movie
-		var genres string
+		var rank float32
 
 		err := rows.Scan(
 			&totalRecords,
 			&movie.ID,
 			&movie.CreatedAt,
+			&movie.UpdatedAt,
 			&movie.Title,
+			&movie.Slug,
 			&movie.Year,
 			&movie.Runtime,
-			&genres,
+			textArray(&movie.Genres),
 			&movie.Version,
+			&movie.Visibility,
+			&movie.OwnerID,
+			&movie.Popularity,
+			&rank,
 		)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
 
-		genresStr := strings.Trim(genres, "{}")
-		movie.Genres = strings.Split(genresStr, ",")
+		if filters.sortColumn() == "relevance" {
+			movie.Rank = &rank
+		}
 
 		movies = append(movies, &movie)
 	}
@@ -211,5 +695,224 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 
 	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
 
+	// Every page which is not the last carries a cursor, so that clients can switch from
+	// offset to keyset pagination at any point.
+	if len(movies) > 0 && totalRecords > filters.offset()+len(movies) {
+		last := movies[len(movies)-1]
+		metadata.NextCursor = encodeCursor(cursor{Sort: filters.Sort, Value: last.sortValue(filters.sortColumn()), ID: last.ID})
+	}
+
+	// With a cursor, the records counted are only those after it, so the page numbers
+	// start over from the cursor, but a page always comes before it.
+	if filters.Cursor != "" {
+		metadata.HasPreviousPage = true
+		metadata.cursor = true
+	}
+
+	if filters.DeepOffset() {
+		metadata.Hint = "deep page offsets are expensive, use the cursor parameter with next_cursor to continue paging"
+	}
+
 	return movies, metadata, nil
 }
+
+// ForEach calls fn for every movie in id order, reading them one row at a time so that the
+// whole catalog is never held in memory. Iteration stops at the first error returned by fn.
+func (m MovieModel) ForEach(ctx context.Context, fn func(*Movie) error) error {
+	query := `
+		SELECT id, created_at, updated_at, title, slug, year, runtime, genres, version
+		FROM movies
+		WHERE deleted_at IS NULL
+		ORDER BY id ASC`
+
+	rows, err := m.DB.ReadQueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.UpdatedAt,
+			&movie.Title,
+			&movie.Slug,
+			&movie.Year,
+			&movie.Runtime,
+			textArray(&movie.Genres),
+			&movie.Version,
+		)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&movie)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Count returns the number of movies in the catalog, including soft-deleted ones, which are
+// reindexed too.
+func (m MovieModel) Count() (int, error) {
+	query := `SELECT count(*) FROM movies`
+
+	var count int
+
+	ctx, cancel := context.WithTimeout(m.parentContext(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.ReadQueryRowContext(ctx, query).Scan(&count)
+	return count, err
+}
+
+// ReindexBatch recomputes the search vector of up to size movies with an id greater than
+// afterID, in id order. It returns the highest id processed and the number of movies
+// processed, which is zero once there are no movies left.
+func (m MovieModel) ReindexBatch(afterID int64, size int) (int64, int, error) {
+	query := fmt.Sprintf(`
+		WITH batch AS (
+			SELECT id FROM movies
+			WHERE id > $1
+			ORDER BY id ASC
+			LIMIT $2
+			FOR UPDATE
+		)
+		UPDATE movies
+		SET search_vector = %s
+		FROM batch
+		WHERE movies.id = batch.id
+		RETURNING movies.id`, searchDocument)
+
+	ctx, cancel := context.WithTimeout(m.parentContext(), 30*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, afterID, size)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer rows.Close()
+
+	lastID, processed := afterID, 0
+
+	for rows.Next() {
+		var id int64
+
+		err := rows.Scan(&id)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		lastID = max(lastID, id)
+		processed++
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	return lastID, processed, nil
+}
+
+// GenreFix reports a movie whose genres were trimmed down to MaxGenres.
+type GenreFix struct {
+	ID      int64    `json:"id"`
+	Removed []string `json:"removed_genres"`
+	Genres  []string `json:"genres"`
+}
+
+// TrimGenres trims the genres of every movie holding more than MaxGenres down to the first
+// MaxGenres entries, bumping the version of each affected row. When dryRun is set the
+// movies that would be changed are reported without being updated.
+func (m MovieModel) TrimGenres(dryRun bool) ([]GenreFix, error) {
+	query := `
+		WITH old AS (
+			SELECT id, genres FROM movies
+			WHERE cardinality(genres) > $1
+			FOR UPDATE
+		)
+		UPDATE movies
+		SET genres = old.genres[1:$1], version = movies.version + 1
+		FROM old
+		WHERE movies.id = old.id
+		RETURNING movies.id, old.genres, movies.genres`
+
+	if dryRun {
+		query = `
+			SELECT id, genres, genres[1:$1]
+			FROM movies
+			WHERE cardinality(genres) > $1`
+	}
+
+	ctx, cancel := context.WithTimeout(m.parentContext(), 30*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, MaxGenres)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	fixes := []GenreFix{}
+
+	for rows.Next() {
+		var fix GenreFix
+		var oldGenres []string
+
+		err := rows.Scan(&fix.ID, textArray(&oldGenres), textArray(&fix.Genres))
+		if err != nil {
+			return nil, err
+		}
+
+		fix.Removed = oldGenres[len(fix.Genres):]
+
+		fixes = append(fixes, fix)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return fixes, nil
+}
+
+// sortValue returns the value of the given sort column for the movie, formatted so that it
+// can be stored in a pagination cursor.
+func (m *Movie) sortValue(column string) string {
+	switch column {
+	case "title":
+		return m.Title
+	case "year":
+		return strconv.FormatInt(int64(m.Year), 10)
+	case "runtime":
+		return strconv.FormatInt(int64(m.Runtime), 10)
+	case "popularity":
+		return strconv.FormatInt(m.Popularity, 10)
+	case "relevance":
+		return strconv.FormatFloat(float64(*m.Rank), 'g', -1, 32)
+	default:
+		return strconv.FormatInt(m.ID, 10)
+	}
+}
+
+// movieColumnType returns the PostgreSQL type that a cursor value for the given sort column
+// should be cast to before it is compared.
+func movieColumnType(column string) string {
+	switch column {
+	case "title":
+		return "text"
+	case "relevance":
+		return "real"
+	default:
+		return "bigint"
+	}
+}
@@ -0,0 +1,198 @@
+package data
+
+import (
+	"greenlight/internal/sqlfake"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMovieWritesIndexTheTitle checks that inserts and updates keep the search vector in
+// step with the title, so that movies written while a reindex runs are indexed too.
+func TestMovieWritesIndexTheTitle(t *testing.T) {
+	var writes []string
+
+	db := newTestDB(t, func(query string, args []any) (*sqlfake.Result, error) {
+		switch {
+		case strings.Contains(query, "INSERT INTO movies"):
+			writes = append(writes, query)
+			return &sqlfake.Result{Rows: [][]any{{int64(1), time.Now(), time.Now(), int64(1), "public"}}}, nil
+		case strings.Contains(query, "UPDATE movies"):
+			writes = append(writes, query)
+			return &sqlfake.Result{Rows: [][]any{{int64(2), time.Now()}}}, nil
+		}
+		return nil, nil
+	})
+
+	movies := MovieModel{DB: db}
+
+	movie := &Movie{Title: "Arrival", Slug: "arrival", Year: 2016, Runtime: 116, Genres: []string{"Drama"}}
+	if err := movies.Insert(movie); err != nil {
+		t.Fatal(err)
+	}
+
+	movie.Title = "Arrival (2016)"
+	if err := movies.Update(movie); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(writes) != 2 {
+		t.Fatalf("got %d writes; want 2", len(writes))
+	}
+	for _, query := range writes {
+		if !strings.Contains(query, "search_vector") || !strings.Contains(query, "to_tsvector('simple', $1)") {
+			t.Errorf("got a write which does not index the title: %s", strings.Join(strings.Fields(query), " "))
+		}
+	}
+}
+
+func TestUpsertKeepsThePopularity(t *testing.T) {
+	tests := []struct {
+		title       string
+		wantOutcome string
+	}{
+		{"Alien", UpsertUnchanged},
+		{"Alien (1979)", UpsertUpdated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.wantOutcome, func(t *testing.T) {
+			db := newTestDB(t, func(query string, args []any) (*sqlfake.Result, error) {
+				switch {
+				case strings.Contains(query, "WHERE external_id = $1"):
+					return &sqlfake.Result{Rows: [][]any{
+						{int64(1), time.Now(), time.Now(), "Alien", "alien", int64(1979), int64(117), "{Horror}", int64(1), "public", int64(0), int64(42), "tt0078748", false, true},
+					}}, nil
+				case strings.Contains(query, "UPDATE movies"):
+					return &sqlfake.Result{Rows: [][]any{{int64(2), time.Now()}}}, nil
+				}
+				return nil, nil
+			})
+
+			movie := &Movie{ExternalID: "tt0078748", Title: tt.title, Year: 1979, Runtime: 117, Genres: []string{"Horror"}}
+
+			outcome, err := MovieModel{DB: db}.Upsert(movie, Viewer{All: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if outcome != tt.wantOutcome || movie.Popularity != 42 {
+				t.Errorf("got %s with popularity %d; want %s with the stored popularity 42", outcome, movie.Popularity, tt.wantOutcome)
+			}
+		})
+	}
+}
+
+// keysetCatalog answers GetAll sorted by -year from the movies, applying the keyset
+// predicate of a cursor when the query has one, as PostgreSQL would.
+func keysetCatalog(t *testing.T, movies []*Movie) sqlfake.Handler {
+	return func(query string, args []any) (*sqlfake.Result, error) {
+		if !strings.Contains(query, "count(id) OVER()") {
+			return nil, nil
+		}
+
+		limit, offset := args[2].(int), args[3].(int)
+
+		var page []*Movie
+		for _, m := range movies {
+			if strings.Contains(query, "AND id > $11") {
+				if !strings.Contains(query, "year < $10::bigint") {
+					t.Errorf("got keyset %s; want it to follow the -year sort", strings.Join(strings.Fields(query), " "))
+				}
+				year, _ := strconv.Atoi(args[9].(string))
+				if m.Year > int32(year) || (m.Year == int32(year) && m.ID <= args[10].(int64)) {
+					continue
+				}
+			}
+			page = append(page, m)
+		}
+
+		res := &sqlfake.Result{}
+		for i, m := range page {
+			if i < offset || i >= offset+limit {
+				continue
+			}
+			res.Rows = append(res.Rows, []any{
+				int64(len(page)), m.ID, time.Now(), time.Now(), m.Title, m.Slug, int64(m.Year), int64(m.Runtime), "{Drama}", int64(1), "public", int64(0), int64(0), float64(0),
+			})
+		}
+		return res, nil
+	}
+}
+
+func TestGetAllPagesWithCursors(t *testing.T) {
+	// Sorted by -year, then id.
+	catalog := []*Movie{
+		{ID: 4, Title: "D", Year: 2020},
+		{ID: 1, Title: "A", Year: 2010},
+		{ID: 3, Title: "C", Year: 2010},
+		{ID: 5, Title: "E", Year: 2010},
+		{ID: 2, Title: "B", Year: 2000},
+	}
+
+	movies := MovieModel{DB: newTestDB(t, keysetCatalog(t, catalog))}
+
+	filters := Filters{Page: 1, PageSize: 2, Sort: "-year", SortSafeList: []string{"id", "year", "-year"}}
+
+	var got [][]int64
+	for {
+		page, metadata, err := movies.GetAll("", GenreFilter{}, YearRange{}, filters, Viewer{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ids []int64
+		for _, m := range page {
+			ids = append(ids, m.ID)
+		}
+		got = append(got, ids)
+
+		if metadata.NextCursor == "" {
+			break
+		}
+		if len(got) > 3 {
+			t.Fatal("got more pages than there are movies")
+		}
+
+		c, err := decodeCursor(metadata.NextCursor)
+		if err != nil || c.Sort != "-year" {
+			t.Fatalf("got cursor %+v, %v; want it bound to the -year sort", c, err)
+		}
+
+		filters.Cursor = metadata.NextCursor
+	}
+
+	want := [][]int64{{4, 1}, {3, 5}, {2}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got pages %v; want %v", got, want)
+	}
+}
+
+func TestGetAllOffsetPagesCarryACursor(t *testing.T) {
+	catalog := []*Movie{{ID: 4, Year: 2020}, {ID: 1, Year: 2010}, {ID: 2, Year: 2000}}
+	movies := MovieModel{DB: newTestDB(t, keysetCatalog(t, catalog))}
+
+	filters := Filters{Page: 1, PageSize: 2, Sort: "-year", SortSafeList: []string{"-year"}}
+
+	_, metadata, err := movies.GetAll("", GenreFilter{}, YearRange{}, filters, Viewer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c, err := decodeCursor(metadata.NextCursor); err != nil || c != (cursor{Sort: "-year", Value: "2010", ID: 1}) {
+		t.Errorf("got next cursor %+v, %v; want one after movie 1 in 2010", c, err)
+	}
+
+	filters.Page = 2
+
+	_, metadata, err = movies.GetAll("", GenreFilter{}, YearRange{}, filters, Viewer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metadata.NextCursor != "" {
+		t.Errorf("got next cursor %q on the last page; want none", metadata.NextCursor)
+	}
+}
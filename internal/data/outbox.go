@@ -0,0 +1,250 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"greenlight/internal/fieldcrypt"
+	"time"
+)
+
+const (
+	EmailStatusPending = "pending"
+	EmailStatusSending = "sending"
+	EmailStatusSent    = "sent"
+	EmailStatusFailed  = "failed"
+)
+
+// emailClaimTimeout is how long a claimed email may stay unsent before it is assumed that
+// the process sending it died, and it is handed out again.
+const emailClaimTimeout = 10 * time.Minute
+
+// OutboxEmail is an email waiting in the outbox to be sent by the dispatcher. UserID is the
+// user it is sent to, so that their emails can be deleted along with them.
+type OutboxEmail struct {
+	ID        int64
+	UserID    int64
+	Recipient string `encrypt:"true"`
+	Template  string
+	Data      map[string]any
+	Attempts  int
+}
+
+// EmailOutboxModel stores emails until they are sent. With Keys set, the recipient and
+// the data, which may hold tokens, are encrypted in the table.
+type EmailOutboxModel struct {
+	DB   *DB
+	Keys *fieldcrypt.Keyring
+}
+
+// Enqueue adds an email for the user to the outbox. The data is stored as JSON and passed
+// to the template as a map once the email is sent. Encrypted data is stored as a JSON
+// string.
+func (m EmailOutboxModel) Enqueue(userID int64, recipient, template string, data map[string]any) error {
+	email := &OutboxEmail{UserID: userID, Recipient: recipient, Template: template}
+
+	err := m.Keys.Seal(email)
+	if err != nil {
+		return err
+	}
+
+	js, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if m.Keys != nil {
+		sealed, err := m.Keys.Encrypt(string(js))
+		if err != nil {
+			return err
+		}
+
+		js, err = json.Marshal(sealed)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := `
+		INSERT INTO email_outbox (user_id, recipient, template, data)
+		VALUES ($1, $2, $3, $4)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, email.UserID, email.Recipient, email.Template, js)
+	return err
+}
+
+// ClaimBatch claims up to size pending emails, oldest first, for the caller to send. Rows
+// locked by another dispatcher are skipped, so several instances can share the outbox.
+func (m EmailOutboxModel) ClaimBatch(size int) ([]*OutboxEmail, error) {
+	query := `
+		UPDATE email_outbox
+		SET status = 'sending', claimed_at = NOW(), attempts = attempts + 1
+		WHERE id IN (
+			SELECT id FROM email_outbox
+			WHERE status = 'pending' OR (status = 'sending' AND claimed_at < NOW() - $2::interval)
+			ORDER BY id ASC
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, recipient, template, data, attempts`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, size, emailClaimTimeout.String())
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	emails := []*OutboxEmail{}
+
+	for rows.Next() {
+		var email OutboxEmail
+		var data []byte
+
+		err := rows.Scan(&email.ID, &email.Recipient, &email.Template, &data, &email.Attempts)
+		if err != nil {
+			return nil, err
+		}
+
+		err = m.Keys.Open(&email)
+		if err != nil {
+			return nil, err
+		}
+
+		if bytes.HasPrefix(data, []byte(`"`)) {
+			var sealed string
+
+			err = json.Unmarshal(data, &sealed)
+			if err != nil {
+				return nil, err
+			}
+
+			opened, err := m.Keys.Decrypt(sealed)
+			if err != nil {
+				return nil, err
+			}
+
+			data = []byte(opened)
+		}
+
+		// Numbers are decoded as json.Number so that ids render in templates exactly as
+		// they were enqueued, rather than as floats.
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+
+		err = dec.Decode(&email.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		emails = append(emails, &email)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return emails, nil
+}
+
+// MarkSent records that the email was sent, clearing its data since it may hold tokens.
+func (m EmailOutboxModel) MarkSent(id int64) error {
+	query := `
+		UPDATE email_outbox
+		SET status = 'sent', sent_at = NOW(), data = '{}', last_error = ''
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// MarkFailed records a failed attempt to send the email. It is retried by a later batch
+// until it has been attempted maxAttempts times, after which it is marked as failed and
+// MarkFailed reports true along with the number of attempts.
+func (m EmailOutboxModel) MarkFailed(id int64, sendErr error, maxAttempts int) (bool, int, error) {
+	query := `
+		UPDATE email_outbox
+		SET status = CASE WHEN attempts >= $3 THEN 'failed' ELSE 'pending' END, last_error = $2
+		WHERE id = $1
+		RETURNING status = 'failed', attempts`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var failed bool
+	var attempts int
+
+	err := m.DB.QueryRowContext(ctx, query, id, sendErr.Error(), maxAttempts).Scan(&failed, &attempts)
+	if err != nil {
+		return false, 0, err
+	}
+
+	return failed, attempts, nil
+}
+
+// Requeue returns a failed email to the outbox with its attempts reset, so that it is sent
+// again by the next batch.
+func (m EmailOutboxModel) Requeue(id int64) error {
+	query := `
+		UPDATE email_outbox
+		SET status = 'pending', attempts = 0, claimed_at = NULL
+		WHERE id = $1 AND status = 'failed'`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Release returns claimed emails which were not attempted to the outbox, without counting
+// the claim as an attempt.
+func (m EmailOutboxModel) Release(ids []int64) error {
+	query := `
+		UPDATE email_outbox
+		SET status = 'pending', attempts = attempts - 1
+		WHERE id = ANY($1) AND status = 'sending'`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, ids)
+	return err
+}
+
+// Pending returns the number of emails waiting in the outbox to be sent.
+func (m EmailOutboxModel) Pending() (int, error) {
+	query := `
+		SELECT count(*) FROM email_outbox
+		WHERE status = 'pending'`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var pending int
+
+	err := m.DB.ReadQueryRowContext(ctx, query).Scan(&pending)
+	return pending, err
+}
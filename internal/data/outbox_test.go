@@ -0,0 +1,72 @@
+package data
+
+import (
+	"encoding/base64"
+	"greenlight/internal/fieldcrypt"
+	"greenlight/internal/sqlfake"
+	"strings"
+	"testing"
+)
+
+func TestOutboxEncryptsRecipientsAndData(t *testing.T) {
+	keys := func(s string) *fieldcrypt.Keyring {
+		k, err := fieldcrypt.ParseKeys(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+
+	k1 := "k1:" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat("a", 32)))
+	k2 := "k2:" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat("b", 32)))
+
+	var stored [][]any
+
+	db := newTestDB(t, func(query string, args []any) (*sqlfake.Result, error) {
+		switch {
+		case strings.Contains(query, "INSERT INTO email_outbox"):
+			stored = append(stored, []any{int64(len(stored) + 1), args[1], args[2], args[3], int64(0)})
+		case strings.Contains(query, "RETURNING id, recipient"):
+			return &sqlfake.Result{Rows: stored}, nil
+		}
+		return nil, nil
+	})
+
+	// One email enqueued before encryption was enabled, and one sealed with k1.
+	err := EmailOutboxModel{DB: db}.Enqueue(1, "alice@example.com", "user_welcome.tmpl", map[string]any{"activationToken": "PLAINTOKEN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = EmailOutboxModel{DB: db, Keys: keys(k1)}.Enqueue(2, "bob@example.com", "user_welcome.tmpl", map[string]any{"activationToken": "SECRETTOKEN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recipient, data := stored[1][1].(string), string(stored[1][3].([]byte))
+	if !strings.HasPrefix(recipient, "enc:k1:") || strings.Contains(data, "SECRETTOKEN") {
+		t.Errorf("got recipient %q and data %s stored; want both encrypted", recipient, data)
+	}
+	if stored[1][2] != "user_welcome.tmpl" {
+		t.Errorf("got template %v stored; want it in the clear", stored[1][2])
+	}
+
+	// After rotating to k2, both emails are still read back as they were enqueued.
+	emails, err := EmailOutboxModel{DB: db, Keys: keys(k2 + " " + k1)}.ClaimBatch(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(emails) != 2 {
+		t.Fatalf("got %d emails; want 2", len(emails))
+	}
+
+	for i, want := range []struct{ recipient, token string }{
+		{"alice@example.com", "PLAINTOKEN"},
+		{"bob@example.com", "SECRETTOKEN"},
+	} {
+		if emails[i].Recipient != want.recipient || emails[i].Data["activationToken"] != want.token {
+			t.Errorf("email %d: got %q with data %v; want %q with token %q", i+1, emails[i].Recipient, emails[i].Data, want.recipient, want.token)
+		}
+	}
+}
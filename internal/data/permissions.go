@@ -3,6 +3,7 @@ package data
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"time"
 )
 
@@ -17,8 +18,11 @@ func (p Permissions) Include(code string) bool {
 	return false
 }
 
+// PermissionModel reads and grants permissions. With IncludeRoles set, the permissions of a
+// user are those granted to them directly together with those of every role they hold.
 type PermissionModel struct {
-	DB *sql.DB
+	DB           *DB
+	IncludeRoles bool
 }
 
 func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
@@ -29,10 +33,29 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 		INNER JOIN users ON users_permissions.user_id = users.id
 		WHERE users.id = $1`
 
+	if m.IncludeRoles {
+		query += `
+		UNION
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+		INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+		WHERE users_roles.user_id = $1`
+	}
+
+	return m.codes(query, userID)
+}
+
+// GetAll returns every permission code which exists.
+func (m PermissionModel) GetAll() (Permissions, error) {
+	return m.codes(`SELECT code FROM permissions ORDER BY code`)
+}
+
+func (m PermissionModel) codes(query string, args ...any) (Permissions, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, userID)
+	rows, err := m.DB.ReadQueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -70,3 +93,35 @@ func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
 	_, err := m.DB.ExecContext(ctx, query, userID, codes)
 	return err
 }
+
+// AddRoleForUser grants the user every permission of the role directly, in a single
+// statement. Unlike holding the role, the permissions stay granted when the role changes or
+// is deleted, and do not depend on IncludeRoles.
+func (m PermissionModel) AddRoleForUser(userID int64, role string) error {
+	query := `
+		WITH role AS (
+			SELECT id FROM roles WHERE name = $2
+		), granted AS (
+			INSERT INTO users_permissions (user_id, permission_id)
+			SELECT $1, permission_id FROM roles_permissions WHERE role_id IN (SELECT id FROM role)
+			ON CONFLICT DO NOTHING
+		)
+		SELECT id FROM role`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int64
+
+	err := m.DB.QueryRowContext(ctx, query, userID, role).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
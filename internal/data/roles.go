@@ -0,0 +1,191 @@
+package data
+
+import (
+	"context"
+	"greenlight/internal/validator"
+	"regexp"
+	"time"
+)
+
+var roleNameRX = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// Role is a named set of permissions. Users holding a role have its permissions for as long
+// as they hold it, so changing the permissions of a role changes them for all its users.
+type Role struct {
+	Name        string    `json:"name"`
+	Permissions []string  `json:"permissions"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func ValidateRoleName(v *validator.Validator, name string) {
+	v.Check(name != "", "name", "must be provided")
+	v.Check(len(name) <= 50, "name", "must not be more than 50 bytes long")
+	v.Check(validator.Matches(name, roleNameRX), "name", "must only contain lowercase letters, digits, dashes and underscores")
+}
+
+// ValidateRole checks the role against the permission codes which exist.
+func ValidateRole(v *validator.Validator, role *Role, codes Permissions) {
+	ValidateRoleName(v, role.Name)
+
+	v.Check(role.Permissions != nil, "permissions", "must be provided")
+	v.Check(validator.Unique(role.Permissions), "permissions", "must not contain duplicate values")
+
+	for _, code := range role.Permissions {
+		if !codes.Include(code) {
+			v.AddError("permissions", "must only contain existing permission codes")
+			break
+		}
+	}
+}
+
+type RoleModel struct {
+	DB *DB
+}
+
+// Upsert creates the role, or replaces the permissions of an existing one, in a single
+// statement.
+func (m RoleModel) Upsert(role *Role) error {
+	query := `
+		WITH role AS (
+			INSERT INTO roles (name)
+			VALUES ($1)
+			ON CONFLICT (name) DO UPDATE SET updated_at = NOW()
+			RETURNING id, updated_at
+		), revoked AS (
+			DELETE FROM roles_permissions
+			WHERE role_id IN (SELECT id FROM role)
+			AND permission_id NOT IN (SELECT id FROM permissions WHERE code = ANY($2))
+		), granted AS (
+			INSERT INTO roles_permissions (role_id, permission_id)
+			SELECT role.id, permissions.id FROM role, permissions WHERE permissions.code = ANY($2)
+			ON CONFLICT DO NOTHING
+		)
+		SELECT updated_at FROM role`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, role.Name, role.Permissions).Scan(&role.UpdatedAt)
+}
+
+func (m RoleModel) GetAll() ([]*Role, error) {
+	query := `
+		SELECT roles.name, roles.updated_at,
+			COALESCE(array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+		FROM roles
+		LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+		LEFT JOIN permissions ON permissions.id = roles_permissions.permission_id
+		GROUP BY roles.id
+		ORDER BY roles.name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.ReadQueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+		var role Role
+
+		err := rows.Scan(&role.Name, &role.UpdatedAt, textArray(&role.Permissions))
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, &role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+func (m RoleModel) Delete(name string) error {
+	query := `
+		DELETE FROM roles
+		WHERE name = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, name)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m RoleModel) GetAllForUser(userID int64) ([]string, error) {
+	query := `
+		SELECT roles.name
+		FROM roles
+		INNER JOIN users_roles ON users_roles.role_id = roles.id
+		WHERE users_roles.user_id = $1
+		ORDER BY roles.name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.ReadQueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	names := []string{}
+
+	for rows.Next() {
+		var name string
+
+		err := rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+
+		names = append(names, name)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// SetForUser replaces the roles held by the user. Names which are not roles are ignored.
+func (m RoleModel) SetForUser(userID int64, names []string) error {
+	query := `
+		WITH revoked AS (
+			DELETE FROM users_roles
+			WHERE user_id = $1
+			AND role_id NOT IN (SELECT id FROM roles WHERE name = ANY($2))
+		)
+		INSERT INTO users_roles (user_id, role_id)
+		SELECT $1, roles.id FROM roles WHERE roles.name = ANY($2)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, names)
+	return err
+}
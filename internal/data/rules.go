@@ -0,0 +1,89 @@
+package data
+
+import "greenlight/internal/validator"
+
+// Limits enforced by ValidateMovie and ValidateUser, which are also reported to clients by
+// MovieRules and UserRules.
+const (
+	MaxTitleBytes    = 500
+	MaxNameBytes     = 500
+	MinPasswordBytes = 8
+	MaxPasswordBytes = 72
+)
+
+// FieldRule describes how one field of a request body is validated, so that clients can
+// build forms which agree with the server. Lengths are in bytes, and the minimum of a runtime
+// applies to its number of minutes.
+type FieldRule struct {
+	Type        string `json:"type"`
+	Required    bool   `json:"required"`
+	MinLength   *int   `json:"min_length,omitempty"`
+	MaxLength   *int   `json:"max_length,omitempty"`
+	Minimum     *int64 `json:"minimum,omitempty"`
+	Maximum     *int64 `json:"maximum,omitempty"`
+	Pattern     string `json:"pattern,omitempty"`
+	MinItems    *int   `json:"min_items,omitempty"`
+	MaxItems    *int   `json:"max_items,omitempty"`
+	UniqueItems bool   `json:"unique_items,omitempty"`
+	Items       string `json:"items,omitempty"`
+}
+
+// MovieRules returns the rules ValidateMovie checks movies against, with years between
+// minYear and maxYear inclusive.
+func MovieRules(minYear, maxYear int32) map[string]FieldRule {
+	return map[string]FieldRule{
+		"title": {
+			Type:      "string",
+			Required:  true,
+			MinLength: ptr(1),
+			MaxLength: ptr(MaxTitleBytes),
+		},
+		"year": {
+			Type:     "integer",
+			Required: true,
+			Minimum:  ptr(int64(minYear)),
+			Maximum:  ptr(int64(maxYear)),
+		},
+		"runtime": {
+			Type:     "string",
+			Required: true,
+			Pattern:  "^[0-9]+ mins$",
+			Minimum:  ptr(int64(1)),
+		},
+		"genres": {
+			Type:        "array",
+			Required:    true,
+			MinItems:    ptr(1),
+			MaxItems:    ptr(MaxGenres),
+			UniqueItems: true,
+			Items:       "string",
+		},
+	}
+}
+
+// UserRules returns the rules ValidateUser checks new users against.
+func UserRules() map[string]FieldRule {
+	return map[string]FieldRule{
+		"name": {
+			Type:      "string",
+			Required:  true,
+			MinLength: ptr(1),
+			MaxLength: ptr(MaxNameBytes),
+		},
+		"email": {
+			Type:     "string",
+			Required: true,
+			Pattern:  validator.EmailRX.String(),
+		},
+		"password": {
+			Type:      "string",
+			Required:  true,
+			MinLength: ptr(MinPasswordBytes),
+			MaxLength: ptr(MaxPasswordBytes),
+		},
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
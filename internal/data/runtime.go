@@ -7,7 +7,7 @@ import (
 	"strings"
 )
 
-var ErrInvalidRuntimeFormat = errors.New("invalid runtime format")
+var ErrInvalidRuntimeFormat = errors.New(`invalid runtime format, must be a number of minutes or "<n> mins"`)
 
 type Runtime int32
 
@@ -17,7 +17,20 @@ func (r Runtime) MarshalJSON() ([]byte, error) {
 	return []byte(jsonValue), nil
 }
 
+// UnmarshalJSON accepts a runtime as a number of minutes, such as 107, as well as in the
+// "107 mins" form it is written in.
 func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
+	if len(jsonValue) > 0 && jsonValue[0] != '"' {
+		i, err := strconv.ParseInt(string(jsonValue), 10, 32)
+		if err != nil {
+			return ErrInvalidRuntimeFormat
+		}
+
+		*r = Runtime(i)
+
+		return nil
+	}
+
 	unquotedJSONValue, err := strconv.Unquote(string(jsonValue))
 	if err != nil {
 		return ErrInvalidRuntimeFormat
@@ -0,0 +1,63 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"greenlight/internal/validator"
+	"testing"
+)
+
+func TestRuntimeUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Runtime
+		wantErr error
+	}{
+		{`107`, 107, nil},
+		{`"107 mins"`, 107, nil},
+		{`0`, 0, nil},
+		{`"107 hours"`, 0, ErrInvalidRuntimeFormat},
+		{`"107"`, 0, ErrInvalidRuntimeFormat},
+		{`107.5`, 0, ErrInvalidRuntimeFormat},
+		{`true`, 0, ErrInvalidRuntimeFormat},
+		{`4294967296`, 0, ErrInvalidRuntimeFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			var got Runtime
+
+			err := json.Unmarshal([]byte(tt.input), &got)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("got runtime %d; want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuntimeMarshalJSON(t *testing.T) {
+	got, err := json.Marshal(Runtime(107))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != `"107 mins"` {
+		t.Errorf("got %s; want %q", got, "107 mins")
+	}
+}
+
+func TestValidateMovieRejectsNonPositiveRuntimes(t *testing.T) {
+	for _, runtime := range []Runtime{0, -107} {
+		v := validator.New()
+
+		ValidateMovie(v, &Movie{Title: "Alien", Year: 1979, Runtime: runtime, Genres: []string{"Horror"}}, 1888, 2100)
+
+		if v.Errors["runtime"] == "" {
+			t.Errorf("runtime %d: got errors %v; want runtime rejected", runtime, v.Errors)
+		}
+	}
+}
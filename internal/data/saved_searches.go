@@ -0,0 +1,160 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"greenlight/internal/validator"
+	"time"
+)
+
+type SavedSearch struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    int64     `json:"-"`
+	Name      string    `json:"name"`
+	Title     string    `json:"title"`
+	Genres    []string  `json:"genres"`
+	Sort      string    `json:"sort"`
+	PageSize  int       `json:"page_size"`
+}
+
+// Filters returns the stored search as Filters for the given page, so that a saved search
+// is validated and executed exactly like a live request to the list endpoint.
+func (s *SavedSearch) Filters(page int, sortSafeList []string) Filters {
+	return Filters{
+		Page:         page,
+		PageSize:     s.PageSize,
+		Sort:         s.Sort,
+		SortSafeList: sortSafeList,
+	}
+}
+
+func ValidateSavedSearch(v *validator.Validator, search *SavedSearch, sortSafeList []string) {
+	v.Check(search.Name != "", "name", "must be provided")
+	v.Check(len(search.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(len(search.Title) <= 500, "title", "must not be more than 500 bytes long")
+	v.Check(search.Genres != nil, "genres", "must be provided")
+	v.Check(validator.Unique(search.Genres), "genres", "must not contain duplicate values")
+
+	ValidateFilters(v, search.Filters(1, sortSafeList))
+	ValidateMovieSearch(v, search.Title, search.Filters(1, sortSafeList))
+}
+
+type SavedSearchModel struct {
+	DB *DB
+}
+
+func (m SavedSearchModel) Insert(search *SavedSearch) error {
+	query := `
+		INSERT INTO saved_searches (user_id, name, title, genres, sort, page_size)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+
+	args := []any{search.UserID, search.Name, search.Title, search.Genres, search.Sort, search.PageSize}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&search.ID, &search.CreatedAt)
+}
+
+// GetForUser returns the saved search with the given id, but only if it belongs to userID.
+func (m SavedSearchModel) GetForUser(id, userID int64) (*SavedSearch, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, user_id, name, title, genres, sort, page_size
+		FROM saved_searches
+		WHERE id = $1 AND user_id = $2`
+
+	var search SavedSearch
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(
+		&search.ID,
+		&search.CreatedAt,
+		&search.UserID,
+		&search.Name,
+		&search.Title,
+		textArray(&search.Genres),
+		&search.Sort,
+		&search.PageSize,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &search, nil
+}
+
+func (m SavedSearchModel) GetAllForUser(userID int64) ([]*SavedSearch, error) {
+	query := `
+		SELECT id, created_at, user_id, name, title, genres, sort, page_size
+		FROM saved_searches
+		WHERE user_id = $1
+		ORDER BY id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.ReadQueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	searches := []*SavedSearch{}
+
+	for rows.Next() {
+		var search SavedSearch
+
+		err := rows.Scan(
+			&search.ID,
+			&search.CreatedAt,
+			&search.UserID,
+			&search.Name,
+			&search.Title,
+			textArray(&search.Genres),
+			&search.Sort,
+			&search.PageSize,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		searches = append(searches, &search)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return searches, nil
+}
+
+func (m SavedSearchModel) CountForUser(userID int64) (int, error) {
+	query := `
+		SELECT count(*)
+		FROM saved_searches
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,126 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxSlugLength caps the length of the slug generated from a title, before any suffix.
+const maxSlugLength = 100
+
+var SlugRX = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+
+var slugSeparatorRX = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Slugify turns a title into a lowercase, hyphen separated slug such as "the-godfather".
+// Characters other than ASCII letters and digits are dropped.
+func Slugify(title string) string {
+	slug := slugSeparatorRX.ReplaceAllString(strings.ToLower(title), "-")
+	slug = strings.Trim(slug, "-")
+
+	if len(slug) > maxSlugLength {
+		slug = strings.TrimRight(slug[:maxSlugLength], "-")
+	}
+
+	if slug == "" {
+		return "movie"
+	}
+
+	return slug
+}
+
+// slugMatches reports whether slug is base, or base with a numeric suffix.
+func slugMatches(slug, base string) bool {
+	suffix, ok := strings.CutPrefix(slug, base+"-")
+	if !ok {
+		return slug == base
+	}
+
+	return suffix != "" && strings.Trim(suffix, "0123456789") == ""
+}
+
+// uniqueSlug returns the slug for a movie titled title: the slugified title, followed by the
+// lowest numeric suffix from 2 up which makes it unique when another movie already uses it,
+// either as its slug or as an alias.
+func (m MovieModel) uniqueSlug(ctx context.Context, title string, movieID int64) (string, error) {
+	base := Slugify(title)
+
+	query := `
+		SELECT slug FROM movies
+		WHERE (slug = $1 OR slug LIKE $1 || '-%') AND id <> $2
+		UNION
+		SELECT slug FROM movie_slug_aliases
+		WHERE (slug = $1 OR slug LIKE $1 || '-%') AND movie_id <> $2`
+
+	rows, err := m.DB.QueryContext(ctx, query, base, movieID)
+	if err != nil {
+		return "", err
+	}
+
+	defer rows.Close()
+
+	taken := make(map[string]bool)
+
+	for rows.Next() {
+		var slug string
+
+		err := rows.Scan(&slug)
+		if err != nil {
+			return "", err
+		}
+
+		taken[slug] = true
+	}
+
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+
+	if !taken[base] {
+		return base, nil
+	}
+
+	for n := 2; ; n++ {
+		slug := fmt.Sprintf("%s-%d", base, n)
+		if !taken[slug] {
+			return slug, nil
+		}
+	}
+}
+
+// isSlugConflict reports whether err is a violation of the unique slug constraint, caused by
+// a concurrent write taking the same slug.
+func isSlugConflict(err error) bool {
+	return isUniqueViolation(err, "movies_slug_key")
+}
+
+// GetSlugAlias returns the current slug of the movie which used to have the given slug.
+func (m MovieModel) GetSlugAlias(slug string) (string, error) {
+	query := `
+		SELECT movies.slug
+		FROM movie_slug_aliases
+		INNER JOIN movies ON movies.id = movie_slug_aliases.movie_id
+		WHERE movie_slug_aliases.slug = $1`
+
+	var current string
+
+	ctx, cancel := context.WithTimeout(m.parentContext(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, slug).Scan(&current)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+
+	return current, nil
+}
@@ -6,6 +6,8 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
+	"greenlight/internal/clock"
 	"greenlight/internal/validator"
 	"time"
 )
@@ -13,6 +15,9 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopeAPIKey         = "api-key"
+	ScopeUnsubscribe    = "unsubscribe"
+	ScopePasswordReset  = "password-reset"
 )
 
 type Token struct {
@@ -23,10 +28,10 @@ type Token struct {
 	Scope     string    `json:"-"`
 }
 
-func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
+func generateToken(userID int64, ttl time.Duration, scope string, now time.Time) (*Token, error) {
 	token := &Token{
 		UserID: userID,
-		Expiry: time.Now().Add(ttl),
+		Expiry: now.Add(ttl),
 		Scope:  scope,
 	}
 
@@ -50,7 +55,8 @@ func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 }
 
 type TokenModel struct {
-	DB *sql.DB
+	DB    *DB
+	Clock clock.Clock
 }
 
 func (m TokenModel) Insert(token *Token) error {
@@ -68,7 +74,7 @@ func (m TokenModel) Insert(token *Token) error {
 }
 
 func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
-	token, err := generateToken(userID, ttl, scope)
+	token, err := generateToken(userID, ttl, scope, m.Clock.Now())
 	if err != nil {
 		return nil, err
 	}
@@ -77,6 +83,56 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
+// RecordConsumed remembers that the token was used by the user, so that a second attempt to
+// use it can be told apart from an invalid token. Records older than retention are pruned
+// at the same time.
+func (m TokenModel) RecordConsumed(scope, tokenPlaintext string, userID int64, retention time.Duration) error {
+	query := `
+		WITH pruned AS (
+			DELETE FROM consumed_tokens
+			WHERE consumed_at < $5
+		)
+		INSERT INTO consumed_tokens (hash, user_id, scope, consumed_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (hash) DO NOTHING`
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	now := m.Clock.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, tokenHash[:], userID, scope, now, now.Add(-retention))
+	return err
+}
+
+// GetConsumed returns the id of the user who used the token no longer than retention ago.
+func (m TokenModel) GetConsumed(scope, tokenPlaintext string, retention time.Duration) (int64, error) {
+	query := `
+		SELECT user_id
+		FROM consumed_tokens
+		WHERE hash = $1 AND scope = $2 AND consumed_at >= $3`
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var userID int64
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], scope, m.Clock.Now().Add(-retention)).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
+
 func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	query := `
 		DELETE FROM tokens
@@ -0,0 +1,98 @@
+package data
+
+import (
+	"bytes"
+	"errors"
+	"greenlight/internal/clock"
+	"greenlight/internal/sqlfake"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestDB returns a DB whose queries are answered by h.
+func newTestDB(t *testing.T, h sqlfake.Handler) *DB {
+	t.Helper()
+
+	db := sqlfake.Open(h)
+	t.Cleanup(func() { db.Close() })
+
+	return &DB{DB: db}
+}
+
+func TestTokenExpiryFollowsTheClock(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, time.April, 1, 12, 0, 0, 0, time.UTC))
+
+	var stored *Token
+
+	db := newTestDB(t, func(query string, args []any) (*sqlfake.Result, error) {
+		switch {
+		case strings.Contains(query, "INSERT INTO tokens"):
+			stored = &Token{Hash: args[0].([]byte), UserID: args[1].(int64), Expiry: args[2].(time.Time), Scope: args[3].(string)}
+			return nil, nil
+
+		case strings.Contains(query, "INNER JOIN tokens"):
+			hash, scope, now := args[0].([]byte), args[1].(string), args[2].(time.Time)
+			if stored == nil || !bytes.Equal(stored.Hash, hash) || stored.Scope != scope || !stored.Expiry.After(now) {
+				return nil, nil
+			}
+			return &sqlfake.Result{Rows: [][]any{{stored.UserID, clk.Now(), "Alice", "alice@example.com", []byte("hash"), true, int64(1)}}}, nil
+		}
+
+		t.Fatalf("unexpected query %q", query)
+		return nil, nil
+	})
+
+	models := NewModels(db, clk)
+
+	token, err := models.Tokens.New(7, time.Hour, ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !token.Expiry.Equal(clk.Now().Add(time.Hour)) {
+		t.Errorf("got expiry %s; want an hour from now", token.Expiry)
+	}
+
+	clk.Advance(59 * time.Minute)
+
+	user, err := models.Users.GetForToken(ScopeAuthentication, token.Plaintext)
+	if err != nil {
+		t.Fatalf("before expiry: %v", err)
+	}
+	if user.ID != 7 {
+		t.Errorf("got user %d; want 7", user.ID)
+	}
+
+	clk.Advance(time.Minute)
+
+	if _, err := models.Users.GetForToken(ScopeAuthentication, token.Plaintext); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("at expiry: got error %v; want ErrRecordNotFound", err)
+	}
+}
+
+func TestTokenExpiryToleratesClockSkew(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, time.April, 1, 12, 0, 0, 0, time.UTC))
+	expiry := clk.Now().Add(time.Hour)
+
+	db := newTestDB(t, func(query string, args []any) (*sqlfake.Result, error) {
+		if !expiry.After(args[2].(time.Time)) {
+			return nil, nil
+		}
+		return &sqlfake.Result{Rows: [][]any{{int64(7), clk.Now(), "Alice", "alice@example.com", []byte("hash"), true, int64(1)}}}, nil
+	})
+
+	models := NewModels(db, clk)
+	models.Users.ClockSkew = 30 * time.Second
+
+	clk.Advance(time.Hour + 29*time.Second)
+
+	if _, err := models.Users.GetForToken(ScopeAuthentication, "ABCDEFGHIJKLMNOPQRSTUVWXYZ"); err != nil {
+		t.Errorf("expired within the skew: got error %v; want the user", err)
+	}
+
+	clk.Advance(2 * time.Second)
+
+	if _, err := models.Users.GetForToken(ScopeAuthentication, "ABCDEFGHIJKLMNOPQRSTUVWXYZ"); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expired beyond the skew: got error %v; want ErrRecordNotFound", err)
+	}
+}
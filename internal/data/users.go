@@ -5,7 +5,10 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
+	"greenlight/internal/clock"
 	"greenlight/internal/validator"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -68,13 +71,13 @@ func ValidateEmail(v *validator.Validator, email string) {
 
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
 	v.Check(password != "", "password", "must be provided")
-	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+	v.Check(len(password) >= MinPasswordBytes, "password", fmt.Sprintf("must be at least %d bytes long", MinPasswordBytes))
+	v.Check(len(password) <= MaxPasswordBytes, "password", fmt.Sprintf("must not be more than %d bytes long", MaxPasswordBytes))
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
 	v.Check(user.Name != "", "name", "must be provided")
-	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(len(user.Name) <= MaxNameBytes, "name", fmt.Sprintf("must not be more than %d bytes long", MaxNameBytes))
 
 	ValidateEmail(v, user.Email)
 
@@ -87,8 +90,17 @@ func ValidateUser(v *validator.Validator, user *User) {
 	}
 }
 
+// isDuplicateEmail reports whether err is a violation of the unique email constraint.
+func isDuplicateEmail(err error) bool {
+	return isUniqueViolation(err, "users_email_key")
+}
+
+// UserModel reads and writes users. Tokens are still accepted for ClockSkew after they
+// expire, to allow for clock differences between the API servers and the database.
 type UserModel struct {
-	DB *sql.DB
+	DB        *DB
+	Clock     clock.Clock
+	ClockSkew time.Duration
 }
 
 func (m UserModel) Insert(user *User) error {
@@ -105,7 +117,7 @@ func (m UserModel) Insert(user *User) error {
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
 	if err != nil {
 		switch {
-		case err.Error() == `ERROR: duplicate key value violates unique constraint "users_email_key" (SQLSTATE 23505)`:
+		case isDuplicateEmail(err):
 			return ErrDuplicateEmail
 		default:
 			return err
@@ -114,11 +126,48 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
+func (m UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, name, email, password_hash, activated, version
+		FROM users
+		WHERE id = $1 AND deleted_at IS NULL`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
 		SELECT id, created_at, name, email, password_hash, activated, version
 		FROM users
-		WHERE email = $1`
+		WHERE email = $1 AND deleted_at IS NULL`
 
 	var user User
 
@@ -151,7 +200,7 @@ func (m UserModel) Update(user *User) error {
 	query := `
 		UPDATE users
 		SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-		WHERE id = $5 AND version = $6
+		WHERE id = $5 AND version = $6 AND deleted_at IS NULL
 		RETURNING version`
 
 	args := []any{
@@ -169,7 +218,7 @@ func (m UserModel) Update(user *User) error {
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
 		switch {
-		case err.Error() == `ERROR: duplicate key value violates unique constraint "users_email_key" (SQLSTATE 23505)`:
+		case isDuplicateEmail(err):
 			return ErrDuplicateEmail
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
@@ -189,9 +238,9 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		FROM users
 		INNER JOIN tokens
 		ON users.id = tokens.user_id
-		WHERE tokens.hash = $1 AND tokens.scope = $2 AND tokens.expiry > $3`
+		WHERE tokens.hash = $1 AND tokens.scope = $2 AND tokens.expiry > $3 AND users.deleted_at IS NULL`
 
-	args := []any{tokenHash[:], tokenScope, time.Now()}
+	args := []any{tokenHash[:], tokenScope, m.Clock.Now().Add(-m.ClockSkew)}
 
 	var user User
 
@@ -218,3 +267,199 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 
 	return &user, nil
 }
+
+// SoftDelete marks the user as deleted, which hides them from every lookup, and deletes
+// all their tokens so that they are signed out straight away. The user is kept, so that
+// they can be restored, until PurgeDeleted removes them.
+func (m UserModel) SoftDelete(id int64) error {
+	query := `
+		WITH deleted AS (
+			UPDATE users
+			SET deleted_at = $2, version = version + 1
+			WHERE id = $1 AND deleted_at IS NULL
+			RETURNING id
+		), signed_out AS (
+			DELETE FROM tokens
+			WHERE user_id IN (SELECT id FROM deleted)
+		)
+		SELECT id FROM deleted`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, m.Clock.Now()).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete permanently deletes the user, and with them their tokens, permissions, every email
+// sent to them through the outbox and the dead letters of those emails, which hold their
+// address and tokens. The rest of their data is removed by the foreign keys, apart from the
+// movies and exports they own, which are kept without an owner. Everything is deleted in
+// one transaction, so that a failure part way leaves the user as they were.
+func (m UserModel) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `SELECT id FROM users WHERE id = $1 FOR UPDATE`, id).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	queries := []string{
+		`DELETE FROM tokens WHERE user_id = $1`,
+		`DELETE FROM users_permissions WHERE user_id = $1`,
+		`DELETE FROM dead_letters WHERE kind = 'email' AND source_id IN (SELECT id FROM email_outbox WHERE user_id = $1)`,
+		`DELETE FROM email_outbox WHERE user_id = $1`,
+		`DELETE FROM users WHERE id = $1`,
+	}
+
+	for _, query := range queries {
+		_, err = tx.ExecContext(ctx, query, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Restore reinstates a soft-deleted user. Their tokens are not restored, so they need to
+// sign in again.
+func (m UserModel) Restore(id int64) (*User, error) {
+	query := `
+		UPDATE users
+		SET deleted_at = NULL, version = version + 1
+		WHERE id = $1 AND deleted_at IS NOT NULL
+		RETURNING id, created_at, name, email, password_hash, activated, version`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// PurgeDeleted permanently deletes the users soft-deleted before the given time. Their
+// emails in the outbox go with them through the foreign key, and the dead letters of those
+// emails are deleted alongside.
+func (m UserModel) PurgeDeleted(before time.Time) (int64, error) {
+	query := `
+		WITH purged AS (
+			DELETE FROM users
+			WHERE deleted_at < $1
+			RETURNING id
+		), letters AS (
+			DELETE FROM dead_letters
+			WHERE kind = 'email' AND source_id IN (
+				SELECT email_outbox.id FROM email_outbox JOIN purged ON email_outbox.user_id = purged.id
+			)
+		)
+		SELECT count(*) FROM purged`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var purged int64
+
+	err := m.DB.QueryRowContext(ctx, query, before).Scan(&purged)
+	if err != nil {
+		return 0, err
+	}
+
+	return purged, nil
+}
+
+// GetAll returns a page of users whose email address contains email, ignoring case, and
+// which are activated or not as given by activated, when it is not nil. The password hashes
+// are not read, since a user list has no use for them.
+func (m UserModel) GetAll(email string, activated *bool, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, name, email, activated, version
+		FROM users
+		WHERE (email ILIKE '%%' || $1 || '%%' OR $1 = '')
+		AND ($2::boolean IS NULL OR activated = $2)
+		AND deleted_at IS NULL
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	// The email is matched literally, so the LIKE wildcards in it are escaped.
+	email = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(email)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.ReadQueryContext(ctx, query, email, activated, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Activated,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return users, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
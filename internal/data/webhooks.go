@@ -0,0 +1,136 @@
+package data
+
+import (
+	"context"
+	"greenlight/internal/validator"
+	"greenlight/internal/webhook"
+	"net/url"
+	"time"
+)
+
+const (
+	EventMovieCreated  = "movie.created"
+	EventMovieUpdated  = "movie.updated"
+	EventMovieDeleted  = "movie.deleted"
+	EventMovieRestored = "movie.restored"
+)
+
+// WebhookFields lists the movie fields a webhook can select or filter on. Filters compare
+// the runtime in minutes.
+var WebhookFields = []string{"id", "title", "slug", "year", "runtime", "genres", "version"}
+
+type Webhook struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Fields    []string  `json:"fields"`
+	Filter    string    `json:"filter"`
+}
+
+func ValidateWebhook(v *validator.Validator, hook *Webhook) {
+	u, err := url.Parse(hook.URL)
+	v.Check(hook.URL != "", "url", "must be provided")
+	v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "must be an absolute http or https URL")
+
+	v.Check(len(hook.Events) >= 1, "events", "must contain at least 1 event")
+	v.Check(validator.Unique(hook.Events), "events", "must not contain duplicate values")
+	for _, event := range hook.Events {
+		v.Check(validator.PermittedValue(event, EventMovieCreated, EventMovieUpdated, EventMovieDeleted, EventMovieRestored), "events", "contains an unknown event")
+	}
+
+	v.Check(validator.Unique(hook.Fields), "fields", "must not contain duplicate values")
+	for _, field := range hook.Fields {
+		v.Check(validator.PermittedValue(field, WebhookFields...), "fields", "contains an unknown field")
+	}
+
+	_, err = webhook.ParseFilter(hook.Filter, WebhookFields)
+	if err != nil {
+		v.AddError("filter", err.Error())
+	}
+}
+
+type WebhookModel struct {
+	DB *DB
+}
+
+func (m WebhookModel) Insert(hook *Webhook) error {
+	query := `
+		INSERT INTO webhooks (url, events, fields, filter)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`
+
+	args := []any{hook.URL, hook.Events, hook.Fields, hook.Filter}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&hook.ID, &hook.CreatedAt)
+}
+
+// GetAll returns every webhook, or only those subscribed to event when it is not empty.
+func (m WebhookModel) GetAll(event string) ([]*Webhook, error) {
+	query := `
+		SELECT id, created_at, url, events, fields, filter
+		FROM webhooks
+		WHERE ($1 = '' OR $1 = ANY(events))
+		ORDER BY id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.ReadQueryContext(ctx, query, event)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	hooks := []*Webhook{}
+
+	for rows.Next() {
+		var hook Webhook
+
+		err := rows.Scan(&hook.ID, &hook.CreatedAt, &hook.URL, textArray(&hook.Events), textArray(&hook.Fields), &hook.Filter)
+		if err != nil {
+			return nil, err
+		}
+
+		hooks = append(hooks, &hook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return hooks, nil
+}
+
+func (m WebhookModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM webhooks
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
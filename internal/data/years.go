@@ -0,0 +1,61 @@
+package data
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// YearBound is a bound on movie years. It is either an absolute year, or relative to the
+// current year so that a bound such as "current+2" keeps moving as time passes.
+type YearBound struct {
+	Year     int32
+	Relative bool
+}
+
+// ParseYearBound parses an absolute year such as "1888", or a year relative to the current
+// one such as "current", "current+2" or "current-1".
+func ParseYearBound(s string) (YearBound, error) {
+	s = strings.TrimSpace(s)
+
+	if rest, ok := strings.CutPrefix(s, "current"); ok {
+		if rest == "" {
+			return YearBound{Relative: true}, nil
+		}
+
+		offset, err := strconv.ParseInt(rest, 10, 32)
+		if err != nil || (rest[0] != '+' && rest[0] != '-') {
+			return YearBound{}, fmt.Errorf("invalid relative year %q", s)
+		}
+
+		return YearBound{Year: int32(offset), Relative: true}, nil
+	}
+
+	year, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return YearBound{}, fmt.Errorf("invalid year %q", s)
+	}
+
+	return YearBound{Year: int32(year)}, nil
+}
+
+// Resolve returns the bound as an absolute year at the given time.
+func (b YearBound) Resolve(now time.Time) int32 {
+	if b.Relative {
+		return int32(now.Year()) + b.Year
+	}
+
+	return b.Year
+}
+
+func (b YearBound) String() string {
+	switch {
+	case !b.Relative:
+		return strconv.Itoa(int(b.Year))
+	case b.Year == 0:
+		return "current"
+	default:
+		return fmt.Sprintf("current%+d", b.Year)
+	}
+}
@@ -0,0 +1,46 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseYearBound(t *testing.T) {
+	now := time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value   string
+		want    int32
+		wantStr string
+	}{
+		{"1888", 1888, "1888"},
+		{" 1900 ", 1900, "1900"},
+		{"current", 2024, "current"},
+		{"current+2", 2026, "current+2"},
+		{"current-1", 2023, "current-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			bound, err := ParseYearBound(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := bound.Resolve(now); got != tt.want {
+				t.Errorf("Resolve() = %d; want %d", got, tt.want)
+			}
+			if got := bound.String(); got != tt.wantStr {
+				t.Errorf("String() = %q; want %q", got, tt.wantStr)
+			}
+		})
+	}
+}
+
+func TestParseYearBoundRejectsInvalidValues(t *testing.T) {
+	for _, value := range []string{"", "soon", "current2", "current+", "current+two", "19o0"} {
+		if _, err := ParseYearBound(value); err == nil {
+			t.Errorf("ParseYearBound(%q): got nil error", value)
+		}
+	}
+}
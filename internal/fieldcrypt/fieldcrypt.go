@@ -0,0 +1,175 @@
+// Package fieldcrypt encrypts individual database fields with AES-256-GCM, so that sensitive
+// values are unreadable in the raw columns. Every ciphertext records the id of the key it
+// was sealed with, so keys can be rotated while older values remain readable.
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// prefix marks encrypted values, as enc:<key id>:<base64 nonce and ciphertext>. Values
+// without it are taken to have been stored before encryption was enabled, and are read as
+// they are.
+const prefix = "enc:"
+
+var (
+	ErrUnknownKey = errors.New("fieldcrypt: value was encrypted with an unknown key")
+	ErrMalformed  = errors.New("fieldcrypt: malformed encrypted value")
+)
+
+// Keyring holds the keys values are decrypted with. New values are always encrypted with
+// the current key. A nil *Keyring leaves values unencrypted.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// ParseKeys parses a space separated list of id:key pairs, where each key is 32 bytes
+// encoded with standard base64. The first key is the current one; the others are only
+// used to decrypt values sealed before a rotation. An empty list returns a nil Keyring.
+func ParseKeys(s string) (*Keyring, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+
+	for i, field := range fields {
+		id, encoded, ok := strings.Cut(field, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("key %d must be of the form id:key", i+1)
+		}
+
+		if _, exists := k.keys[id]; exists {
+			return nil, fmt.Errorf("duplicate key id %q", id)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("key %q must be 32 bytes encoded as base64", id)
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		k.keys[id] = aead
+
+		if i == 0 {
+			k.current = id
+		}
+	}
+
+	return k, nil
+}
+
+// Encrypt seals plaintext with the current key.
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	if k == nil {
+		return plaintext, nil
+	}
+
+	aead := k.keys[k.current]
+
+	nonce := make([]byte, aead.NonceSize())
+
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(k.current))
+
+	return prefix + k.current + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value sealed by Encrypt with any key in the keyring. Values which were
+// never encrypted are returned unchanged.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !strings.HasPrefix(value, prefix) {
+		return value, nil
+	}
+
+	id, encoded, ok := strings.Cut(strings.TrimPrefix(value, prefix), ":")
+	if !ok {
+		return "", ErrMalformed
+	}
+
+	if k == nil {
+		return "", ErrUnknownKey
+	}
+
+	aead, ok := k.keys[id]
+	if !ok {
+		return "", ErrUnknownKey
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", ErrMalformed
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(id))
+	if err != nil {
+		return "", ErrMalformed
+	}
+
+	return string(plaintext), nil
+}
+
+// Seal encrypts in place every string field of the struct pointed to by v which is tagged
+// `encrypt:"true"`.
+func (k *Keyring) Seal(v any) error {
+	return k.each(v, k.Encrypt)
+}
+
+// Open decrypts in place the fields encrypted by Seal.
+func (k *Keyring) Open(v any) error {
+	return k.each(v, k.Decrypt)
+}
+
+func (k *Keyring) each(v any, fn func(string) (string, error)) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("fieldcrypt: value must be a pointer to a struct")
+	}
+
+	rv = rv.Elem()
+	rt := rv.Type()
+
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.Tag.Get("encrypt") != "true" {
+			continue
+		}
+
+		if field.Type.Kind() != reflect.String {
+			return fmt.Errorf("fieldcrypt: field %s must be a string", field.Name)
+		}
+
+		value, err := fn(rv.Field(i).String())
+		if err != nil {
+			return fmt.Errorf("fieldcrypt: field %s: %w", field.Name, err)
+		}
+
+		rv.Field(i).SetString(value)
+	}
+
+	return nil
+}
@@ -0,0 +1,194 @@
+package fieldcrypt
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func key(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(b), 32)))
+}
+
+func mustParseKeys(t *testing.T, s string) *Keyring {
+	t.Helper()
+
+	k, err := ParseKeys(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return k
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	k := mustParseKeys(t, "k1:"+key('a'))
+
+	sealed, err := k.Encrypt("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(sealed, "enc:k1:") || strings.Contains(sealed, "alice") {
+		t.Errorf("got sealed value %q; want it unreadable and marked with key k1", sealed)
+	}
+
+	again, err := k.Encrypt("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again == sealed {
+		t.Error("got the same ciphertext twice; want a fresh nonce each time")
+	}
+
+	opened, err := k.Decrypt(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opened != "alice@example.com" {
+		t.Errorf("got %q; want %q", opened, "alice@example.com")
+	}
+}
+
+func TestDecryptAfterRotation(t *testing.T) {
+	old := mustParseKeys(t, "k1:"+key('a'))
+
+	sealed, err := old.Encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := mustParseKeys(t, "k2:"+key('b')+" k1:"+key('a'))
+
+	opened, err := rotated.Decrypt(sealed)
+	if err != nil || opened != "secret" {
+		t.Fatalf("got %q, %v; want the value sealed with the retired key", opened, err)
+	}
+
+	resealed, err := rotated.Encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(resealed, "enc:k2:") {
+		t.Errorf("got %q; want new values sealed with the current key k2", resealed)
+	}
+
+	// Once the retired key is dropped, its values can no longer be read.
+	_, err = mustParseKeys(t, "k2:"+key('b')).Decrypt(sealed)
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("got error %v; want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	k := mustParseKeys(t, "k1:"+key('a'))
+
+	sealed, err := k.Encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The key id is authenticated, so relabelling a value breaks it.
+	relabelled := strings.Replace(sealed, "enc:k1:", "enc:k2:", 1)
+	other := mustParseKeys(t, "k2:"+key('a'))
+
+	tests := []struct {
+		name    string
+		keys    *Keyring
+		value   string
+		want    string
+		wantErr error
+	}{
+		{"plain value", k, "bob@example.com", "bob@example.com", nil},
+		{"plain value without keys", nil, "bob@example.com", "bob@example.com", nil},
+		{"sealed value without keys", nil, sealed, "", ErrUnknownKey},
+		{"missing key id", k, "enc:abc", "", ErrMalformed},
+		{"bad base64", k, "enc:k1:!!!", "", ErrMalformed},
+		{"too short", k, "enc:k1:AAAA", "", ErrMalformed},
+		{"tampered", k, sealed[:len(sealed)-2] + "AA", "", ErrMalformed},
+		{"relabelled", other, relabelled, "", ErrMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.keys.Decrypt(tt.value)
+			if !errors.Is(err, tt.wantErr) || got != tt.want {
+				t.Errorf("got %q, %v; want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	k, err := ParseKeys("  ")
+	if err != nil || k != nil {
+		t.Errorf("got %v, %v; want a nil keyring for no keys", k, err)
+	}
+
+	tests := []struct {
+		keys string
+		want string
+	}{
+		{key('a'), "must be of the form id:key"},
+		{":" + key('a'), "must be of the form id:key"},
+		{"k1:" + key('a') + " k1:" + key('b'), `duplicate key id "k1"`},
+		{"k1:notbase64", `key "k1" must be 32 bytes`},
+		{"k1:" + base64.StdEncoding.EncodeToString([]byte("short")), `key "k1" must be 32 bytes`},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseKeys(tt.keys)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseKeys(%q): got error %v; want it to contain %q", tt.keys, err, tt.want)
+		}
+	}
+}
+
+func TestSealAndOpen(t *testing.T) {
+	type record struct {
+		Email string `encrypt:"true"`
+		Name  string
+	}
+
+	k := mustParseKeys(t, "k1:"+key('a'))
+
+	r := &record{Email: "alice@example.com", Name: "Alice"}
+
+	if err := k.Seal(r); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(r.Email, "enc:k1:") || r.Name != "Alice" {
+		t.Errorf("got %+v; want only the tagged field sealed", r)
+	}
+
+	if err := k.Open(r); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Email != "alice@example.com" {
+		t.Errorf("got email %q; want it opened", r.Email)
+	}
+
+	var nilKeys *Keyring
+
+	plain := &record{Email: "alice@example.com"}
+	if err := nilKeys.Seal(plain); err != nil || plain.Email != "alice@example.com" {
+		t.Errorf("got %q, %v; want a nil keyring to leave fields as they are", plain.Email, err)
+	}
+
+	type badRecord struct {
+		ID int `encrypt:"true"`
+	}
+
+	if err := k.Seal(&badRecord{}); err == nil {
+		t.Error("got no error sealing a field which is not a string")
+	}
+	if err := k.Seal(record{}); err == nil {
+		t.Error("got no error sealing a struct which is not a pointer")
+	}
+}
@@ -0,0 +1,78 @@
+// Package idempotency stores the responses to requests sent with an Idempotency-Key header,
+// so that a retried request is answered with the original response instead of being
+// executed a second time.
+package idempotency
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ErrInProgress is returned by Begin when another request holding the same key has not
+// completed yet.
+var ErrInProgress = errors.New("idempotency: request with this key is in progress")
+
+// Response is a stored response, replayed for retries. Fingerprint identifies the request
+// it answered, so that a key reused for a different request is not answered with it.
+type Response struct {
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header"`
+	Body        []byte      `json:"body"`
+	Fingerprint string      `json:"fingerprint"`
+}
+
+// replayedHeaders are the only response headers stored and replayed. The others, such as
+// Set-Cookie, Date or the rate limit headers, describe the original exchange rather than the
+// resource and are set afresh for each request.
+var replayedHeaders = []string{
+	"Content-Type",
+	"Content-Language",
+	"Location",
+	"ETag",
+	"Last-Modified",
+}
+
+// ReplayedHeader returns a copy of the headers in h which are stored and replayed.
+func ReplayedHeader(h http.Header) http.Header {
+	replayed := http.Header{}
+
+	for _, key := range replayedHeaders {
+		if values := h.Values(key); len(values) > 0 {
+			replayed[http.CanonicalHeaderKey(key)] = append([]string(nil), values...)
+		}
+	}
+
+	return replayed
+}
+
+// Fingerprint returns an HMAC keyed with secret identifying a request by its method, path
+// and body. Bodies may hold passwords, so a plain hash of them is not stored.
+func Fingerprint(secret []byte, method, path string, body []byte) string {
+	h := hmac.New(sha256.New, secret)
+	fmt.Fprintf(h, "%s %s\n", method, path)
+	h.Write(body)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Store is implemented by each storage backend. Keys expire after the ttl passed to Begin
+// or Complete.
+type Store interface {
+	// Begin claims key for a new request and returns a nil Response. If the key has
+	// already completed its stored Response is returned instead, and if it is still
+	// being processed ErrInProgress is returned.
+	Begin(ctx context.Context, key string, ttl time.Duration) (*Response, error)
+
+	// Complete stores the response for a key claimed by Begin.
+	Complete(ctx context.Context, key string, resp *Response, ttl time.Duration) error
+
+	// Release gives up a claimed key without storing a response, so that the request can
+	// be retried.
+	Release(ctx context.Context, key string) error
+}
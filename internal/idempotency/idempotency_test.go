@@ -0,0 +1,45 @@
+package idempotency
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestReplayedHeader(t *testing.T) {
+	h := http.Header{}
+	h.Set("Content-Type", "application/json")
+	h.Set("ETag", `"abc"`)
+	h.Set("Set-Cookie", "session=secret")
+	h.Set("Date", "Mon, 01 Apr 2024 00:00:00 GMT")
+
+	replayed := ReplayedHeader(h)
+
+	if len(replayed) != 2 || replayed.Get("Content-Type") != "application/json" || replayed.Get("ETag") != `"abc"` {
+		t.Errorf("got %v; want only Content-Type and ETag", replayed)
+	}
+
+	replayed.Set("Content-Type", "text/plain")
+	if h.Get("Content-Type") != "application/json" {
+		t.Error("ReplayedHeader did not copy the header")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	secret := []byte("secret")
+	base := Fingerprint(secret, "POST", "/v1/movies", []byte(`{"title":"Moana"}`))
+
+	if Fingerprint(secret, "POST", "/v1/movies", []byte(`{"title":"Moana"}`)) != base {
+		t.Error("fingerprint of the same request changed")
+	}
+
+	for _, other := range []string{
+		Fingerprint(secret, "PUT", "/v1/movies", []byte(`{"title":"Moana"}`)),
+		Fingerprint(secret, "POST", "/v1/movies/1", []byte(`{"title":"Moana"}`)),
+		Fingerprint(secret, "POST", "/v1/movies", []byte(`{"title":"Frozen"}`)),
+		Fingerprint([]byte("other"), "POST", "/v1/movies", []byte(`{"title":"Moana"}`)),
+	} {
+		if other == base {
+			t.Error("different requests have the same fingerprint")
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"greenlight/internal/redis"
+	"strconv"
+	"time"
+)
+
+// RedisStore keeps keys in Redis, relying on key expiry instead of a periodic sweep.
+type RedisStore struct {
+	Client *redis.Client
+}
+
+func NewRedisStore(addr, password string, db int) *RedisStore {
+	return &RedisStore{Client: redis.New(addr, password, db)}
+}
+
+// redisEntry is the value stored under each key. A nil Response marks a key whose request
+// is still in progress.
+type redisEntry struct {
+	Response *Response `json:"response,omitempty"`
+}
+
+func (s *RedisStore) Begin(ctx context.Context, key string, ttl time.Duration) (*Response, error) {
+	pending, err := json.Marshal(redisEntry{})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.Client.Do(ctx, "SET", key, string(pending), "NX", "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, redis.ErrNil) {
+		return nil, err
+	}
+
+	value, err := s.Client.Do(ctx, "GET", key)
+	if err != nil {
+		// The key expired between SET and GET, so the caller can simply retry.
+		if errors.Is(err, redis.ErrNil) {
+			return nil, ErrInProgress
+		}
+		return nil, err
+	}
+
+	var entry redisEntry
+
+	err = json.Unmarshal([]byte(value), &entry)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.Response == nil {
+		return nil, ErrInProgress
+	}
+
+	return entry.Response, nil
+}
+
+func (s *RedisStore) Complete(ctx context.Context, key string, resp *Response, ttl time.Duration) error {
+	value, err := json.Marshal(redisEntry{Response: resp})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.Client.Do(ctx, "SET", key, string(value), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+func (s *RedisStore) Release(ctx context.Context, key string) error {
+	_, err := s.Client.Do(ctx, "DEL", key)
+	return err
+}
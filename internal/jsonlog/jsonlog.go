@@ -2,17 +2,21 @@ package jsonlog
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Level int8
 
 const (
-	LevelInfo Level = iota
+	LevelDebug Level = iota
+	LevelInfo
 	LevelError
 	LevelFatal
 	LevelOff
@@ -20,6 +24,8 @@ const (
 
 func (l Level) String() string {
 	switch l {
+	case LevelDebug:
+		return "DEBUG"
 	case LevelInfo:
 		return "INFO"
 	case LevelError:
@@ -31,21 +37,48 @@ func (l Level) String() string {
 	}
 }
 
+// ParseLevel returns the level called s, ignoring case, out of debug, info and error.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return 0, fmt.Errorf("jsonlog: unknown level %q", s)
+	}
+}
+
+// Logger writes log entries as JSON lines. Its minimum level can be changed with SetLevel
+// while it is in use.
 type Logger struct {
 	out      io.Writer
-	minLevel Level
+	minLevel atomic.Int32
 	mu       sync.Mutex
 }
 
 func New(out io.Writer, minLevel Level) *Logger {
-	return &Logger{
-		out:      out,
-		minLevel: minLevel,
-	}
+	l := &Logger{out: out}
+	l.minLevel.Store(int32(minLevel))
+
+	return l
+}
+
+// Level returns the minimum level of the entries written.
+func (l *Logger) Level() Level {
+	return Level(l.minLevel.Load())
+}
+
+// SetLevel changes the minimum level of the entries written, taking effect for entries
+// printed from then on.
+func (l *Logger) SetLevel(level Level) {
+	l.minLevel.Store(int32(level))
 }
 
 func (l *Logger) print(level Level, message string, properties map[string]string) (int, error) {
-	if level < l.minLevel {
+	if level < l.Level() {
 		return 0, nil
 	}
 
@@ -79,6 +112,10 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 	return l.out.Write(append(line, '\n'))
 }
 
+func (l *Logger) PrintDebug(message string, properties map[string]string) {
+	l.print(LevelDebug, message, properties)
+}
+
 func (l *Logger) PrintInfo(message string, properties map[string]string) {
 	l.print(LevelInfo, message, properties)
 }
@@ -0,0 +1,84 @@
+package jsonlog
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    Level
+		wantErr bool
+	}{
+		{"debug", LevelDebug, false},
+		{"INFO", LevelInfo, false},
+		{"Error", LevelError, false},
+		{"fatal", 0, true},
+		{"", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseLevel(tt.s)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseLevel(%q): got %s, %v; want %s", tt.s, got, err, tt.want)
+		}
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	var out bytes.Buffer
+	l := New(&out, LevelInfo)
+
+	l.PrintDebug("hidden", nil)
+	if out.Len() != 0 {
+		t.Fatalf("got %q; want debug entries left out at the info level", out.String())
+	}
+
+	l.SetLevel(LevelDebug)
+	l.PrintDebug("shown", nil)
+	if !strings.Contains(out.String(), `"level":"DEBUG","time"`) || !strings.Contains(out.String(), `"message":"shown"`) {
+		t.Fatalf("got %q; want the debug entry", out.String())
+	}
+
+	out.Reset()
+	l.SetLevel(LevelError)
+	l.PrintInfo("hidden", nil)
+	if out.Len() != 0 || l.Level() != LevelError {
+		t.Errorf("got %q at level %s; want info entries left out at the error level", out.String(), l.Level())
+	}
+}
+
+func TestSetLevelWhilePrinting(t *testing.T) {
+	var out bytes.Buffer
+	l := New(&out, LevelInfo)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				l.PrintDebug("debug", nil)
+				l.PrintInfo("info", nil)
+			}
+		}()
+	}
+
+	for _, level := range []Level{LevelDebug, LevelError, LevelInfo} {
+		l.SetLevel(level)
+	}
+
+	wg.Wait()
+	l.PrintInfo("done", nil)
+
+	// Every entry is written whole, whatever the level was at the time.
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		if !strings.HasPrefix(line, "{") || !strings.HasSuffix(line, "}") {
+			t.Fatalf("got a broken entry %q", line)
+		}
+	}
+}
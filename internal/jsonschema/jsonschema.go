@@ -0,0 +1,240 @@
+// Package jsonschema implements the subset of JSON Schema needed to check the shape of
+// request bodies: type, properties, required, additionalProperties, items, enum, pattern,
+// and the length, item count and numeric bounds keywords.
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Schema struct {
+	Type                 Types              `json:"type"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	Enum                 []any              `json:"enum"`
+	Pattern              string             `json:"pattern"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	MinItems             *int               `json:"minItems"`
+	MaxItems             *int               `json:"maxItems"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+
+	pattern *regexp.Regexp
+}
+
+// Types holds the type keyword, which is the name of a single type or a list of them.
+type Types []string
+
+func (t *Types) UnmarshalJSON(data []byte) error {
+	var name string
+	if json.Unmarshal(data, &name) == nil {
+		*t = Types{name}
+		return nil
+	}
+
+	var names []string
+
+	err := json.Unmarshal(data, &names)
+	if err != nil {
+		return err
+	}
+
+	*t = names
+
+	return nil
+}
+
+// Parse parses and compiles a schema document.
+func Parse(data []byte) (*Schema, error) {
+	var s Schema
+
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+func (s *Schema) compile() error {
+	if s.Pattern != "" {
+		rx, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return err
+		}
+		s.pattern = rx
+	}
+
+	for _, child := range s.Properties {
+		err := child.compile()
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.Items != nil {
+		return s.Items.compile()
+	}
+
+	return nil
+}
+
+// ValidateJSON decodes data and validates it against the schema. It returns a map of JSON
+// pointer paths to error messages, which is empty when the document is valid, or an error
+// when data is not valid JSON at all.
+func (s *Schema) ValidateJSON(data []byte) (map[string]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var doc any
+
+	err := dec.Decode(&doc)
+	if err != nil {
+		return nil, err
+	}
+
+	errs := make(map[string]string)
+	s.validate("", doc, errs)
+
+	return errs, nil
+}
+
+func (s *Schema) validate(path string, value any, errs map[string]string) {
+	if len(s.Type) > 0 && !slices.ContainsFunc(s.Type, func(typ string) bool { return hasType(value, typ) }) {
+		addError(errs, path, fmt.Sprintf("must be of type %s", strings.Join(s.Type, " or ")))
+		return
+	}
+
+	if len(s.Enum) > 0 && !inEnum(value, s.Enum) {
+		addError(errs, path, "must be one of the permitted values")
+	}
+
+	switch v := value.(type) {
+	case string:
+		length := len([]rune(v))
+		if s.MinLength != nil && length < *s.MinLength {
+			addError(errs, path, fmt.Sprintf("must be at least %d characters long", *s.MinLength))
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			addError(errs, path, fmt.Sprintf("must not be more than %d characters long", *s.MaxLength))
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			addError(errs, path, fmt.Sprintf("must match the pattern %s", s.Pattern))
+		}
+
+	case json.Number:
+		f, _ := v.Float64()
+		if s.Minimum != nil && f < *s.Minimum {
+			addError(errs, path, fmt.Sprintf("must be greater than or equal to %v", *s.Minimum))
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			addError(errs, path, fmt.Sprintf("must be less than or equal to %v", *s.Maximum))
+		}
+
+	case []any:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			addError(errs, path, fmt.Sprintf("must contain at least %d items", *s.MinItems))
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			addError(errs, path, fmt.Sprintf("must not contain more than %d items", *s.MaxItems))
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(path+"/"+strconv.Itoa(i), item, errs)
+			}
+		}
+
+	case map[string]any:
+		for _, key := range s.Required {
+			if _, ok := v[key]; !ok {
+				addError(errs, path+"/"+escape(key), "must be provided")
+			}
+		}
+
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			child, ok := s.Properties[key]
+			switch {
+			case ok:
+				child.validate(path+"/"+escape(key), v[key], errs)
+			case s.AdditionalProperties != nil && !*s.AdditionalProperties:
+				addError(errs, path+"/"+escape(key), "is not a permitted property")
+			}
+		}
+	}
+}
+
+func hasType(value any, typ string) bool {
+	switch typ {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	case "number":
+		_, ok := value.(json.Number)
+		return ok
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			return false
+		}
+		_, err := n.Int64()
+		return err == nil
+	default:
+		return true
+	}
+}
+
+func inEnum(value any, enum []any) bool {
+	for _, permitted := range enum {
+		if fmt.Sprint(permitted) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// escape encodes a property name for use in a JSON pointer, as described in RFC 6901.
+func escape(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
+
+func addError(errs map[string]string, path, message string) {
+	if path == "" {
+		path = "/"
+	}
+
+	if _, exists := errs[path]; !exists {
+		errs[path] = message
+	}
+}
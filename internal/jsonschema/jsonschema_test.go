@@ -0,0 +1,78 @@
+package jsonschema
+
+import (
+	"maps"
+	"testing"
+)
+
+const movieSchema = `{
+	"type": "object",
+	"additionalProperties": false,
+	"required": ["title", "year"],
+	"properties": {
+		"title": {"type": "string", "minLength": 1, "maxLength": 10},
+		"year": {"type": "integer", "minimum": 1888},
+		"runtime": {"type": ["integer", "string"], "pattern": "^[0-9]+ mins$"},
+		"genres": {"type": "array", "maxItems": 2, "items": {"type": "string", "enum": ["Drama", "Comedy"]}},
+		"a/b": {"type": "boolean"}
+	}
+}`
+
+func TestValidateJSON(t *testing.T) {
+	schema, err := Parse([]byte(movieSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		doc  string
+		want map[string]string
+	}{
+		{"valid", `{"title": "Alien", "year": 1979, "runtime": "117 mins", "genres": ["Drama"]}`, map[string]string{}},
+		{"either type", `{"title": "Alien", "year": 1979, "runtime": 117}`, map[string]string{}},
+		{"not an object", `[]`, map[string]string{"/": "must be of type object"}},
+		{"missing required", `{"title": "Alien"}`, map[string]string{"/year": "must be provided"}},
+		{"type mismatch", `{"title": "Alien", "year": "1979"}`, map[string]string{"/year": "must be of type integer"}},
+		{"not an integer", `{"title": "Alien", "year": 1979.5}`, map[string]string{"/year": "must be of type integer"}},
+		{"minimum", `{"title": "Alien", "year": 1800}`, map[string]string{"/year": "must be greater than or equal to 1888"}},
+		{"length", `{"title": "", "year": 1979}`, map[string]string{"/title": "must be at least 1 characters long"}},
+		{"max length", `{"title": "Alien Resurrection", "year": 1997}`, map[string]string{"/title": "must not be more than 10 characters long"}},
+		{"pattern", `{"title": "Alien", "year": 1979, "runtime": "117"}`, map[string]string{"/runtime": "must match the pattern ^[0-9]+ mins$"}},
+		{"items", `{"title": "Alien", "year": 1979, "genres": ["Drama", 1]}`, map[string]string{"/genres/1": "must be of type string"}},
+		{"enum", `{"title": "Alien", "year": 1979, "genres": ["Horror"]}`, map[string]string{"/genres/0": "must be one of the permitted values"}},
+		{"max items", `{"title": "Alien", "year": 1979, "genres": ["Drama", "Comedy", "Drama"]}`, map[string]string{"/genres": "must not contain more than 2 items"}},
+		{"additional property", `{"title": "Alien", "year": 1979, "budget": 11}`, map[string]string{"/budget": "is not a permitted property"}},
+		{"escaped pointer", `{"title": "Alien", "year": 1979, "a/b": "yes"}`, map[string]string{"/a~1b": "must be of type boolean"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := schema.ValidateJSON([]byte(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateJSONRejectsMalformedDocuments(t *testing.T) {
+	schema, err := Parse([]byte(movieSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := schema.ValidateJSON([]byte(`{"title": `)); err == nil {
+		t.Error("got nil error; want the decode error")
+	}
+}
+
+func TestParseRejectsInvalidPatterns(t *testing.T) {
+	if _, err := Parse([]byte(`{"properties": {"title": {"pattern": "("}}}`)); err == nil {
+		t.Error("got nil error; want the pattern rejected")
+	}
+}
@@ -0,0 +1,150 @@
+// Package jwt signs and verifies JSON Web Tokens signed with HMAC-SHA256 (HS256), the only
+// algorithm the API accepts.
+package jwt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformed = errors.New("jwt: malformed token")
+	ErrSignature = errors.New("jwt: invalid signature")
+	ErrExpired   = errors.New("jwt: token expired or not yet valid")
+	ErrIssuer    = errors.New("jwt: unexpected issuer")
+	ErrAudience  = errors.New("jwt: unexpected audience")
+	ErrIssuedAt  = errors.New("jwt: token issued in the future")
+)
+
+// Claims holds the registered claims the API uses, plus the scope of tokens it issues.
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	Scope     string   `json:"scope,omitempty"`
+}
+
+// Audience is the aud claim, which may be a single string or an array of them.
+type Audience []string
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+
+	if json.Unmarshal(data, &single) == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+// Sign returns the compact form of a token holding claims, signed with secret.
+func Sign(claims Claims, secret []byte) (string, error) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// LooksLikeJWT reports whether s has the three dot separated parts of a compact JWT.
+func LooksLikeJWT(s string) bool {
+	return strings.Count(s, ".") == 2
+}
+
+// Verify checks the signature and validity period of token and returns its claims. The
+// token's iss claim must be issuer and its aud claim must contain audience, so tokens
+// without either are rejected, as are tokens without an exp claim. The exp, nbf and iat
+// claims are allowed to be off by up to skew, so that small clock differences between the
+// issuer and the API do not reject valid tokens.
+func Verify(token string, secret []byte, issuer, audience string, now time.Time, skew time.Duration) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+
+	err = json.Unmarshal(headerJSON, &header)
+	if err != nil || header.Alg != "HS256" {
+		return nil, ErrMalformed
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, ErrSignature
+	}
+
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	var claims Claims
+
+	err = json.Unmarshal(claimsJSON, &claims)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	if claims.ExpiresAt == 0 || !now.Before(time.Unix(claims.ExpiresAt, 0).Add(skew)) {
+		return nil, ErrExpired
+	}
+
+	if claims.NotBefore != 0 && now.Before(time.Unix(claims.NotBefore, 0).Add(-skew)) {
+		return nil, ErrExpired
+	}
+
+	if claims.IssuedAt != 0 && now.Before(time.Unix(claims.IssuedAt, 0).Add(-skew)) {
+		return nil, ErrIssuedAt
+	}
+
+	if claims.Issuer == "" || claims.Issuer != issuer {
+		return nil, ErrIssuer
+	}
+
+	if audience == "" || !slices.Contains(claims.Audience, audience) {
+		return nil, ErrAudience
+	}
+
+	return &claims, nil
+}
@@ -0,0 +1,110 @@
+package jwt
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerifyRequiresIssuerAndAudience(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Date(2024, time.April, 1, 12, 0, 0, 0, time.UTC)
+
+	valid := Claims{
+		Subject:   "1",
+		Issuer:    "greenlight",
+		Audience:  Audience{"api"},
+		ExpiresAt: now.Add(time.Hour).Unix(),
+		Scope:     "authentication",
+	}
+
+	tests := []struct {
+		name     string
+		claims   func(c *Claims)
+		issuer   string
+		audience string
+		want     error
+	}{
+		{"valid", func(c *Claims) {}, "greenlight", "api", nil},
+		{"missing iss", func(c *Claims) { c.Issuer = "" }, "greenlight", "api", ErrIssuer},
+		{"other iss", func(c *Claims) { c.Issuer = "other" }, "greenlight", "api", ErrIssuer},
+		{"missing aud", func(c *Claims) { c.Audience = nil }, "greenlight", "api", ErrAudience},
+		{"other aud", func(c *Claims) { c.Audience = Audience{"other"} }, "greenlight", "api", ErrAudience},
+		{"no expected issuer", func(c *Claims) { c.Issuer = "" }, "", "api", ErrIssuer},
+		{"no expected audience", func(c *Claims) { c.Audience = nil }, "greenlight", "", ErrAudience},
+		{"missing exp", func(c *Claims) { c.ExpiresAt = 0 }, "greenlight", "api", ErrExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid
+			tt.claims(&claims)
+
+			token, err := Sign(claims, secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = Verify(token, secret, tt.issuer, tt.audience, now, 0)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got error %v; want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	now := time.Date(2024, time.April, 1, 12, 0, 0, 0, time.UTC)
+
+	token, err := Sign(Claims{Subject: "1", Issuer: "greenlight", Audience: Audience{"api"}, ExpiresAt: now.Add(time.Hour).Unix()}, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Verify(token, []byte("other"), "greenlight", "api", now, 0)
+	if !errors.Is(err, ErrSignature) {
+		t.Errorf("got error %v; want %v", err, ErrSignature)
+	}
+}
+
+func TestVerifyToleratesClockSkew(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Date(2024, time.April, 1, 12, 0, 0, 0, time.UTC)
+	skew := 30 * time.Second
+
+	tests := []struct {
+		name   string
+		claims func(c *Claims)
+		want   error
+	}{
+		{"expired within skew", func(c *Claims) { c.ExpiresAt = now.Add(-29 * time.Second).Unix() }, nil},
+		{"expired beyond skew", func(c *Claims) { c.ExpiresAt = now.Add(-31 * time.Second).Unix() }, ErrExpired},
+		{"expired exactly at skew", func(c *Claims) { c.ExpiresAt = now.Add(-skew).Unix() }, ErrExpired},
+		{"not yet valid within skew", func(c *Claims) { c.NotBefore = now.Add(29 * time.Second).Unix() }, nil},
+		{"not yet valid beyond skew", func(c *Claims) { c.NotBefore = now.Add(31 * time.Second).Unix() }, ErrExpired},
+		{"issued in the future within skew", func(c *Claims) { c.IssuedAt = now.Add(29 * time.Second).Unix() }, nil},
+		{"issued in the future beyond skew", func(c *Claims) { c.IssuedAt = now.Add(31 * time.Second).Unix() }, ErrIssuedAt},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := Claims{Subject: "1", Issuer: "greenlight", Audience: Audience{"api"}, ExpiresAt: now.Add(time.Hour).Unix()}
+			tt.claims(&claims)
+
+			token, err := Sign(claims, secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = Verify(token, secret, "greenlight", "api", now, skew)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got error %v; want %v", err, tt.want)
+			}
+
+			// Without any tolerance, every one of them is rejected.
+			if _, err := Verify(token, secret, "greenlight", "api", now, 0); err == nil {
+				t.Error("without skew: got no error")
+			}
+		})
+	}
+}
@@ -2,8 +2,17 @@ package mailer
 
 import (
 	"bytes"
+	"context"
 	"embed"
+	"errors"
+	"fmt"
+	"greenlight/internal/outbound"
 	"html/template"
+	"io"
+	"math/rand/v2"
+	"mime"
+	"net/textproto"
+	"strings"
 	"time"
 
 	"github.com/go-mail/mail/v2"
@@ -12,9 +21,15 @@ import (
 //go:embed "templates"
 var templateFS embed.FS
 
+// Mailer sends templated emails. Each attempt at sending is recorded with Recorder, which
+// may be nil. Transient failures are retried up to MaxAttempts times in all, waiting
+// RetryBackoff before the first retry and doubling the wait, with jitter, each time after.
 type Mailer struct {
-	dialer *mail.Dialer
-	sender string
+	dialer       *mail.Dialer
+	sender       string
+	Recorder     *outbound.Recorder
+	MaxAttempts  int
+	RetryBackoff time.Duration
 }
 
 func New(host string, port int, username, password, sender string) Mailer {
@@ -22,12 +37,29 @@ func New(host string, port int, username, password, sender string) Mailer {
 	dialer.Timeout = 5 * time.Second
 
 	return Mailer{
-		dialer: dialer,
-		sender: sender,
+		dialer:       dialer,
+		sender:       sender,
+		MaxAttempts:  3,
+		RetryBackoff: 500 * time.Millisecond,
 	}
 }
 
-func (m Mailer) Send(recipient, templateFile string, data any) error {
+// Attachment is a file sent along with an email.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Content     []byte
+}
+
+// Send renders the template and sends it to recipient. Retries stop once ctx is done, in
+// which case the error of the last attempt is returned.
+func (m Mailer) Send(ctx context.Context, recipient, templateFile string, data any) error {
+	return m.SendWithAttachments(ctx, recipient, templateFile, data, nil)
+}
+
+// SendWithAttachments is like Send, but attaches files to the email next to the plain-text
+// and HTML bodies.
+func (m Mailer) SendWithAttachments(ctx context.Context, recipient, templateFile string, data any, attachments []Attachment) error {
 	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
 	if err != nil {
 		return err
@@ -58,15 +90,113 @@ func (m Mailer) Send(recipient, templateFile string, data any) error {
 	msg.SetBody("text/plain", plainBody.String())
 	msg.AddAlternative("text/html", htmlBody.String())
 
-	for i := 1; i <= 3; i++ {
+	for _, attachment := range attachments {
+		name := sanitizeFilename(attachment.Filename)
+
+		mediaType, _, err := mime.ParseMediaType(attachment.ContentType)
+		if err != nil {
+			mediaType = "application/octet-stream"
+		}
+
+		// The library writes file names into the headers as they are, so both headers are
+		// set here from the sanitized name. The content is copied afresh for every attempt,
+		// where a reader would be empty on retries.
+		content := attachment.Content
+
+		msg.AttachReader(name, nil,
+			mail.SetHeader(map[string][]string{
+				"Content-Type":        {mime.FormatMediaType(mediaType, map[string]string{"name": name})},
+				"Content-Disposition": {mime.FormatMediaType("attachment", map[string]string{"filename": name})},
+			}),
+			mail.SetCopyFunc(func(w io.Writer) error {
+				_, err := w.Write(content)
+				return err
+			}),
+		)
+	}
+
+	backoff := m.RetryBackoff
+
+	for i := 1; i <= max(m.MaxAttempts, 1); i++ {
+		if i > 1 {
+			// Waiting between half and all of the backoff keeps the instances sharing an
+			// SMTP server from retrying in lockstep.
+			wait := backoff/2 + rand.N(backoff/2+1)
+			backoff *= 2
+
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(wait):
+			}
+		}
+
+		start := time.Now()
 		err = m.dialer.DialAndSend(msg)
+
+		m.Recorder.Record(outbound.Call{
+			Dependency: "smtp",
+			Target:     fmt.Sprintf("%s:%d", m.dialer.Host, m.dialer.Port),
+			Attempt:    i,
+			Duration:   time.Since(start),
+			Err:        err,
+		})
+
 		// If everything worked, return nil.
 		if nil == err {
 			return nil
 		}
 
-		time.Sleep(500 * time.Millisecond)
+		err = &outbound.DependencyError{Dependency: "smtp", Err: err}
+
+		if permanent(err) {
+			return err
+		}
 	}
 
 	return err
 }
+
+// maxFilenameBytes caps the length of attachment file names.
+const maxFilenameBytes = 100
+
+// sanitizeFilename reduces name to letters, digits, spaces, dots, hyphens and underscores,
+// so that it cannot break out of the quoted header parameters it is written into. Leading
+// dots are dropped too, and a name which is left empty becomes "attachment".
+func sanitizeFilename(name string) string {
+	var b strings.Builder
+
+	for _, c := range name {
+		switch {
+		case c == ' ' || c == '.' || c == '-' || c == '_',
+			c >= '0' && c <= '9', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+			b.WriteRune(c)
+		}
+	}
+
+	sanitized := b.String()
+	if len(sanitized) > maxFilenameBytes {
+		sanitized = sanitized[len(sanitized)-maxFilenameBytes:]
+	}
+
+	sanitized = strings.TrimSpace(strings.TrimLeft(sanitized, ". "))
+
+	if sanitized == "" {
+		return "attachment"
+	}
+
+	return sanitized
+}
+
+// permanent reports whether a failure to send will not go away by retrying, which is the
+// case when the SMTP server rejected the email with a 5xx reply, such as for an unknown
+// recipient. Connection errors and 4xx replies are transient.
+func permanent(err error) bool {
+	var sendErr *mail.SendError
+	if errors.As(err, &sendErr) {
+		err = sendErr.Cause
+	}
+
+	var protoErr *textproto.Error
+	return errors.As(err, &protoErr) && protoErr.Code >= 500
+}
@@ -0,0 +1,348 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"greenlight/internal/jsonlog"
+	"greenlight/internal/outbound"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveSMTP accepts SMTP sessions on a local port, answering RCPT TO with rcptReply and
+// every other command as a server which accepts the email would. It returns the port.
+func serveSMTP(t *testing.T, rcptReply string) int {
+	t.Helper()
+
+	port, _ := recordSMTP(t, rcptReply)
+	return port
+}
+
+// recordSMTP is like serveSMTP, but also returns the channel each email received is sent
+// to, as it was sent after DATA.
+func recordSMTP(t *testing.T, rcptReply string) (int, <-chan string) {
+	t.Helper()
+
+	received := make(chan string, 8)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				r := bufio.NewReader(conn)
+				reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+				reply("220 localhost ESMTP")
+
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+
+					switch cmd := strings.ToUpper(strings.Fields(line + " ")[0]); cmd {
+					case "EHLO", "HELO":
+						reply("250 localhost")
+					case "RCPT":
+						reply(rcptReply)
+					case "DATA":
+						reply("354 go ahead")
+						var data strings.Builder
+						for {
+							line, err := r.ReadString('\n')
+							if err != nil || line == ".\r\n" {
+								break
+							}
+							data.WriteString(line)
+						}
+						select {
+						case received <- data.String():
+						default:
+						}
+						reply("250 queued")
+					case "QUIT":
+						reply("221 bye")
+						return
+					default:
+						reply("250 ok")
+					}
+				}
+			}()
+		}
+	}()
+
+	return ln.Addr().(*net.TCPAddr).Port, received
+}
+
+// outboundCalls returns the properties of the "outbound call" entries in the log.
+func outboundCalls(t *testing.T, log *bytes.Buffer) []map[string]string {
+	t.Helper()
+
+	var calls []map[string]string
+
+	for _, line := range strings.Split(strings.TrimSpace(log.String()), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var entry struct {
+			Message    string            `json:"message"`
+			Properties map[string]string `json:"properties"`
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+
+		if entry.Message == "outbound call" {
+			calls = append(calls, entry.Properties)
+		}
+	}
+
+	return calls
+}
+
+func TestSendRecordsEachAttempt(t *testing.T) {
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refusedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	tests := []struct {
+		name         string
+		port         int
+		wantOutcomes []string
+	}{
+		{"accepted", serveSMTP(t, "250 ok"), []string{outbound.OutcomeSuccess}},
+		{"rejected recipient", serveSMTP(t, "550 no such user"), []string{outbound.OutcomeError}},
+		{"connection refused", refusedPort, []string{outbound.OutcomeError, outbound.OutcomeError}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+
+			m := New("127.0.0.1", tt.port, "greenlight", "secret", "Greenlight <no-reply@example.com>")
+			m.Recorder = &outbound.Recorder{Logger: jsonlog.New(&out, jsonlog.LevelInfo)}
+			m.MaxAttempts = 2
+			m.RetryBackoff = time.Millisecond
+
+			err := m.Send(context.Background(), "alice@example.com", "user_welcome.tmpl", map[string]any{"ID": 1, "activationToken": "ABC"})
+			if succeeded := err == nil; succeeded != (tt.wantOutcomes[0] == outbound.OutcomeSuccess) {
+				t.Fatalf("got error %v", err)
+			}
+
+			calls := outboundCalls(t, &out)
+			if len(calls) != len(tt.wantOutcomes) {
+				t.Fatalf("got %d calls logged; want %d", len(calls), len(tt.wantOutcomes))
+			}
+
+			for i, call := range calls {
+				if call["dependency"] != "smtp" || call["target"] != "127.0.0.1:"+strconv.Itoa(tt.port) || call["attempt"] != strconv.Itoa(i+1) {
+					t.Errorf("call %d: got %v", i, call)
+				}
+				if call["outcome"] != tt.wantOutcomes[i] {
+					t.Errorf("call %d: got outcome %q; want %q", i, call["outcome"], tt.wantOutcomes[i])
+				}
+				if _, ok := call["duration_ms"]; !ok {
+					t.Errorf("call %d: got no duration", i)
+				}
+				if strings.Contains(call["error"], "secret") {
+					t.Errorf("call %d: got the password logged", i)
+				}
+			}
+		})
+	}
+}
+
+func TestSendRetriesTransientFailures(t *testing.T) {
+	tests := []struct {
+		name         string
+		rcptReply    string
+		wantAttempts int
+	}{
+		{"temporary failure", "451 try again later", 3},
+		{"mailbox busy", "450 mailbox unavailable", 3},
+		{"unknown recipient", "550 no such user", 1},
+		{"rejected policy", "554 rejected", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+
+			m := New("127.0.0.1", serveSMTP(t, tt.rcptReply), "greenlight", "secret", "Greenlight <no-reply@example.com>")
+			m.Recorder = &outbound.Recorder{Logger: jsonlog.New(&out, jsonlog.LevelInfo)}
+			m.MaxAttempts = 3
+			m.RetryBackoff = time.Millisecond
+
+			err := m.Send(context.Background(), "alice@example.com", "user_welcome.tmpl", map[string]any{"ID": 1, "activationToken": "ABC"})
+			if err == nil {
+				t.Fatal("got no error")
+			}
+
+			if got := len(outboundCalls(t, &out)); got != tt.wantAttempts {
+				t.Errorf("got %d attempts; want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestSendStopsRetryingWhenTheContextIsDone(t *testing.T) {
+	m := New("127.0.0.1", serveSMTP(t, "451 try again later"), "greenlight", "secret", "Greenlight <no-reply@example.com>")
+	m.MaxAttempts = 5
+	m.RetryBackoff = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	err := m.Send(ctx, "alice@example.com", "user_welcome.tmpl", map[string]any{"ID": 1, "activationToken": "ABC"})
+	if err == nil || !strings.Contains(err.Error(), "451") {
+		t.Errorf("got error %v; want the last attempt's", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("got Send to return after %s; want it to stop at the deadline", elapsed)
+	}
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"receipt.pdf", "receipt.pdf"},
+		{"Receipt 2024-04_01.pdf", "Receipt 2024-04_01.pdf"},
+		{"../../etc/passwd", "etcpasswd"},
+		{"evil\"\r\nBcc: mallory@example.com.pdf", "evilBcc malloryexample.com.pdf"},
+		{"reçu.pdf", "reu.pdf"},
+		{"...", "attachment"},
+		{"", "attachment"},
+		{strings.Repeat("a", 200) + ".pdf", strings.Repeat("a", 96) + ".pdf"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeFilename(tt.name); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendWithAttachments(t *testing.T) {
+	port, received := recordSMTP(t, "250 ok")
+
+	m := New("127.0.0.1", port, "greenlight", "secret", "Greenlight <no-reply@example.com>")
+
+	receipt := []byte("%PDF-1.4 receipt")
+	attachments := []Attachment{
+		{Filename: "receipt.pdf", ContentType: "application/pdf", Content: receipt},
+		{Filename: "notes\"\r\nBcc: mallory@example.com.txt", ContentType: "not a type", Content: []byte("notes")},
+	}
+
+	err := m.SendWithAttachments(context.Background(), "alice@example.com", "user_welcome.tmpl", map[string]any{"ID": 1, "activationToken": "ABC"}, attachments)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(<-received))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Header.Get("Bcc") != "" {
+		t.Error("got a Bcc header injected through a file name")
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("got Content-Type %q; want multipart/mixed", msg.Header.Get("Content-Type"))
+	}
+
+	type part struct {
+		contentType string
+		filename    string
+		content     string
+	}
+
+	var parts []part
+
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		contentType, params, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+
+		// The bodies are nested in a multipart/alternative part of their own.
+		if contentType == "multipart/alternative" {
+			alt := multipart.NewReader(p, params["boundary"])
+			for {
+				body, err := alt.NextPart()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				bodyType, _, _ := mime.ParseMediaType(body.Header.Get("Content-Type"))
+				parts = append(parts, part{contentType: bodyType})
+			}
+			continue
+		}
+
+		var content []byte
+		if p.Header.Get("Content-Transfer-Encoding") == "base64" {
+			content, err = io.ReadAll(base64.NewDecoder(base64.StdEncoding, p))
+		} else {
+			content, err = io.ReadAll(p)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parts = append(parts, part{contentType, p.FileName(), string(content)})
+	}
+
+	want := []part{
+		{contentType: "text/plain"},
+		{contentType: "text/html"},
+		{"application/pdf", "receipt.pdf", string(receipt)},
+		{"application/octet-stream", "notesBcc malloryexample.com.txt", "notes"},
+	}
+
+	if !slices.Equal(parts, want) {
+		t.Errorf("got parts %+v; want %+v", parts, want)
+	}
+}
@@ -0,0 +1,165 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrQueueFull is returned by Queue.Send when the queue stayed full for the whole wait.
+	ErrQueueFull = errors.New("mailer: queue is full")
+
+	// ErrQueueClosed is returned by Queue.Send once the queue has been shut down, and passed
+	// to the Done function of the messages it was holding at the time.
+	ErrQueueClosed = errors.New("mailer: queue is closed")
+)
+
+// SendFunc sends a single email, such as Mailer.Send.
+type SendFunc func(ctx context.Context, recipient, templateFile string, data any) error
+
+// Message is an email waiting in a Queue. Done, when set, is called with the result of
+// sending it, from the worker which sent it.
+type Message struct {
+	Recipient string
+	Template  string
+	Data      any
+	Done      func(error)
+}
+
+func (msg Message) done(err error) {
+	if msg.Done != nil {
+		msg.Done(err)
+	}
+}
+
+// Queue sends emails in the background from a fixed pool of workers, so that a burst of
+// emails never opens more connections to the SMTP server than there are workers. Send only
+// blocks while the queue is full, and gives up with ErrQueueFull after the wait passed to
+// NewQueue, so that callers can hold on to the email instead of it being dropped.
+type Queue struct {
+	send     SendFunc
+	messages chan Message
+	wait     time.Duration
+
+	// mu is held for reading by Send, so that the channel is never closed under it.
+	mu     sync.RWMutex
+	closed atomic.Bool
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	inFlight atomic.Int64
+}
+
+// NewQueue starts workers goroutines sending the messages of a queue holding up to
+// capacity messages.
+func NewQueue(send SendFunc, workers, capacity int, wait time.Duration) *Queue {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	q := &Queue{
+		send:     send,
+		messages: make(chan Message, capacity),
+		wait:     wait,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+
+	q.wg.Add(workers)
+	for range workers {
+		go q.work()
+	}
+
+	return q
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+
+	for msg := range q.messages {
+		// The messages left once the queue is shut down are handed back unsent.
+		if q.closed.Load() {
+			msg.done(ErrQueueClosed)
+			continue
+		}
+
+		q.inFlight.Add(1)
+		err := q.send(q.ctx, msg.Recipient, msg.Template, msg.Data)
+		q.inFlight.Add(-1)
+
+		msg.done(err)
+	}
+}
+
+// Send adds msg to the queue. When the queue is full it waits for room, until the wait of
+// the queue has passed or ctx is done.
+func (q *Queue) Send(ctx context.Context, msg Message) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed.Load() {
+		return ErrQueueClosed
+	}
+
+	select {
+	case q.messages <- msg:
+		return nil
+	default:
+	}
+
+	timer := time.NewTimer(q.wait)
+	defer timer.Stop()
+
+	select {
+	case q.messages <- msg:
+		return nil
+	case <-timer.C:
+		return ErrQueueFull
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Len returns the number of messages waiting to be sent.
+func (q *Queue) Len() int {
+	return len(q.messages)
+}
+
+// Free returns the number of messages which can be added without waiting.
+func (q *Queue) Free() int {
+	return cap(q.messages) - len(q.messages)
+}
+
+// InFlight returns the number of messages being sent.
+func (q *Queue) InFlight() int {
+	return int(q.inFlight.Load())
+}
+
+// Shutdown stops the queue from accepting messages, hands the ones still waiting back to
+// their Done functions with ErrQueueClosed, and waits for the sends in flight to complete.
+// Once ctx is done the sends in flight are cancelled, and ctx's error is returned without
+// waiting for them any longer.
+func (q *Queue) Shutdown(ctx context.Context) error {
+	defer q.cancel()
+
+	q.mu.Lock()
+	if !q.closed.Swap(true) {
+		close(q.messages)
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
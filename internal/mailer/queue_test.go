@@ -0,0 +1,144 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQueueBoundsConcurrentSends(t *testing.T) {
+	const workers = 2
+
+	var running, peak atomic.Int64
+	release := make(chan struct{})
+
+	send := func(ctx context.Context, recipient, templateFile string, data any) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+
+		<-release
+		return nil
+	}
+
+	q := NewQueue(send, workers, 10, time.Second)
+
+	var wg sync.WaitGroup
+	for range 6 {
+		wg.Add(1)
+		err := q.Send(context.Background(), Message{Recipient: "alice@example.com", Done: func(err error) {
+			if err != nil {
+				t.Errorf("send failed: %v", err)
+			}
+			wg.Done()
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	close(release)
+	wg.Wait()
+
+	if got := peak.Load(); got > workers {
+		t.Errorf("%d sends ran at once; want at most %d", got, workers)
+	}
+
+	if err := q.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueueFullBlocksThenFails(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	send := func(ctx context.Context, recipient, templateFile string, data any) error {
+		<-release
+		return nil
+	}
+
+	// One message is held by the worker and one fills the queue.
+	q := NewQueue(send, 1, 1, 20*time.Millisecond)
+
+	for range 2 {
+		if err := q.Send(context.Background(), Message{}); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if q.Len() != 1 || q.Free() != 0 || q.InFlight() != 1 {
+		t.Fatalf("got Len %d, Free %d, InFlight %d; want 1, 0, 1", q.Len(), q.Free(), q.InFlight())
+	}
+
+	start := time.Now()
+	err := q.Send(context.Background(), Message{})
+
+	if !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("got %v; want ErrQueueFull", err)
+	}
+	if waited := time.Since(start); waited < 20*time.Millisecond {
+		t.Errorf("Send gave up after %s; want it to wait for room first", waited)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := q.Send(ctx, Message{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v; want context.Canceled", err)
+	}
+}
+
+func TestQueueShutdownReturnsWaitingMessages(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	send := func(ctx context.Context, recipient, templateFile string, data any) error {
+		close(started)
+		<-release
+		return nil
+	}
+
+	q := NewQueue(send, 1, 2, time.Second)
+
+	results := make(chan error, 2)
+	for range 2 {
+		if err := q.Send(context.Background(), Message{Done: func(err error) { results <- err }}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	<-started
+
+	shutdown := make(chan error)
+	go func() { shutdown <- q.Shutdown(context.Background()) }()
+
+	// Wait for Shutdown to close the queue before letting the first send complete.
+	for !q.closed.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	if err := <-results; err != nil {
+		t.Errorf("message in flight: got %v; want nil", err)
+	}
+	if err := <-results; !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("waiting message: got %v; want ErrQueueClosed", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+	if err := q.Send(context.Background(), Message{}); !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("Send after Shutdown: got %v; want ErrQueueClosed", err)
+	}
+}
@@ -0,0 +1,90 @@
+// Package metadata looks movies up in an external metadata API so that sparse records can
+// be filled in.
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("metadata: movie not found")
+
+// Result holds the fields a provider knows about a movie. Fields the provider does not know
+// are left at their zero value.
+type Result struct {
+	Runtime int32    `json:"runtime"`
+	Genres  []string `json:"genres"`
+}
+
+// Provider is implemented by each metadata source.
+type Provider interface {
+	Name() string
+	Lookup(ctx context.Context, title string, year int32) (*Result, error)
+}
+
+// HTTPProvider queries a metadata API at {BaseURL}/movies?title=...&year=... and expects a
+// JSON object in the shape of Result in return.
+type HTTPProvider struct {
+	ProviderName string
+	BaseURL      string
+	APIKey       string
+	HTTPClient   *http.Client
+}
+
+func NewHTTPProvider(name, baseURL, apiKey string, timeout time.Duration) *HTTPProvider {
+	return &HTTPProvider{
+		ProviderName: name,
+		BaseURL:      strings.TrimRight(baseURL, "/"),
+		APIKey:       apiKey,
+		HTTPClient:   &http.Client{Timeout: timeout},
+	}
+}
+
+func (p *HTTPProvider) Name() string {
+	return p.ProviderName
+}
+
+func (p *HTTPProvider) Lookup(ctx context.Context, title string, year int32) (*Result, error) {
+	query := url.Values{}
+	query.Set("title", title)
+	query.Set("year", strconv.Itoa(int(year)))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.BaseURL+"/movies?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if p.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	}
+
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, ErrNotFound
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return nil, fmt.Errorf("metadata: unexpected status %d from %s", resp.StatusCode, p.ProviderName)
+	}
+
+	var result Result
+
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return nil, fmt.Errorf("metadata: decode response from %s: %w", p.ProviderName, err)
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,56 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestHTTPProviderLookup(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer key" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Query().Get("title") {
+		case "Arrival":
+			if r.URL.Query().Get("year") != "2016" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(`{"runtime": 116, "genres": ["Drama", "Science Fiction"]}`))
+		case "Broken":
+			http.Error(w, "oops", http.StatusInternalServerError)
+		case "Slow":
+			time.Sleep(200 * time.Millisecond)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	p := NewHTTPProvider("stub", srv.URL+"/", "key", 50*time.Millisecond)
+
+	result, err := p.Lookup(context.Background(), "Arrival", 2016)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Runtime != 116 || !slices.Equal(result.Genres, []string{"Drama", "Science Fiction"}) {
+		t.Errorf("got %+v", result)
+	}
+
+	if _, err := p.Lookup(context.Background(), "Unknown", 2000); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown movie: got error %v; want ErrNotFound", err)
+	}
+
+	for _, title := range []string{"Broken", "Slow"} {
+		if _, err := p.Lookup(context.Background(), title, 2000); err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: got error %v; want a failure", title, err)
+		}
+	}
+}
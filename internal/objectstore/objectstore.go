@@ -0,0 +1,370 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when the requested object does not exist.
+var ErrNotFound = errors.New("objectstore: object not found")
+
+// partSize is the size of each part in a multipart upload. S3 requires every part except the
+// last one to be at least 5MiB.
+const partSize = 5 * 1024 * 1024
+
+// Client reads and writes objects in an S3-compatible object store, using path-style URLs of the form
+// {Endpoint}/{Bucket}/{key} and AWS Signature Version 4.
+type Client struct {
+	Endpoint   string
+	Region     string
+	Bucket     string
+	AccessKey  string
+	SecretKey  string
+	HTTPClient *http.Client
+}
+
+func New(endpoint, region, bucket, accessKey, secretKey string) *Client {
+	return &Client{
+		Endpoint:   strings.TrimRight(endpoint, "/"),
+		Region:     region,
+		Bucket:     bucket,
+		AccessKey:  accessKey,
+		SecretKey:  secretKey,
+		HTTPClient: &http.Client{Timeout: time.Minute},
+	}
+}
+
+// Upload streams r to the object store under key using a multipart upload, so that only one
+// part is held in memory at a time however large the object is. If anything fails the upload
+// is aborted so that no partial object is left behind.
+func (c *Client) Upload(ctx context.Context, key string, r io.Reader) error {
+	uploadID, err := c.createMultipartUpload(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	err = c.uploadParts(ctx, key, uploadID, r)
+	if err != nil {
+		abortErr := c.abortMultipartUpload(key, uploadID)
+		return errors.Join(err, abortErr)
+	}
+
+	return nil
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (c *Client) uploadParts(ctx context.Context, key, uploadID string, r io.Reader) error {
+	var parts []completedPart
+
+	buf := make([]byte, partSize)
+
+	for partNumber := 1; ; partNumber++ {
+		n, readErr := io.ReadFull(r, buf)
+		if readErr != nil && !errors.Is(readErr, io.EOF) && !errors.Is(readErr, io.ErrUnexpectedEOF) {
+			return readErr
+		}
+
+		// S3 needs at least one part, even when the object is empty.
+		if n > 0 || partNumber == 1 {
+			query := url.Values{}
+			query.Set("partNumber", fmt.Sprint(partNumber))
+			query.Set("uploadId", uploadID)
+
+			resp, err := c.do(ctx, http.MethodPut, key, query, buf[:n], nil)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+
+			parts = append(parts, completedPart{PartNumber: partNumber, ETag: resp.Header.Get("ETag")})
+		}
+
+		if readErr != nil {
+			break
+		}
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	query.Set("uploadId", uploadID)
+
+	resp, err := c.do(ctx, http.MethodPost, key, query, body, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// A complete request can fail after the 200 status line has been sent, in which case the
+	// error is reported in the body instead.
+	var result struct {
+		XMLName xml.Name
+		Message string `xml:"Message"`
+	}
+
+	err = xml.NewDecoder(resp.Body).Decode(&result)
+	if err == nil && result.XMLName.Local == "Error" {
+		return fmt.Errorf("objectstore: complete multipart upload: %s", result.Message)
+	}
+
+	return nil
+}
+
+func (c *Client) createMultipartUpload(ctx context.Context, key string) (string, error) {
+	query := url.Values{}
+	query.Set("uploads", "")
+
+	resp, err := c.do(ctx, http.MethodPost, key, query, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+
+	err = xml.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return "", err
+	}
+
+	if result.UploadID == "" {
+		return "", errors.New("objectstore: missing upload id in response")
+	}
+
+	return result.UploadID, nil
+}
+
+func (c *Client) abortMultipartUpload(key, uploadID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := url.Values{}
+	query.Set("uploadId", uploadID)
+
+	resp, err := c.do(ctx, http.MethodDelete, key, query, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// Put stores body under key in a single request. It is meant for small objects, such as
+// images, which are already held in memory; use Upload to stream large objects.
+func (c *Client) Put(ctx context.Context, key string, body []byte, contentType string) error {
+	header := make(http.Header)
+	header.Set("Content-Type", contentType)
+
+	resp, err := c.do(ctx, http.MethodPut, key, url.Values{}, body, header)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// Get returns the response for the object stored under key. The caller must close the
+// response body. ErrNotFound is returned when there is no such object.
+func (c *Client) Get(ctx context.Context, key string) (*http.Response, error) {
+	return c.do(ctx, http.MethodGet, key, url.Values{}, nil, nil)
+}
+
+func (c *Client) Delete(ctx context.Context, key string) error {
+	resp, err := c.do(ctx, http.MethodDelete, key, url.Values{}, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// PresignGet returns a URL which grants GET access to the object stored under key, without
+// credentials, until expires has elapsed.
+func (c *Client) PresignGet(key string, expires time.Duration) (string, error) {
+	path := "/" + escapePath(c.Bucket) + "/" + escapePath(key)
+
+	u, err := url.Parse(c.Endpoint + path)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + c.Region + "/s3/aws4_request"
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", c.AccessKey+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", fmt.Sprint(int(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		path,
+		canonicalQuery(query),
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	signature := c.signature(now, sha256Hex([]byte(canonicalRequest)))
+
+	return c.Endpoint + path + "?" + canonicalQuery(query) + "&X-Amz-Signature=" + signature, nil
+}
+
+// do sends a signed request and returns an error for any non-2xx response.
+func (c *Client) do(ctx context.Context, method, key string, query url.Values, body []byte, header http.Header) (*http.Response, error) {
+	path := "/" + escapePath(c.Bucket) + "/" + escapePath(key)
+
+	req, err := http.NewRequestWithContext(ctx, method, c.Endpoint+path+"?"+canonicalQuery(query), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	c.sign(req, path, query, body, time.Now().UTC())
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("objectstore: %s %s: unexpected status %d: %s", method, path, resp.StatusCode, msg)
+	}
+
+	return resp, nil
+}
+
+func (c *Client) sign(req *http.Request, path string, query url.Values, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(query),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + c.Region + "/s3/aws4_request"
+	signature := c.signature(now, sha256Hex([]byte(canonicalRequest)))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+// signature signs the hash of a canonical request made at the given time.
+func (c *Client) signature(now time.Time, canonicalRequestHash string) string {
+	date := now.Format("20060102")
+	scope := date + "/" + c.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + scope + "\n" + canonicalRequestHash
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretKey), date)
+	key = hmacSHA256(key, c.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, escape(k)+"="+escape(query.Get(k)))
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i := range segments {
+		segments[i] = escape(segments[i])
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// escape percent-encodes everything except the RFC 3986 unreserved characters, as required
+// by Signature Version 4.
+func escape(s string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,168 @@
+// Package outbound logs the calls the server makes to external dependencies, such as SMTP
+// servers, webhook targets and metadata providers, in one consistent structured format.
+package outbound
+
+import (
+	"context"
+	"greenlight/internal/jsonlog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Outcomes of a call.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeError   = "error"
+)
+
+// Call describes a single attempt at calling a dependency. Status is the protocol status,
+// such as the HTTP status code, when a response was received.
+type Call struct {
+	Dependency string
+	Target     string
+	Attempt    int
+	Duration   time.Duration
+	Status     int
+	Err        error
+}
+
+// DependencyError is the failure of a call to an external dependency. It names the
+// dependency, so that a failed request can tell its client which one is unavailable. The
+// message is that of Err.
+type DependencyError struct {
+	Dependency string
+	Err        error
+}
+
+func (e *DependencyError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DependencyError) Unwrap() error {
+	return e.Err
+}
+
+// Recorder logs calls. A nil *Recorder records nothing, so that logging can be turned off
+// without the callers checking.
+type Recorder struct {
+	Logger *jsonlog.Logger
+}
+
+func (rec *Recorder) Record(call Call) {
+	if rec == nil || rec.Logger == nil {
+		return
+	}
+
+	outcome := OutcomeSuccess
+	switch {
+	case call.Err != nil:
+		outcome = OutcomeError
+	case call.Status >= 400:
+		outcome = OutcomeFailure
+	}
+
+	properties := map[string]string{
+		"dependency":  call.Dependency,
+		"target":      call.Target,
+		"attempt":     strconv.Itoa(max(call.Attempt, 1)),
+		"duration_ms": strconv.FormatInt(call.Duration.Milliseconds(), 10),
+		"outcome":     outcome,
+	}
+
+	if call.Status != 0 {
+		properties["status"] = strconv.Itoa(call.Status)
+	}
+
+	if call.Err != nil {
+		properties["error"] = call.Err.Error()
+	}
+
+	rec.Logger.PrintInfo("outbound call", properties)
+}
+
+type attemptContextKey struct{}
+
+// WithAttempt returns a copy of ctx recording that calls made with it are the nth attempt.
+func WithAttempt(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, attemptContextKey{}, n)
+}
+
+func attempt(ctx context.Context) int {
+	n, _ := ctx.Value(attemptContextKey{}).(int)
+	return max(n, 1)
+}
+
+// Instrument returns a copy of client which records every request it sends as a call to
+// dependency. The client is returned as it is when rec is nil.
+func Instrument(client *http.Client, dependency string, rec *Recorder) *http.Client {
+	if rec == nil {
+		return client
+	}
+
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	instrumented := *client
+	instrumented.Transport = &transport{base: base, dependency: dependency, rec: rec}
+
+	return &instrumented
+}
+
+type transport struct {
+	base       http.RoundTripper
+	dependency string
+	rec        *Recorder
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+
+	call := Call{
+		Dependency: t.dependency,
+		Target:     req.Method + " " + RedactURL(req.URL),
+		Attempt:    attempt(req.Context()),
+		Duration:   time.Since(start),
+		Err:        err,
+	}
+
+	if resp != nil {
+		call.Status = resp.StatusCode
+	}
+
+	t.rec.Record(call)
+
+	return resp, err
+}
+
+// sensitiveParams are substrings of query parameter names whose values are redacted.
+var sensitiveParams = []string{"key", "token", "secret", "password", "signature", "credential", "auth"}
+
+// RedactURL returns u without its user info, and with the values of query parameters which
+// look like credentials replaced.
+func RedactURL(u *url.URL) string {
+	redacted := *u
+	redacted.User = nil
+
+	query := redacted.Query()
+	for name := range query {
+		lower := strings.ToLower(name)
+
+		for _, s := range sensitiveParams {
+			if strings.Contains(lower, s) {
+				query.Set(name, "REDACTED")
+				break
+			}
+		}
+	}
+
+	redacted.RawQuery = query.Encode()
+
+	return redacted.String()
+}
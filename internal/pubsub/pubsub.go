@@ -0,0 +1,98 @@
+// Package pubsub implements an in-process hub which fans out published messages to every
+// current subscriber.
+package pubsub
+
+import "sync"
+
+// Hub delivers each published message to all of its subscribers. It is safe for concurrent
+// use. Publishing never blocks: a subscriber whose buffer is full misses the message, so
+// that one slow subscriber cannot hold up the publishers or the other subscribers.
+type Hub[T any] struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription[T]]struct{}
+	closed      bool
+}
+
+func New[T any]() *Hub[T] {
+	return &Hub[T]{subscribers: make(map[*Subscription[T]]struct{})}
+}
+
+// Subscription receives the messages published to a hub on C until it is closed.
+type Subscription[T any] struct {
+	C <-chan T
+
+	c   chan T
+	hub *Hub[T]
+}
+
+// Subscribe returns a subscription buffering up to buffer messages. Subscribing to a closed
+// hub returns a subscription whose C is closed already.
+func (h *Hub[T]) Subscribe(buffer int) *Subscription[T] {
+	c := make(chan T, buffer)
+	s := &Subscription[T]{C: c, c: c, hub: h}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		close(c)
+		return s
+	}
+
+	h.subscribers[s] = struct{}{}
+
+	return s
+}
+
+// Publish sends msg to every subscriber with room for it in its buffer, and reports how
+// many subscribers missed it.
+func (h *Hub[T]) Publish(msg T) (dropped int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for s := range h.subscribers {
+		select {
+		case s.c <- msg:
+		default:
+			dropped++
+		}
+	}
+
+	return dropped
+}
+
+// Len returns the number of subscribers.
+func (h *Hub[T]) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.subscribers)
+}
+
+// Close closes every subscription and refuses new ones, so that subscribers waiting on C
+// return. It may be called more than once.
+func (h *Hub[T]) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+
+	for s := range h.subscribers {
+		delete(h.subscribers, s)
+		close(s.c)
+	}
+}
+
+// Close unsubscribes from the hub and closes C. It may be called more than once, and after
+// the hub has been closed.
+func (s *Subscription[T]) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	if _, ok := s.hub.subscribers[s]; !ok {
+		return
+	}
+
+	delete(s.hub.subscribers, s)
+	close(s.c)
+}
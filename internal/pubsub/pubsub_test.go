@@ -0,0 +1,103 @@
+package pubsub
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestHub(t *testing.T) {
+	h := New[int]()
+
+	a, b := h.Subscribe(1), h.Subscribe(1)
+
+	if dropped := h.Publish(1); dropped != 0 {
+		t.Fatalf("got %d subscribers missing the message; want none", dropped)
+	}
+	if got := <-a.C; got != 1 {
+		t.Errorf("a: got %d; want 1", got)
+	}
+	if got := <-b.C; got != 1 {
+		t.Errorf("b: got %d; want 1", got)
+	}
+
+	// b does not read this message, so it has no room for the next.
+	h.Publish(2)
+	<-a.C
+
+	if dropped := h.Publish(3); dropped != 1 {
+		t.Errorf("got %d subscribers missing the message; want b", dropped)
+	}
+	if got := <-b.C; got != 2 {
+		t.Errorf("b: got %d; want 2, the message it had room for", got)
+	}
+	if got := <-a.C; got != 3 {
+		t.Errorf("a: got %d; want 3", got)
+	}
+
+	a.Close()
+	a.Close()
+
+	if _, ok := <-a.C; ok {
+		t.Error("got a message on a closed subscription")
+	}
+	if h.Len() != 1 {
+		t.Errorf("got %d subscribers; want 1", h.Len())
+	}
+}
+
+func TestHubClose(t *testing.T) {
+	h := New[int]()
+	s := h.Subscribe(1)
+
+	h.Close()
+	h.Close()
+
+	if _, ok := <-s.C; ok {
+		t.Error("got a message after the hub was closed")
+	}
+	if h.Len() != 0 {
+		t.Errorf("got %d subscribers; want none", h.Len())
+	}
+
+	// Closing the subscription after the hub does not close its channel again.
+	s.Close()
+
+	late := h.Subscribe(1)
+	if _, ok := <-late.C; ok {
+		t.Error("got an open subscription to a closed hub")
+	}
+
+	if dropped := h.Publish(1); dropped != 0 {
+		t.Errorf("got %d subscribers missing a message to a closed hub; want none", dropped)
+	}
+}
+
+func TestHubConcurrentPublishers(t *testing.T) {
+	const publishers, messages = 8, 100
+
+	h := New[int]()
+	s := h.Subscribe(publishers * messages)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < publishers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < messages; j++ {
+				h.Publish(j)
+			}
+		}()
+	}
+
+	// Subscribers come and go while the messages are published.
+	for i := 0; i < messages; i++ {
+		h.Subscribe(0).Close()
+	}
+
+	wg.Wait()
+
+	if got := len(s.C); got != publishers*messages {
+		t.Errorf("got %d messages; want %d", got, publishers*messages)
+	}
+}
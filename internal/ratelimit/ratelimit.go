@@ -0,0 +1,124 @@
+// Package ratelimit limits how often each client may make requests, with a token bucket per
+// key which refills at a fixed rate up to a burst.
+package ratelimit
+
+import (
+	"context"
+	"greenlight/internal/clock"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Limiter is implemented by each backend.
+type Limiter interface {
+	// Allow takes a token from the bucket of key, and reports whether there was one along
+	// with the state of the bucket.
+	Allow(ctx context.Context, key string) (Result, error)
+	// Peek reports the state of the bucket of key without taking a token from it. Allowed
+	// reports whether it holds one.
+	Peek(ctx context.Context, key string) (Result, error)
+}
+
+// Result is the state of a bucket after a request took, or failed to take, a token from it.
+type Result struct {
+	Allowed bool
+	// Limit is the burst, and Remaining the whole tokens left in the bucket.
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again, and RetryAfter how long until it
+	// next holds a token. RetryAfter is zero while it has one.
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// result works out the state of a bucket holding tokens, which refills at rps up to burst.
+func result(allowed bool, tokens, rps float64, burst int) Result {
+	res := Result{
+		Allowed:   allowed,
+		Limit:     burst,
+		Remaining: max(0, int(math.Floor(tokens))),
+	}
+
+	if rps > 0 {
+		res.Reset = time.Duration((float64(burst) - tokens) / rps * float64(time.Second))
+		if tokens < 1 {
+			res.RetryAfter = time.Duration((1 - tokens) / rps * float64(time.Second))
+		}
+	}
+
+	return res
+}
+
+// Memory keeps the buckets in this process, so each instance of the application enforces
+// the limit on its own. Buckets unused for a few minutes are evicted.
+type Memory struct {
+	rps   float64
+	burst int
+	clock clock.Clock
+
+	mu      sync.Mutex
+	clients map[string]*client
+}
+
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewMemory returns a Memory limiter, and starts the goroutine evicting its unused buckets.
+func NewMemory(rps float64, burst int, clk clock.Clock) *Memory {
+	m := &Memory{rps: rps, burst: burst, clock: clk, clients: make(map[string]*client)}
+
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+
+			m.mu.Lock()
+
+			for key, client := range m.clients {
+				if m.clock.Now().Sub(client.lastSeen) > 3*time.Minute {
+					delete(m.clients, key)
+				}
+			}
+
+			m.mu.Unlock()
+		}
+	}()
+
+	return m
+}
+
+func (m *Memory) Allow(ctx context.Context, key string) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, found := m.clients[key]; !found {
+		m.clients[key] = &client{limiter: rate.NewLimiter(rate.Limit(m.rps), m.burst)}
+	}
+
+	now := m.clock.Now()
+
+	c := m.clients[key]
+	c.lastSeen = now
+
+	allowed := c.limiter.AllowN(now, 1)
+
+	return result(allowed, c.limiter.TokensAt(now), m.rps, m.burst), nil
+}
+
+func (m *Memory) Peek(ctx context.Context, key string) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, found := m.clients[key]
+	if !found {
+		return result(m.burst >= 1, float64(m.burst), m.rps, m.burst), nil
+	}
+
+	tokens := c.limiter.TokensAt(m.clock.Now())
+
+	return result(tokens >= 1, tokens, m.rps, m.burst), nil
+}
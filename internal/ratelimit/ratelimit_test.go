@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"context"
+	"greenlight/internal/clock"
+	"testing"
+	"time"
+)
+
+func TestResult(t *testing.T) {
+	tests := []struct {
+		name   string
+		tokens float64
+		rps    float64
+		want   Result
+	}{
+		{"full", 3, 2, Result{Allowed: true, Limit: 3, Remaining: 3}},
+		{"partial", 1.5, 2, Result{Allowed: true, Limit: 3, Remaining: 1, Reset: 750 * time.Millisecond}},
+		{"empty", 0.5, 2, Result{Limit: 3, Reset: 1250 * time.Millisecond, RetryAfter: 250 * time.Millisecond}},
+		{"no refill", 0, 0, Result{Limit: 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := result(tt.tokens >= 1, tt.tokens, tt.rps, 3); got != tt.want {
+				t.Errorf("got %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemory(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC))
+	m := NewMemory(2, 3, clk)
+	ctx := context.Background()
+
+	if res, _ := m.Peek(ctx, "a"); !res.Allowed || res.Remaining != 3 {
+		t.Fatalf("unused bucket: got %+v; want a full bucket", res)
+	}
+
+	for want := 2; want >= 0; want-- {
+		res, _ := m.Allow(ctx, "a")
+		if !res.Allowed || res.Remaining != want {
+			t.Fatalf("got %+v; want allowed with %d remaining", res, want)
+		}
+	}
+
+	res, _ := m.Allow(ctx, "a")
+	if res.Allowed || res.RetryAfter != 500*time.Millisecond {
+		t.Fatalf("empty bucket: got %+v; want denied for 500ms", res)
+	}
+
+	if res, _ := m.Allow(ctx, "b"); !res.Allowed {
+		t.Error("another key shares the bucket")
+	}
+
+	clk.Advance(500 * time.Millisecond)
+
+	// Peeking leaves the refilled token for the next request.
+	if res, _ := m.Peek(ctx, "a"); !res.Allowed || res.Remaining != 1 {
+		t.Errorf("peek after refill: got %+v; want 1 token", res)
+	}
+	if res, _ := m.Allow(ctx, "a"); !res.Allowed {
+		t.Error("the refilled token was not available")
+	}
+	if res, _ := m.Allow(ctx, "a"); res.Allowed {
+		t.Error("got a second token after half a second")
+	}
+}
@@ -0,0 +1,106 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"greenlight/internal/redis"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tokenBucket refills and takes a token from the bucket in KEYS[1] atomically, returning 1
+// when there was a token and 0 otherwise, followed by a colon and the tokens left. ARGV holds
+// the rate per second, the burst, the milliseconds after which an unused bucket expires and
+// whether to take a token, 1 or 0. Without taking one the bucket is left as it was. The
+// time is taken from the Redis server, so that instances with skewed clocks share the same
+// buckets correctly.
+const tokenBucket = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+  tokens = burst
+  ts = now
+end
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+
+local allowed = 0
+if tokens >= 1 then
+  allowed = 1
+end
+
+if ARGV[4] == '0' then
+  return allowed .. ':' .. tostring(tokens)
+end
+
+if allowed == 1 then
+  tokens = tokens - 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+
+return allowed .. ':' .. tostring(tokens)
+`
+
+// Redis keeps the buckets in Redis, so that the limit holds across every instance of the
+// application sharing it.
+type Redis struct {
+	Client *redis.Client
+	rps    float64
+	burst  int
+}
+
+func NewRedis(client *redis.Client, rps float64, burst int) *Redis {
+	return &Redis{Client: client, rps: rps, burst: burst}
+}
+
+func (l *Redis) Allow(ctx context.Context, key string) (Result, error) {
+	return l.eval(ctx, key, true)
+}
+
+func (l *Redis) Peek(ctx context.Context, key string) (Result, error) {
+	return l.eval(ctx, key, false)
+}
+
+// eval runs tokenBucket on the bucket of key, taking a token from it when take is set.
+func (l *Redis) eval(ctx context.Context, key string, take bool) (Result, error) {
+	// A bucket expires a second after it would have refilled completely, when it is the
+	// same as a new one.
+	ttl := time.Minute
+	if l.rps > 0 {
+		ttl = min(time.Duration(float64(l.burst)/l.rps*float64(time.Second))+time.Second, time.Hour)
+	}
+
+	args := []string{
+		"EVAL", tokenBucket, "1", "ratelimit:" + key,
+		strconv.FormatFloat(l.rps, 'f', -1, 64),
+		strconv.Itoa(l.burst),
+		strconv.FormatInt(ttl.Milliseconds(), 10),
+		"0",
+	}
+
+	if take {
+		args[len(args)-1] = "1"
+	}
+
+	reply, err := l.Client.Do(ctx, args...)
+	if err != nil {
+		return Result{}, err
+	}
+
+	allowed, left, ok := strings.Cut(reply, ":")
+	tokens, err := strconv.ParseFloat(left, 64)
+	if !ok || err != nil {
+		return Result{}, fmt.Errorf("ratelimit: unexpected reply %q", reply)
+	}
+
+	return result(allowed == "1", tokens, l.rps, l.burst), nil
+}
@@ -0,0 +1,124 @@
+package ratelimit
+
+import (
+	"bufio"
+	"context"
+	"greenlight/internal/redis"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveRedis accepts Redis connections on a local port, answering every command with reply
+// and sending the arguments of each command to the returned channel.
+func serveRedis(t *testing.T, reply string) (*redis.Client, <-chan []string) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	commands := make(chan []string, 8)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				r := bufio.NewReader(conn)
+				readLine := func() (string, error) {
+					line, err := r.ReadString('\n')
+					return strings.TrimSuffix(line, "\r\n"), err
+				}
+
+				for {
+					line, err := readLine()
+					if err != nil || !strings.HasPrefix(line, "*") {
+						return
+					}
+
+					n, _ := strconv.Atoi(line[1:])
+					args := make([]string, n)
+
+					for i := range args {
+						line, err := readLine()
+						if err != nil {
+							return
+						}
+
+						size, _ := strconv.Atoi(strings.TrimPrefix(line, "$"))
+						buf := make([]byte, size+2)
+						if _, err := io.ReadFull(r, buf); err != nil {
+							return
+						}
+						args[i] = string(buf[:size])
+					}
+
+					commands <- args
+					conn.Write([]byte(reply))
+				}
+			}()
+		}
+	}()
+
+	return redis.New(ln.Addr().String(), "", 0), commands
+}
+
+func TestRedis(t *testing.T) {
+	tests := []struct {
+		name     string
+		take     bool
+		wantTake string
+	}{
+		{"allow", true, "1"},
+		{"peek", false, "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, commands := serveRedis(t, "$5\r\n1:2.5\r\n")
+			l := NewRedis(client, 2, 3)
+
+			call := l.Peek
+			if tt.take {
+				call = l.Allow
+			}
+
+			res, err := call(context.Background(), "192.0.2.1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := (Result{Allowed: true, Limit: 3, Remaining: 2, Reset: 250 * time.Millisecond}); res != want {
+				t.Errorf("got %+v; want %+v", res, want)
+			}
+
+			// The bucket expires a second after refilling completely from empty.
+			args := <-commands
+			want := []string{"EVAL", tokenBucket, "1", "ratelimit:192.0.2.1", "2", "3", "2500", tt.wantTake}
+			if !slices.Equal(args, want) {
+				t.Errorf("got arguments %q; want %q", args[2:], want[2:])
+			}
+		})
+	}
+}
+
+func TestRedisRejectsUnexpectedReplies(t *testing.T) {
+	client, _ := serveRedis(t, "+OK\r\n")
+
+	_, err := NewRedis(client, 2, 3).Allow(context.Background(), "192.0.2.1")
+	if err == nil || !strings.Contains(err.Error(), "unexpected reply") {
+		t.Errorf("got error %v; want the reply rejected", err)
+	}
+}
@@ -0,0 +1,213 @@
+// Package redis is a minimal Redis client. It speaks just enough of the Redis protocol for
+// the few commands the application needs, over a small pool of connections.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNil is returned by Do for a Redis nil reply.
+var ErrNil = errors.New("redis: nil")
+
+type Client struct {
+	Addr     string
+	Password string
+	DB       int
+	Timeout  time.Duration
+
+	mu   sync.Mutex
+	idle []*conn
+}
+
+func New(addr, password string, db int) *Client {
+	return &Client{Addr: addr, Password: password, DB: db, Timeout: 3 * time.Second}
+}
+
+// ParseURL returns a client for a URL of the form redis://[:password@]host[:port][/db].
+func ParseURL(rawURL string) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "redis" || u.Hostname() == "" {
+		return nil, fmt.Errorf("redis: invalid URL %q", rawURL)
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+
+	password, _ := u.User.Password()
+
+	db := 0
+	if path := strings.Trim(u.Path, "/"); path != "" {
+		db, err = strconv.Atoi(path)
+		if err != nil || db < 0 {
+			return nil, fmt.Errorf("redis: invalid database in URL %q", rawURL)
+		}
+	}
+
+	return New(addr, password, db), nil
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// Do sends a command and returns its reply, which must be a simple string, integer or bulk
+// string.
+func (c *Client) Do(ctx context.Context, args ...string) (string, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	deadline := time.Now().Add(c.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	cn.SetDeadline(deadline)
+
+	reply, err := cn.do(args)
+	if err != nil && !errors.Is(err, ErrNil) && !isServerError(err) {
+		cn.Close()
+		return "", err
+	}
+
+	c.put(cn)
+
+	return reply, err
+}
+
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	c.mu.Lock()
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+
+	dialer := net.Dialer{Timeout: c.Timeout}
+
+	nc, err := dialer.DialContext(ctx, "tcp", c.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc)}
+	cn.SetDeadline(time.Now().Add(c.Timeout))
+
+	if c.Password != "" {
+		_, err = cn.do([]string{"AUTH", c.Password})
+		if err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+
+	if c.DB != 0 {
+		_, err = cn.do([]string{"SELECT", strconv.Itoa(c.DB)})
+		if err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+
+	return cn, nil
+}
+
+// put returns a healthy connection to the pool, keeping at most a handful idle.
+func (c *Client) put(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.idle) >= 8 {
+		cn.Close()
+		return
+	}
+
+	c.idle = append(c.idle, cn)
+}
+
+type serverError string
+
+func (e serverError) Error() string {
+	return "redis: " + string(e)
+}
+
+func isServerError(err error) bool {
+	var se serverError
+	return errors.As(err, &se)
+}
+
+func (c *conn) do(args []string) (string, error) {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	_, err := c.Write([]byte(b.String()))
+	if err != nil {
+		return "", err
+	}
+
+	line, err := c.readLine()
+	if err != nil {
+		return "", err
+	}
+
+	if line == "" {
+		return "", errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+', ':':
+		return line[1:], nil
+	case '-':
+		return "", serverError(line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", fmt.Errorf("redis: invalid bulk length %q", line)
+		}
+		if n < 0 {
+			return "", ErrNil
+		}
+
+		buf := make([]byte, n+2)
+		_, err = io.ReadFull(c.r, buf)
+		if err != nil {
+			return "", err
+		}
+
+		return string(buf[:n]), nil
+	default:
+		return "", fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
+
+func (c *conn) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
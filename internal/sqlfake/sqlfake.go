@@ -0,0 +1,171 @@
+// Package sqlfake is a database/sql driver for tests, whose queries are answered by a
+// function instead of a database, so that the models can be exercised without PostgreSQL.
+package sqlfake
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+)
+
+// Result is the answer to a query or statement. Rows hold driver values: int64, float64,
+// bool, []byte, string, time.Time or nil.
+type Result struct {
+	Columns      []string
+	Rows         [][]any
+	RowsAffected int64
+}
+
+// Handler answers a query or statement. The args are those given to database/sql, passed
+// through unconverted. A nil Result is an empty result.
+type Handler func(query string, args []any) (*Result, error)
+
+// Open returns a database whose queries, statements and pings are all answered by h. Pings
+// call h with an empty query.
+func Open(h Handler) *sql.DB {
+	return sql.OpenDB(connector{h: h})
+}
+
+type connector struct {
+	h Handler
+}
+
+func (c connector) Connect(context.Context) (driver.Conn, error) {
+	return &conn{h: c.h}, nil
+}
+
+func (c connector) Driver() driver.Driver {
+	return fakeDriver{h: c.h}
+}
+
+type fakeDriver struct {
+	h Handler
+}
+
+func (d fakeDriver) Open(string) (driver.Conn, error) {
+	return &conn{h: d.h}, nil
+}
+
+type conn struct {
+	h Handler
+}
+
+func (c *conn) answer(query string, named []driver.NamedValue) (*Result, error) {
+	args := make([]any, len(named))
+	for i, arg := range named {
+		args[i] = arg.Value
+	}
+
+	res, err := c.h(query, args)
+	if res == nil && err == nil {
+		res = &Result{}
+	}
+
+	return res, err
+}
+
+// CheckNamedValue accepts every argument as it is, such as the []string genres.
+func (c *conn) CheckNamedValue(*driver.NamedValue) error {
+	return nil
+}
+
+func (c *conn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	res, err := c.answer(query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rows{res: res}, nil
+}
+
+func (c *conn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res, err := c.answer(query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return driver.RowsAffected(res.RowsAffected), nil
+}
+
+func (c *conn) Ping(context.Context) error {
+	_, err := c.h("", nil)
+	return err
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{conn: c, query: query}, nil
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return tx{}, nil
+}
+
+func (c *conn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	return tx{}, nil
+}
+
+// tx is a transaction in name only: its statements take effect straight away.
+type tx struct{}
+
+func (tx) Commit() error   { return nil }
+func (tx) Rollback() error { return nil }
+
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+func (s *stmt) Close() error  { return nil }
+func (s *stmt) NumInput() int { return -1 }
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, named(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, named(args))
+}
+
+func named(args []driver.Value) []driver.NamedValue {
+	nv := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		nv[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+
+	return nv
+}
+
+type rows struct {
+	res  *Result
+	next int
+}
+
+func (r *rows) Columns() []string {
+	if r.res.Columns == nil && len(r.res.Rows) > 0 {
+		return make([]string, len(r.res.Rows[0]))
+	}
+
+	return r.res.Columns
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.next >= len(r.res.Rows) {
+		return io.EOF
+	}
+
+	for i, v := range r.res.Rows[r.next] {
+		dest[i] = v
+	}
+	r.next++
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package sqlfake
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	var gotQuery string
+	var gotArgs []any
+
+	db := Open(func(query string, args []any) (*Result, error) {
+		gotQuery, gotArgs = query, args
+
+		if query == "fail" {
+			return nil, errors.New("boom")
+		}
+
+		return &Result{Columns: []string{"id", "name"}, Rows: [][]any{{int64(1), "a"}, {int64(2), "b"}}, RowsAffected: 2}, nil
+	})
+	defer db.Close()
+
+	rows, err := db.Query("SELECT", "x", []string{"Drama"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for rows.Next() {
+		var id int64
+		var name string
+
+		if err := rows.Scan(&id, &name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+
+	if gotQuery != "SELECT" || len(gotArgs) != 2 || !slices.Equal(gotArgs[1].([]string), []string{"Drama"}) {
+		t.Errorf("handler got %q %v", gotQuery, gotArgs)
+	}
+	if !slices.Equal(names, []string{"a", "b"}) {
+		t.Errorf("got names %q", names)
+	}
+
+	result, err := db.Exec("UPDATE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := result.RowsAffected(); n != 2 {
+		t.Errorf("got %d rows affected; want 2", n)
+	}
+
+	if _, err := db.Exec("fail"); err == nil || err.Error() != "boom" {
+		t.Errorf("got error %v; want boom", err)
+	}
+}
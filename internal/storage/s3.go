@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"greenlight/internal/objectstore"
+	"io"
+	"net/http"
+	"time"
+)
+
+// S3Store keeps blobs in an S3-compatible object store.
+type S3Store struct {
+	Client *objectstore.Client
+}
+
+func NewS3Store(client *objectstore.Client) *S3Store {
+	return &S3Store{Client: client}
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	return s.Client.Put(ctx, key, body, contentType)
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (*Blob, error) {
+	resp, err := s.Client.Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, objectstore.ErrNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+
+	return &Blob{
+		Body:        resp.Body,
+		ContentType: resp.Header.Get("Content-Type"),
+		Size:        resp.ContentLength,
+		ModTime:     modTime,
+	}, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	err := s.Client.Delete(ctx, key)
+	if errors.Is(err, objectstore.ErrNotFound) {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func (s *S3Store) PresignGet(key string, expires time.Duration) (string, error) {
+	return s.Client.PresignGet(key, expires)
+}
@@ -0,0 +1,33 @@
+// Package storage stores binary media such as movie posters, either on the local filesystem
+// or in an S3-compatible object store.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrNotFound = errors.New("storage: blob not found")
+
+// Blob is a stored object. The caller must close Body.
+type Blob struct {
+	Body        io.ReadCloser
+	ContentType string
+	Size        int64
+	ModTime     time.Time
+}
+
+// BlobStore is implemented by each storage backend.
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	Get(ctx context.Context, key string) (*Blob, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Presigner is implemented by backends which can hand out temporary URLs, so that clients
+// can fetch blobs directly from the backend rather than through the API.
+type Presigner interface {
+	PresignGet(key string, expires time.Duration) (string, error)
+}
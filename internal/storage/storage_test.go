@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"greenlight/internal/objectstore"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is an S3-compatible server holding its objects in memory.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Header.Get("x-amz-date") == "" || !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		http.Error(w, "unsigned request", http.StatusForbidden)
+		return
+	}
+
+	key := r.URL.Path
+
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		s.objects[key] = body
+		s.types[key] = r.Header.Get("Content-Type")
+	case http.MethodGet:
+		body, ok := s.objects[key]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", s.types[key])
+		w.Write(body)
+	case http.MethodDelete:
+		if _, ok := s.objects[key]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestBlobStores(t *testing.T) {
+	srv := httptest.NewServer(&fakeS3{objects: map[string][]byte{}, types: map[string]string{}})
+	t.Cleanup(srv.Close)
+
+	fileStore, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stores := map[string]BlobStore{
+		"filesystem": fileStore,
+		"s3":         NewS3Store(objectstore.New(srv.URL, "us-east-1", "posters", "access", "secret")),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			if _, err := store.Get(ctx, "posters/1"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Get before Put: got error %v; want ErrNotFound", err)
+			}
+
+			if err := store.Put(ctx, "posters/1", strings.NewReader("poster bytes"), "image/png"); err != nil {
+				t.Fatal(err)
+			}
+
+			blob, err := store.Get(ctx, "posters/1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body, err := io.ReadAll(blob.Body)
+			blob.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(body) != "poster bytes" || blob.ContentType != "image/png" || blob.Size != int64(len(body)) {
+				t.Errorf("got body %q, content type %q and size %d", body, blob.ContentType, blob.Size)
+			}
+
+			if err := store.Delete(ctx, "posters/1"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Get(ctx, "posters/1"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get after Delete: got error %v; want ErrNotFound", err)
+			}
+			if err := store.Delete(ctx, "posters/1"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Delete twice: got error %v; want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestFileStoreRejectsKeysOutsideItsDirectory(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"../escape", "posters/../../escape", ""} {
+		if err := store.Put(context.Background(), key, strings.NewReader("x"), "text/plain"); err == nil {
+			t.Errorf("Put(%q): got nil error", key)
+		}
+	}
+}
+
+func TestS3StorePresignsURLs(t *testing.T) {
+	store := NewS3Store(objectstore.New("https://s3.example.com", "us-east-1", "posters", "access", "secret"))
+
+	url, err := store.PresignGet("posters/1", 15*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(url, "https://s3.example.com/posters/posters/1?") || !strings.Contains(url, "X-Amz-Expires=900") || !strings.Contains(url, "X-Amz-Signature=") {
+		t.Errorf("got URL %q", url)
+	}
+}
@@ -0,0 +1,169 @@
+// Package tracing provides lightweight request tracing compatible with the W3C Trace Context
+// traceparent header, so that spans can be correlated with upstream and downstream services
+// which use OpenTelemetry.
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type TraceID [16]byte
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+type SpanID [8]byte
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// SpanContext identifies a span and carries the sampling decision made for its trace.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// Traceparent formats the span context as a W3C traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses a W3C traceparent header value. It reports false if the value is
+// missing or malformed, in which case a new trace should be started.
+func ParseTraceparent(header string) (SpanContext, bool) {
+	var sc SpanContext
+
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+
+	traceID, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return sc, false
+	}
+
+	spanID, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return sc, false
+	}
+
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Sampled = flags[0]&0x01 == 0x01
+
+	if sc.TraceID == (TraceID{}) || sc.SpanID == (SpanID{}) {
+		return sc, false
+	}
+
+	return sc, true
+}
+
+func NewTraceID() TraceID {
+	var t TraceID
+	rand.Read(t[:])
+	return t
+}
+
+func NewSpanID() SpanID {
+	var s SpanID
+	rand.Read(s[:])
+	return s
+}
+
+// Sampler decides whether a new span is recorded. parent is nil for the root of a trace.
+type Sampler interface {
+	ShouldSample(traceID TraceID, parent *SpanContext) bool
+}
+
+// ParentBasedRatio follows the sampling decision of the parent span when there is one, and
+// otherwise samples the given fraction of traces, chosen deterministically from the trace id
+// so that every service sampling at the same ratio keeps the same traces.
+type ParentBasedRatio struct {
+	Ratio float64
+}
+
+func (s ParentBasedRatio) ShouldSample(traceID TraceID, parent *SpanContext) bool {
+	if parent != nil {
+		return parent.Sampled
+	}
+
+	switch {
+	case s.Ratio >= 1:
+		return true
+	case s.Ratio <= 0:
+		return false
+	}
+
+	threshold := uint64(s.Ratio * (1 << 63))
+	return binary.BigEndian.Uint64(traceID[8:])>>1 < threshold
+}
+
+// Span is a finished unit of work, ready to be exported.
+type Span struct {
+	Name         string
+	Context      SpanContext
+	ParentSpanID SpanID
+	Start        time.Time
+	Duration     time.Duration
+	Attributes   map[string]string
+}
+
+// Provider starts spans using its Sampler and hands finished, sampled spans to Export.
+type Provider struct {
+	Sampler Sampler
+	Export  func(Span)
+}
+
+// Start begins a span named name, as a child of parent when one is given.
+func (p *Provider) Start(name string, parent *SpanContext, now time.Time) *Span {
+	span := &Span{
+		Name:       name,
+		Start:      now,
+		Attributes: make(map[string]string),
+	}
+
+	if parent != nil {
+		span.Context.TraceID = parent.TraceID
+		span.ParentSpanID = parent.SpanID
+	} else {
+		span.Context.TraceID = NewTraceID()
+	}
+
+	span.Context.SpanID = NewSpanID()
+	span.Context.Sampled = p.Sampler.ShouldSample(span.Context.TraceID, parent)
+
+	return span
+}
+
+// End finishes the span. It is exported when it was sampled, or when force is set, which is
+// used to always keep spans for errors and slow requests whatever the sampling rate.
+func (p *Provider) End(span *Span, now time.Time, force bool) {
+	span.Duration = now.Sub(span.Start)
+
+	if force {
+		span.Context.Sampled = true
+	}
+
+	if span.Context.Sampled && p.Export != nil {
+		p.Export(*span)
+	}
+}
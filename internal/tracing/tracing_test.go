@@ -0,0 +1,78 @@
+package tracing
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	sc, ok := ParseTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	if !ok {
+		t.Fatal("got a valid traceparent rejected")
+	}
+
+	if sc.TraceID.String() != "0af7651916cd43dd8448eb211c80319c" || sc.SpanID.String() != "b7ad6b7169203331" || !sc.Sampled {
+		t.Errorf("got %+v", sc)
+	}
+	if got := sc.Traceparent(); got != "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01" {
+		t.Errorf("Traceparent() = %q", got)
+	}
+
+	for _, header := range []string{
+		"",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331",
+		"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01",
+		"00-0af7651916cd43dd8448eb211c80319z-b7ad6b7169203331-01",
+	} {
+		if _, ok := ParseTraceparent(header); ok {
+			t.Errorf("ParseTraceparent(%q): got ok", header)
+		}
+	}
+}
+
+func TestParentBasedRatio(t *testing.T) {
+	const traces = 10000
+
+	for _, ratio := range []float64{0, 0.25, 1} {
+		sampler := ParentBasedRatio{Ratio: ratio}
+
+		sampled := 0
+		for range traces {
+			traceID := NewTraceID()
+			if sampler.ShouldSample(traceID, nil) {
+				sampled++
+			}
+			if sampler.ShouldSample(traceID, nil) != sampler.ShouldSample(traceID, nil) {
+				t.Fatalf("ratio %v: got different decisions for the same trace", ratio)
+			}
+		}
+
+		if got := float64(sampled) / traces; got < ratio-0.03 || got > ratio+0.03 {
+			t.Errorf("ratio %v: sampled %v of traces", ratio, got)
+		}
+	}
+
+	sampler := ParentBasedRatio{Ratio: 0}
+	if !sampler.ShouldSample(NewTraceID(), &SpanContext{Sampled: true}) {
+		t.Error("got a sampled parent ignored")
+	}
+}
+
+func TestProviderForcesExport(t *testing.T) {
+	var exported []Span
+	p := &Provider{Sampler: ParentBasedRatio{Ratio: 0}, Export: func(s Span) { exported = append(exported, s) }}
+
+	start := time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC)
+
+	p.End(p.Start("GET /v1/movies", nil, start), start.Add(time.Second), false)
+	if len(exported) != 0 {
+		t.Fatalf("got %d spans exported; want none", len(exported))
+	}
+
+	p.End(p.Start("GET /v1/movies", nil, start), start.Add(time.Second), true)
+	if len(exported) != 1 || exported[0].Duration != time.Second || !exported[0].Context.Sampled {
+		t.Errorf("got %+v; want the forced span exported", exported)
+	}
+}
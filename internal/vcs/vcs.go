@@ -6,43 +6,103 @@ import (
 	"strings"
 )
 
-func Version() string {
-	var (
-		time string
-		revision string
-		modified bool
-		version string
-	)
+// BuildInfo describes the build of the running binary. Version is only set when it was
+// given with -ldflags, and Revision, Time and Modified come from the version control
+// information Go stamps into binaries built from a repository.
+type BuildInfo struct {
+	Version  string `json:"version"`
+	Revision string `json:"revision"`
+	// Time is when the revision was committed.
+	Time     string `json:"time"`
+	Modified bool   `json:"dirty"`
+
+	// module is the version of the main module, which go install sets from the module
+	// proxy. It is "(devel)" for other builds.
+	module string
+}
 
+// Info returns the build information of the running binary.
+func Info() BuildInfo {
 	bi, ok := debug.ReadBuildInfo()
-	if ok {
-		for _, s := range bi.Settings {
-			switch s.Key {
-			case "vcs.time":
-				time = s.Value
-			case "vcs.revision":
-				revision = s.Value
-			case "vcs.modified":
-				if s.Value == "true" {
-					modified = true
-				}
-			case "-ldflags":
-				if len(strings.Split(s.Value, "=")) == 2 {
-					version = strings.Split(s.Value, "=")[1]
-				} else {
-					version = ""
-				}
-			}
-		}
+	if !ok {
+		return BuildInfo{}
 	}
 
-	if version != "" {
-		return version
+	return parse(bi)
+}
+
+func parse(bi *debug.BuildInfo) BuildInfo {
+	info := BuildInfo{module: bi.Main.Version}
+
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.time":
+			info.Time = s.Value
+		case "vcs.revision":
+			info.Revision = s.Value
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		case "-ldflags":
+			info.Version = ldflagsVersion(s.Value)
+		}
 	}
 
-	if modified {
-		return fmt.Sprintf("%s-%s-dirty", time, revision)
+	return info
+}
+
+// ldflagsVersion returns the value given to a variable called version by the -X flags in
+// ldflags, such as -X main.version=1.0.0 or -X=main.version=1.0.0, or an empty string when
+// there is none. Other flags are ignored.
+func ldflagsVersion(ldflags string) string {
+	fields := strings.Fields(ldflags)
+
+	for i := 0; i < len(fields); i++ {
+		var definition string
+
+		switch {
+		case fields[i] == "-X" || fields[i] == "--X":
+			if i+1 == len(fields) {
+				return ""
+			}
+			i++
+			definition = fields[i]
+		case strings.HasPrefix(fields[i], "-X="):
+			definition = strings.TrimPrefix(fields[i], "-X=")
+		case strings.HasPrefix(fields[i], "--X="):
+			definition = strings.TrimPrefix(fields[i], "--X=")
+		default:
+			continue
+		}
+
+		name, value, ok := strings.Cut(strings.Trim(definition, `'"`), "=")
+		if ok && (name == "version" || strings.HasSuffix(name, ".version")) {
+			return value
+		}
 	}
 
-	return fmt.Sprintf("%s-%s", time, revision)
+	return ""
+}
+
+// Version formats the build information as a single string: the version given with
+// -ldflags, or else the commit time and revision, suffixed with -dirty when the working tree
+// had changes. Without version control information it falls back to the module version,
+// and to "unknown" when there is none either.
+func Version() string {
+	return Info().String()
+}
+
+func (info BuildInfo) String() string {
+	switch {
+	case info.Version != "":
+		return info.Version
+	case info.Time == "" && info.Revision == "":
+		if info.module != "" && info.module != "(devel)" {
+			return info.module
+		}
+		return "unknown"
+	case info.Modified:
+		return fmt.Sprintf("%s-%s-dirty", info.Time, info.Revision)
+	default:
+		return fmt.Sprintf("%s-%s", info.Time, info.Revision)
+	}
 }
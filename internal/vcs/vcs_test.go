@@ -0,0 +1,116 @@
+package vcs
+
+import (
+	"runtime/debug"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	setting := func(key, value string) debug.BuildSetting {
+		return debug.BuildSetting{Key: key, Value: value}
+	}
+
+	tests := []struct {
+		name     string
+		bi       debug.BuildInfo
+		want     BuildInfo
+		wantText string
+	}{
+		{
+			name: "clean",
+			bi: debug.BuildInfo{Main: debug.Module{Version: "(devel)"}, Settings: []debug.BuildSetting{
+				setting("vcs.revision", "abc123"),
+				setting("vcs.time", "2024-04-01T12:00:00Z"),
+				setting("vcs.modified", "false"),
+			}},
+			want:     BuildInfo{Revision: "abc123", Time: "2024-04-01T12:00:00Z", module: "(devel)"},
+			wantText: "2024-04-01T12:00:00Z-abc123",
+		},
+		{
+			name: "dirty",
+			bi: debug.BuildInfo{Main: debug.Module{Version: "(devel)"}, Settings: []debug.BuildSetting{
+				setting("vcs.revision", "abc123"),
+				setting("vcs.time", "2024-04-01T12:00:00Z"),
+				setting("vcs.modified", "true"),
+			}},
+			want:     BuildInfo{Revision: "abc123", Time: "2024-04-01T12:00:00Z", Modified: true, module: "(devel)"},
+			wantText: "2024-04-01T12:00:00Z-abc123-dirty",
+		},
+		{
+			name: "ldflags override",
+			bi: debug.BuildInfo{Main: debug.Module{Version: "(devel)"}, Settings: []debug.BuildSetting{
+				setting("-ldflags", "-s -X main.version=1.2.3"),
+				setting("vcs.revision", "abc123"),
+				setting("vcs.time", "2024-04-01T12:00:00Z"),
+				setting("vcs.modified", "true"),
+			}},
+			want:     BuildInfo{Version: "1.2.3", Revision: "abc123", Time: "2024-04-01T12:00:00Z", Modified: true, module: "(devel)"},
+			wantText: "1.2.3",
+		},
+		{
+			name: "ldflags with other variables",
+			bi: debug.BuildInfo{Settings: []debug.BuildSetting{
+				setting("-ldflags", `-X=main.commit=abc -X 'greenlight/internal/vcs.version=2.0.0'`),
+			}},
+			want:     BuildInfo{Version: "2.0.0"},
+			wantText: "2.0.0",
+		},
+		{
+			name:     "no vcs",
+			bi:       debug.BuildInfo{Main: debug.Module{Version: "(devel)"}},
+			want:     BuildInfo{module: "(devel)"},
+			wantText: "unknown",
+		},
+		{
+			name:     "no vcs with module version",
+			bi:       debug.BuildInfo{Main: debug.Module{Version: "v1.4.0"}},
+			want:     BuildInfo{module: "v1.4.0"},
+			wantText: "v1.4.0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parse(&tt.bi)
+
+			if got != tt.want {
+				t.Errorf("parse() = %+v; want %+v", got, tt.want)
+			}
+			if text := got.String(); text != tt.wantText {
+				t.Errorf("String() = %q; want %q", text, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestLdflagsVersion(t *testing.T) {
+	tests := []struct {
+		ldflags string
+		want    string
+	}{
+		{"", ""},
+		{"-s -w", ""},
+		{"-X main.version=1.0.0", "1.0.0"},
+		{"-X=main.version=1.0.0", "1.0.0"},
+		{"--X main.version=1.0.0", "1.0.0"},
+		{`-X "main.version=1.0.0"`, "1.0.0"},
+		{"-X main.versions=1.0.0", ""},
+		{"-X", ""},
+	}
+
+	for _, tt := range tests {
+		if got := ldflagsVersion(tt.ldflags); got != tt.want {
+			t.Errorf("ldflagsVersion(%q) = %q; want %q", tt.ldflags, got, tt.want)
+		}
+	}
+}
+
+func TestInfoMatchesVersion(t *testing.T) {
+	// Test binaries carry no VCS information, but Info and Version must still agree.
+	if got, want := Version(), Info().String(); got != want {
+		t.Errorf("Version() = %q; Info().String() = %q", got, want)
+	}
+	if Version() == "" {
+		t.Error("Version() is empty")
+	}
+}
@@ -0,0 +1,178 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Filter is a predicate over a payload, written in a small JSONPath-style syntax: one or
+// more clauses of the form `$.field op value` joined by `&&`, where op is one of ==, !=,
+// >, >=, <, <= or contains, and value is a JSON string, number, boolean or null. For
+// example:
+//
+//	$.genres contains "Drama" && $.year >= 2000
+//
+// An empty filter matches every payload.
+type Filter struct {
+	clauses []clause
+}
+
+type clause struct {
+	field    string
+	operator string
+	value    any
+}
+
+var clauseRX = regexp.MustCompile(`^\$\.([a-z_]+)\s*(==|!=|>=|<=|>|<|contains)\s*(.+)$`)
+
+// ParseFilter parses a filter expression. Fields are checked against permittedFields so
+// that an expression cannot silently refer to a field that never exists.
+func ParseFilter(expr string, permittedFields []string) (Filter, error) {
+	var f Filter
+
+	if strings.TrimSpace(expr) == "" {
+		return f, nil
+	}
+
+	for _, part := range strings.Split(expr, "&&") {
+		matches := clauseRX.FindStringSubmatch(strings.TrimSpace(part))
+		if matches == nil {
+			return f, fmt.Errorf("invalid clause %q", strings.TrimSpace(part))
+		}
+
+		if !contains(permittedFields, matches[1]) {
+			return f, fmt.Errorf("unknown field %q", matches[1])
+		}
+
+		var value any
+
+		err := json.Unmarshal([]byte(matches[3]), &value)
+		if err != nil {
+			return f, fmt.Errorf("invalid value %s", matches[3])
+		}
+
+		// Values are compared with ==, so arrays and objects, which cannot be, are rejected.
+		switch value.(type) {
+		case []any, map[string]any:
+			return f, fmt.Errorf("invalid value %s: must be a string, number, boolean or null", matches[3])
+		}
+
+		f.clauses = append(f.clauses, clause{field: matches[1], operator: matches[2], value: value})
+	}
+
+	return f, nil
+}
+
+// Match reports whether every clause of the filter holds for payload.
+func (f Filter) Match(payload map[string]any) bool {
+	for _, c := range f.clauses {
+		if !c.match(payload[c.field]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c clause) match(actual any) bool {
+	switch c.operator {
+	case "contains":
+		items, ok := actual.([]any)
+		if !ok {
+			s, ok := actual.(string)
+			want, isString := c.value.(string)
+			return ok && isString && strings.Contains(s, want)
+		}
+
+		for _, item := range items {
+			if equal(item, c.value) {
+				return true
+			}
+		}
+		return false
+	case "==":
+		return equal(actual, c.value)
+	case "!=":
+		return !equal(actual, c.value)
+	}
+
+	a, aOK := actual.(float64)
+	b, bOK := c.value.(float64)
+	if !aOK || !bOK {
+		return false
+	}
+
+	switch c.operator {
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	}
+
+	return false
+}
+
+func equal(a, b any) bool {
+	if s, ok := a.(string); ok {
+		t, ok := b.(string)
+		return ok && strings.EqualFold(s, t)
+	}
+
+	return a == b
+}
+
+// Project returns a copy of payload holding only the given fields. When fields is empty the
+// payload is returned unchanged.
+func Project(payload map[string]any, fields []string) map[string]any {
+	if len(fields) == 0 {
+		return payload
+	}
+
+	projected := make(map[string]any, len(fields))
+
+	for _, field := range fields {
+		if value, ok := payload[field]; ok {
+			projected[field] = value
+		}
+	}
+
+	return projected
+}
+
+// ToPayload converts v into the generic map form used for filtering and projection, using
+// its JSON representation so that webhooks see exactly what the API would return.
+func ToPayload(v any) (map[string]any, error) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload map[string]any
+
+	err = json.Unmarshal(js, &payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if payload == nil {
+		return nil, errors.New("webhook: payload must be a JSON object")
+	}
+
+	return payload, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
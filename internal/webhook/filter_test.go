@@ -0,0 +1,97 @@
+package webhook
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testFields = []string{"title", "year", "runtime", "genres"}
+
+func TestParseFilterRejectsInvalidExpressions(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{`title == "Heat"`, "invalid clause"},
+		{`$.title ~ "Heat"`, "invalid clause"},
+		{`$.director == "Mann"`, `unknown field "director"`},
+		{`$.title == Heat`, "invalid value Heat"},
+		{`$.genres == ["Drama"]`, "must be a string, number, boolean or null"},
+		{`$.genres contains {"name": "Drama"}`, "must be a string, number, boolean or null"},
+		{`$.year >= 2000 && $.genres != ["Drama"]`, "must be a string, number, boolean or null"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := ParseFilter(tt.expr, testFields)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v; want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	payload := map[string]any{
+		"title":   "Heat",
+		"year":    float64(1995),
+		"runtime": float64(170),
+		"genres":  []any{"Crime", "Drama"},
+	}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{``, true},
+		{`$.title == "heat"`, true},
+		{`$.title != "Alien"`, true},
+		{`$.title contains "ea"`, true},
+		{`$.genres contains "drama"`, true},
+		{`$.genres contains "Horror"`, false},
+		{`$.year >= 1995`, true},
+		{`$.year > 1995`, false},
+		{`$.year < 2000 && $.runtime <= 170`, true},
+		{`$.year < 2000 && $.runtime < 170`, false},
+		{`$.title > 1`, false},
+		{`$.runtime == 170`, true},
+		{`$.genres == "Crime"`, false},
+		{`$.genres != null`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			f, err := ParseFilter(tt.expr, testFields)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := f.Match(payload); got != tt.want {
+				t.Errorf("got %t; want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProject(t *testing.T) {
+	payload := map[string]any{"id": float64(1), "title": "Heat", "year": float64(1995)}
+
+	tests := []struct {
+		name   string
+		fields []string
+		want   map[string]any
+	}{
+		{"all", nil, payload},
+		{"selected", []string{"title"}, map[string]any{"title": "Heat"}},
+		{"missing", []string{"title", "runtime"}, map[string]any{"title": "Heat"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Project(payload, tt.fields); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
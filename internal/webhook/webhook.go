@@ -0,0 +1,56 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"greenlight/internal/outbound"
+	"net/http"
+	"time"
+)
+
+// Event is the body POSTed to a webhook target.
+type Event struct {
+	Type      string         `json:"type"`
+	Timestamp time.Time      `json:"timestamp"`
+	Data      map[string]any `json:"data"`
+}
+
+type Client struct {
+	HTTPClient *http.Client
+}
+
+func New(timeout time.Duration) Client {
+	return Client{
+		HTTPClient: &http.Client{Timeout: timeout},
+	}
+}
+
+// Send POSTs the event to url as JSON. Any response outside the 2xx range is an error.
+func (c Client) Send(ctx context.Context, url string, event Event) error {
+	js, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(js))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "greenlight-webhooks")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return &outbound.DependencyError{Dependency: "webhook", Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &outbound.DependencyError{Dependency: "webhook", Err: fmt.Errorf("webhook: unexpected status %d from %s", resp.StatusCode, url)}
+	}
+
+	return nil
+}
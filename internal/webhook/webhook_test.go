@@ -0,0 +1,89 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"greenlight/internal/jsonlog"
+	"greenlight/internal/outbound"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendIsLoggedAsAnOutboundCall(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		wantOutcome string
+	}{
+		{"delivered", http.StatusNoContent, outbound.OutcomeSuccess},
+		{"rejected", http.StatusBadGateway, outbound.OutcomeFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received Event
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+					t.Error(err)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer ts.Close()
+
+			var out bytes.Buffer
+
+			c := New(time.Second)
+			c.HTTPClient = outbound.Instrument(c.HTTPClient, "webhook", &outbound.Recorder{Logger: jsonlog.New(&out, jsonlog.LevelInfo)})
+
+			url := strings.Replace(ts.URL, "http://", "http://user:pass@", 1) + "/hooks?token=abc&movie=1"
+
+			err := c.Send(context.Background(), url, Event{Type: "movie.created", Data: map[string]any{"id": 1}})
+
+			var depErr *outbound.DependencyError
+			if delivered := err == nil; delivered != (tt.wantOutcome == outbound.OutcomeSuccess) {
+				t.Fatalf("got error %v", err)
+			}
+			if err != nil && (!errors.As(err, &depErr) || depErr.Dependency != "webhook") {
+				t.Errorf("got error %#v; want a webhook DependencyError", err)
+			}
+			if received.Type != "movie.created" {
+				t.Errorf("got event %+v", received)
+			}
+
+			var entry struct {
+				Message    string            `json:"message"`
+				Properties map[string]string `json:"properties"`
+			}
+			if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+				t.Fatalf("got log %q: %s", out.String(), err)
+			}
+
+			want := map[string]string{
+				"dependency": "webhook",
+				"target":     "POST " + ts.URL + "/hooks?movie=1&token=REDACTED",
+				"attempt":    "1",
+				"outcome":    tt.wantOutcome,
+				"status":     strconv.Itoa(tt.status),
+			}
+
+			if entry.Message != "outbound call" {
+				t.Fatalf("got message %q; want outbound call", entry.Message)
+			}
+			for key, value := range want {
+				if entry.Properties[key] != value {
+					t.Errorf("got %s %q; want %q", key, entry.Properties[key], value)
+				}
+			}
+			if _, ok := entry.Properties["duration_ms"]; !ok {
+				t.Error("got no duration_ms")
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS exports (
+  id bigserial PRIMARY KEY,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  user_id bigint REFERENCES users ON DELETE SET NULL,
+  status text NOT NULL DEFAULT 'pending',
+  object_key text NOT NULL,
+  records bigint NOT NULL DEFAULT 0,
+  error text NOT NULL DEFAULT '',
+  completed_at timestamp(0) with time zone
+);
+
+INSERT INTO permissions (code)
+VALUES
+  ('admin:exports');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permissions WHERE code = 'admin:exports';
+DROP TABLE IF EXISTS exports;
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS webhooks (
+  id bigserial PRIMARY KEY,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  url text NOT NULL,
+  events text[] NOT NULL,
+  fields text[] NOT NULL DEFAULT '{}',
+  filter text NOT NULL DEFAULT ''
+);
+
+INSERT INTO permissions (code)
+VALUES
+  ('admin:webhooks');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permissions WHERE code = 'admin:webhooks';
+DROP TABLE IF EXISTS webhooks;
+-- +goose StatementEnd
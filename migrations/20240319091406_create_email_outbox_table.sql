@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS email_outbox (
+  id bigserial PRIMARY KEY,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  recipient text NOT NULL,
+  template text NOT NULL,
+  data jsonb NOT NULL DEFAULT '{}',
+  status text NOT NULL DEFAULT 'pending',
+  attempts integer NOT NULL DEFAULT 0,
+  claimed_at timestamp(0) with time zone,
+  sent_at timestamp(0) with time zone,
+  last_error text NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS email_outbox_pending_idx ON email_outbox (id) WHERE status IN ('pending', 'sending');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS email_outbox;
+-- +goose StatementEnd
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS slug text;
+
+WITH base AS (
+  SELECT id, coalesce(nullif(trim(both '-' from lower(regexp_replace(title, '[^a-zA-Z0-9]+', '-', 'g'))), ''), 'movie') AS slug
+  FROM movies
+), numbered AS (
+  SELECT id, slug, row_number() OVER (PARTITION BY slug ORDER BY id) AS n
+  FROM base
+)
+UPDATE movies
+SET slug = CASE WHEN numbered.n = 1 THEN numbered.slug ELSE numbered.slug || '-' || movies.id END
+FROM numbered
+WHERE movies.id = numbered.id;
+
+ALTER TABLE movies ALTER COLUMN slug SET NOT NULL;
+ALTER TABLE movies ADD CONSTRAINT movies_slug_key UNIQUE (slug);
+
+CREATE TABLE IF NOT EXISTS movie_slug_aliases (
+  slug text PRIMARY KEY,
+  movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS movie_slug_aliases;
+ALTER TABLE movies DROP COLUMN IF EXISTS slug;
+-- +goose StatementEnd
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS access_denials (
+  id bigserial PRIMARY KEY,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  method text NOT NULL,
+  path text NOT NULL,
+  permission text NOT NULL,
+  reason text NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS access_denials_user_id_idx ON access_denials (user_id);
+
+INSERT INTO permissions (code)
+VALUES
+  ('admin:audit');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permissions WHERE code = 'admin:audit';
+DROP TABLE IF EXISTS access_denials;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS genre_mappings (
+  alias text PRIMARY KEY,
+  canonical text NOT NULL,
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS genre_mappings;
+-- +goose StatementEnd
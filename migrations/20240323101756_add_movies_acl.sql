@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS visibility text NOT NULL DEFAULT 'public';
+ALTER TABLE movies ADD CONSTRAINT movies_visibility_check CHECK (visibility IN ('public', 'authenticated', 'restricted'));
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS owner_id bigint REFERENCES users ON DELETE SET NULL;
+
+CREATE TABLE IF NOT EXISTS movie_acl (
+  movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+  user_id bigint REFERENCES users ON DELETE CASCADE,
+  permission text,
+  CHECK ((user_id IS NULL) <> (permission IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS movie_acl_movie_id_idx ON movie_acl (movie_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS movie_acl;
+ALTER TABLE movies DROP COLUMN IF EXISTS owner_id;
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_visibility_check;
+ALTER TABLE movies DROP COLUMN IF EXISTS visibility;
+-- +goose StatementEnd
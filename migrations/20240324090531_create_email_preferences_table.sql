@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS email_preferences (
+  user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+  digests boolean NOT NULL DEFAULT true,
+  notifications boolean NOT NULL DEFAULT true,
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS email_preferences;
+-- +goose StatementEnd
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS consumed_tokens (
+  hash bytea PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  scope text NOT NULL,
+  consumed_at timestamp(0) with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS consumed_tokens_consumed_at_idx ON consumed_tokens (consumed_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS consumed_tokens;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS dead_letters (
+  id bigserial PRIMARY KEY,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  kind text NOT NULL,
+  source_id bigint NOT NULL,
+  target text NOT NULL,
+  payload jsonb,
+  attempts integer NOT NULL,
+  last_error text NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS dead_letters_kind_source_idx ON dead_letters (kind, source_id);
+
+INSERT INTO permissions (code)
+VALUES
+  ('admin:dead_letters');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permissions WHERE code = 'admin:dead_letters';
+DROP TABLE IF EXISTS dead_letters;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS popularity bigint NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS movies_popularity_idx ON movies (popularity, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS movies_popularity_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS popularity;
+-- +goose StatementEnd
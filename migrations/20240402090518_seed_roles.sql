@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+INSERT INTO roles (name)
+VALUES
+  ('editor'),
+  ('admin')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE roles.name = 'editor' AND permissions.code IN ('movies:read', 'movies:write')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE roles.name = 'admin'
+ON CONFLICT DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM roles WHERE name IN ('editor', 'admin');
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+INSERT INTO permissions (code)
+VALUES
+  ('metrics:read');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE roles.name = 'admin' AND permissions.code = 'metrics:read'
+ON CONFLICT DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permissions WHERE code = 'metrics:read';
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+INSERT INTO permissions (code)
+VALUES
+  ('admin:logs');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE roles.name = 'admin' AND permissions.code = 'admin:logs'
+ON CONFLICT DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permissions WHERE code = 'admin:logs';
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE email_outbox ADD COLUMN IF NOT EXISTS user_id bigint REFERENCES users ON DELETE CASCADE;
+
+-- Only plaintext recipients can be matched to their user. Emails enqueued with field
+-- encryption enabled are left without one.
+UPDATE email_outbox
+SET user_id = users.id
+FROM users
+WHERE email_outbox.user_id IS NULL AND email_outbox.recipient = users.email;
+
+CREATE INDEX IF NOT EXISTS email_outbox_user_id_idx ON email_outbox (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS email_outbox_user_id_idx;
+ALTER TABLE email_outbox DROP COLUMN IF EXISTS user_id;
+-- +goose StatementEnd